@@ -0,0 +1,201 @@
+// Command strategy-archive exports strategy packages from an API server as
+// signed archives and imports them into another, for scripted promotion of
+// strategies between environments. Both servers must share the same
+// STRATEGY_ARCHIVE_KEY.
+//
+// Usage:
+//
+//	strategy-archive export -api URL -strategy ID [-o FILE] [-include-backtest]
+//	strategy-archive import -api URL -f FILE
+//	strategy-archive promote -from URL -to URL -strategy ID
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage:
+  strategy-archive export -api URL -strategy ID [-o FILE] [-include-backtest]
+  strategy-archive import -api URL -f FILE
+  strategy-archive promote -from URL -to URL -strategy ID`
+
+var client = &http.Client{Timeout: 60 * time.Second}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("strategy-archive: ")
+
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "promote":
+		err = runPromote(os.Args[2:])
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	api := fs.String("api", "http://localhost:8080", "API server URL")
+	strategyID := fs.String("strategy", "", "ID of the strategy to export")
+	output := fs.String("o", "", "archive file to write (default: the name sent by the server)")
+	includeBacktest := fs.Bool("include-backtest", false, "include the latest completed backtest summary")
+	fs.Parse(args)
+	if *strategyID == "" {
+		return fmt.Errorf("-strategy is required")
+	}
+
+	data, filename, err := exportArchive(*api, *strategyID, *includeBacktest)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = filename
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+	log.Printf("exported strategy %s to %s", *strategyID, *output)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	api := fs.String("api", "http://localhost:8080", "API server URL")
+	file := fs.String("f", "", "archive file to import")
+	fs.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("-f is required")
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	return importArchive(*api, data)
+}
+
+func runPromote(args []string) error {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	from := fs.String("from", "", "API server URL to export from")
+	to := fs.String("to", "", "API server URL to import into")
+	strategyID := fs.String("strategy", "", "ID of the strategy to promote")
+	fs.Parse(args)
+	if *from == "" || *to == "" || *strategyID == "" {
+		return fmt.Errorf("-from, -to and -strategy are required")
+	}
+
+	data, _, err := exportArchive(*from, *strategyID, false)
+	if err != nil {
+		return err
+	}
+	return importArchive(*to, data)
+}
+
+// exportArchive downloads the archive of a strategy, returning it with the
+// file name the server suggests
+func exportArchive(api, strategyID string, includeBacktest bool) ([]byte, string, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/strategies/%s/export", strings.TrimRight(api, "/"), url.PathEscape(strategyID))
+	if includeBacktest {
+		endpoint += "?include_backtest=true"
+	}
+
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", responseError("export", resp.StatusCode, data)
+	}
+
+	filename := strategyID + ".zip"
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+		if _, after, ok := strings.Cut(disposition, "filename="); ok {
+			filename = strings.Trim(after, `"`)
+		}
+	}
+	return data, filename, nil
+}
+
+// importArchive uploads an archive and prints the import result
+func importArchive(api string, data []byte) error {
+	endpoint := strings.TrimRight(api, "/") + "/api/v1/strategies/import"
+	resp, err := client.Post(endpoint, "application/zip", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return responseError("import", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Data struct {
+			PackageID       string `json:"package_id"`
+			PackageCreated  bool   `json:"package_created"`
+			ActiveVersionID string `json:"active_version_id"`
+			Versions        []struct {
+				Version   string `json:"version"`
+				VersionID string `json:"version_id"`
+				Status    string `json:"status"`
+			} `json:"versions"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("import: invalid response: %v", err)
+	}
+
+	action := "updated"
+	if result.Data.PackageCreated {
+		action = "created"
+	}
+	fmt.Printf("%s strategy %s\n", action, result.Data.PackageID)
+	for _, version := range result.Data.Versions {
+		fmt.Printf("  %-12s %-10s %s\n", version.Version, version.Status, version.VersionID)
+	}
+	if result.Data.ActiveVersionID != "" {
+		fmt.Printf("active version %s\n", result.Data.ActiveVersionID)
+	}
+	return nil
+}
+
+// responseError turns an error response of the API into an error
+func responseError(action string, status int, body []byte) error {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Error != "" {
+		return fmt.Errorf("%s: %s (HTTP %d)", action, response.Error, status)
+	}
+	return fmt.Errorf("%s: HTTP %d", action, status)
+}
@@ -8,6 +8,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/redis/go-redis/v9 v9.12.1
 	github.com/stretchr/testify v1.11.0
+	go.starlark.net v0.0.0-20231121155337-90ade8b19d09
 	gorm.io/driver/mysql v1.6.0
 	gorm.io/gorm v1.30.1
 )
@@ -0,0 +1,310 @@
+// Package analytics computes the performance tearsheet of an equity curve and
+// the trades behind it.
+package analytics
+
+import (
+	"math"
+	"time"
+)
+
+// Trading calendar used to annualize returns
+const (
+	TradingDaysPerYear = 252
+	TradingDay         = 6*time.Hour + 30*time.Minute // regular session of a US trading day
+	calendarYear       = 365.25 * 24 * time.Hour
+)
+
+// MaxProfitFactor is the profit factor of trades with profits and no losses,
+// whose ratio has no finite value, and caps every profit factor so that
+// those trades compare as the best
+const MaxProfitFactor = 1000.0
+
+// EquityPoint represents an equity point of a backtest
+type EquityPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Equity    float64   `json:"equity"`
+	Drawdown  float64   `json:"drawdown"`
+	Exposure  float64   `json:"exposure"` // gross value of the open positions as a share of equity
+}
+
+// Trade represents a closed round trip of a backtest
+type Trade struct {
+	ID         string    `json:"id"`
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"`
+	Quantity   float64   `json:"quantity"`
+	EntryPrice float64   `json:"entry_price"`
+	ExitPrice  float64   `json:"exit_price"`
+	EntryTime  time.Time `json:"entry_time"`
+	ExitTime   time.Time `json:"exit_time"`
+	PnL        float64   `json:"pnl"`
+	Commission float64   `json:"commission"`
+	BorrowFee  float64   `json:"borrow_fee,omitempty"`
+}
+
+// Performance contains performance metrics
+type Performance struct {
+	TotalReturn          float64 `json:"total_return"`
+	AnnualizedReturn     float64 `json:"annualized_return"` // period returns compounded over the periods of a year
+	CAGR                 float64 `json:"cagr"`              // Compound Annual Growth Rate
+	Volatility           float64 `json:"volatility"`        // annualized standard deviation of period returns
+	SharpeRatio          float64 `json:"sharpe_ratio"`
+	SortinoRatio         float64 `json:"sortino_ratio"`
+	CalmarRatio          float64 `json:"calmar_ratio"`
+	MaxDrawdown          float64 `json:"max_drawdown"`
+	MaxDrawdownDays      float64 `json:"max_drawdown_days"` // longest time below a previous peak
+	Exposure             float64 `json:"exposure"`          // share of equity points with an open position
+	TotalTrades          int     `json:"total_trades"`
+	WinRate              float64 `json:"win_rate"`
+	ProfitFactor         float64 `json:"profit_factor"` // gross profit over gross loss, at most MaxProfitFactor
+	AverageWin           float64 `json:"average_win"`
+	AverageLoss          float64 `json:"average_loss"` // negative
+	Expectancy           float64 `json:"expectancy"`   // mean PnL per trade
+	SQN                  float64 `json:"sqn"`          // System Quality Number
+	MaxConsecutiveWins   int     `json:"max_consecutive_wins"`
+	MaxConsecutiveLosses int     `json:"max_consecutive_losses"`
+}
+
+// Config sets how returns are measured. Equity is sampled at the last point
+// of each Frequency period, UTC days for daily returns, and period returns
+// are annualized over the trading calendar: 252 days a year of 6.5 hours
+// each for intraday periods.
+type Config struct {
+	RiskFreeRate float64       // annual, compounded over the periods of a year
+	Frequency    time.Duration // period of returns; zero for daily
+}
+
+// PeriodsPerYear returns the number of trading periods of a frequency in a
+// year
+func PeriodsPerYear(frequency time.Duration) float64 {
+	if frequency <= 0 || frequency >= 24*time.Hour {
+		return TradingDaysPerYear
+	}
+	return TradingDaysPerYear * float64(TradingDay) / float64(frequency)
+}
+
+// Compute computes the performance of an equity curve starting from initial
+// and of the trades made along it, in the order they closed
+func Compute(initial float64, equity []EquityPoint, trades []Trade, config Config) *Performance {
+	performance := &Performance{}
+	tradeStats(performance, trades)
+	if len(equity) == 0 || initial <= 0 {
+		return performance
+	}
+
+	final := equity[len(equity)-1].Equity
+	performance.TotalReturn = final/initial - 1
+	if years := float64(equity[len(equity)-1].Timestamp.Sub(equity[0].Timestamp)) / float64(calendarYear); years > 0 && final > 0 {
+		// Compounding over spans of minutes can overflow; such a CAGR is left
+		// at zero so results stay encodable as JSON
+		if cagr := math.Pow(final/initial, 1/years) - 1; !math.IsInf(cagr, 0) {
+			performance.CAGR = cagr
+		}
+	}
+
+	periods := PeriodsPerYear(config.Frequency)
+	returns := Returns(initial, equity, config.Frequency)
+	riskFree := math.Pow(1+config.RiskFreeRate, 1/periods) - 1
+	if len(returns) > 0 && final > 0 {
+		// The period returns compound to the total return
+		if annualized := math.Pow(final/initial, periods/float64(len(returns))) - 1; !math.IsInf(annualized, 0) {
+			performance.AnnualizedReturn = annualized
+		}
+	}
+	performance.Volatility = stddev(returns) * math.Sqrt(periods)
+	performance.SharpeRatio = Sharpe(returns, riskFree, periods)
+	performance.SortinoRatio = Sortino(returns, riskFree, periods)
+
+	performance.MaxDrawdown, performance.MaxDrawdownDays = drawdowns(initial, equity)
+	if performance.MaxDrawdown > 0 {
+		performance.CalmarRatio = performance.CAGR / performance.MaxDrawdown
+	}
+
+	exposed := 0
+	for _, point := range equity {
+		if point.Exposure > 0 {
+			exposed++
+		}
+	}
+	performance.Exposure = float64(exposed) / float64(len(equity))
+	return performance
+}
+
+// Returns returns the period returns of an equity curve starting from
+// initial, sampling the last point of each frequency period
+func Returns(initial float64, equity []EquityPoint, frequency time.Duration) []float64 {
+	if frequency <= 0 {
+		frequency = 24 * time.Hour
+	}
+	var returns []float64
+	previous := initial
+	for i, point := range equity {
+		if i+1 < len(equity) && equity[i+1].Timestamp.Truncate(frequency).Equal(point.Timestamp.Truncate(frequency)) {
+			continue
+		}
+		if previous != 0 {
+			returns = append(returns, point.Equity/previous-1)
+		}
+		previous = point.Equity
+	}
+	return returns
+}
+
+// Sharpe returns the annualized Sharpe ratio of period returns: their mean
+// excess over the period risk-free rate over its sample standard deviation
+func Sharpe(returns []float64, riskFree, periodsPerYear float64) float64 {
+	excess := excessReturns(returns, riskFree)
+	deviation := stddev(excess)
+	if deviation == 0 {
+		return 0
+	}
+	return mean(excess) / deviation * math.Sqrt(periodsPerYear)
+}
+
+// Sortino returns the annualized Sortino ratio of period returns: their mean
+// excess over the period risk-free rate over the downside deviation, the root
+// mean square of the excess returns below zero over all periods
+func Sortino(returns []float64, riskFree, periodsPerYear float64) float64 {
+	excess := excessReturns(returns, riskFree)
+	if len(excess) == 0 {
+		return 0
+	}
+	downside := 0.0
+	for _, r := range excess {
+		if r < 0 {
+			downside += r * r
+		}
+	}
+	downside = math.Sqrt(downside / float64(len(excess)))
+	if downside == 0 {
+		return 0
+	}
+	return mean(excess) / downside * math.Sqrt(periodsPerYear)
+}
+
+func excessReturns(returns []float64, riskFree float64) []float64 {
+	excess := make([]float64, len(returns))
+	for i, r := range returns {
+		excess[i] = r - riskFree
+	}
+	return excess
+}
+
+// drawdowns returns the largest drawdown of an equity curve from its peak,
+// starting at initial, and the longest time in days the curve took to
+// recover a peak, or has spent below it at the end
+func drawdowns(initial float64, equity []EquityPoint) (float64, float64) {
+	maxDrawdown, longest := 0.0, time.Duration(0)
+	peak := initial
+	peakTime := equity[0].Timestamp
+	underwater := false
+	for _, point := range equity {
+		if point.Equity >= peak {
+			if d := point.Timestamp.Sub(peakTime); underwater && d > longest {
+				longest = d
+			}
+			peak, peakTime, underwater = point.Equity, point.Timestamp, false
+			continue
+		}
+		underwater = true
+		if drawdown := (peak - point.Equity) / peak; drawdown > maxDrawdown {
+			maxDrawdown = drawdown
+		}
+	}
+	if d := equity[len(equity)-1].Timestamp.Sub(peakTime); underwater && d > longest {
+		longest = d
+	}
+	return maxDrawdown, longest.Hours() / 24
+}
+
+// tradeStats fills in the statistics of closed trades
+func tradeStats(performance *Performance, trades []Trade) {
+	performance.TotalTrades = len(trades)
+	if len(trades) == 0 {
+		return
+	}
+
+	var wins, losses int
+	var grossProfit, grossLoss float64
+	var winStreak, lossStreak int
+	pnls := make([]float64, len(trades))
+	for i, trade := range trades {
+		pnls[i] = trade.PnL
+		switch {
+		case trade.PnL > 0:
+			wins++
+			grossProfit += trade.PnL
+			winStreak, lossStreak = winStreak+1, 0
+		case trade.PnL < 0:
+			losses++
+			grossLoss -= trade.PnL
+			winStreak, lossStreak = 0, lossStreak+1
+		default:
+			winStreak, lossStreak = 0, 0
+		}
+		performance.MaxConsecutiveWins = max(performance.MaxConsecutiveWins, winStreak)
+		performance.MaxConsecutiveLosses = max(performance.MaxConsecutiveLosses, lossStreak)
+	}
+
+	performance.WinRate = float64(wins) / float64(len(trades))
+	performance.ProfitFactor = profitFactor(grossProfit, grossLoss)
+	if wins > 0 {
+		performance.AverageWin = grossProfit / float64(wins)
+	}
+	if losses > 0 {
+		performance.AverageLoss = -grossLoss / float64(losses)
+	}
+	performance.Expectancy = mean(pnls)
+	if deviation := stddev(pnls); deviation > 0 {
+		performance.SQN = math.Sqrt(float64(len(pnls))) * performance.Expectancy / deviation
+	}
+}
+
+// ProfitFactor returns the profit factor of trades
+func ProfitFactor(trades []Trade) float64 {
+	var grossProfit, grossLoss float64
+	for _, trade := range trades {
+		if trade.PnL > 0 {
+			grossProfit += trade.PnL
+		} else {
+			grossLoss -= trade.PnL
+		}
+	}
+	return profitFactor(grossProfit, grossLoss)
+}
+
+// profitFactor returns gross profit over gross loss, capped at
+// MaxProfitFactor, and MaxProfitFactor for profits without losses
+func profitFactor(grossProfit, grossLoss float64) float64 {
+	switch {
+	case grossLoss > 0:
+		return math.Min(grossProfit/grossLoss, MaxProfitFactor)
+	case grossProfit > 0:
+		return MaxProfitFactor
+	}
+	return 0
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// stddev returns the sample standard deviation of values
+func stddev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
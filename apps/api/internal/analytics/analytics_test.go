@@ -0,0 +1,141 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var day1 = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// dailyCurve returns one equity point per day from day1
+func dailyCurve(equity []float64, exposure []float64) []EquityPoint {
+	points := make([]EquityPoint, len(equity))
+	for i, e := range equity {
+		points[i] = EquityPoint{Timestamp: day1.AddDate(0, 0, i), Equity: e}
+		if exposure != nil {
+			points[i].Exposure = exposure[i]
+		}
+	}
+	return points
+}
+
+func tradesWithPnL(pnls ...float64) []Trade {
+	trades := make([]Trade, len(pnls))
+	for i, pnl := range pnls {
+		trades[i] = Trade{PnL: pnl}
+	}
+	return trades
+}
+
+// Reference values were computed independently from the definitions in the
+// doc comments: sample standard deviations, 252 periods a year and the
+// risk-free rate compounded per period
+func TestCompute_ReferenceValues(t *testing.T) {
+	equity := dailyCurve([]float64{102, 101, 104, 103, 107, 105}, []float64{0, 1, 1, 0, 0.5, 0})
+	trades := tradesWithPnL(10, -5, 20, 15, 5, -10, -5, 0, 8)
+
+	p := Compute(100, equity, trades, Config{Frequency: 24 * time.Hour})
+	assert.InDelta(t, 0.05, p.TotalReturn, 1e-12)
+	assert.InDelta(t, 34.30842106520559, p.CAGR, 1e-9, "1.05 over 5 of 365.25 days")
+	assert.InDelta(t, 6.761587555117436, p.AnnualizedReturn, 1e-9, "1.05 over 6 of 252 daily returns")
+	assert.InDelta(t, 0.382608817713521, p.Volatility, 1e-12)
+	assert.InDelta(t, 5.535510492404904, p.SharpeRatio, 1e-9)
+	assert.InDelta(t, 14.090222881663543, p.SortinoRatio, 1e-9)
+	assert.InDelta(t, 2.0/107, p.MaxDrawdown, 1e-12)
+	assert.InDelta(t, 1835.5005269884994, p.CalmarRatio, 1e-6)
+	assert.Equal(t, 2.0, p.MaxDrawdownDays, "from the peaks of days 1 and 3 to recoveries two days later")
+	assert.Equal(t, 0.5, p.Exposure)
+
+	assert.Equal(t, 9, p.TotalTrades)
+	assert.InDelta(t, 5.0/9, p.WinRate, 1e-12)
+	assert.InDelta(t, 2.9, p.ProfitFactor, 1e-12)
+	assert.InDelta(t, 11.6, p.AverageWin, 1e-12)
+	assert.InDelta(t, -20.0/3, p.AverageLoss, 1e-12)
+	assert.InDelta(t, 38.0/9, p.Expectancy, 1e-12)
+	assert.InDelta(t, 1.2638611999517049, p.SQN, 1e-12)
+	assert.Equal(t, 3, p.MaxConsecutiveWins)
+	assert.Equal(t, 2, p.MaxConsecutiveLosses, "a flat trade breaks a streak")
+
+	withRiskFree := Compute(100, equity, trades, Config{RiskFreeRate: 0.05, Frequency: 24 * time.Hour})
+	assert.InDelta(t, 5.407978440371496, withRiskFree.SharpeRatio, 1e-9)
+	assert.InDelta(t, 13.579183896213902, withRiskFree.SortinoRatio, 1e-9)
+}
+
+func TestCompute_CAGRTakesTheRootOfYears(t *testing.T) {
+	year := 365*24*time.Hour + 6*time.Hour
+	curve := func(span time.Duration) []EquityPoint {
+		return []EquityPoint{{Timestamp: day1, Equity: 100}, {Timestamp: day1.Add(span), Equity: 121}}
+	}
+
+	assert.InDelta(t, 0.21, Compute(100, curve(year), nil, Config{}).CAGR, 1e-12)
+	assert.InDelta(t, 0.10, Compute(100, curve(2*year), nil, Config{}).CAGR, 1e-12)
+	assert.InDelta(t, 0.21, Compute(100, curve(2*year), nil, Config{}).TotalReturn, 1e-12)
+	assert.Zero(t, Compute(100, curve(time.Minute), nil, Config{}).CAGR, "overflows")
+}
+
+func TestCompute_AnnualizedReturnCompoundsPeriodReturns(t *testing.T) {
+	// Two daily returns of 10% compound to 21%, annualized over 252 days
+	p := Compute(100, dailyCurve([]float64{110, 121}, nil), nil, Config{})
+	assert.InDelta(t, math.Pow(1.21, 126)-1, p.AnnualizedReturn, 1e-3)
+
+	// Returns that go nowhere annualize to nothing
+	flat := Compute(100, dailyCurve([]float64{110, 100}, nil), nil, Config{})
+	assert.InDelta(t, 0, flat.AnnualizedReturn, 1e-12)
+	assert.Greater(t, flat.Volatility, 0.0)
+}
+
+func TestProfitFactor_WithoutLosses(t *testing.T) {
+	assert.Equal(t, MaxProfitFactor, Compute(100, nil, tradesWithPnL(10, 5, 0), Config{}).ProfitFactor, "flawless trades are the best")
+	assert.InDelta(t, 3.0, ProfitFactor(tradesWithPnL(10, 5, -5)), 1e-12)
+	assert.Equal(t, MaxProfitFactor, ProfitFactor(tradesWithPnL(5000, -1)), "capped")
+	assert.Zero(t, ProfitFactor(tradesWithPnL(0, -5)))
+	assert.Zero(t, ProfitFactor(nil))
+}
+
+func TestCompute_DrawdownStillOpenAtTheEnd(t *testing.T) {
+	p := Compute(100, dailyCurve([]float64{110, 100, 105, 108}, nil), nil, Config{})
+	assert.InDelta(t, 10.0/110, p.MaxDrawdown, 1e-12)
+	assert.Equal(t, 3.0, p.MaxDrawdownDays)
+
+	rising := Compute(100, dailyCurve([]float64{101, 102, 103}, nil), nil, Config{})
+	assert.Zero(t, rising.MaxDrawdown)
+	assert.Zero(t, rising.MaxDrawdownDays)
+	assert.Zero(t, rising.CalmarRatio)
+	assert.Zero(t, rising.SortinoRatio, "no downside")
+}
+
+func TestCompute_EmptyInputs(t *testing.T) {
+	assert.Equal(t, &Performance{}, Compute(100, nil, nil, Config{}))
+
+	p := Compute(100, dailyCurve([]float64{100}, nil), tradesWithPnL(5), Config{})
+	assert.Zero(t, p.SharpeRatio, "one return has no deviation")
+	assert.Zero(t, p.SQN, "one trade has no deviation")
+	assert.Equal(t, MaxProfitFactor, p.ProfitFactor, "no losses")
+	assert.Equal(t, 5.0, p.Expectancy)
+}
+
+func TestReturns_SampleTheLastPointOfEachPeriod(t *testing.T) {
+	equity := []EquityPoint{
+		{Timestamp: day1.Add(15 * time.Hour), Equity: 101},
+		{Timestamp: day1.Add(20 * time.Hour), Equity: 102},
+		{Timestamp: day1.Add(36 * time.Hour), Equity: 99},
+	}
+
+	daily := Returns(100, equity, 24*time.Hour)
+	require.Len(t, daily, 2)
+	assert.InDelta(t, 0.02, daily[0], 1e-12)
+	assert.InDelta(t, 99.0/102-1, daily[1], 1e-12)
+
+	assert.Len(t, Returns(100, equity, time.Hour), 3)
+}
+
+func TestPeriodsPerYear(t *testing.T) {
+	assert.Equal(t, 98280.0, PeriodsPerYear(time.Minute))
+	assert.Equal(t, 1638.0, PeriodsPerYear(time.Hour))
+	assert.Equal(t, 252.0, PeriodsPerYear(24*time.Hour))
+	assert.Equal(t, 252.0, PeriodsPerYear(0))
+}
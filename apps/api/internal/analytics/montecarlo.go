@@ -0,0 +1,248 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Resampling methods of Monte Carlo analyses
+const (
+	ResampleBootstrap = "bootstrap"
+	ResampleShuffle   = "shuffle"
+)
+
+// Defaults and bounds of Monte Carlo analyses
+const (
+	DefaultIterations   = 1000
+	MaxIterations       = 100000
+	DefaultRuinDrawdown = 0.5
+	histogramBins       = 20
+)
+
+// DefaultConfidenceLevels are the confidence levels reported unless set
+var DefaultConfidenceLevels = []float64{0.9, 0.95, 0.99}
+
+// percentiles are the percentiles of each distribution
+var percentiles = []float64{1, 5, 10, 25, 50, 75, 90, 95, 99}
+
+// MonteCarloConfig configures a Monte Carlo analysis of trades. Each
+// iteration replays the trades in a new order: bootstrap draws as many trades
+// as there are with replacement, shuffle replays each trade once. With
+// SlippageBps each replayed trade also pays extra slippage on its entry and
+// on its exit, each drawn uniformly between none and SlippageBps.
+type MonteCarloConfig struct {
+	Method           string    `json:"method"`            // bootstrap (default) or shuffle
+	Iterations       int       `json:"iterations"`        // 1000 by default
+	Seed             int64     `json:"seed"`              // of the random draws
+	SlippageBps      float64   `json:"slippage_bps"`      // most extra slippage per fill, in basis points
+	RuinDrawdown     float64   `json:"ruin_drawdown"`     // drawdown counted as ruin; 0.5 by default
+	ConfidenceLevels []float64 `json:"confidence_levels"` // 0.9, 0.95 and 0.99 by default
+}
+
+// Validate checks a Monte Carlo configuration and fills in its defaults
+func (config *MonteCarloConfig) Validate() error {
+	switch config.Method {
+	case "":
+		config.Method = ResampleBootstrap
+	case ResampleBootstrap, ResampleShuffle:
+	default:
+		return fmt.Errorf("invalid method %q: must be bootstrap or shuffle", config.Method)
+	}
+	if config.Iterations == 0 {
+		config.Iterations = DefaultIterations
+	}
+	if config.Iterations < 0 || config.Iterations > MaxIterations {
+		return fmt.Errorf("iterations must be between 1 and %d", MaxIterations)
+	}
+	if config.SlippageBps < 0 {
+		return fmt.Errorf("slippage must not be negative")
+	}
+	if config.RuinDrawdown == 0 {
+		config.RuinDrawdown = DefaultRuinDrawdown
+	}
+	if config.RuinDrawdown < 0 || config.RuinDrawdown > 1 {
+		return fmt.Errorf("ruin drawdown must be between 0 and 1")
+	}
+	if len(config.ConfidenceLevels) == 0 {
+		config.ConfidenceLevels = DefaultConfidenceLevels
+	}
+	for _, level := range config.ConfidenceLevels {
+		if level <= 0 || level >= 1 {
+			return fmt.Errorf("confidence levels must be between 0 and 1, got %g", level)
+		}
+	}
+	return nil
+}
+
+// Percentile is a value of a distribution below which Percent percent of it
+// lies
+type Percentile struct {
+	Percent float64 `json:"percent"`
+	Value   float64 `json:"value"`
+}
+
+// Bin is a bin of a histogram, counting the values from Low up to High; the
+// last bin includes High
+type Bin struct {
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+	Count int     `json:"count"`
+}
+
+// Distribution summarizes the values of a metric over the iterations
+type Distribution struct {
+	Mean        float64      `json:"mean"`
+	StdDev      float64      `json:"std_dev"`
+	Min         float64      `json:"min"`
+	Max         float64      `json:"max"`
+	Percentiles []Percentile `json:"percentiles"`
+	Histogram   []Bin        `json:"histogram"`
+}
+
+// ConfidenceLevel is the outcome of a Monte Carlo analysis at a confidence
+// level: the final equity reached and the drawdown not exceeded with that
+// confidence, and the upper bound of the risk of ruin at it
+type ConfidenceLevel struct {
+	Confidence  float64 `json:"confidence"`
+	FinalEquity float64 `json:"final_equity"`
+	MaxDrawdown float64 `json:"max_drawdown"`
+	RiskOfRuin  float64 `json:"risk_of_ruin"`
+}
+
+// MonteCarloResult is the outcome of a Monte Carlo analysis
+type MonteCarloResult struct {
+	Config           MonteCarloConfig  `json:"config"`
+	Trades           int               `json:"trades"`
+	InitialBalance   float64           `json:"initial_balance"`
+	FinalEquity      Distribution      `json:"final_equity"`
+	MaxDrawdown      Distribution      `json:"max_drawdown"`
+	RiskOfRuin       float64           `json:"risk_of_ruin"` // share of iterations reaching the ruin drawdown
+	ConfidenceLevels []ConfidenceLevel `json:"confidence_levels"`
+}
+
+// MonteCarlo runs a Monte Carlo analysis of trades made from initial. The
+// same configuration and seed always give the same result.
+func MonteCarlo(initial float64, trades []Trade, config MonteCarloConfig) (*MonteCarloResult, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if len(trades) == 0 {
+		return nil, fmt.Errorf("there are no trades to resample")
+	}
+	if initial <= 0 {
+		return nil, fmt.Errorf("initial balance must be positive")
+	}
+
+	random := rand.New(rand.NewSource(config.Seed))
+	finals := make([]float64, config.Iterations)
+	drawdowns := make([]float64, config.Iterations)
+	ruined := 0
+	order := make([]int, len(trades))
+	for iteration := range finals {
+		for i := range order {
+			order[i] = i
+		}
+		if config.Method == ResampleShuffle {
+			random.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		} else {
+			for i := range order {
+				order[i] = random.Intn(len(trades))
+			}
+		}
+
+		equity, peak, maxDrawdown := initial, initial, 0.0
+		for _, i := range order {
+			trade := trades[i]
+			equity += trade.PnL
+			if config.SlippageBps > 0 {
+				// Each fill draws its own slippage
+				entry := math.Abs(trade.Quantity*trade.EntryPrice) * random.Float64()
+				exit := math.Abs(trade.Quantity*trade.ExitPrice) * random.Float64()
+				equity -= (entry + exit) * config.SlippageBps / 10000
+			}
+			peak = math.Max(peak, equity)
+			if drawdown := (peak - equity) / peak; drawdown > maxDrawdown {
+				maxDrawdown = drawdown
+			}
+		}
+		finals[iteration], drawdowns[iteration] = equity, math.Min(maxDrawdown, 1)
+		if drawdowns[iteration] >= config.RuinDrawdown {
+			ruined++
+		}
+	}
+
+	result := &MonteCarloResult{
+		Config:         config,
+		Trades:         len(trades),
+		InitialBalance: initial,
+		FinalEquity:    distribution(finals),
+		MaxDrawdown:    distribution(drawdowns),
+		RiskOfRuin:     float64(ruined) / float64(config.Iterations),
+	}
+	for _, level := range config.ConfidenceLevels {
+		result.ConfidenceLevels = append(result.ConfidenceLevels, ConfidenceLevel{
+			Confidence:  level,
+			FinalEquity: percentile(finals, (1-level)*100),
+			MaxDrawdown: percentile(drawdowns, level*100),
+			RiskOfRuin:  wilsonUpper(result.RiskOfRuin, config.Iterations, level),
+		})
+	}
+	return result, nil
+}
+
+// distribution summarizes values, sorting them
+func distribution(values []float64) Distribution {
+	sort.Float64s(values)
+	d := Distribution{
+		Mean:   mean(values),
+		StdDev: stddev(values),
+		Min:    values[0],
+		Max:    values[len(values)-1],
+	}
+	for _, percent := range percentiles {
+		d.Percentiles = append(d.Percentiles, Percentile{Percent: percent, Value: percentile(values, percent)})
+	}
+
+	width := (d.Max - d.Min) / histogramBins
+	if width == 0 {
+		d.Histogram = []Bin{{Low: d.Min, High: d.Max, Count: len(values)}}
+		return d
+	}
+	d.Histogram = make([]Bin, histogramBins)
+	for i := range d.Histogram {
+		d.Histogram[i].Low = d.Min + float64(i)*width
+		d.Histogram[i].High = d.Min + float64(i+1)*width
+	}
+	d.Histogram[histogramBins-1].High = d.Max
+	for _, value := range values {
+		bin := int((value - d.Min) / width)
+		if bin >= histogramBins {
+			bin = histogramBins - 1
+		}
+		d.Histogram[bin].Count++
+	}
+	return d
+}
+
+// percentile returns the percentile of sorted values, interpolating linearly
+// between the closest ranks
+func percentile(sorted []float64, percent float64) float64 {
+	rank := percent / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// wilsonUpper returns the upper bound of the one-sided Wilson score interval
+// of a proportion p observed over n trials, at a confidence level
+func wilsonUpper(p float64, n int, confidence float64) float64 {
+	z := math.Sqrt2 * math.Erfinv(2*confidence-1)
+	trials := float64(n)
+	center := p + z*z/(2*trials)
+	margin := z * math.Sqrt(p*(1-p)/trials+z*z/(4*trials*trials))
+	return math.Min((center+margin)/(1+z*z/trials), 1)
+}
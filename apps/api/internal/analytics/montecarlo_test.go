@@ -0,0 +1,121 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pnlTrades(pnls ...float64) []Trade {
+	trades := make([]Trade, len(pnls))
+	for i, pnl := range pnls {
+		trades[i] = Trade{Quantity: 10, EntryPrice: 100, ExitPrice: 100 + pnl/10, PnL: pnl}
+	}
+	return trades
+}
+
+func TestMonteCarlo_ShuffleKeepsTheFinalEquity(t *testing.T) {
+	result, err := MonteCarlo(1000, pnlTrades(100, -300, 200, -100, 150), MonteCarloConfig{Method: ResampleShuffle, Iterations: 500, Seed: 1})
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, result.Trades)
+	assert.Equal(t, 1050.0, result.FinalEquity.Min)
+	assert.Equal(t, 1050.0, result.FinalEquity.Max)
+	require.Len(t, result.FinalEquity.Histogram, 1)
+	assert.Equal(t, 500, result.FinalEquity.Histogram[0].Count)
+	// The order only moves the drawdown: from losing 300 after the other
+	// trades up to losing 400 first
+	assert.InDelta(t, 300.0/1350, result.MaxDrawdown.Min, 1e-9)
+	assert.InDelta(t, 0.4, result.MaxDrawdown.Max, 1e-9)
+	assert.Zero(t, result.RiskOfRuin)
+}
+
+func TestMonteCarlo_IsReproducibleFromItsSeed(t *testing.T) {
+	trades := pnlTrades(100, -300, 200, -100, 150, 50, -20)
+	config := MonteCarloConfig{Iterations: 200, Seed: 42, SlippageBps: 10}
+	first, err := MonteCarlo(1000, trades, config)
+	require.NoError(t, err)
+	second, err := MonteCarlo(1000, trades, config)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	config.Seed = 43
+	other, err := MonteCarlo(1000, trades, config)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.FinalEquity, other.FinalEquity)
+}
+
+func TestMonteCarlo_BootstrapDistributions(t *testing.T) {
+	result, err := MonteCarlo(1000, pnlTrades(100, -100), MonteCarloConfig{Iterations: 10000, Seed: 3})
+	require.NoError(t, err)
+
+	assert.Equal(t, ResampleBootstrap, result.Config.Method)
+	assert.Equal(t, DefaultConfidenceLevels, result.Config.ConfidenceLevels)
+	// Two draws of +100 or -100: 1200, 1000 or 800 a quarter, a half and a
+	// quarter of the time
+	assert.Equal(t, 800.0, result.FinalEquity.Min)
+	assert.Equal(t, 1200.0, result.FinalEquity.Max)
+	assert.InDelta(t, 1000, result.FinalEquity.Mean, 10)
+	assert.Equal(t, Percentile{Percent: 50, Value: 1000}, result.FinalEquity.Percentiles[4])
+	count := 0
+	for _, bin := range result.FinalEquity.Histogram {
+		count += bin.Count
+	}
+	assert.Equal(t, 10000, count)
+
+	require.Len(t, result.ConfidenceLevels, 3)
+	level := result.ConfidenceLevels[1]
+	assert.Equal(t, 0.95, level.Confidence)
+	assert.Equal(t, 800.0, level.FinalEquity, "equity reached 95% of the time")
+	assert.InDelta(t, 0.2, level.MaxDrawdown, 1e-9, "drawdown not exceeded 95% of the time")
+}
+
+func TestMonteCarlo_RiskOfRuin(t *testing.T) {
+	result, err := MonteCarlo(1000, pnlTrades(-300, 100), MonteCarloConfig{Iterations: 4000, Seed: 5, RuinDrawdown: 0.5})
+	require.NoError(t, err)
+
+	// Ruined by drawing the loss twice, a quarter of the time
+	assert.InDelta(t, 0.25, result.RiskOfRuin, 0.02)
+	for _, level := range result.ConfidenceLevels {
+		assert.Greater(t, level.RiskOfRuin, result.RiskOfRuin, "upper bound at %g", level.Confidence)
+	}
+	assert.Less(t, result.ConfidenceLevels[0].RiskOfRuin, result.ConfidenceLevels[2].RiskOfRuin)
+}
+
+func TestMonteCarlo_SlippageOnlyCosts(t *testing.T) {
+	trades := pnlTrades(100, 100)
+	result, err := MonteCarlo(1000, trades, MonteCarloConfig{Method: ResampleShuffle, Iterations: 100, SlippageBps: 50})
+	require.NoError(t, err)
+
+	assert.Less(t, result.FinalEquity.Max, 1200.0)
+	// At most 50 bps of entry and exit notional per trade: 2 * 10 * (100 + 110) * 0.005
+	assert.GreaterOrEqual(t, result.FinalEquity.Min, 1200-21.0)
+}
+
+func TestMonteCarlo_SlippageIsDrawnPerFill(t *testing.T) {
+	// 100 bps of 1000 notional on the entry and on the exit: two independent
+	// uniform costs of up to 10, whose sum deviates by 10 * sqrt(2/12)
+	trades := []Trade{{Quantity: 10, EntryPrice: 100, ExitPrice: 100}}
+	result, err := MonteCarlo(1000, trades, MonteCarloConfig{Iterations: 20000, Seed: 9, SlippageBps: 100})
+	require.NoError(t, err)
+
+	assert.InDelta(t, 990, result.FinalEquity.Mean, 0.2)
+	assert.InDelta(t, 10*math.Sqrt(2.0/12), result.FinalEquity.StdDev, 0.1)
+}
+
+func TestMonteCarloConfig_Validate(t *testing.T) {
+	for name, config := range map[string]MonteCarloConfig{
+		"method":     {Method: "jackknife"},
+		"iterations": {Iterations: MaxIterations + 1},
+		"slippage":   {SlippageBps: -1},
+		"ruin":       {RuinDrawdown: 1.5},
+		"confidence": {ConfidenceLevels: []float64{95}},
+	} {
+		assert.Error(t, config.Validate(), name)
+	}
+
+	_, err := MonteCarlo(1000, nil, MonteCarloConfig{})
+	assert.Error(t, err, "no trades")
+}
@@ -0,0 +1,294 @@
+// Package archive exports strategy packages as signed zip archives and
+// imports them, for promoting strategies between environments
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+)
+
+// FormatVersion is the version of the archive layout written by Write
+const FormatVersion = 1
+
+// Files of an archive besides the code files
+const (
+	ManifestFile  = "manifest.json"
+	SignatureFile = "manifest.sig"
+)
+
+// maxFileSize bounds the size of any file read from an archive
+const maxFileSize = 5 << 20
+
+var (
+	// ErrInvalidArchive is returned for archives that are malformed or whose
+	// content does not validate
+	ErrInvalidArchive = errors.New("invalid strategy archive")
+
+	// ErrBadSignature is returned for archives whose manifest signature is
+	// missing or does not match the signing key
+	ErrBadSignature = errors.New("strategy archive signature does not match")
+
+	// ErrNoSigningKey is returned when no signing key is configured
+	ErrNoSigningKey = errors.New("strategy archive signing key is not configured")
+)
+
+// Manifest describes the content of an archive. Code is stored in separate
+// files whose hashes the manifest records, so the signature of the manifest
+// covers the code too.
+type Manifest struct {
+	FormatVersion   int              `json:"format_version"`
+	ExportedAt      time.Time        `json:"exported_at"`
+	SourcePackageID string           `json:"source_package_id"`
+	Package         Package          `json:"package"`
+	Versions        []Version        `json:"versions"`
+	LatestBacktest  *BacktestSummary `json:"latest_backtest,omitempty"`
+}
+
+// Package is the metadata of an archived strategy package
+type Package struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Author      string  `json:"author"`
+	IsPublic    bool    `json:"is_public"`
+}
+
+// Version is an archived strategy version
+type Version struct {
+	Version     string  `json:"version"`
+	Description *string `json:"description"`
+	IsActive    bool    `json:"is_active"`
+	CodeFile    string  `json:"code_file"`
+	CodeSHA256  string  `json:"code_sha256"`
+	ContentHash string  `json:"content_hash"`
+	Params      []Param `json:"params"`
+}
+
+// Param is an archived parameter definition
+type Param struct {
+	Name         string          `json:"name"`
+	Type         string          `json:"type"`
+	DefaultValue *string         `json:"default_value,omitempty"`
+	MinValue     *float64        `json:"min_value,omitempty"`
+	MaxValue     *float64        `json:"max_value,omitempty"`
+	Step         *float64        `json:"step,omitempty"`
+	EnumValues   json.RawMessage `json:"enum_values,omitempty"`
+	Description  *string         `json:"description,omitempty"`
+	IsRequired   bool            `json:"is_required"`
+}
+
+// BacktestSummary is the latest completed backtest of an archived package, for
+// reference only; imports do not recreate it
+type BacktestSummary struct {
+	Name        string          `json:"name"`
+	Symbols     []string        `json:"symbols"`
+	StartDate   time.Time       `json:"start_date"`
+	EndDate     time.Time       `json:"end_date"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Results     json.RawMessage `json:"results,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+}
+
+// Archive is the manifest of an archive with the code of its versions by code file
+type Archive struct {
+	Manifest *Manifest
+	Code     map[string]string
+}
+
+// NewParam archives a parameter definition
+func NewParam(param *database.StrategyParam) Param {
+	return Param{
+		Name:         param.ParamName,
+		Type:         param.ParamType,
+		DefaultValue: param.DefaultValue,
+		MinValue:     param.MinValue,
+		MaxValue:     param.MaxValue,
+		Step:         param.Step,
+		EnumValues:   param.EnumValues,
+		Description:  param.Description,
+		IsRequired:   param.IsRequired,
+	}
+}
+
+// Definition returns the parameter definition of an archived parameter
+func (p Param) Definition() *database.StrategyParam {
+	return &database.StrategyParam{
+		ParamName:    p.Name,
+		ParamType:    p.Type,
+		DefaultValue: p.DefaultValue,
+		MinValue:     p.MinValue,
+		MaxValue:     p.MaxValue,
+		Step:         p.Step,
+		EnumValues:   p.EnumValues,
+		Description:  p.Description,
+		IsRequired:   p.IsRequired,
+	}
+}
+
+// ContentHash identifies the content of a version: its code and parameter
+// definitions, independent of the version label and of parameter order
+func ContentHash(code string, params []Param) string {
+	sorted := append([]Param(nil), params...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	data, _ := json.Marshal(struct {
+		Code   string  `json:"code"`
+		Params []Param `json:"params"`
+	}{code, sorted})
+	return sha256Hex(data)
+}
+
+// CodeFile returns the archive path of the code of a version
+func CodeFile(version string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, version)
+	return path.Join("versions", name, "strategy.star")
+}
+
+// Write writes an archive as a zip holding the manifest, its signature and
+// the code files. Code hashes in the manifest are filled in from code.
+func Write(w io.Writer, manifest *Manifest, code map[string]string, key []byte) error {
+	if len(key) == 0 {
+		return ErrNoSigningKey
+	}
+	manifest.FormatVersion = FormatVersion
+	for i := range manifest.Versions {
+		version := &manifest.Versions[i]
+		source, ok := code[version.CodeFile]
+		if !ok {
+			return fmt.Errorf("no code for version %s", version.Version)
+		}
+		version.CodeSHA256 = sha256Hex([]byte(source))
+		version.ContentHash = ContentHash(source, version.Params)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{ManifestFile, data},
+		{SignatureFile, []byte(sign(data, key) + "\n")},
+	}
+	for _, version := range manifest.Versions {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{version.CodeFile, []byte(code[version.CodeFile])})
+	}
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: manifest.ExportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Read opens an archive, checking the signature of its manifest and the
+// hashes of its code files
+func Read(data []byte, key []byte) (*Archive, error) {
+	if len(key) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+
+	manifestData, err := readFile(files, ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := readFile(files, SignatureFile)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	if !hmac.Equal([]byte(strings.TrimSpace(string(signature))), []byte(sign(manifestData, key))) {
+		return nil, ErrBadSignature
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %v", ErrInvalidArchive, err)
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidArchive, manifest.FormatVersion)
+	}
+
+	archive := &Archive{Manifest: &manifest, Code: make(map[string]string, len(manifest.Versions))}
+	for _, version := range manifest.Versions {
+		source, err := readFile(files, version.CodeFile)
+		if err != nil {
+			return nil, err
+		}
+		if sha256Hex(source) != version.CodeSHA256 {
+			return nil, fmt.Errorf("%w: code of version %s does not match its hash", ErrInvalidArchive, version.Version)
+		}
+		archive.Code[version.CodeFile] = string(source)
+	}
+	return archive, nil
+}
+
+// readFile reads a file of an archive
+func readFile(files map[string]*zip.File, name string) ([]byte, error) {
+	file, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, name)
+	}
+	if file.UncompressedSize64 > maxFileSize {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidArchive, name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidArchive, name)
+	}
+	return data, nil
+}
+
+// sign returns the hex HMAC-SHA256 of data
+func sign(data, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCode = `def on_bar(symbol, bar):
+    if bar["close"] > params["threshold"]:
+        order(symbol, "BUY", "MARKET", 1)
+`
+
+var (
+	testKey                = []byte("test-key")
+	strategyVersionColumns = []string{"id", "package_id", "version", "code", "description", "is_active", "created_at", "updated_at"}
+	strategyParamColumns   = []string{"id", "version_id", "param_name", "param_type", "default_value", "min_value", "max_value", "step", "enum_values", "description", "is_required", "created_at", "updated_at"}
+	strategyPackageColumns = []string{"id", "name", "description", "author", "is_public", "created_at", "updated_at"}
+)
+
+func testManifest() (*Manifest, map[string]string) {
+	threshold := "100"
+	manifest := &Manifest{
+		ExportedAt: time.Date(2024, 1, 15, 14, 30, 0, 0, time.UTC),
+		Package:    Package{Name: "Threshold", Author: "alice"},
+		Versions: []Version{
+			{Version: "1.0.0", IsActive: true, CodeFile: CodeFile("1.0.0"), Params: []Param{{Name: "threshold", Type: "float", DefaultValue: &threshold}}},
+		},
+	}
+	return manifest, map[string]string{CodeFile("1.0.0"): testCode}
+}
+
+func writeArchive(t *testing.T, manifest *Manifest, code map[string]string, key []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, manifest, code, key))
+	return buf.Bytes()
+}
+
+// rewrite copies an archive, replacing the content of the named files
+func rewrite(t *testing.T, data []byte, replace map[string]string) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		if replacement, ok := replace[file.Name]; ok {
+			content = []byte(replacement)
+		}
+		fw, err := zw.Create(file.Name)
+		require.NoError(t, err)
+		_, err = fw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestWriteRead_RoundTrip(t *testing.T) {
+	manifest, code := testManifest()
+	data := writeArchive(t, manifest, code, testKey)
+
+	archive, err := Read(data, testKey)
+	require.NoError(t, err)
+	assert.Equal(t, FormatVersion, archive.Manifest.FormatVersion)
+	assert.Equal(t, "Threshold", archive.Manifest.Package.Name)
+	require.Len(t, archive.Manifest.Versions, 1)
+	version := archive.Manifest.Versions[0]
+	assert.Equal(t, "versions/1.0.0/strategy.star", version.CodeFile)
+	assert.Equal(t, testCode, archive.Code[version.CodeFile])
+	assert.Equal(t, ContentHash(testCode, version.Params), version.ContentHash)
+	assert.NoError(t, validate(archive))
+}
+
+func TestRead_RejectsWrongKeyAndTampering(t *testing.T) {
+	manifest, code := testManifest()
+	data := writeArchive(t, manifest, code, testKey)
+
+	_, err := Read(data, []byte("other-key"))
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	_, err = Read(rewrite(t, data, map[string]string{SignatureFile: "00"}), testKey)
+	assert.ErrorIs(t, err, ErrBadSignature)
+
+	_, err = Read(rewrite(t, data, map[string]string{CodeFile("1.0.0"): testCode + "    pass\n"}), testKey)
+	assert.ErrorIs(t, err, ErrInvalidArchive)
+
+	_, err = Read([]byte("not a zip"), testKey)
+	assert.ErrorIs(t, err, ErrInvalidArchive)
+
+	_, err = Read(data, nil)
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestContentHash_IgnoresParamOrder(t *testing.T) {
+	a := Param{Name: "a", Type: "int"}
+	b := Param{Name: "b", Type: "bool"}
+
+	assert.Equal(t, ContentHash(testCode, []Param{a, b}), ContentHash(testCode, []Param{b, a}))
+	assert.NotEqual(t, ContentHash(testCode, []Param{a}), ContentHash(testCode, []Param{a, b}))
+}
+
+func TestValidate_RejectsCodeUsingUndeclaredParams(t *testing.T) {
+	manifest, code := testManifest()
+	manifest.Versions[0].Params = nil
+	archive, err := Read(writeArchive(t, manifest, code, testKey), testKey)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, validate(archive), ErrInvalidArchive)
+}
+
+func TestService_ImportCreatesPackageAndActivatesVersion(t *testing.T) {
+	db, mock := testutil.NewSQLMock(t)
+	service := NewService(database.NewStrategyRepository(db), nil, database.NewAuditLogRepository(db), string(testKey))
+	manifest, code := testManifest()
+
+	mock.ExpectQuery(`FROM strategy_packages WHERE name = \? AND author = \?`).
+		WithArgs("Threshold", "alice").
+		WillReturnRows(strategyPackageColumns)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO strategy_packages`).
+		WithArgs(testutil.AnyArg, "Threshold", testutil.AnyArg, "alice", false, testutil.AnyArg, testutil.AnyArg)
+	mock.ExpectExec(`INSERT INTO strategy_versions`).
+		WithArgs(testutil.AnyArg, testutil.AnyArg, "1.0.0", testCode, nil, false, testutil.AnyArg, testutil.AnyArg)
+	mock.ExpectExec(`INSERT INTO strategy_params`).
+		WithArgs(testutil.AnyArg, testutil.AnyArg, "threshold", "float", "100", nil, nil, nil, []byte(nil), nil, false, testutil.AnyArg, testutil.AnyArg)
+	// The version is activated in the transaction that creates it
+	mock.ExpectQuery(`SELECT id FROM strategy_versions`).WillReturnRows([]string{"id"})
+	mock.ExpectExec(`UPDATE strategy_versions SET is_active = false`)
+	mock.ExpectExec(`UPDATE strategy_versions SET is_active = true`)
+	mock.ExpectExec(`INSERT INTO strategy_version_activations`)
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_logs`).
+		WithArgs(testutil.AnyArg, database.AuditLevelInfo, strategy.AuditCategoryStrategy, "Activated version 1.0.0", testutil.AnyArg, nil, nil, nil, testutil.AnyArg, testutil.AnyArg)
+
+	result, err := service.Import(context.Background(), writeArchive(t, manifest, code, testKey))
+
+	require.NoError(t, err)
+	assert.True(t, result.PackageCreated)
+	assert.NotEmpty(t, result.PackageID)
+	require.Len(t, result.Versions, 1)
+	assert.Equal(t, VersionCreated, result.Versions[0].Status)
+	assert.Equal(t, result.Versions[0].VersionID, result.ActiveVersionID)
+}
+
+func TestService_ImportRejectsChangedVersionWithSameLabel(t *testing.T) {
+	db, mock := testutil.NewSQLMock(t)
+	service := NewService(database.NewStrategyRepository(db), nil, database.NewAuditLogRepository(db), string(testKey))
+	manifest, code := testManifest()
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM strategy_packages WHERE name = \? AND author = \?`).
+		WillReturnRows(strategyPackageColumns, []interface{}{"p1", "Threshold", nil, "alice", false, now, now})
+	mock.ExpectQuery(`FROM strategy_versions WHERE package_id = \?`).
+		WithArgs("p1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "pass\n", nil, true, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns)
+
+	_, err := service.Import(context.Background(), writeArchive(t, manifest, code, testKey))
+
+	assert.ErrorIs(t, err, ErrConflict)
+}
@@ -0,0 +1,313 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// ErrConflict is returned when an archived version has the label of an
+// existing version of the package but different content
+var ErrConflict = errors.New("strategy archive conflicts with an existing version")
+
+// Statuses of imported versions
+const (
+	VersionCreated   = "created"   // the version was added to the package
+	VersionUnchanged = "unchanged" // the package has the version with the same content
+	VersionDuplicate = "duplicate" // the package has the same content under another label
+)
+
+// ImportResult reports what an import did
+type ImportResult struct {
+	PackageID       string            `json:"package_id"`
+	PackageCreated  bool              `json:"package_created"`
+	ActiveVersionID string            `json:"active_version_id,omitempty"`
+	Versions        []ImportedVersion `json:"versions"`
+}
+
+// ImportedVersion is the outcome of importing one archived version
+type ImportedVersion struct {
+	Version   string `json:"version"`
+	VersionID string `json:"version_id"`
+	Status    string `json:"status"`
+}
+
+// Service exports strategy packages to archives and imports them
+type Service struct {
+	strategies *database.StrategyRepository
+	backtests  *database.BacktestRepository
+	auditLogs  *database.AuditLogRepository
+	key        []byte
+}
+
+// NewService creates an archive service signing archives with key. Versions
+// activated by imports are written to auditLogs when it is set.
+func NewService(strategies *database.StrategyRepository, backtests *database.BacktestRepository, auditLogs *database.AuditLogRepository, key string) *Service {
+	return &Service{strategies: strategies, backtests: backtests, auditLogs: auditLogs, key: []byte(key)}
+}
+
+// Export archives a package with all of its versions and their parameters,
+// and optionally the summary of its latest completed backtest. Unknown
+// packages return sql.ErrNoRows.
+func (s *Service) Export(ctx context.Context, packageID string, includeBacktest bool) ([]byte, *Manifest, error) {
+	pkg, err := s.strategies.GetPackageByID(ctx, packageID)
+	if err != nil {
+		return nil, nil, err
+	}
+	versions, err := s.strategies.ListVersionsByPackageID(ctx, packageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest := &Manifest{
+		ExportedAt:      time.Now().UTC().Truncate(time.Second),
+		SourcePackageID: pkg.ID,
+		Package: Package{
+			Name:        pkg.Name,
+			Description: pkg.Description,
+			Author:      pkg.Author,
+			IsPublic:    pkg.IsPublic,
+		},
+		Versions: make([]Version, 0, len(versions)),
+	}
+	code := make(map[string]string, len(versions))
+
+	// Versions are listed newest first; archive them in creation order
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		params, err := s.strategies.GetParamsByVersionID(ctx, version.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		archived := Version{
+			Version:     version.Version,
+			Description: version.Description,
+			IsActive:    version.IsActive,
+			CodeFile:    CodeFile(version.Version),
+			Params:      make([]Param, 0, len(params)),
+		}
+		for _, param := range params {
+			archived.Params = append(archived.Params, NewParam(param))
+		}
+		manifest.Versions = append(manifest.Versions, archived)
+		code[archived.CodeFile] = version.Code
+	}
+
+	if includeBacktest && s.backtests != nil {
+		status := "completed"
+		backtests, err := s.backtests.ListBacktests(ctx, &packageID, &status, nil, 1, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(backtests) > 0 {
+			backtest := backtests[0]
+			manifest.LatestBacktest = &BacktestSummary{
+				Name:        backtest.Name,
+				Symbols:     backtest.Symbols,
+				StartDate:   backtest.StartDate,
+				EndDate:     backtest.EndDate,
+				Parameters:  backtest.Parameters,
+				Results:     backtest.Results,
+				CompletedAt: backtest.CompletedAt,
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, manifest, code, s.key); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), manifest, nil
+}
+
+// Import validates an archive and creates its package, or adds its new
+// versions to the package with the same name and author. Versions whose
+// content the package already has are not created again. The version active
+// in the archive becomes the active version in the same transaction, and its
+// activation is written to the audit log.
+func (s *Service) Import(ctx context.Context, data []byte) (*ImportResult, error) {
+	archive, err := Read(data, s.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(archive); err != nil {
+		return nil, err
+	}
+	manifest := archive.Manifest
+
+	pkg, err := s.strategies.GetPackageByNameAndAuthor(ctx, manifest.Package.Name, manifest.Package.Author)
+	if err == sql.ErrNoRows {
+		pkg = &database.StrategyPackage{Name: manifest.Package.Name, Author: manifest.Package.Author}
+	} else if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{PackageCreated: pkg.ID == ""}
+
+	// The versions the package has by label and by content hash
+	type existingVersion struct {
+		hash    string
+		version *database.StrategyVersion
+	}
+	byLabel := make(map[string]existingVersion)
+	byHash := make(map[string]*database.StrategyVersion)
+	if pkg.ID != "" {
+		existing, err := s.strategies.ListVersionsByPackageID(ctx, pkg.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range existing {
+			params, err := s.strategies.GetParamsByVersionID(ctx, version.ID)
+			if err != nil {
+				return nil, err
+			}
+			archived := make([]Param, len(params))
+			for i, param := range params {
+				archived[i] = NewParam(param)
+			}
+			hash := ContentHash(version.Code, archived)
+			byLabel[version.Version] = existingVersion{hash, version}
+			byHash[hash] = version
+		}
+	}
+
+	// Resolve every archived version to an existing version or a new one
+	var created []*database.StrategyVersion
+	var createdParams [][]*database.StrategyParam
+	resolved := make([]*database.StrategyVersion, len(manifest.Versions))
+	statuses := make([]string, len(manifest.Versions))
+	for i, archived := range manifest.Versions {
+		existing, exists := byLabel[archived.Version]
+		switch {
+		case exists && existing.hash == archived.ContentHash:
+			resolved[i], statuses[i] = existing.version, VersionUnchanged
+		case exists:
+			return nil, fmt.Errorf("%w: version %s has different code or parameters", ErrConflict, archived.Version)
+		case byHash[archived.ContentHash] != nil:
+			resolved[i], statuses[i] = byHash[archived.ContentHash], VersionDuplicate
+		default:
+			version := &database.StrategyVersion{
+				Version:     archived.Version,
+				Code:        archive.Code[archived.CodeFile],
+				Description: archived.Description,
+			}
+			params := make([]*database.StrategyParam, len(archived.Params))
+			for j, param := range archived.Params {
+				params[j] = param.Definition()
+			}
+			created = append(created, version)
+			createdParams = append(createdParams, params)
+			byHash[archived.ContentHash] = version
+			resolved[i], statuses[i] = version, VersionCreated
+		}
+	}
+
+	pkg.Description = manifest.Package.Description
+	pkg.IsPublic = manifest.Package.IsPublic
+	var active *database.StrategyVersion
+	for i, archived := range manifest.Versions {
+		if archived.IsActive {
+			active = resolved[i]
+		}
+	}
+	previousID, err := s.strategies.SavePackageVersions(ctx, pkg, created, createdParams, active)
+	if err != nil {
+		return nil, err
+	}
+	result.PackageID = pkg.ID
+
+	for i, archived := range manifest.Versions {
+		result.Versions = append(result.Versions, ImportedVersion{Version: archived.Version, VersionID: resolved[i].ID, Status: statuses[i]})
+	}
+	if active != nil {
+		result.ActiveVersionID = active.ID
+		if previousID != active.ID {
+			s.auditActivation(ctx, pkg.ID, active, previousID)
+		}
+	}
+	return result, nil
+}
+
+// auditActivation records the activation of an imported version in the audit
+// log, as the version handlers do; failures are logged and do not fail the
+// import
+func (s *Service) auditActivation(ctx context.Context, packageID string, version *database.StrategyVersion, previousID string) {
+	if s.auditLogs == nil {
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"action":     "import",
+		"version_id": version.ID,
+	}
+	if previousID != "" {
+		metadata["previous_version_id"] = previousID
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("Failed to encode audit log metadata of strategy %s: %v", packageID, err)
+		return
+	}
+	entry := &database.AuditLog{
+		Level:      database.AuditLevelInfo,
+		Category:   strategy.AuditCategoryStrategy,
+		Message:    fmt.Sprintf("Activated version %s", version.Version),
+		StrategyID: &packageID,
+		Metadata:   data,
+	}
+	if err := s.auditLogs.CreateAuditLog(ctx, entry); err != nil {
+		log.Printf("Failed to record audit log of strategy %s: %v", packageID, err)
+	}
+}
+
+// validate checks that the versions of an archive have distinct labels,
+// valid parameter definitions and code that compiles against them
+func validate(archive *Archive) error {
+	manifest := archive.Manifest
+	if manifest.Package.Name == "" || manifest.Package.Author == "" {
+		return fmt.Errorf("%w: package name and author are required", ErrInvalidArchive)
+	}
+	if len(manifest.Versions) == 0 {
+		return fmt.Errorf("%w: no versions", ErrInvalidArchive)
+	}
+
+	labels := make(map[string]bool, len(manifest.Versions))
+	for _, version := range manifest.Versions {
+		if version.Version == "" || labels[version.Version] {
+			return fmt.Errorf("%w: missing or repeated version label %q", ErrInvalidArchive, version.Version)
+		}
+		labels[version.Version] = true
+
+		code := archive.Code[version.CodeFile]
+		if ContentHash(code, version.Params) != version.ContentHash {
+			return fmt.Errorf("%w: content of version %s does not match its hash", ErrInvalidArchive, version.Version)
+		}
+
+		names := make([]string, 0, len(version.Params))
+		seen := make(map[string]bool, len(version.Params))
+		for _, param := range version.Params {
+			if seen[param.Name] {
+				return fmt.Errorf("%w: version %s repeats parameter %s", ErrInvalidArchive, version.Version, param.Name)
+			}
+			seen[param.Name] = true
+			if err := strategy.ValidateParamDefinition(param.Definition()); err != nil {
+				return fmt.Errorf("%w: version %s: parameter %s: %v", ErrInvalidArchive, version.Version, param.Name, err)
+			}
+			names = append(names, param.Name)
+		}
+
+		for _, diagnostic := range strategy.Validate(version.CodeFile, code, names) {
+			if diagnostic.Severity == strategy.SeverityError {
+				return fmt.Errorf("%w: version %s: %d:%d: %s", ErrInvalidArchive, version.Version, diagnostic.Line, diagnostic.Column, diagnostic.Message)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// simulatedBroker executes the orders a strategy places during a backtest.
+// Orders rest in its order book until the bars fill them as their type and
+// the fill model decide; fills pay the fees of the commission model and
+// update the position, balance and trades of the backtest.
+type simulatedBroker struct {
+	state       *BacktestState
+	riskManager RiskManager
+	fills       FillModel
+	commissions CommissionModel
+	bars        map[string]Bar // current bar of each symbol
+	book        []*restingOrder
+	updates     []broker.OrderUpdate
+	nextID      int
+	nextFillID  int
+}
+
+func newSimulatedBroker(state *BacktestState, riskManager RiskManager, fills FillModel, commissions CommissionModel) *simulatedBroker {
+	return &simulatedBroker{
+		state:       state,
+		riskManager: riskManager,
+		fills:       fills,
+		commissions: commissions,
+		bars:        make(map[string]Bar),
+	}
+}
+
+// setBars makes the bars of a period the current bars of their symbols and
+// works the resting orders of those symbols that were placed on earlier bars
+func (b *simulatedBroker) setBars(bars strategy.AlignedBars) {
+	for symbol, bar := range bars.Bars {
+		b.bars[symbol] = bar
+	}
+	b.match(bars)
+}
+
+// PlaceOrder accepts an order into the order book. Unless the fill model
+// waits for the next bar, the order first works against the close of the
+// current bar of its symbol, where market orders fill and marketable limit
+// and stop orders fill as market orders would. Symbols without a bar yet and
+// orders the equity cannot cover the margin of are rejected.
+func (b *simulatedBroker) PlaceOrder(ctx context.Context, order *broker.Order) error {
+	bar, ok := b.bars[order.Symbol]
+	if !ok {
+		return fmt.Errorf("no bar for %s yet", order.Symbol)
+	}
+	if b.riskManager != nil {
+		if err := b.riskManager.CheckOrderRisk(ctx, order, b.state.Equity); err != nil {
+			return err
+		}
+	}
+	if err := b.checkMargin(order, order.Quantity, bar.Close); err != nil {
+		return err
+	}
+
+	b.nextID++
+	order.ID = fmt.Sprintf("backtest-%d", b.nextID)
+	order.Status = broker.OrderStatusSubmitted
+	order.CreatedAt = bar.Timestamp
+	order.UpdatedAt = bar.Timestamp
+
+	o := newRestingOrder(*order, bar)
+	if !b.fills.NextBar() {
+		b.work(o, Bar{Timestamp: bar.Timestamp, Open: bar.Close, High: bar.Close, Low: bar.Close, Close: bar.Close, Volume: bar.Volume})
+	}
+	if o.working() {
+		b.book = append(b.book, o)
+	}
+	return nil
+}
+
+// execute fills quantity of a resting order at price on bar and queues the
+// update for the script
+func (b *simulatedBroker) execute(o *restingOrder, bar Bar, price, quantity float64) {
+	if quantity <= 0 {
+		return
+	}
+	order := &o.order
+	if err := b.checkMargin(order, quantity, price); err != nil {
+		order.Status = broker.OrderStatusRejected
+		order.UpdatedAt = bar.Timestamp
+		b.updates = append(b.updates, broker.OrderUpdate{Order: *order, Reason: err.Error(), Timestamp: bar.Timestamp})
+		return
+	}
+
+	commission := b.commissions.Commission(order.Side, quantity, price)
+	b.state.applyFill(order.Symbol, order.Side, quantity, price, commission, bar.Timestamp)
+	if b.riskManager != nil {
+		b.riskManager.UpdatePosition(order.Symbol, quantity, price, string(order.Side))
+	}
+
+	o.notional += quantity * price
+	order.FilledQuantity += quantity
+	average := o.notional / order.FilledQuantity
+	order.AvgFillPrice = &average
+	order.Status = broker.OrderStatusPartial
+	if order.FilledQuantity >= order.Quantity {
+		order.Status = broker.OrderStatusFilled
+	}
+	order.UpdatedAt = bar.Timestamp
+
+	b.nextFillID++
+	b.updates = append(b.updates, broker.OrderUpdate{
+		Order: *order,
+		Fill: &broker.Trade{
+			ID:         fmt.Sprintf("backtest-fill-%d", b.nextFillID),
+			OrderID:    order.ID,
+			Symbol:     order.Symbol,
+			Side:       order.Side,
+			Quantity:   quantity,
+			Price:      price,
+			Commission: commission,
+			TradeTime:  bar.Timestamp,
+		},
+		Timestamp: bar.Timestamp,
+	})
+}
+
+// checkMargin returns an error when filling quantity of an order at price
+// would leave the equity short of the margin the positions need, valued at
+// the current closes. Fills that reduce a position always pass.
+func (b *simulatedBroker) checkMargin(order *broker.Order, quantity, price float64) error {
+	ledger := b.state.Ledger
+	if !ledger.marginEnabled() {
+		return nil
+	}
+	delta := quantity
+	if order.Side == broker.OrderSideSell {
+		delta = -quantity
+	}
+	if net := ledger.Net(order.Symbol); math.Abs(net+delta) <= math.Abs(net) {
+		return nil
+	}
+
+	prices := make(map[string]float64, len(b.bars))
+	for symbol, bar := range b.bars {
+		prices[symbol] = bar.Close
+	}
+	prices[order.Symbol] = price
+	equity := b.state.Balance + ledger.UnrealizedPnL(prices)
+	if required := ledger.MarginRequirement(prices, order.Symbol, delta); required > equity {
+		return fmt.Errorf("insufficient margin: positions would need %.2f of equity, have %.2f", required, equity)
+	}
+	return nil
+}
+
+// GetAccountInfo reports the backtest's equity as the account balance
+func (b *simulatedBroker) GetAccountInfo(context.Context) (map[string]interface{}, error) {
+	return map[string]interface{}{"balance": b.state.Equity}, nil
+}
+
+// GetLastTick reports no ticks, so price() reads the script's latest bar
+func (b *simulatedBroker) GetLastTick(string) (broker.MarketData, bool) {
+	return broker.MarketData{}, false
+}
+
+// NetPosition returns the signed quantity of the open position in symbol
+func (b *simulatedBroker) NetPosition(symbol string) float64 {
+	return b.state.Ledger.Net(symbol)
+}
+
+// NextUpdate pops the oldest order update not yet delivered to the script
+func (b *simulatedBroker) NextUpdate() (broker.OrderUpdate, bool) {
+	if len(b.updates) == 0 {
+		return broker.OrderUpdate{}, false
+	}
+	update := b.updates[0]
+	b.updates = b.updates[1:]
+	return update, true
+}
+
+// applyFill books a fill in the ledger, pays its commission and realizes the
+// PnL of the lots it closes, recording their trades
+func (state *BacktestState) applyFill(symbol string, side broker.OrderSide, quantity, price, commission float64, at time.Time) {
+	state.Balance -= commission
+	trades, realized := state.Ledger.Fill(symbol, side, quantity, price, commission, at)
+	state.Balance += realized
+	for _, trade := range trades {
+		trade.ID = fmt.Sprintf("trade-%d", len(state.Trades)+1)
+		state.Trades = append(state.Trades, trade)
+	}
+}
@@ -0,0 +1,171 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/moomoo-trading/api/internal/broker"
+)
+
+// CommissionModel decides the fees charged for a fill
+type CommissionModel interface {
+	Commission(side broker.OrderSide, quantity, price float64) float64
+	String() string
+}
+
+// Commission models
+const (
+	CommissionNone     = "none"
+	CommissionPerShare = "per_share"
+	CommissionPercent  = "percent"
+	CommissionMoomooUS = "moomoo_us"
+	CommissionMoomooJP = "moomoo_jp"
+)
+
+// CommissionConfig selects the commission model of a backtest. Rate, Minimum
+// and Maximum configure the per_share and percent models; a zero Maximum
+// leaves fees uncapped.
+type CommissionConfig struct {
+	Model   string  `json:"model,omitempty"`   // none (default), per_share, percent, moomoo_us or moomoo_jp
+	Rate    float64 `json:"rate,omitempty"`    // per share, or a fraction of the notional
+	Minimum float64 `json:"minimum,omitempty"` // per fill
+	Maximum float64 `json:"maximum,omitempty"` // per fill
+}
+
+// NewCommissionModel builds the commission model a configuration selects
+func NewCommissionModel(config CommissionConfig) (CommissionModel, error) {
+	if config.Rate < 0 || config.Minimum < 0 || config.Maximum < 0 {
+		return nil, fmt.Errorf("commission rate, minimum and maximum must not be negative")
+	}
+	if config.Maximum > 0 && config.Maximum < config.Minimum {
+		return nil, fmt.Errorf("commission maximum %g is below the minimum %g", config.Maximum, config.Minimum)
+	}
+
+	switch config.Model {
+	case "", CommissionNone:
+		return NoCommission{}, nil
+	case CommissionPerShare:
+		return PerShareCommission{Rate: config.Rate, Minimum: config.Minimum, Maximum: config.Maximum}, nil
+	case CommissionPercent:
+		return PercentCommission{Rate: config.Rate, Minimum: config.Minimum, Maximum: config.Maximum}, nil
+	case CommissionMoomooUS:
+		return MoomooUSCommission{}, nil
+	case CommissionMoomooJP:
+		return MoomooJPCommission{}, nil
+	}
+	return nil, fmt.Errorf("invalid commission model %q: must be none, per_share, percent, moomoo_us or moomoo_jp", config.Model)
+}
+
+// NoCommission charges nothing
+type NoCommission struct{}
+
+func (NoCommission) Commission(broker.OrderSide, float64, float64) float64 { return 0 }
+
+func (NoCommission) String() string { return CommissionNone }
+
+// PerShareCommission charges a fee per share, bounded per fill
+type PerShareCommission struct {
+	Rate    float64
+	Minimum float64
+	Maximum float64
+}
+
+func (m PerShareCommission) Commission(_ broker.OrderSide, quantity, _ float64) float64 {
+	return roundCents(bound(m.Rate*quantity, m.Minimum, m.Maximum))
+}
+
+func (m PerShareCommission) String() string {
+	return fmt.Sprintf("%s(%g/share)", CommissionPerShare, m.Rate)
+}
+
+// PercentCommission charges a fraction of the notional, bounded per fill
+type PercentCommission struct {
+	Rate    float64
+	Minimum float64
+	Maximum float64
+}
+
+func (m PercentCommission) Commission(_ broker.OrderSide, quantity, price float64) float64 {
+	return roundCents(bound(m.Rate*quantity*price, m.Minimum, m.Maximum))
+}
+
+func (m PercentCommission) String() string {
+	return fmt.Sprintf("%s(%g%%)", CommissionPercent, m.Rate*100)
+}
+
+// US regulatory fees charged on sells, passed through by both Moomoo schedules
+const (
+	secFeeRate      = 0.0000278 // SEC section 31 fee, per dollar sold
+	finraTAFRate    = 0.000166  // FINRA trading activity fee, per share sold
+	finraTAFMaximum = 8.30
+)
+
+// Moomoo US fees for US stocks: no commission, and a platform fee per share
+// with a minimum per order, capped at a share of the trade value
+const (
+	moomooUSPlatformRate    = 0.005
+	moomooUSPlatformMinimum = 1.00
+	moomooUSPlatformCap     = 0.01
+)
+
+// Moomoo Japan fees for US stocks: a share of the notional including
+// consumption tax, capped per order
+const (
+	moomooJPRate    = 0.00132
+	moomooJPMaximum = 22.00
+)
+
+// MoomooUSCommission is the fee schedule of a Moomoo US account trading US
+// stocks: the platform fee plus the SEC fee and FINRA trading activity fee on
+// sells. Fees are in USD.
+type MoomooUSCommission struct{}
+
+func (MoomooUSCommission) Commission(side broker.OrderSide, quantity, price float64) float64 {
+	platform := bound(moomooUSPlatformRate*quantity, moomooUSPlatformMinimum, moomooUSPlatformCap*quantity*price)
+	return roundCents(platform + regulatoryFees(side, quantity, price))
+}
+
+func (MoomooUSCommission) String() string { return CommissionMoomooUS }
+
+// MoomooJPCommission is the fee schedule of a Moomoo Japan account trading US
+// stocks: 0.132% of the notional including tax, at most 22 USD per fill, plus
+// the SEC fee and FINRA trading activity fee on sells
+type MoomooJPCommission struct{}
+
+func (MoomooJPCommission) Commission(side broker.OrderSide, quantity, price float64) float64 {
+	commission := bound(moomooJPRate*quantity*price, 0, moomooJPMaximum)
+	return roundCents(roundCents(commission) + regulatoryFees(side, quantity, price))
+}
+
+func (MoomooJPCommission) String() string { return CommissionMoomooJP }
+
+// regulatoryFees returns the US regulatory fees of a fill, each rounded up to
+// the cent as brokers pass them on
+func regulatoryFees(side broker.OrderSide, quantity, price float64) float64 {
+	if side != broker.OrderSideSell {
+		return 0
+	}
+	return ceilCents(secFeeRate*quantity*price) + ceilCents(math.Min(finraTAFRate*quantity, finraTAFMaximum))
+}
+
+// bound clamps a fee to a minimum and, when positive, a maximum
+func bound(fee, minimum, maximum float64) float64 {
+	if fee < minimum {
+		fee = minimum
+	}
+	if maximum > 0 && fee > maximum {
+		fee = maximum
+	}
+	return fee
+}
+
+// roundCents rounds a fee to the cent
+func roundCents(fee float64) float64 {
+	return math.Round(fee*100) / 100
+}
+
+// ceilCents rounds a fee up to the cent, ignoring floating-point noise below
+// a millionth of a cent
+func ceilCents(fee float64) float64 {
+	return math.Ceil(fee*100-1e-6) / 100
+}
@@ -0,0 +1,37 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// StoredBars provides the minute bars stored in MySQL to backtests
+type StoredBars struct {
+	bars *database.BarRepository
+}
+
+// NewStoredBars creates a data provider reading bars from a repository
+func NewStoredBars(bars *database.BarRepository) *StoredBars {
+	return &StoredBars{bars: bars}
+}
+
+// GetHistoricalData returns the minute bars of a symbol from startDate up to
+// but excluding endDate
+func (s *StoredBars) GetHistoricalData(symbol string, startDate, endDate time.Time, interval string) ([]Bar, error) {
+	if interval != strategy.DefaultTimeframe {
+		return nil, fmt.Errorf("only %s bars are stored", strategy.DefaultTimeframe)
+	}
+	stored, err := s.bars.GetBars(context.Background(), symbol, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	bars := make([]Bar, len(stored))
+	for i, bar := range stored {
+		bars[i] = Bar{Timestamp: bar.Timestamp, Open: bar.Open, High: bar.High, Low: bar.Low, Close: bar.Close, Volume: bar.Volume}
+	}
+	return bars, nil
+}
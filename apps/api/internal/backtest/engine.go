@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
+	"github.com/moomoo-trading/api/internal/analytics"
 	"github.com/moomoo-trading/api/internal/broker"
 	"github.com/moomoo-trading/api/internal/strategy"
 )
@@ -27,24 +29,30 @@ type RiskManager interface {
 	UpdatePosition(symbol string, quantity float64, price float64, side string)
 }
 
-// Bar represents a price bar
-type Bar struct {
-	Timestamp time.Time `json:"timestamp"`
-	Open      float64   `json:"open"`
-	High      float64   `json:"high"`
-	Low       float64   `json:"low"`
-	Close     float64   `json:"close"`
-	Volume    float64   `json:"volume"`
+// Bar represents a price bar. It is the strategy bar type so backtests feed
+// scripts exactly the bars live trading does.
+type Bar = strategy.Bar
+
+// SymbolBar is a bar of one of the symbols of a portfolio backtest
+type SymbolBar struct {
+	Symbol string
+	Bar
 }
 
 // BacktestConfig contains backtest configuration
 type BacktestConfig struct {
 	Symbol        string                 `json:"symbol"`
+	Symbols       []string               `json:"symbols,omitempty"` // portfolio strategies; defaults to the strategy's symbols
 	StartDate     time.Time              `json:"start_date"`
 	EndDate       time.Time              `json:"end_date"`
 	InitialBalance float64               `json:"initial_balance"`
 	Strategy      *strategy.Strategy     `json:"strategy"`
 	Parameters    map[string]interface{} `json:"parameters"`
+	Fill          FillConfig             `json:"fill"`
+	Commission    CommissionConfig       `json:"commission"`
+	Margin        MarginConfig           `json:"margin"`
+	Analytics     AnalyticsConfig        `json:"analytics"`
+	Progress      func(done, total int)  `json:"-"` // called with the number of bars replayed, if set
 }
 
 // BacktestResult contains the results of a backtest
@@ -53,40 +61,35 @@ type BacktestResult struct {
 	Trades           []Trade         `json:"trades"`
 	Equity           []EquityPoint   `json:"equity"`
 	Performance      *Performance    `json:"performance"`
+	FillModel        string          `json:"fill_model"`       // the fill model the trades were simulated with
+	CommissionModel  string          `json:"commission_model"` // the commission model their fees were charged with
 	CompletedAt      time.Time       `json:"completed_at"`
 }
 
-// Trade represents a trade in the backtest
-type Trade struct {
-	ID          string    `json:"id"`
-	Symbol      string    `json:"symbol"`
-	Side        string    `json:"side"`
-	Quantity    float64   `json:"quantity"`
-	EntryPrice  float64   `json:"entry_price"`
-	ExitPrice   float64   `json:"exit_price"`
-	EntryTime   time.Time `json:"entry_time"`
-	ExitTime    time.Time `json:"exit_time"`
-	PnL         float64   `json:"pnl"`
-	Commission  float64   `json:"commission"`
-}
+// Trade, EquityPoint and Performance are the analytics types, so results
+// feed the tearsheet as they are
+type (
+	Trade       = analytics.Trade
+	EquityPoint = analytics.EquityPoint
+	Performance = analytics.Performance
+)
 
-// EquityPoint represents an equity point in the backtest
-type EquityPoint struct {
-	Timestamp time.Time `json:"timestamp"`
-	Equity    float64   `json:"equity"`
-	Drawdown  float64   `json:"drawdown"`
+// AnalyticsConfig sets how the performance of a backtest is measured
+type AnalyticsConfig struct {
+	RiskFreeRate float64 `json:"risk_free_rate,omitempty"` // annual
+	Frequency    string  `json:"frequency,omitempty"`      // period of the returns of ratios, e.g. "1h"; daily by default
 }
 
-// Performance contains performance metrics
-type Performance struct {
-	TotalReturn     float64 `json:"total_return"`
-	AnnualizedReturn float64 `json:"annualized_return"`
-	SharpeRatio     float64 `json:"sharpe_ratio"`
-	MaxDrawdown     float64 `json:"max_drawdown"`
-	WinRate         float64 `json:"win_rate"`
-	ProfitFactor    float64 `json:"profit_factor"`
-	SQN             float64 `json:"sqn"` // System Quality Number
-	CAGR            float64 `json:"cagr"` // Compound Annual Growth Rate
+// config converts the configuration into its analytics form
+func (config AnalyticsConfig) config() (analytics.Config, error) {
+	frequency := 24 * time.Hour
+	if config.Frequency != "" {
+		var err error
+		if frequency, err = strategy.ParseTimeframe(config.Frequency); err != nil {
+			return analytics.Config{}, err
+		}
+	}
+	return analytics.Config{RiskFreeRate: config.RiskFreeRate, Frequency: frequency}, nil
 }
 
 // NewBacktestEngine creates a new backtest engine
@@ -99,29 +102,57 @@ func NewBacktestEngine(dataProvider DataProvider, riskManager RiskManager) *Back
 
 // RunBacktest runs a backtest with the given configuration
 func (be *BacktestEngine) RunBacktest(ctx context.Context, config *BacktestConfig) (*BacktestResult, error) {
-	log.Printf("Starting backtest for %s from %s to %s", 
-		config.Symbol, config.StartDate.Format("2006-01-02"), config.EndDate.Format("2006-01-02"))
-
-	// Get historical data
-	data, err := be.dataProvider.GetHistoricalData(
-		config.Symbol, 
-		config.StartDate, 
-		config.EndDate, 
-		"1m", // Default to 1-minute bars
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get historical data: %w", err)
-	}
+	log.Printf("Starting backtest for %v from %s to %s", 
+		config.symbols(), config.StartDate.Format("2006-01-02"), config.EndDate.Format("2006-01-02"))
 
 	// Initialize backtest state
 	state := &BacktestState{
 		Config:       config,
 		Balance:      config.InitialBalance,
 		Equity:       config.InitialBalance,
-		Position:     nil,
+		Ledger:       NewLedger(config.Margin),
 		Trades:       make([]Trade, 0),
 		EquityPoints: make([]EquityPoint, 0),
-		Bars:         data,
+	}
+
+	// Get historical data
+	var err error
+	if config.portfolio() {
+		state.SymbolBars, err = be.portfolioData(config)
+	} else {
+		state.Bars, err = be.dataProvider.GetHistoricalData(
+			config.Symbol, 
+			config.StartDate, 
+			config.EndDate, 
+			strategy.DefaultTimeframe, // Aggregated to the strategy's timeframe while replaying
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get historical data: %w", err)
+	}
+
+	// Load the strategy against a simulated broker
+	fills, err := NewFillModel(config.Fill)
+	if err != nil {
+		return nil, err
+	}
+	commissions, err := NewCommissionModel(config.Commission)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Margin.validate(); err != nil {
+		return nil, err
+	}
+	analyticsConfig, err := config.Analytics.config()
+	if err != nil {
+		return nil, err
+	}
+	if config.Strategy != nil {
+		state.broker = newSimulatedBroker(state, be.riskManager, fills, commissions)
+		state.simulation, err = strategy.NewSimulation(ctx, config.strategy(), state.broker, strategy.DefaultQuota)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load strategy: %w", err)
+		}
 	}
 
 	// Run the backtest
@@ -130,14 +161,16 @@ func (be *BacktestEngine) RunBacktest(ctx context.Context, config *BacktestConfi
 	}
 
 	// Calculate performance metrics
-	performance := be.calculatePerformance(state)
+	performance := analytics.Compute(config.InitialBalance, state.EquityPoints, state.Trades, analyticsConfig)
 
 	result := &BacktestResult{
-		Config:      config,
-		Trades:      state.Trades,
-		Equity:      state.EquityPoints,
-		Performance: performance,
-		CompletedAt: time.Now(),
+		Config:          config,
+		Trades:          state.Trades,
+		Equity:          state.EquityPoints,
+		Performance:     performance,
+		FillModel:       fills.String(),
+		CommissionModel: commissions.String(),
+		CompletedAt:     time.Now(),
 	}
 
 	log.Printf("Backtest completed. Total return: %.2f%%, Max drawdown: %.2f%%", 
@@ -151,37 +184,155 @@ type BacktestState struct {
 	Config       *BacktestConfig
 	Balance      float64
 	Equity       float64
-	Position     *Position
+	Ledger       *Ledger // open positions by symbol
 	Trades       []Trade
 	EquityPoints []EquityPoint
 	Bars         []Bar
+	SymbolBars   []SymbolBar // bars of every symbol of a portfolio backtest in time order
 	CurrentBar   int
+	simulation   *strategy.Simulation
+	broker       *simulatedBroker
 }
 
-// Position represents a position during backtesting
-type Position struct {
-	Symbol   string
-	Quantity float64
-	Side     string
-	EntryPrice float64
-	EntryTime time.Time
+// portfolio reports whether the backtest runs a portfolio strategy
+func (config *BacktestConfig) portfolio() bool {
+	return config.Strategy != nil && config.Strategy.IsPortfolio()
+}
+
+// strategy returns the strategy of the backtest with the backtest's
+// parameters overriding its own
+func (config *BacktestConfig) strategy() *strategy.Strategy {
+	s := *config.Strategy
+	s.Parameters = make(map[string]interface{}, len(config.Strategy.Parameters)+len(config.Parameters))
+	for name, value := range config.Strategy.Parameters {
+		s.Parameters[name] = value
+	}
+	for name, value := range config.Parameters {
+		s.Parameters[name] = value
+	}
+	return &s
+}
+
+// symbols returns the symbols the backtest trades
+func (config *BacktestConfig) symbols() []string {
+	if !config.portfolio() {
+		return []string{config.Symbol}
+	}
+	if len(config.Symbols) > 0 {
+		return config.Symbols
+	}
+	return config.Strategy.Symbols
+}
+
+// portfolioData loads the history of every symbol of a portfolio backtest and
+// merges it in time order, ties broken by symbol
+func (be *BacktestEngine) portfolioData(config *BacktestConfig) ([]SymbolBar, error) {
+	var bars []SymbolBar
+	for _, symbol := range config.symbols() {
+		data, err := be.dataProvider.GetHistoricalData(symbol, config.StartDate, config.EndDate, strategy.DefaultTimeframe)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", symbol, err)
+		}
+		for _, bar := range data {
+			bars = append(bars, SymbolBar{Symbol: symbol, Bar: bar})
+		}
+	}
+	sort.SliceStable(bars, func(i, j int) bool {
+		if !bars[i].Timestamp.Equal(bars[j].Timestamp) {
+			return bars[i].Timestamp.Before(bars[j].Timestamp)
+		}
+		return bars[i].Symbol < bars[j].Symbol
+	})
+	return bars, nil
 }
 
 // runBacktest executes the backtest
 func (be *BacktestEngine) runBacktest(ctx context.Context, state *BacktestState) error {
-	// TODO: Implement actual strategy execution
-	// This is a placeholder for the actual backtest logic
+	// Bars reach the strategy through the same feed live ticks do
+	timeframe := ""
+	if state.Config.Strategy != nil {
+		timeframe = state.Config.Strategy.Timeframe
+	}
+	if state.Config.portfolio() {
+		return be.runPortfolio(ctx, state)
+	}
+	feed, err := strategy.NewBarFeed(timeframe)
+	if err != nil {
+		return err
+	}
 
 	for i, bar := range state.Bars {
 		state.CurrentBar = i
 
-		// Update equity
+		// Execute strategy logic once a bar of the strategy's timeframe completes
+		if completed, ok := feed.AddBar(state.Config.Symbol, bar); ok {
+			if err := be.executeStrategy(ctx, state, state.single(completed)); err != nil {
+				return fmt.Errorf("strategy execution failed: %w", err)
+			}
+		}
+
+		// Update equity, including the fills of the strategy at the completed bar
 		state.updateEquity(bar)
+		state.reportProgress(i+1, len(state.Bars))
 
-		// Execute strategy logic
-		if err := be.executeStrategy(state, bar); err != nil {
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+	}
+
+	for _, bar := range feed.Flush(time.Time{}) {
+		if err := be.executeStrategy(ctx, state, state.single(bar)); err != nil {
 			return fmt.Errorf("strategy execution failed: %w", err)
 		}
+	}
+
+	// Revalue the last bar with the fills of the final period, made after it was valued
+	if len(state.Bars) > 0 {
+		state.EquityPoints = state.EquityPoints[:len(state.EquityPoints)-1]
+		state.updateEquity(state.Bars[len(state.Bars)-1])
+	}
+
+	return nil
+}
+
+// runPortfolio executes a portfolio backtest. The bars of all symbols are
+// replayed in time order and reach the strategy aligned by timestamp, as in
+// live trading; a period is complete once the data has moved past its end.
+func (be *BacktestEngine) runPortfolio(ctx context.Context, state *BacktestState) error {
+	config := state.Config
+	feed, err := strategy.NewPortfolioFeed(config.Strategy.Timeframe, config.symbols(), config.Strategy.MissingBars)
+	if err != nil {
+		return err
+	}
+	execute := func(groups []strategy.AlignedBars) error {
+		for _, group := range groups {
+			if err := be.executeStrategy(ctx, state, group); err != nil {
+				return fmt.Errorf("strategy execution failed: %w", err)
+			}
+		}
+		return nil
+	}
+
+	closes := make(map[string]float64)
+	for i, bar := range state.SymbolBars {
+		state.CurrentBar = i
+
+		if err := execute(feed.Flush(bar.Timestamp)); err != nil {
+			return err
+		}
+		if err := execute(feed.AddBar(bar.Symbol, bar.Bar)); err != nil {
+			return err
+		}
+
+		// Update equity once every symbol's bar of the timestamp is in
+		closes[bar.Symbol] = bar.Close
+		if i+1 == len(state.SymbolBars) || !state.SymbolBars[i+1].Timestamp.Equal(bar.Timestamp) {
+			state.markToMarket(bar.Timestamp, closes)
+		}
+		state.reportProgress(i+1, len(state.SymbolBars))
 
 		// Check for context cancellation
 		select {
@@ -191,53 +342,69 @@ func (be *BacktestEngine) runBacktest(ctx context.Context, state *BacktestState)
 		}
 	}
 
+	if err := execute(feed.Flush(time.Time{})); err != nil {
+		return err
+	}
+
+	// Revalue the last timestamp with the fills of the final periods, made after it was valued
+	if n := len(state.SymbolBars); n > 0 {
+		state.EquityPoints = state.EquityPoints[:len(state.EquityPoints)-1]
+		state.markToMarket(state.SymbolBars[n-1].Timestamp, closes)
+	}
 	return nil
 }
 
-// executeStrategy executes the strategy logic for a bar
-func (be *BacktestEngine) executeStrategy(state *BacktestState, bar Bar) error {
-	// TODO: Implement actual strategy execution
-	// This would involve:
-	// 1. Running the Starlark code
-	// 2. Checking for signals
-	// 3. Executing orders
-	// 4. Updating positions
+// single returns a bar of a single-symbol backtest as the bars of its period
+func (state *BacktestState) single(bar Bar) strategy.AlignedBars {
+	return strategy.AlignedBars{Timestamp: bar.Timestamp, Bars: map[string]Bar{state.Config.Symbol: bar}}
+}
 
-	return nil
+// executeStrategy executes the strategy logic for the bars of a period: the
+// bar of the backtest's symbol, or the aligned bars of a portfolio. Orders
+// waiting for these bars fill before the script sees them.
+func (be *BacktestEngine) executeStrategy(ctx context.Context, state *BacktestState, bars strategy.AlignedBars) error {
+	if state.simulation == nil {
+		return nil
+	}
+	state.broker.setBars(bars)
+	return state.simulation.Step(ctx, bars)
 }
 
-// updateEquity updates the equity curve
-func (state *BacktestState) updateEquity(bar Bar) {
-	// Calculate current equity including unrealized PnL
-	equity := state.Balance
-	if state.Position != nil {
-		unrealizedPnL := state.calculateUnrealizedPnL(bar.Close)
-		equity += unrealizedPnL
+// reportProgress reports the number of bars replayed out of total
+func (state *BacktestState) reportProgress(done, total int) {
+	if state.Config.Progress != nil {
+		state.Config.Progress(done, total)
 	}
+}
 
+// updateEquity updates the equity curve of a single-symbol backtest with the
+// close of bar
+func (state *BacktestState) updateEquity(bar Bar) {
+	state.markToMarket(bar.Timestamp, map[string]float64{state.Config.Symbol: bar.Close})
+}
+
+// markToMarket charges the borrow fees accrued up to timestamp and updates
+// the equity curve with the latest close of each symbol
+func (state *BacktestState) markToMarket(timestamp time.Time, closes map[string]float64) {
+	state.Balance -= state.Ledger.AccrueBorrowFees(timestamp, closes)
+
+	// Calculate current equity including unrealized PnL
+	equity := state.Balance + state.Ledger.UnrealizedPnL(closes)
 	state.Equity = equity
+	exposure := 0.0
+	if equity > 0 {
+		exposure = state.Ledger.GrossValue(closes) / equity
+	}
 
 	// Add equity point
 	state.EquityPoints = append(state.EquityPoints, EquityPoint{
-		Timestamp: bar.Timestamp,
+		Timestamp: timestamp,
 		Equity:    equity,
 		Drawdown:  state.calculateDrawdown(equity),
+		Exposure:  exposure,
 	})
 }
 
-// calculateUnrealizedPnL calculates unrealized PnL for current position
-func (state *BacktestState) calculateUnrealizedPnL(currentPrice float64) float64 {
-	if state.Position == nil {
-		return 0
-	}
-
-	if state.Position.Side == "LONG" {
-		return (currentPrice - state.Position.EntryPrice) * state.Position.Quantity
-	} else {
-		return (state.Position.EntryPrice - currentPrice) * state.Position.Quantity
-	}
-}
-
 // calculateDrawdown calculates the current drawdown
 func (state *BacktestState) calculateDrawdown(currentEquity float64) float64 {
 	// Find peak equity
@@ -254,82 +421,3 @@ func (state *BacktestState) calculateDrawdown(currentEquity float64) float64 {
 
 	return (peak - currentEquity) / peak
 }
-
-// calculatePerformance calculates performance metrics
-func (be *BacktestEngine) calculatePerformance(state *BacktestState) *Performance {
-	if len(state.EquityPoints) == 0 {
-		return &Performance{}
-	}
-
-	initialEquity := state.Config.InitialBalance
-	finalEquity := state.EquityPoints[len(state.EquityPoints)-1].Equity
-
-	// Calculate basic metrics
-	totalReturn := (finalEquity - initialEquity) / initialEquity
-
-	// Calculate max drawdown
-	maxDrawdown := 0.0
-	peak := initialEquity
-	for _, point := range state.EquityPoints {
-		if point.Equity > peak {
-			peak = point.Equity
-		}
-		drawdown := (peak - point.Equity) / peak
-		if drawdown > maxDrawdown {
-			maxDrawdown = drawdown
-		}
-	}
-
-	// Calculate win rate
-	wins := 0
-	totalTrades := len(state.Trades)
-	for _, trade := range state.Trades {
-		if trade.PnL > 0 {
-			wins++
-		}
-	}
-	winRate := 0.0
-	if totalTrades > 0 {
-		winRate = float64(wins) / float64(totalTrades)
-	}
-
-	// Calculate profit factor
-	grossProfit := 0.0
-	grossLoss := 0.0
-	for _, trade := range state.Trades {
-		if trade.PnL > 0 {
-			grossProfit += trade.PnL
-		} else {
-			grossLoss += -trade.PnL
-		}
-	}
-	profitFactor := 0.0
-	if grossLoss > 0 {
-		profitFactor = grossProfit / grossLoss
-	}
-
-	// Calculate annualized return
-	duration := state.EquityPoints[len(state.EquityPoints)-1].Timestamp.Sub(state.EquityPoints[0].Timestamp)
-	years := duration.Hours() / 8760 // 8760 hours in a year
-	annualizedReturn := 0.0
-	if years > 0 {
-		annualizedReturn = (1 + totalReturn) / years
-	}
-
-	// Calculate CAGR
-	cagr := 0.0
-	if years > 0 {
-		cagr = (finalEquity / initialEquity) - 1
-		cagr = (1 + cagr) / years
-	}
-
-	return &Performance{
-		TotalReturn:      totalReturn,
-		AnnualizedReturn: annualizedReturn,
-		MaxDrawdown:      maxDrawdown,
-		WinRate:          winRate,
-		ProfitFactor:     profitFactor,
-		CAGR:             cagr,
-		// TODO: Calculate Sharpe ratio and SQN
-	}
-}
\ No newline at end of file
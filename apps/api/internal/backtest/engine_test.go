@@ -0,0 +1,310 @@
+package backtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testStart = time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+// staticData serves fixed closes per symbol as one-minute bars
+type staticData map[string][]float64
+
+func (d staticData) GetHistoricalData(symbol string, startDate, endDate time.Time, interval string) ([]Bar, error) {
+	bars := make([]Bar, len(d[symbol]))
+	for i, close := range d[symbol] {
+		bars[i] = Bar{Timestamp: testStart.Add(time.Duration(i) * time.Minute), Open: close, High: close, Low: close, Close: close, Volume: 1000}
+	}
+	return bars, nil
+}
+
+const thresholdStrategy = `
+def on_bar(symbol, bar):
+    if bar.close <= buy_below and position(symbol) <= 0:
+        order(symbol, "BUY", "MARKET", quantity - position(symbol))
+    elif bar.close >= sell_above and position(symbol) >= 0:
+        order(symbol, "SELL", "MARKET", quantity + position(symbol))
+`
+
+func runTestBacktest(t *testing.T, data staticData, config *BacktestConfig) *BacktestResult {
+	t.Helper()
+	config.StartDate = testStart
+	config.EndDate = testStart.Add(time.Hour)
+	if config.InitialBalance == 0 {
+		config.InitialBalance = 10000
+	}
+	result, err := NewBacktestEngine(data, nil).RunBacktest(context.Background(), config)
+	require.NoError(t, err)
+	return result
+}
+
+func TestRunBacktest_RecordsRoundTripsAndFlips(t *testing.T) {
+	result := runTestBacktest(t, staticData{"AAPL": {100, 102, 110, 104, 95, 97}}, &BacktestConfig{
+		Symbol: "AAPL",
+		Strategy: &strategy.Strategy{
+			ID:         "threshold",
+			Code:       thresholdStrategy,
+			Parameters: map[string]interface{}{"buy_below": 100, "sell_above": 110, "quantity": 10},
+		},
+	})
+
+	// Long 10 at 100, reversed to short 10 at 110, reversed to long 10 at 95
+	require.Len(t, result.Trades, 2)
+	assert.Equal(t, Trade{
+		ID: "trade-1", Symbol: "AAPL", Side: SideLong, Quantity: 10,
+		EntryPrice: 100, ExitPrice: 110, EntryTime: testStart, ExitTime: testStart.Add(2 * time.Minute), PnL: 100,
+	}, result.Trades[0])
+	assert.Equal(t, Trade{
+		ID: "trade-2", Symbol: "AAPL", Side: SideShort, Quantity: 10,
+		EntryPrice: 110, ExitPrice: 95, EntryTime: testStart.Add(2 * time.Minute), ExitTime: testStart.Add(4 * time.Minute), PnL: 150,
+	}, result.Trades[1])
+
+	// The long opened at 95 is still open at 97
+	last := result.Equity[len(result.Equity)-1]
+	assert.Equal(t, 10000+250+20.0, last.Equity)
+	assert.Equal(t, 1.0, result.Performance.WinRate)
+}
+
+func TestRunBacktest_IsReproducible(t *testing.T) {
+	data := staticData{"AAPL": {101, 99, 98, 103, 111, 108, 99, 112, 100}}
+	config := func() *BacktestConfig {
+		return &BacktestConfig{
+			Symbol: "AAPL",
+			Strategy: &strategy.Strategy{
+				Code:       thresholdStrategy,
+				Parameters: map[string]interface{}{"buy_below": 100, "sell_above": 110, "quantity": 5},
+			},
+			Parameters: map[string]interface{}{"quantity": 7},
+		}
+	}
+
+	first := runTestBacktest(t, data, config())
+	second := runTestBacktest(t, data, config())
+
+	require.NotEmpty(t, first.Trades)
+	assert.Equal(t, 7.0, first.Trades[0].Quantity, "backtest parameters override the strategy's")
+	assert.Equal(t, first.Trades, second.Trades)
+	assert.Equal(t, first.Equity, second.Equity)
+	assert.Equal(t, first.Performance, second.Performance)
+}
+
+func TestRunBacktest_RejectedOrdersReachTheScript(t *testing.T) {
+	result := runTestBacktest(t, staticData{"AAPL": {100, 101}}, &BacktestConfig{
+		Symbol: "AAPL",
+		Strategy: &strategy.Strategy{Code: `
+def on_bar(symbol, bar):
+    result = order("MSFT", "BUY", "MARKET", 1)
+    if not result.accepted:
+        order(symbol, "BUY", "MARKET", 1)
+`},
+	})
+
+	assert.Empty(t, result.Trades)
+	assert.Equal(t, 10000+1.0, result.Equity[len(result.Equity)-1].Equity)
+}
+
+func TestRunBacktest_PortfolioTradesOnAlignedBars(t *testing.T) {
+	result := runTestBacktest(t, staticData{"AAPL": {100, 105, 110}, "MSFT": {200, 190, 180}}, &BacktestConfig{
+		Strategy: &strategy.Strategy{
+			Mode:    strategy.ModePortfolio,
+			Symbols: []string{"AAPL", "MSFT"},
+			Code: `
+def on_bars(bars_by_symbol):
+    aapl = bars_by_symbol["AAPL"]
+    if aapl.close == 100:
+        order("AAPL", "BUY", "MARKET", 10)
+    elif aapl.close == 110:
+        order("AAPL", "SELL", "MARKET", 10)
+`,
+		},
+	})
+
+	require.Len(t, result.Trades, 1)
+	assert.Equal(t, "AAPL", result.Trades[0].Symbol)
+	assert.Equal(t, 100.0, result.Trades[0].PnL)
+}
+
+func TestRunBacktest_CompileErrorsFailTheBacktest(t *testing.T) {
+	_, err := NewBacktestEngine(staticData{}, nil).RunBacktest(context.Background(), &BacktestConfig{
+		Symbol:         "AAPL",
+		InitialBalance: 10000,
+		Strategy:       &strategy.Strategy{Code: "def on_bar(symbol, bar):\n    undefined()\n"},
+	})
+
+	var compileErr *strategy.CompileError
+	assert.ErrorAs(t, err, &compileErr)
+}
+
+// barData serves fixed one-minute bars per symbol
+type barData map[string][]Bar
+
+func (d barData) GetHistoricalData(symbol string, startDate, endDate time.Time, interval string) ([]Bar, error) {
+	bars := make([]Bar, len(d[symbol]))
+	for i, bar := range d[symbol] {
+		bar.Timestamp = testStart.Add(time.Duration(i) * time.Minute)
+		bars[i] = bar
+	}
+	return bars, nil
+}
+
+func TestRunBacktest_FillsAtNextOpenWithCommission(t *testing.T) {
+	bars := make([]Bar, 0, 5)
+	for _, ohlc := range [][2]float64{{99, 100}, {101, 102}, {103.5, 104}, {102, 103}, {104, 105}} {
+		bars = append(bars, Bar{Open: ohlc[0], High: ohlc[1], Low: ohlc[0], Close: ohlc[1], Volume: 1000})
+	}
+	result, err := NewBacktestEngine(barData{"AAPL": bars}, nil).RunBacktest(context.Background(), &BacktestConfig{
+		Symbol:         "AAPL",
+		InitialBalance: 10000,
+		Strategy: &strategy.Strategy{Code: `
+def on_bar(symbol, bar):
+    if bar.close == 100:
+        order(symbol, "BUY", "MARKET", 10)
+    elif bar.close == 104:
+        order(symbol, "SELL", "MARKET", 10)
+`},
+		Fill:       FillConfig{Model: FillNextOpen},
+		Commission: CommissionConfig{Model: CommissionPerShare, Rate: 0.1},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, result.Trades, 1)
+	trade := result.Trades[0]
+	assert.Equal(t, 101.0, trade.EntryPrice)
+	assert.Equal(t, 102.0, trade.ExitPrice)
+	assert.Equal(t, testStart.Add(time.Minute), trade.EntryTime)
+	assert.Equal(t, testStart.Add(3*time.Minute), trade.ExitTime)
+	assert.Equal(t, 2.0, trade.Commission)
+	assert.Equal(t, 8.0, trade.PnL)
+	assert.Equal(t, 10008.0, result.Equity[len(result.Equity)-1].Equity)
+	assert.Equal(t, "next_open", result.FillModel)
+	assert.Equal(t, "per_share(0.1/share)", result.CommissionModel)
+}
+
+func TestRunBacktest_ParticipationSpreadsFillsOverBars(t *testing.T) {
+	var bars []Bar
+	for _, close := range []float64{100, 101, 102, 110, 111, 112, 113} {
+		bars = append(bars, Bar{Open: close, High: close, Low: close, Close: close, Volume: 50})
+	}
+	result, err := NewBacktestEngine(barData{"AAPL": bars}, nil).RunBacktest(context.Background(), &BacktestConfig{
+		Symbol:         "AAPL",
+		InitialBalance: 10000,
+		Strategy: &strategy.Strategy{Code: `
+def on_bar(symbol, bar):
+    if bar.close == 100:
+        order(symbol, "BUY", "MARKET", 12)
+    elif bar.close == 110 and position(symbol) == 12:
+        order(symbol, "SELL", "MARKET", 12)
+`},
+		Fill: FillConfig{Participation: 0.1},
+	})
+	require.NoError(t, err)
+
+	// Bought 5 at 100, 5 at 101 and 2 at 102; sold 5 at 110, 5 at 111 and 2 at
+	// 112, each sell closing the oldest lot
+	require.Len(t, result.Trades, 3)
+	for i, want := range []struct{ quantity, entry, exit float64 }{{5, 100, 110}, {5, 101, 111}, {2, 102, 112}} {
+		assert.Equal(t, want.quantity, result.Trades[i].Quantity)
+		assert.Equal(t, want.entry, result.Trades[i].EntryPrice)
+		assert.Equal(t, want.exit, result.Trades[i].ExitPrice)
+	}
+	assert.InDelta(t, 10120, result.Equity[len(result.Equity)-1].Equity, 1e-9)
+}
+
+func TestRunBacktest_RestingOrdersFillOnLaterBars(t *testing.T) {
+	bars := []Bar{
+		{Open: 101, High: 101, Low: 100, Close: 100, Volume: 1000},
+		{Open: 99, High: 100, Low: 97, Close: 98, Volume: 1000},
+		{Open: 99, High: 103, Low: 97, Close: 102, Volume: 1000},
+		{Open: 102, High: 102, Low: 100, Close: 100.5, Volume: 1000},
+	}
+	result, err := NewBacktestEngine(barData{"AAPL": bars}, nil).RunBacktest(context.Background(), &BacktestConfig{
+		Symbol:         "AAPL",
+		InitialBalance: 10000,
+		Strategy: &strategy.Strategy{Code: `
+def on_bar(symbol, bar):
+    if bar.close == 100 and position(symbol) == 0:
+        order(symbol, "BUY", "LIMIT", 10, price=98, time_in_force="GTC")
+
+def on_order_fill(fill):
+    if fill.side == "BUY":
+        order(fill.symbol, "SELL", "TRAILING", 10, trail_amount=2)
+`},
+	})
+	require.NoError(t, err)
+
+	// The limit fills as the second bar trades through 98; the trailing stop
+	// rises to 101 with the third bar's high and triggers on the fourth
+	require.Len(t, result.Trades, 1)
+	assert.Equal(t, 98.0, result.Trades[0].EntryPrice)
+	assert.Equal(t, 101.0, result.Trades[0].ExitPrice)
+	assert.Equal(t, testStart.Add(3*time.Minute), result.Trades[0].ExitTime)
+	assert.Equal(t, 10030.0, result.Equity[len(result.Equity)-1].Equity)
+}
+
+func TestRunBacktest_MarginLimitsPositions(t *testing.T) {
+	result := runTestBacktest(t, staticData{"AAPL": {100, 101, 102}}, &BacktestConfig{
+		Symbol: "AAPL",
+		Strategy: &strategy.Strategy{Code: `
+def on_bar(symbol, bar):
+    if bar.close == 100:
+        result = order(symbol, "SELL", "MARKET", 150)
+        if not result.accepted:
+            order(symbol, "SELL", "MARKET", 100)
+`},
+		Margin: MarginConfig{ShortMargin: 1, BorrowRate: 0.5},
+	})
+
+	// Shorting 150 at 100 needs 15000 of equity; 100 fits in 10000
+	last := result.Equity[len(result.Equity)-1]
+	assert.Less(t, last.Equity, 10000-200.0, "short 100 from 100 to 102 pays borrow fees on top")
+	assert.Greater(t, last.Equity, 10000-201.0)
+}
+
+func TestRunBacktest_PortfolioHoldsSeveralPositions(t *testing.T) {
+	result := runTestBacktest(t, staticData{"AAPL": {100, 105, 110}, "MSFT": {200, 190, 180}}, &BacktestConfig{
+		Strategy: &strategy.Strategy{
+			Mode:    strategy.ModePortfolio,
+			Symbols: []string{"AAPL", "MSFT"},
+			Code: `
+def on_bars(bars_by_symbol):
+    if bars_by_symbol["AAPL"].close == 100:
+        order("AAPL", "BUY", "MARKET", 10)
+        order("MSFT", "SELL", "MARKET", 5)
+    elif bars_by_symbol["AAPL"].close == 105:
+        order("AAPL", "BUY", "MARKET", 10)
+`,
+		},
+	})
+
+	// Long 10 at 100 and 10 at 105 valued at 110, short 5 from 200 valued at 180
+	assert.Empty(t, result.Trades)
+	assert.Equal(t, 10000+100+50+100.0, result.Equity[len(result.Equity)-1].Equity)
+}
+
+func TestRunBacktest_ReportsTheTearsheet(t *testing.T) {
+	result := runTestBacktest(t, staticData{"AAPL": {100, 102, 110, 104, 95, 97}}, &BacktestConfig{
+		Symbol: "AAPL",
+		Strategy: &strategy.Strategy{
+			Code:       thresholdStrategy,
+			Parameters: map[string]interface{}{"buy_below": 100, "sell_above": 110, "quantity": 10},
+		},
+		Analytics: AnalyticsConfig{Frequency: "1m"},
+	})
+
+	performance := result.Performance
+	assert.Equal(t, 2, performance.TotalTrades)
+	assert.Equal(t, 125.0, performance.Expectancy)
+	assert.Equal(t, 2, performance.MaxConsecutiveWins)
+	assert.InDelta(t, 5.0/6, performance.Exposure, 1e-12, "the first bar completes, and buys, as the second arrives")
+	assert.Positive(t, performance.SharpeRatio)
+
+	_, err := NewBacktestEngine(staticData{}, nil).RunBacktest(context.Background(), &BacktestConfig{
+		Symbol: "AAPL", InitialBalance: 10000, Analytics: AnalyticsConfig{Frequency: "weekly"},
+	})
+	assert.Error(t, err)
+}
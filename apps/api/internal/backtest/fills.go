@@ -0,0 +1,186 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/moomoo-trading/api/internal/broker"
+)
+
+// FillModel decides when, at what price and for how much of its quantity a
+// market order fills. Orders whose own terms set their price, such as limit
+// and triggered stop orders, take only its slippage and volume cap.
+type FillModel interface {
+	// NextBar reports whether orders fill from the bar after the one that
+	// placed them rather than at that bar
+	NextBar() bool
+
+	// Fill returns the price of a fill of up to remaining on bar and the
+	// quantity filled. Quantity left unfilled is offered again on later bars.
+	Fill(side broker.OrderSide, remaining float64, bar Bar) (price, quantity float64)
+
+	// FillAt is Fill for an order filling at price on bar. Slippage applies
+	// only with slip, so limit prices are never worsened.
+	FillAt(side broker.OrderSide, remaining, price float64, bar Bar, slip bool) (float64, float64)
+
+	String() string
+}
+
+// Fill price models
+const (
+	FillClose    = "close"
+	FillNextOpen = "next_open"
+	FillVWAP     = "vwap"
+)
+
+// FillConfig selects the fill model of a backtest. Slippage and spread are
+// charged against the order on top of the price model, and a participation
+// rate caps each fill at a share of the bar's volume.
+type FillConfig struct {
+	Model         string  `json:"model,omitempty"`         // close (default), next_open or vwap
+	SlippageBps   float64 `json:"slippage_bps,omitempty"`  // fixed slippage in basis points of the price
+	SpreadBps     float64 `json:"spread_bps,omitempty"`    // bid-ask spread in basis points; orders pay half of it
+	Participation float64 `json:"participation,omitempty"` // largest share of a bar's volume one order may fill, 0 for no cap
+}
+
+// NewFillModel builds the fill model a configuration selects
+func NewFillModel(config FillConfig) (FillModel, error) {
+	var model FillModel
+	switch config.Model {
+	case "", FillClose:
+		model = CloseFill{}
+	case FillNextOpen:
+		model = NextOpenFill{}
+	case FillVWAP:
+		model = VWAPFill{}
+	default:
+		return nil, fmt.Errorf("invalid fill model %q: must be close, next_open or vwap", config.Model)
+	}
+
+	if config.SlippageBps < 0 || config.SpreadBps < 0 {
+		return nil, fmt.Errorf("slippage and spread must not be negative")
+	}
+	if bps := config.SlippageBps + config.SpreadBps/2; bps > 0 {
+		model = SlippageFill{Base: model, Bps: bps}
+	}
+
+	if config.Participation < 0 || config.Participation > 1 {
+		return nil, fmt.Errorf("participation must be between 0 and 1, got %g", config.Participation)
+	}
+	if config.Participation > 0 {
+		model = ParticipationFill{Base: model, Rate: config.Participation}
+	}
+	return model, nil
+}
+
+// CloseFill fills orders in full at the close of the bar that placed them
+type CloseFill struct{}
+
+func (CloseFill) NextBar() bool { return false }
+
+func (CloseFill) Fill(_ broker.OrderSide, remaining float64, bar Bar) (float64, float64) {
+	return bar.Close, remaining
+}
+
+func (CloseFill) FillAt(_ broker.OrderSide, remaining, price float64, _ Bar, _ bool) (float64, float64) {
+	return price, remaining
+}
+
+func (CloseFill) String() string { return FillClose }
+
+// NextOpenFill fills orders in full at the open of the bar after the one that
+// placed them, the first price a script reacting to a close could trade at
+type NextOpenFill struct{}
+
+func (NextOpenFill) NextBar() bool { return true }
+
+func (NextOpenFill) Fill(_ broker.OrderSide, remaining float64, bar Bar) (float64, float64) {
+	return bar.Open, remaining
+}
+
+func (NextOpenFill) FillAt(_ broker.OrderSide, remaining, price float64, _ Bar, _ bool) (float64, float64) {
+	return price, remaining
+}
+
+func (NextOpenFill) String() string { return FillNextOpen }
+
+// VWAPFill fills orders in full over the bar after the one that placed them,
+// at that bar's typical price (high + low + close) / 3 as an estimate of its
+// volume-weighted average price
+type VWAPFill struct{}
+
+func (VWAPFill) NextBar() bool { return true }
+
+func (VWAPFill) Fill(_ broker.OrderSide, remaining float64, bar Bar) (float64, float64) {
+	return (bar.High + bar.Low + bar.Close) / 3, remaining
+}
+
+func (VWAPFill) FillAt(_ broker.OrderSide, remaining, price float64, _ Bar, _ bool) (float64, float64) {
+	return price, remaining
+}
+
+func (VWAPFill) String() string { return FillVWAP }
+
+// SlippageFill worsens the prices of a base model by a fixed number of basis
+// points: buys pay more and sells receive less
+type SlippageFill struct {
+	Base FillModel
+	Bps  float64
+}
+
+func (m SlippageFill) NextBar() bool { return m.Base.NextBar() }
+
+func (m SlippageFill) Fill(side broker.OrderSide, remaining float64, bar Bar) (float64, float64) {
+	price, quantity := m.Base.Fill(side, remaining, bar)
+	return m.slip(side, price), quantity
+}
+
+func (m SlippageFill) FillAt(side broker.OrderSide, remaining, price float64, bar Bar, slip bool) (float64, float64) {
+	price, quantity := m.Base.FillAt(side, remaining, price, bar, slip)
+	if slip {
+		price = m.slip(side, price)
+	}
+	return price, quantity
+}
+
+func (m SlippageFill) slip(side broker.OrderSide, price float64) float64 {
+	adjustment := price * m.Bps / 10000
+	if side == broker.OrderSideSell {
+		adjustment = -adjustment
+	}
+	return price + adjustment
+}
+
+func (m SlippageFill) String() string {
+	return fmt.Sprintf("%s+slippage(%gbps)", m.Base, m.Bps)
+}
+
+// ParticipationFill caps the fills of a base model at a share of each bar's
+// volume; the rest of an order waits for later bars. Bars without volume fill
+// nothing.
+type ParticipationFill struct {
+	Base FillModel
+	Rate float64
+}
+
+func (m ParticipationFill) NextBar() bool { return m.Base.NextBar() }
+
+func (m ParticipationFill) Fill(side broker.OrderSide, remaining float64, bar Bar) (float64, float64) {
+	price, quantity := m.Base.Fill(side, remaining, bar)
+	return price, m.limit(quantity, bar)
+}
+
+func (m ParticipationFill) FillAt(side broker.OrderSide, remaining, price float64, bar Bar, slip bool) (float64, float64) {
+	price, quantity := m.Base.FillAt(side, remaining, price, bar, slip)
+	return price, m.limit(quantity, bar)
+}
+
+// limit caps a fill to the rate of the bar's volume, in whole shares only so
+// an order never fills in dust
+func (m ParticipationFill) limit(quantity float64, bar Bar) float64 {
+	return math.Min(quantity, math.Floor(bar.Volume*m.Rate))
+}
+
+func (m ParticipationFill) String() string {
+	return fmt.Sprintf("%s+participation(%g%%)", m.Base, m.Rate*100)
+}
@@ -0,0 +1,270 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+)
+
+// Position sides
+const (
+	SideLong  = "LONG"
+	SideShort = "SHORT"
+)
+
+// borrowYear is the day count short borrow fees accrue over
+const borrowYear = 365 * 24 * time.Hour
+
+// MarginConfig sets what positions cost to hold and the equity they need.
+// Zero values charge no borrow fees and leave positions unlimited by margin.
+type MarginConfig struct {
+	BorrowRate  float64 `json:"borrow_rate,omitempty"`  // annual fee on the value of short positions, accrued over calendar time
+	LongMargin  float64 `json:"long_margin,omitempty"`  // share of the value of long positions equity must cover
+	ShortMargin float64 `json:"short_margin,omitempty"` // share of the value of short positions equity must cover
+}
+
+// validate checks a margin configuration
+func (config MarginConfig) validate() error {
+	if config.BorrowRate < 0 || config.LongMargin < 0 || config.ShortMargin < 0 {
+		return fmt.Errorf("borrow rate and margin requirements must not be negative")
+	}
+	return nil
+}
+
+// requirement returns the margin a net quantity of a symbol needs at price
+func (config MarginConfig) requirement(net, price float64) float64 {
+	if net < 0 {
+		return -net * price * config.ShortMargin
+	}
+	return net * price * config.LongMargin
+}
+
+// Lot is the open quantity of one fill of a position
+type Lot struct {
+	Quantity   float64
+	Price      float64
+	Time       time.Time
+	Commission float64   // entry commissions of the open quantity
+	BorrowFee  float64   // borrow fees accrued on the open quantity of a short lot
+	accruedAt  time.Time // time borrow fees are accrued up to
+}
+
+// Position is the open position of a backtest in one symbol: the lots of the
+// fills that opened it, oldest first, all on one side
+type Position struct {
+	Symbol string
+	Side   string
+	Lots   []Lot
+}
+
+// Quantity returns the open quantity of the position
+func (p *Position) Quantity() float64 {
+	quantity := 0.0
+	for _, lot := range p.Lots {
+		quantity += lot.Quantity
+	}
+	return quantity
+}
+
+// Net returns the open quantity of the position, negative when short
+func (p *Position) Net() float64 {
+	if p.Side == SideShort {
+		return -p.Quantity()
+	}
+	return p.Quantity()
+}
+
+// AveragePrice returns the average entry price of the open lots
+func (p *Position) AveragePrice() float64 {
+	quantity, cost := 0.0, 0.0
+	for _, lot := range p.Lots {
+		quantity += lot.Quantity
+		cost += lot.Quantity * lot.Price
+	}
+	if quantity == 0 {
+		return 0
+	}
+	return cost / quantity
+}
+
+// UnrealizedPnL returns the PnL of the open lots at price, before fees
+func (p *Position) UnrealizedPnL(price float64) float64 {
+	pnl := 0.0
+	for _, lot := range p.Lots {
+		pnl += (price - lot.Price) * lot.Quantity
+	}
+	if p.Side == SideShort {
+		return -pnl
+	}
+	return pnl
+}
+
+// Ledger books the fills of a backtest into a position per symbol. Fills on
+// the side of a position add a lot to it; fills on the other side close its
+// lots first in, first out, each closed lot or part of one making a trade,
+// and any remaining quantity opens a position on the other side.
+type Ledger struct {
+	positions map[string]*Position
+	margin    MarginConfig
+}
+
+// NewLedger creates an empty ledger
+func NewLedger(margin MarginConfig) *Ledger {
+	return &Ledger{positions: make(map[string]*Position), margin: margin}
+}
+
+// Position returns the open position in symbol, or nil when flat
+func (l *Ledger) Position(symbol string) *Position {
+	return l.positions[symbol]
+}
+
+// Net returns the net quantity held in symbol, negative when short
+func (l *Ledger) Net(symbol string) float64 {
+	if position := l.positions[symbol]; position != nil {
+		return position.Net()
+	}
+	return 0
+}
+
+// Symbols returns the symbols with an open position in symbol order
+func (l *Ledger) Symbols() []string {
+	symbols := make([]string, 0, len(l.positions))
+	for symbol := range l.positions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Fill books a fill and returns the trades of the lots it closed, without
+// IDs, and their PnL before fees. Trades carry the commissions of the
+// quantity they close, at entry and exit, and the borrow fees accrued on it,
+// and their PnL is net of both.
+func (l *Ledger) Fill(symbol string, side broker.OrderSide, quantity, price, commission float64, at time.Time) ([]Trade, float64) {
+	fillSide := SideLong
+	if side == broker.OrderSideSell {
+		fillSide = SideShort
+	}
+
+	var trades []Trade
+	realized := 0.0
+	position := l.positions[symbol]
+	if position != nil && position.Side != fillSide {
+		fillQuantity, fillCommission := quantity, commission
+		for len(position.Lots) > 0 && quantity > 0 {
+			lot := &position.Lots[0]
+			closed := math.Min(quantity, lot.Quantity)
+			entryCommission := lot.Commission * closed / lot.Quantity
+			exitCommission := fillCommission * closed / fillQuantity
+			borrowFee := lot.BorrowFee * closed / lot.Quantity
+			pnl := (price - lot.Price) * closed
+			if position.Side == SideShort {
+				pnl = -pnl
+			}
+			trades = append(trades, Trade{
+				Symbol:     symbol,
+				Side:       position.Side,
+				Quantity:   closed,
+				EntryPrice: lot.Price,
+				ExitPrice:  price,
+				EntryTime:  lot.Time,
+				ExitTime:   at,
+				PnL:        pnl - entryCommission - exitCommission - borrowFee,
+				Commission: entryCommission + exitCommission,
+				BorrowFee:  borrowFee,
+			})
+			realized += pnl
+
+			lot.Quantity -= closed
+			lot.Commission -= entryCommission
+			lot.BorrowFee -= borrowFee
+			quantity -= closed
+			commission -= exitCommission
+			if lot.Quantity == 0 {
+				position.Lots = position.Lots[1:]
+			}
+		}
+		if len(position.Lots) == 0 {
+			delete(l.positions, symbol)
+			position = nil
+		}
+	}
+
+	if quantity > 0 {
+		if position == nil {
+			position = &Position{Symbol: symbol, Side: fillSide}
+			l.positions[symbol] = position
+		}
+		position.Lots = append(position.Lots, Lot{Quantity: quantity, Price: price, Time: at, Commission: commission, accruedAt: at})
+	}
+	return trades, realized
+}
+
+// UnrealizedPnL returns the PnL of the open positions at prices, before fees
+func (l *Ledger) UnrealizedPnL(prices map[string]float64) float64 {
+	pnl := 0.0
+	for _, symbol := range l.Symbols() {
+		pnl += l.positions[symbol].UnrealizedPnL(prices[symbol])
+	}
+	return pnl
+}
+
+// GrossValue returns the value of the open positions at prices, long and
+// short alike
+func (l *Ledger) GrossValue(prices map[string]float64) float64 {
+	value := 0.0
+	for _, symbol := range l.Symbols() {
+		value += l.positions[symbol].Quantity() * prices[symbol]
+	}
+	return value
+}
+
+// AccrueBorrowFees accrues the borrow fees of short lots up to at, on their
+// value at prices, and returns the fees accrued
+func (l *Ledger) AccrueBorrowFees(at time.Time, prices map[string]float64) float64 {
+	if l.margin.BorrowRate == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, symbol := range l.Symbols() {
+		position := l.positions[symbol]
+		if position.Side != SideShort {
+			continue
+		}
+		price, ok := prices[symbol]
+		if !ok {
+			continue
+		}
+		for i := range position.Lots {
+			lot := &position.Lots[i]
+			if !at.After(lot.accruedAt) {
+				continue
+			}
+			fee := lot.Quantity * price * l.margin.BorrowRate * float64(at.Sub(lot.accruedAt)) / float64(borrowYear)
+			lot.BorrowFee += fee
+			lot.accruedAt = at
+			total += fee
+		}
+	}
+	return total
+}
+
+// MarginRequirement returns the margin the open positions need at prices
+// with delta added to the net quantity of symbol
+func (l *Ledger) MarginRequirement(prices map[string]float64, symbol string, delta float64) float64 {
+	required := l.margin.requirement(l.Net(symbol)+delta, prices[symbol])
+	for _, other := range l.Symbols() {
+		if other != symbol {
+			required += l.margin.requirement(l.Net(other), prices[other])
+		}
+	}
+	return required
+}
+
+// marginEnabled reports whether positions need margin
+func (l *Ledger) marginEnabled() bool {
+	return l.margin.LongMargin > 0 || l.margin.ShortMargin > 0
+}
@@ -0,0 +1,93 @@
+package backtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger_ClosesLotsFirstInFirstOut(t *testing.T) {
+	ledger := NewLedger(MarginConfig{})
+	t1, t2, t3 := testStart, testStart.Add(time.Minute), testStart.Add(2*time.Minute)
+	ledger.Fill("AAPL", broker.OrderSideBuy, 10, 100, 2, t1)
+	ledger.Fill("AAPL", broker.OrderSideBuy, 5, 110, 1, t2)
+	assert.Equal(t, 15.0, ledger.Net("AAPL"))
+	assert.InDelta(t, 1550.0/15, ledger.Position("AAPL").AveragePrice(), 1e-9)
+
+	trades, realized := ledger.Fill("AAPL", broker.OrderSideSell, 12, 120, 1.2, t3)
+	require.Len(t, trades, 2)
+	assert.Equal(t, Trade{
+		Symbol: "AAPL", Side: SideLong, Quantity: 10, EntryPrice: 100, ExitPrice: 120,
+		EntryTime: t1, ExitTime: t3, PnL: 200 - 2 - 1, Commission: 3,
+	}, trades[0])
+	assert.Equal(t, 2.0, trades[1].Quantity)
+	assert.Equal(t, 110.0, trades[1].EntryPrice)
+	assert.Equal(t, t2, trades[1].EntryTime)
+	assert.InDelta(t, 0.4+0.2, trades[1].Commission, 1e-9)
+	assert.InDelta(t, 20-0.6, trades[1].PnL, 1e-9)
+	assert.Equal(t, 220.0, realized)
+
+	position := ledger.Position("AAPL")
+	require.Len(t, position.Lots, 1)
+	assert.Equal(t, 3.0, position.Quantity())
+	assert.InDelta(t, 0.6, position.Lots[0].Commission, 1e-9)
+	assert.Equal(t, 30.0, position.UnrealizedPnL(120))
+}
+
+func TestLedger_FlipsThroughZero(t *testing.T) {
+	ledger := NewLedger(MarginConfig{})
+	ledger.Fill("AAPL", broker.OrderSideBuy, 3, 110, 0, testStart)
+
+	at := testStart.Add(time.Minute)
+	trades, realized := ledger.Fill("AAPL", broker.OrderSideSell, 5, 100, 5, at)
+	require.Len(t, trades, 1)
+	assert.Equal(t, 3.0, trades[0].Quantity)
+	assert.Equal(t, -30.0, realized)
+	assert.Equal(t, -30.0-3, trades[0].PnL, "the closing share of the commission")
+
+	position := ledger.Position("AAPL")
+	assert.Equal(t, SideShort, position.Side)
+	assert.Equal(t, -2.0, ledger.Net("AAPL"))
+	assert.Equal(t, []Lot{{Quantity: 2, Price: 100, Time: at, Commission: 2, accruedAt: at}}, position.Lots)
+	assert.Equal(t, 10.0, position.UnrealizedPnL(95))
+
+	ledger.Fill("AAPL", broker.OrderSideBuy, 2, 95, 0, at)
+	assert.Nil(t, ledger.Position("AAPL"))
+	assert.Empty(t, ledger.Symbols())
+}
+
+func TestLedger_ShortLotsAccrueBorrowFees(t *testing.T) {
+	day := 24 * time.Hour
+	ledger := NewLedger(MarginConfig{BorrowRate: 0.365}) // 0.1% a day
+	ledger.Fill("AAPL", broker.OrderSideSell, 100, 50, 0, testStart)
+	ledger.Fill("MSFT", broker.OrderSideBuy, 10, 300, 0, testStart)
+
+	// A day at 50, then two at 40; longs pay nothing
+	assert.InDelta(t, 5.0, ledger.AccrueBorrowFees(testStart.Add(day), map[string]float64{"AAPL": 50, "MSFT": 300}), 1e-9)
+	assert.InDelta(t, 8.0, ledger.AccrueBorrowFees(testStart.Add(3*day), map[string]float64{"AAPL": 40, "MSFT": 310}), 1e-9)
+	assert.Zero(t, ledger.AccrueBorrowFees(testStart.Add(3*day), map[string]float64{"AAPL": 40}))
+
+	trades, realized := ledger.Fill("AAPL", broker.OrderSideBuy, 40, 40, 0, testStart.Add(3*day))
+	require.Len(t, trades, 1)
+	assert.Equal(t, 400.0, realized)
+	assert.InDelta(t, 13*0.4, trades[0].BorrowFee, 1e-9)
+	assert.InDelta(t, 400-13*0.4, trades[0].PnL, 1e-9)
+	assert.InDelta(t, 13*0.6, ledger.Position("AAPL").Lots[0].BorrowFee, 1e-9)
+}
+
+func TestLedger_ValuesEveryPosition(t *testing.T) {
+	ledger := NewLedger(MarginConfig{LongMargin: 0.5, ShortMargin: 1})
+	ledger.Fill("AAPL", broker.OrderSideBuy, 10, 100, 0, testStart)
+	ledger.Fill("MSFT", broker.OrderSideSell, 5, 200, 0, testStart)
+	prices := map[string]float64{"AAPL": 110, "MSFT": 190}
+
+	assert.Equal(t, 100.0+50, ledger.UnrealizedPnL(prices))
+	assert.Equal(t, []string{"AAPL", "MSFT"}, ledger.Symbols())
+	assert.Equal(t, 550.0+950, ledger.MarginRequirement(prices, "AAPL", 0))
+	assert.Equal(t, 1100.0+950, ledger.MarginRequirement(prices, "AAPL", 10))
+	// Flipping AAPL short 5 needs the short requirement on it
+	assert.Equal(t, 550.0+950, ledger.MarginRequirement(prices, "AAPL", -15))
+}
@@ -0,0 +1,90 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFillModels(t *testing.T) {
+	bar := Bar{Open: 99, High: 103, Low: 97, Close: 102, Volume: 1500}
+
+	tests := []struct {
+		config   FillConfig
+		name     string
+		nextBar  bool
+		side     broker.OrderSide
+		price    float64
+		quantity float64
+	}{
+		{FillConfig{}, "close", false, broker.OrderSideBuy, 102, 400},
+		{FillConfig{Model: FillNextOpen}, "next_open", true, broker.OrderSideBuy, 99, 400},
+		{FillConfig{Model: FillVWAP}, "vwap", true, broker.OrderSideSell, 302.0 / 3, 400},
+		{FillConfig{SlippageBps: 10}, "close+slippage(10bps)", false, broker.OrderSideBuy, 102.102, 400},
+		{FillConfig{SlippageBps: 5, SpreadBps: 10}, "close+slippage(10bps)", false, broker.OrderSideSell, 101.898, 400},
+		{FillConfig{Model: FillNextOpen, Participation: 0.1}, "next_open+participation(10%)", true, broker.OrderSideBuy, 99, 150},
+	}
+	for _, tt := range tests {
+		model, err := NewFillModel(tt.config)
+		require.NoError(t, err)
+		assert.Equal(t, tt.name, model.String())
+		assert.Equal(t, tt.nextBar, model.NextBar(), tt.name)
+
+		price, quantity := model.Fill(tt.side, 400, bar)
+		assert.InDelta(t, tt.price, price, 1e-9, tt.name)
+		assert.Equal(t, tt.quantity, quantity, tt.name)
+	}
+
+	// Orders filling at their own price take only slippage, when asked, and the volume cap
+	model, err := NewFillModel(FillConfig{SlippageBps: 10, Participation: 0.1})
+	require.NoError(t, err)
+	price, quantity := model.FillAt(broker.OrderSideBuy, 400, 98, bar, true)
+	assert.InDelta(t, 98.098, price, 1e-9)
+	assert.Equal(t, 150.0, quantity)
+	price, _ = model.FillAt(broker.OrderSideBuy, 400, 98, bar, false)
+	assert.Equal(t, 98.0, price)
+
+	_, err = NewFillModel(FillConfig{Model: "midpoint"})
+	assert.Error(t, err)
+	_, err = NewFillModel(FillConfig{Participation: 1.5})
+	assert.Error(t, err)
+}
+
+func TestCommissionModels(t *testing.T) {
+	tests := []struct {
+		config   CommissionConfig
+		side     broker.OrderSide
+		quantity float64
+		price    float64
+		want     float64
+	}{
+		{CommissionConfig{}, broker.OrderSideBuy, 100, 150, 0},
+		{CommissionConfig{Model: CommissionPerShare, Rate: 0.005, Minimum: 1}, broker.OrderSideBuy, 100, 150, 1},
+		{CommissionConfig{Model: CommissionPerShare, Rate: 0.005, Minimum: 1}, broker.OrderSideBuy, 1000, 150, 5},
+		{CommissionConfig{Model: CommissionPerShare, Rate: 0.005, Maximum: 2}, broker.OrderSideBuy, 1000, 150, 2},
+		{CommissionConfig{Model: CommissionPercent, Rate: 0.001, Minimum: 1, Maximum: 20}, broker.OrderSideBuy, 10, 50, 1},
+		{CommissionConfig{Model: CommissionPercent, Rate: 0.001, Minimum: 1, Maximum: 20}, broker.OrderSideBuy, 100, 150, 15},
+		{CommissionConfig{Model: CommissionPercent, Rate: 0.001, Minimum: 1, Maximum: 20}, broker.OrderSideBuy, 1000, 150, 20},
+		// Platform fee at its minimum; sells add the SEC fee (0.417 -> 0.42) and FINRA TAF (0.0166 -> 0.02)
+		{CommissionConfig{Model: CommissionMoomooUS}, broker.OrderSideBuy, 100, 150, 1},
+		{CommissionConfig{Model: CommissionMoomooUS}, broker.OrderSideSell, 100, 150, 1.44},
+		// Platform fee capped at 1% of 5 dollars; TAF capped at 8.30
+		{CommissionConfig{Model: CommissionMoomooUS}, broker.OrderSideBuy, 10, 0.5, 0.05},
+		{CommissionConfig{Model: CommissionMoomooUS}, broker.OrderSideSell, 100000, 10, 500 + 27.80 + 8.30},
+		{CommissionConfig{Model: CommissionMoomooJP}, broker.OrderSideBuy, 100, 150, 19.80},
+		{CommissionConfig{Model: CommissionMoomooJP}, broker.OrderSideBuy, 1000, 150, 22},
+		{CommissionConfig{Model: CommissionMoomooJP}, broker.OrderSideSell, 100, 150, 20.24},
+	}
+	for _, tt := range tests {
+		model, err := NewCommissionModel(tt.config)
+		require.NoError(t, err)
+		assert.InDelta(t, tt.want, model.Commission(tt.side, tt.quantity, tt.price), 1e-9, "%s %s %g@%g", model, tt.side, tt.quantity, tt.price)
+	}
+
+	_, err := NewCommissionModel(CommissionConfig{Model: CommissionPercent, Minimum: 5, Maximum: 1})
+	assert.Error(t, err)
+	_, err = NewCommissionModel(CommissionConfig{Model: "flat"})
+	assert.Error(t, err)
+}
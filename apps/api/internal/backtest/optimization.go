@@ -0,0 +1,420 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/moomoo-trading/api/internal/analytics"
+)
+
+// Backtest kinds
+const (
+	KindBacktest     = "backtest"
+	KindOptimization = "optimization"
+)
+
+// Search methods of optimizations
+const (
+	SearchGrid   = "grid"
+	SearchRandom = "random"
+)
+
+// Objectives optimizations rank parameter sets by; higher is better
+const (
+	ObjectiveSharpe       = "sharpe"
+	ObjectiveSortino      = "sortino"
+	ObjectiveCAGR         = "cagr"
+	ObjectiveTotalReturn  = "total_return"
+	ObjectiveProfitFactor = "profit_factor"
+)
+
+// MaxOptimizationRuns bounds the backtests an optimization runs
+const MaxOptimizationRuns = 1000
+
+// DefaultOptimizationConcurrency is the number of backtests an optimization
+// runs at once unless set
+const DefaultOptimizationConcurrency = 4
+
+// ParamRange is the values an optimization tries for a parameter: Values,
+// or Min to Max in steps of Step
+type ParamRange struct {
+	Name   string        `json:"name"`
+	Values []interface{} `json:"values,omitempty"`
+	Min    float64       `json:"min,omitempty"`
+	Max    float64       `json:"max,omitempty"`
+	Step   float64       `json:"step,omitempty"`
+}
+
+// values returns the values of a range in order
+func (r ParamRange) values() ([]interface{}, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("optimized parameters must have a name")
+	}
+	if len(r.Values) > 0 {
+		return r.Values, nil
+	}
+	if r.Step <= 0 {
+		return nil, fmt.Errorf("parameter %s needs values or a positive step", r.Name)
+	}
+	if r.Max < r.Min {
+		return nil, fmt.Errorf("parameter %s has a max below its min", r.Name)
+	}
+	count := math.Floor((r.Max-r.Min)/r.Step+1e-9) + 1
+	if count > MaxOptimizationRuns {
+		return nil, fmt.Errorf("parameter %s has more than %d values", r.Name, MaxOptimizationRuns)
+	}
+	values := make([]interface{}, int(count))
+	for i := range values {
+		// Rounded so steps such as 0.1 give 0.3 rather than 0.30000000000000004
+		values[i] = math.Round((r.Min+float64(i)*r.Step)*1e9) / 1e9
+	}
+	return values, nil
+}
+
+// OptimizationConfig configures an optimization: the parameter sets it
+// backtests and how it ranks them. Grid search backtests every combination
+// of the ranges' values; random search backtests Samples combinations drawn
+// from them with Seed. Parameters not optimized keep the values given with
+// the backtest.
+type OptimizationConfig struct {
+	Method      string       `json:"method,omitempty"` // grid (default) or random
+	Parameters  []ParamRange `json:"parameters"`
+	Samples     int          `json:"samples,omitempty"`     // parameter sets random search draws
+	Seed        int64        `json:"seed,omitempty"`        // of random search
+	Objective   string       `json:"objective,omitempty"`   // sharpe (default), sortino, cagr, total_return or profit_factor
+	MinTrades   int          `json:"min_trades,omitempty"`  // trades a backtest needs to be ranked
+	Concurrency int          `json:"concurrency,omitempty"` // backtests run at once; 4 by default
+}
+
+// Validate checks an optimization configuration
+func (config *OptimizationConfig) Validate() error {
+	switch config.Method {
+	case "", SearchGrid:
+	case SearchRandom:
+		if config.Samples <= 0 || config.Samples > MaxOptimizationRuns {
+			return fmt.Errorf("random search needs between 1 and %d samples", MaxOptimizationRuns)
+		}
+	default:
+		return fmt.Errorf("invalid optimization method %q: must be grid or random", config.Method)
+	}
+	switch config.Objective {
+	case "", ObjectiveSharpe, ObjectiveSortino, ObjectiveCAGR, ObjectiveTotalReturn, ObjectiveProfitFactor:
+	default:
+		return fmt.Errorf("invalid objective %q: must be sharpe, sortino, cagr, total_return or profit_factor", config.Objective)
+	}
+	if config.MinTrades < 0 || config.Concurrency < 0 {
+		return fmt.Errorf("min trades and concurrency must not be negative")
+	}
+	_, _, err := config.combinations()
+	return err
+}
+
+func (config *OptimizationConfig) method() string {
+	if config.Method == "" {
+		return SearchGrid
+	}
+	return config.Method
+}
+
+func (config *OptimizationConfig) objective() string {
+	if config.Objective == "" {
+		return ObjectiveSharpe
+	}
+	return config.Objective
+}
+
+func (config *OptimizationConfig) concurrency() int {
+	if config.Concurrency <= 0 {
+		return DefaultOptimizationConcurrency
+	}
+	return config.Concurrency
+}
+
+// paramGrid is the values of the optimized parameters
+type paramGrid struct {
+	names  []string
+	values [][]interface{}
+}
+
+func (config *OptimizationConfig) grid() (*paramGrid, error) {
+	if len(config.Parameters) == 0 {
+		return nil, fmt.Errorf("optimizations need parameters to optimize")
+	}
+	grid := &paramGrid{}
+	seen := make(map[string]bool, len(config.Parameters))
+	for _, r := range config.Parameters {
+		values, err := r.values()
+		if err != nil {
+			return nil, err
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("parameter %s is optimized twice", r.Name)
+		}
+		seen[r.Name] = true
+		grid.names = append(grid.names, r.Name)
+		grid.values = append(grid.values, values)
+	}
+	return grid, nil
+}
+
+// size returns the number of combinations of the grid, or -1 when there are
+// more than fit in an int
+func (g *paramGrid) size() int {
+	size := 1
+	for _, values := range g.values {
+		if size > math.MaxInt32/len(values) {
+			return -1
+		}
+		size *= len(values)
+	}
+	return size
+}
+
+// combination returns the value indexes of the index-th combination, the
+// last parameter varying fastest
+func (g *paramGrid) combination(index int) []int {
+	combination := make([]int, len(g.values))
+	for i := len(g.values) - 1; i >= 0; i-- {
+		combination[i] = index % len(g.values[i])
+		index /= len(g.values[i])
+	}
+	return combination
+}
+
+// params returns the parameter values of a combination
+func (g *paramGrid) params(combination []int) map[string]interface{} {
+	params := make(map[string]interface{}, len(g.names))
+	for i, name := range g.names {
+		params[name] = g.values[i][combination[i]]
+	}
+	return params
+}
+
+// combinations returns the grid and the combinations of it an optimization
+// backtests, in order
+func (config *OptimizationConfig) combinations() (*paramGrid, [][]int, error) {
+	grid, err := config.grid()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := grid.size()
+
+	if config.method() == SearchGrid {
+		if size < 0 || size > MaxOptimizationRuns {
+			return nil, nil, fmt.Errorf("grid has more than %d parameter sets; narrow the ranges or use random search", MaxOptimizationRuns)
+		}
+		combinations := make([][]int, size)
+		for i := range combinations {
+			combinations[i] = grid.combination(i)
+		}
+		return grid, combinations, nil
+	}
+
+	// Random search draws distinct combinations; grids no larger than the
+	// samples are backtested whole
+	if size >= 0 && size <= config.Samples {
+		combinations := make([][]int, size)
+		for i := range combinations {
+			combinations[i] = grid.combination(i)
+		}
+		return grid, combinations, nil
+	}
+	random := rand.New(rand.NewSource(config.Seed))
+	drawn := make(map[string]bool, config.Samples)
+	var combinations [][]int
+	for len(combinations) < config.Samples {
+		combination := make([]int, len(grid.values))
+		for i, values := range grid.values {
+			combination[i] = random.Intn(len(values))
+		}
+		key := fmt.Sprint(combination)
+		if drawn[key] {
+			continue
+		}
+		drawn[key] = true
+		combinations = append(combinations, combination)
+	}
+	return grid, combinations, nil
+}
+
+// ParameterSets returns the parameter sets an optimization backtests in
+// order: base with the values of a combination of the optimized parameters
+func (config *OptimizationConfig) ParameterSets(base map[string]interface{}) ([]map[string]interface{}, error) {
+	grid, combinations, err := config.combinations()
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]map[string]interface{}, len(combinations))
+	for i, combination := range combinations {
+		sets[i] = mergeParams(base, grid.params(combination))
+	}
+	return sets, nil
+}
+
+// mergeParams returns base with the values of params
+func mergeParams(base, params map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(params))
+	for name, value := range base {
+		merged[name] = value
+	}
+	for name, value := range params {
+		merged[name] = value
+	}
+	return merged
+}
+
+// Metrics are the metrics a backtest is ranked and compared by. Metrics of
+// backtests over several symbols are averaged over the symbols, and their
+// trades added up; the profit factor is that of all their trades.
+type Metrics struct {
+	Objective        float64 `json:"objective"`
+	SharpeRatio      float64 `json:"sharpe_ratio"`
+	SortinoRatio     float64 `json:"sortino_ratio"`
+	CAGR             float64 `json:"cagr"`
+	AnnualizedReturn float64 `json:"annualized_return"`
+	TotalReturn      float64 `json:"total_return"`
+	ProfitFactor     float64 `json:"profit_factor"`
+	MaxDrawdown      float64 `json:"max_drawdown"`
+	TotalTrades      int     `json:"total_trades"`
+}
+
+// measure records the metrics of a report
+func (metrics *Metrics) measure(report *Report, objective string) {
+	if len(report.Results) == 0 {
+		return
+	}
+	n := float64(len(report.Results))
+	var trades []Trade
+	for _, result := range report.Results {
+		performance := result.Performance
+		metrics.SharpeRatio += performance.SharpeRatio / n
+		metrics.SortinoRatio += performance.SortinoRatio / n
+		metrics.CAGR += performance.CAGR / n
+		metrics.AnnualizedReturn += performance.AnnualizedReturn / n
+		metrics.TotalReturn += performance.TotalReturn / n
+		metrics.MaxDrawdown += performance.MaxDrawdown / n
+		metrics.TotalTrades += performance.TotalTrades
+		trades = append(trades, result.Trades...)
+	}
+	// Trades without losses have analytics.MaxProfitFactor, the best value
+	metrics.ProfitFactor = analytics.ProfitFactor(trades)
+	switch objective {
+	case ObjectiveSortino:
+		metrics.Objective = metrics.SortinoRatio
+	case ObjectiveCAGR:
+		metrics.Objective = metrics.CAGR
+	case ObjectiveTotalReturn:
+		metrics.Objective = metrics.TotalReturn
+	case ObjectiveProfitFactor:
+		metrics.Objective = metrics.ProfitFactor
+	default:
+		metrics.Objective = metrics.SharpeRatio
+	}
+}
+
+// OptimizationRun is the outcome of the backtest of one parameter set
+type OptimizationRun struct {
+	BacktestID string                 `json:"backtest_id,omitempty"`
+	Parameters map[string]interface{} `json:"parameters"` // the optimized parameters
+	Status     string                 `json:"status"`
+	Error      string                 `json:"error,omitempty"`
+	Rank       int                    `json:"rank,omitempty"` // 1 for the best; runs that failed or made too few trades are not ranked
+	Metrics
+
+	combination []int
+}
+
+// Heatmap is a 2D slice of an optimization over two of its parameters. Each
+// cell holds the best objective of the ranked runs with those values of X
+// and Y, whatever the values of the other parameters, or null without one.
+type Heatmap struct {
+	X       string        `json:"x"`
+	Y       string        `json:"y"`
+	XValues []interface{} `json:"x_values"`
+	YValues []interface{} `json:"y_values"`
+	Values  [][]*float64  `json:"values"` // by Y value, then X value
+}
+
+// OptimizationReport is the outcome of an optimization, stored as its
+// results
+type OptimizationReport struct {
+	Method    string             `json:"method"`
+	Objective string             `json:"objective"`
+	MinTrades int                `json:"min_trades"`
+	Runs      []*OptimizationRun `json:"runs"` // ranked runs best first, then the others
+	Heatmaps  []*Heatmap         `json:"heatmaps"`
+}
+
+// Best returns the best ranked run, or nil when no run was ranked
+func (report *OptimizationReport) Best() *OptimizationRun {
+	if len(report.Runs) == 0 || report.Runs[0].Rank == 0 {
+		return nil
+	}
+	return report.Runs[0]
+}
+
+// newOptimizationReport ranks the runs of an optimization and slices them
+// into heatmaps
+func newOptimizationReport(config *OptimizationConfig, grid *paramGrid, runs []*OptimizationRun) *OptimizationReport {
+	report := &OptimizationReport{
+		Method:    config.method(),
+		Objective: config.objective(),
+		MinTrades: config.MinTrades,
+		Runs:      make([]*OptimizationRun, len(runs)),
+		Heatmaps:  []*Heatmap{},
+	}
+	copy(report.Runs, runs)
+
+	ranked := func(run *OptimizationRun) bool {
+		return run.Status == "completed" && run.TotalTrades >= config.MinTrades && !math.IsNaN(run.Objective)
+	}
+	sort.SliceStable(report.Runs, func(i, j int) bool {
+		a, b := report.Runs[i], report.Runs[j]
+		if ranked(a) != ranked(b) {
+			return ranked(a)
+		}
+		if !ranked(a) {
+			return false
+		}
+		// Ties, e.g. runs without losses at the capped profit factor, go to
+		// the higher total return
+		if a.Objective != b.Objective {
+			return a.Objective > b.Objective
+		}
+		return a.TotalReturn > b.TotalReturn
+	})
+	for i, run := range report.Runs {
+		if ranked(run) {
+			run.Rank = i + 1
+		}
+	}
+
+	for x := 0; x < len(grid.names); x++ {
+		for y := x + 1; y < len(grid.names); y++ {
+			heatmap := &Heatmap{
+				X:       grid.names[x],
+				Y:       grid.names[y],
+				XValues: grid.values[x],
+				YValues: grid.values[y],
+				Values:  make([][]*float64, len(grid.values[y])),
+			}
+			for i := range heatmap.Values {
+				heatmap.Values[i] = make([]*float64, len(grid.values[x]))
+			}
+			for _, run := range report.Runs {
+				if run.Rank == 0 {
+					continue
+				}
+				cell := &heatmap.Values[run.combination[y]][run.combination[x]]
+				if *cell == nil || run.Objective > **cell {
+					objective := run.Objective
+					*cell = &objective
+				}
+			}
+			report.Heatmaps = append(report.Heatmaps, heatmap)
+		}
+	}
+	return report
+}
@@ -0,0 +1,160 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/moomoo-trading/api/internal/analytics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptimizationConfig_GridBacktestsEveryCombination(t *testing.T) {
+	config := &OptimizationConfig{Parameters: []ParamRange{
+		{Name: "fast_period", Min: 0.1, Max: 0.3, Step: 0.1},
+		{Name: "slow_period", Values: []interface{}{20.0, 30.0}},
+	}}
+	require.NoError(t, config.Validate())
+
+	sets, err := config.ParameterSets(map[string]interface{}{"quantity": 10.0, "slow_period": 5.0})
+	require.NoError(t, err)
+	require.Len(t, sets, 6)
+	assert.Equal(t, map[string]interface{}{"quantity": 10.0, "fast_period": 0.1, "slow_period": 20.0}, sets[0])
+	assert.Equal(t, map[string]interface{}{"quantity": 10.0, "fast_period": 0.1, "slow_period": 30.0}, sets[1])
+	assert.Equal(t, map[string]interface{}{"quantity": 10.0, "fast_period": 0.3, "slow_period": 30.0}, sets[5], "steps don't accumulate rounding errors")
+}
+
+func TestOptimizationConfig_RandomSearchDrawsDistinctSetsFromItsSeed(t *testing.T) {
+	config := &OptimizationConfig{
+		Method:  SearchRandom,
+		Samples: 20,
+		Seed:    7,
+		Parameters: []ParamRange{
+			{Name: "fast_period", Min: 1, Max: 100, Step: 1},
+			{Name: "slow_period", Min: 1, Max: 100, Step: 1},
+		},
+	}
+	require.NoError(t, config.Validate())
+
+	sets, err := config.ParameterSets(nil)
+	require.NoError(t, err)
+	require.Len(t, sets, 20)
+	seen := make(map[[2]float64]bool)
+	for _, set := range sets {
+		key := [2]float64{set["fast_period"].(float64), set["slow_period"].(float64)}
+		assert.False(t, seen[key], "drawn twice: %v", key)
+		seen[key] = true
+	}
+
+	again, err := config.ParameterSets(nil)
+	require.NoError(t, err)
+	assert.Equal(t, sets, again)
+	config.Seed = 8
+	other, err := config.ParameterSets(nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, sets, other)
+
+	config.Samples = 10000
+	config.Parameters = config.Parameters[:1]
+	sets, err = config.ParameterSets(nil)
+	require.NoError(t, err)
+	assert.Len(t, sets, 100, "small grids are backtested whole")
+}
+
+func TestOptimizationConfig_Validate(t *testing.T) {
+	for name, config := range map[string]OptimizationConfig{
+		"no parameters":  {},
+		"no step":        {Parameters: []ParamRange{{Name: "fast_period", Min: 1, Max: 10}}},
+		"twice":          {Parameters: []ParamRange{{Name: "fast_period", Values: []interface{}{1}}, {Name: "fast_period", Values: []interface{}{2}}}},
+		"grid too large": {Parameters: []ParamRange{{Name: "fast_period", Min: 1, Max: 100, Step: 1}, {Name: "slow_period", Min: 1, Max: 100, Step: 1}}},
+		"no samples":     {Method: SearchRandom, Parameters: []ParamRange{{Name: "fast_period", Values: []interface{}{1}}}},
+		"objective":      {Objective: "profit", Parameters: []ParamRange{{Name: "fast_period", Values: []interface{}{1}}}},
+	} {
+		assert.Error(t, config.Validate(), name)
+	}
+}
+
+func TestNewOptimizationReport_RanksRunsAndSlicesHeatmaps(t *testing.T) {
+	config := &OptimizationConfig{
+		Objective: ObjectiveProfitFactor,
+		MinTrades: 5,
+		Parameters: []ParamRange{
+			{Name: "fast_period", Values: []interface{}{5.0, 10.0}},
+			{Name: "slow_period", Values: []interface{}{20.0, 30.0}},
+			{Name: "quantity", Values: []interface{}{1.0, 2.0}},
+		},
+	}
+	grid, _, err := config.combinations()
+	require.NoError(t, err)
+	run := func(id string, combination []int, status string, objective float64, trades int) *OptimizationRun {
+		return &OptimizationRun{BacktestID: id, Status: status, Metrics: Metrics{Objective: objective, TotalTrades: trades}, combination: combination}
+	}
+	report := newOptimizationReport(config, grid, []*OptimizationRun{
+		run("a", []int{0, 0, 0}, "completed", 1.2, 10),
+		run("b", []int{0, 0, 1}, "completed", 1.5, 10),
+		run("c", []int{1, 0, 0}, "completed", 3.0, 2),
+		run("d", []int{1, 1, 0}, "failed", 0, 0),
+		run("e", []int{1, 1, 1}, "completed", 1.8, 8),
+	})
+
+	var order []string
+	var ranks []int
+	for _, run := range report.Runs {
+		order = append(order, run.BacktestID)
+		ranks = append(ranks, run.Rank)
+	}
+	assert.Equal(t, []string{"e", "b", "a", "c", "d"}, order)
+	assert.Equal(t, []int{1, 2, 3, 0, 0}, ranks, "runs with too few trades are not ranked")
+	assert.Equal(t, "e", report.Best().BacktestID)
+
+	require.Len(t, report.Heatmaps, 3)
+	heatmap := report.Heatmaps[0]
+	assert.Equal(t, "fast_period", heatmap.X)
+	assert.Equal(t, "slow_period", heatmap.Y)
+	require.Len(t, heatmap.Values, 2)
+	assert.Equal(t, 1.5, *heatmap.Values[0][0], "the best of the runs with those values")
+	assert.Nil(t, heatmap.Values[0][1], "the run with those values made too few trades")
+	assert.Nil(t, heatmap.Values[1][0])
+	assert.Equal(t, 1.8, *heatmap.Values[1][1])
+}
+
+func TestNewOptimizationReport_RanksRunsWithoutLossesFirstByProfitFactor(t *testing.T) {
+	config := &OptimizationConfig{
+		Objective:  ObjectiveProfitFactor,
+		Parameters: []ParamRange{{Name: "fast_period", Values: []interface{}{5.0, 10.0, 15.0, 20.0}}},
+	}
+	grid, _, err := config.combinations()
+	require.NoError(t, err)
+	run := func(id string, i int, totalReturn float64, pnls ...[]float64) *OptimizationRun {
+		report := &Report{}
+		for _, symbol := range pnls {
+			result := &BacktestResult{Performance: &analytics.Performance{TotalReturn: totalReturn}}
+			for _, pnl := range symbol {
+				result.Trades = append(result.Trades, Trade{PnL: pnl})
+			}
+			report.Results = append(report.Results, result)
+		}
+		r := &OptimizationRun{BacktestID: id, Status: "completed", combination: []int{i}}
+		r.measure(report, config.objective())
+		return r
+	}
+
+	report := newOptimizationReport(config, grid, []*OptimizationRun{
+		run("losing", 0, 0.1, []float64{30, -10}),
+		run("flawless", 1, 0.05, []float64{10, 20}),
+		run("flawless and richer", 2, 0.2, []float64{50}),
+		// Profit factor of the trades of both symbols, not a mean with the
+		// symbol that never lost
+		run("two symbols", 3, 0.3, []float64{40}, []float64{20, -20}),
+	})
+
+	var order []string
+	for _, run := range report.Runs {
+		order = append(order, run.BacktestID)
+	}
+	assert.Equal(t, []string{"flawless and richer", "flawless", "two symbols", "losing"}, order, "ties go to the higher total return")
+	assert.Equal(t, analytics.MaxProfitFactor, report.Runs[0].ProfitFactor)
+	assert.Equal(t, 3.0, report.Runs[2].ProfitFactor)
+	assert.Equal(t, 3.0, report.Runs[3].ProfitFactor)
+	assert.Equal(t, []int{1, 2, 3, 4}, []int{report.Runs[0].Rank, report.Runs[1].Rank, report.Runs[2].Rank, report.Runs[3].Rank})
+}
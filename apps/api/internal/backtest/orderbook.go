@@ -0,0 +1,243 @@
+package backtest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// restingOrder is an order working in the simulated order book: waiting for
+// a bar to fill on, for its trigger or limit price to trade, or to fill the
+// rest of its quantity
+type restingOrder struct {
+	order     broker.Order
+	placedAt  time.Time // timestamp of the bar that placed the order
+	notional  float64   // filled quantity times price, for the average fill price
+	day       time.Time // trading day of the first bar after placement, when a DAY order expires
+	triggered bool      // the stop traded: stop and trailing orders now fill at market, stop-limits at their limit
+	stop      float64   // current stop of a trailing order
+	extreme   float64   // best price since a trailing order was placed, which its stop follows
+}
+
+// working reports whether an order can still fill
+func (o *restingOrder) working() bool {
+	return o.order.Status == broker.OrderStatusSubmitted || o.order.Status == broker.OrderStatusPartial
+}
+
+// priority orders the resting orders of a bar under the conservative
+// assumption that the bar moves against the strategy first: market orders
+// fill at the open or close, then stops trigger, and only then do limits
+// fill
+func (o *restingOrder) priority() int {
+	switch o.order.Type {
+	case broker.OrderTypeMarket:
+		return 0
+	case broker.OrderTypeLimit:
+		return 2
+	case broker.OrderTypeStopLimit:
+		if o.triggered {
+			return 2
+		}
+	default:
+		if o.triggered {
+			return 0
+		}
+	}
+	return 1
+}
+
+// newRestingOrder accepts an order into the book at the bar that placed it.
+// Trailing stops start from that bar's close.
+func newRestingOrder(order broker.Order, bar Bar) *restingOrder {
+	o := &restingOrder{order: order, placedAt: bar.Timestamp}
+	if order.Type == broker.OrderTypeTrailing {
+		o.extreme = bar.Close
+		o.stop = o.trailingStop()
+	}
+	return o
+}
+
+// match works the resting orders of the symbols of a period against their
+// bars, in priority order, and drops orders that are done
+func (b *simulatedBroker) match(bars strategy.AlignedBars) {
+	due := make([]*restingOrder, 0, len(b.book))
+	for _, o := range b.book {
+		if bar, ok := bars.Bars[o.order.Symbol]; ok && bar.Timestamp.After(o.placedAt) {
+			due = append(due, o)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].priority() < due[j].priority() })
+
+	for _, o := range due {
+		bar := bars.Bars[o.order.Symbol]
+		if b.expire(o, bar) {
+			continue
+		}
+		b.work(o, bar)
+	}
+
+	book := b.book[:0]
+	for _, o := range b.book {
+		if o.working() {
+			book = append(book, o)
+		}
+	}
+	b.book = book
+}
+
+// expire cancels a DAY order at the first bar of a trading day after the one
+// it could first fill on. Orders placed on the last bar of a day thus work
+// through the next day, as orders placed after the close do.
+func (b *simulatedBroker) expire(o *restingOrder, bar Bar) bool {
+	if o.order.TimeInForce == broker.TimeInForceGTC {
+		return false
+	}
+	day := strategy.TradingDay(bar.Timestamp)
+	if o.day.IsZero() {
+		o.day = day
+		return false
+	}
+	if !day.After(o.day) {
+		return false
+	}
+
+	o.order.Status = broker.OrderStatusCancelled
+	o.order.UpdatedAt = bar.Timestamp
+	b.updates = append(b.updates, broker.OrderUpdate{Order: o.order, Reason: "DAY order expired", Timestamp: bar.Timestamp})
+	return true
+}
+
+// work fills as much of an order on bar as its type, the bar's range and the
+// fill model allow. The path of prices within a bar is unknown, so orders
+// assume the least favourable one:
+//   - Market orders, and stop and trailing orders once triggered, fill as the
+//     fill model decides.
+//   - Limit orders fill at the open when it is at or better than the limit,
+//     otherwise at the limit only when the bar trades through it; a bar that
+//     just touches the limit may not have reached the order in the queue.
+//   - Stop orders trigger when the bar trades at or through the stop, and fill
+//     at the open when it gaps through the stop or at the stop otherwise, with
+//     slippage.
+//   - Stop-limit orders trigger as stop orders and then fill as limit orders.
+//     On the bar that triggers them they fill at the trigger price when it is
+//     within the limit, or, when the open gapped through the stop, as the
+//     rest of the bar trades through the limit. A stop triggered within the
+//     bar may have come after its low, so the limit waits for the next bar.
+//   - Trailing orders trigger against the stop of the bars before, and only
+//     then does the bar move the stop, so a bar's high and low never both
+//     ratchet and trigger it.
+func (b *simulatedBroker) work(o *restingOrder, bar Bar) {
+	order := &o.order
+	remaining := order.Quantity - order.FilledQuantity
+
+	switch {
+	case order.Type == broker.OrderTypeMarket || o.triggered && order.Type != broker.OrderTypeStopLimit:
+		price, quantity := b.fills.Fill(order.Side, remaining, bar)
+		b.execute(o, bar, price, quantity)
+
+	case order.Type == broker.OrderTypeLimit || o.triggered:
+		if price, ok := limitFill(order.Side, *order.Price, bar); ok {
+			price, quantity := b.fills.FillAt(order.Side, remaining, price, bar, false)
+			b.execute(o, bar, price, quantity)
+		}
+
+	case order.Type == broker.OrderTypeStop:
+		if price, ok := stopTrigger(order.Side, *order.StopPrice, bar); ok {
+			o.triggered = true
+			price, quantity := b.fills.FillAt(order.Side, remaining, price, bar, true)
+			b.execute(o, bar, price, quantity)
+		}
+
+	case order.Type == broker.OrderTypeStopLimit:
+		price, ok := stopTrigger(order.Side, *order.StopPrice, bar)
+		if !ok {
+			return
+		}
+		o.triggered = true
+		limit := *order.Price
+		if within(order.Side, price, limit) {
+			price, quantity := b.fills.FillAt(order.Side, remaining, price, bar, true)
+			if !within(order.Side, price, limit) {
+				price = limit
+			}
+			b.execute(o, bar, price, quantity)
+		} else if price == bar.Open {
+			if price, ok := limitFill(order.Side, limit, bar); ok {
+				price, quantity := b.fills.FillAt(order.Side, remaining, price, bar, false)
+				b.execute(o, bar, price, quantity)
+			}
+		}
+
+	case order.Type == broker.OrderTypeTrailing:
+		if price, ok := stopTrigger(order.Side, o.stop, bar); ok {
+			o.triggered = true
+			price, quantity := b.fills.FillAt(order.Side, remaining, price, bar, true)
+			b.execute(o, bar, price, quantity)
+			return
+		}
+		o.trail(bar)
+	}
+}
+
+// trail moves a trailing stop after the best price of bar
+func (o *restingOrder) trail(bar Bar) {
+	if o.order.Side == broker.OrderSideSell && bar.High > o.extreme {
+		o.extreme = bar.High
+	} else if o.order.Side == broker.OrderSideBuy && bar.Low < o.extreme {
+		o.extreme = bar.Low
+	}
+	o.stop = o.trailingStop()
+}
+
+// trailingStop returns the stop a trailing order's trail sets from its best
+// price: below it for sells, above it for buys
+func (o *restingOrder) trailingStop() float64 {
+	trail := 0.0
+	if o.order.TrailAmount != nil {
+		trail = *o.order.TrailAmount
+	} else if o.order.TrailPercent != nil {
+		trail = o.extreme * *o.order.TrailPercent / 100
+	}
+	if o.order.Side == broker.OrderSideSell {
+		return o.extreme - trail
+	}
+	return o.extreme + trail
+}
+
+// limitFill returns the price a limit order fills at on bar: the open when
+// it is at or better than the limit, or the limit when the bar trades
+// through it
+func limitFill(side broker.OrderSide, limit float64, bar Bar) (float64, bool) {
+	if within(side, bar.Open, limit) {
+		return bar.Open, true
+	}
+	if side == broker.OrderSideBuy && bar.Low < limit || side == broker.OrderSideSell && bar.High > limit {
+		return limit, true
+	}
+	return 0, false
+}
+
+// stopTrigger returns the price a stop triggers at on bar: the open when it
+// gaps through the stop, or the stop when the bar trades at or through it
+func stopTrigger(side broker.OrderSide, stop float64, bar Bar) (float64, bool) {
+	if side == broker.OrderSideBuy {
+		if bar.Open >= stop {
+			return bar.Open, true
+		}
+		return stop, bar.High >= stop
+	}
+	if bar.Open <= stop {
+		return bar.Open, true
+	}
+	return stop, bar.Low <= stop
+}
+
+// within reports whether price is at or better than limit for side
+func within(side broker.OrderSide, price, limit float64) bool {
+	if side == broker.OrderSideBuy {
+		return price <= limit
+	}
+	return price >= limit
+}
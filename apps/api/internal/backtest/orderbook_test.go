@@ -0,0 +1,269 @@
+package backtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/strategy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func newTestBroker(t *testing.T, config FillConfig) *simulatedBroker {
+	t.Helper()
+	fills, err := NewFillModel(config)
+	require.NoError(t, err)
+	return newSimulatedBroker(&BacktestState{Balance: 10000, Equity: 10000, Ledger: NewLedger(MarginConfig{})}, nil, fills, NoCommission{})
+}
+
+// minuteBar returns the AAPL bar of the i-th minute after testStart
+func minuteBar(i int, open, high, low, close float64) Bar {
+	return Bar{Timestamp: testStart.Add(time.Duration(i) * time.Minute), Open: open, High: high, Low: low, Close: close, Volume: 1000}
+}
+
+func step(b *simulatedBroker, bar Bar) {
+	b.setBars(strategy.AlignedBars{Timestamp: bar.Timestamp, Bars: map[string]Bar{"AAPL": bar}})
+}
+
+func drainUpdates(b *simulatedBroker) []broker.OrderUpdate {
+	var updates []broker.OrderUpdate
+	for update, ok := b.NextUpdate(); ok; update, ok = b.NextUpdate() {
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+type testFill struct{ quantity, price float64 }
+
+func fillsOf(updates []broker.OrderUpdate) []testFill {
+	var fills []testFill
+	for _, update := range updates {
+		if update.Fill != nil {
+			fills = append(fills, testFill{update.Fill.Quantity, update.Fill.Price})
+		}
+	}
+	return fills
+}
+
+// TestOrderBook_FillSemantics documents how resting orders fill on the bars
+// after the one that placed them. Every order is placed on a bar closing at
+// 100, under the next_open fill model so nothing fills at placement.
+func TestOrderBook_FillSemantics(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FillConfig
+		order  broker.Order
+		bars   []Bar
+		want   []testFill
+	}{
+		{
+			name:  "limit buy fills at an open below the limit",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeLimit, Quantity: 10, Price: floatPtr(99)},
+			bars:  []Bar{minuteBar(1, 98, 100, 97, 99)},
+			want:  []testFill{{10, 98}},
+		},
+		{
+			name:  "limit buy needs a bar trading through the limit, not touching it",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeLimit, Quantity: 10, Price: floatPtr(99)},
+			bars:  []Bar{minuteBar(1, 100, 101, 99, 100), minuteBar(2, 100, 100, 98.5, 99)},
+			want:  []testFill{{10, 99}},
+		},
+		{
+			name:   "limit sell fills at the limit without slippage",
+			config: FillConfig{SlippageBps: 10},
+			order:  broker.Order{Side: broker.OrderSideSell, Type: broker.OrderTypeLimit, Quantity: 10, Price: floatPtr(101)},
+			bars:   []Bar{minuteBar(1, 100, 101.5, 99, 101)},
+			want:   []testFill{{10, 101}},
+		},
+		{
+			name:  "stop buy triggers at the stop when the bar trades at it",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeStop, Quantity: 10, StopPrice: floatPtr(101)},
+			bars:  []Bar{minuteBar(1, 100, 100.5, 99, 100), minuteBar(2, 100, 101, 99, 100)},
+			want:  []testFill{{10, 101}},
+		},
+		{
+			name:  "stop sell fills at an open gapping through the stop",
+			order: broker.Order{Side: broker.OrderSideSell, Type: broker.OrderTypeStop, Quantity: 10, StopPrice: floatPtr(98)},
+			bars:  []Bar{minuteBar(1, 96, 97, 95, 96)},
+			want:  []testFill{{10, 96}},
+		},
+		{
+			name:   "stop sell pays slippage",
+			config: FillConfig{SlippageBps: 10},
+			order:  broker.Order{Side: broker.OrderSideSell, Type: broker.OrderTypeStop, Quantity: 10, StopPrice: floatPtr(98)},
+			bars:   []Bar{minuteBar(1, 99, 99, 97, 98)},
+			want:   []testFill{{10, 97.902}},
+		},
+		{
+			name:  "stop-limit fills at the stop within the limit",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeStopLimit, Quantity: 10, StopPrice: floatPtr(101), Price: floatPtr(102)},
+			bars:  []Bar{minuteBar(1, 100, 103, 99, 102)},
+			want:  []testFill{{10, 101}},
+		},
+		{
+			name:  "stop-limit gapping past its limit fills when the bar trades back through it",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeStopLimit, Quantity: 10, StopPrice: floatPtr(101), Price: floatPtr(102)},
+			bars:  []Bar{minuteBar(1, 103, 104, 101, 103)},
+			want:  []testFill{{10, 102}},
+		},
+		{
+			// The bar's low may have come before it reached the stop, so the
+			// limit below the stop only works from the next bar
+			name:  "stop-limit triggered within a bar rests as a limit from the next bar",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeStopLimit, Quantity: 10, StopPrice: floatPtr(101), Price: floatPtr(100.5)},
+			bars:  []Bar{minuteBar(1, 100, 102, 99, 101), minuteBar(2, 101, 101, 100, 100.5)},
+			want:  []testFill{{10, 100.5}},
+		},
+		{
+			name:  "trailing sell stop follows the high of earlier bars",
+			order: broker.Order{Side: broker.OrderSideSell, Type: broker.OrderTypeTrailing, Quantity: 10, TrailAmount: floatPtr(2)},
+			bars:  []Bar{minuteBar(1, 100, 105, 99, 104), minuteBar(2, 104, 104.5, 102, 102.5)},
+			want:  []testFill{{10, 103}},
+		},
+		{
+			// The low of the bar that raised the stop to 107.8 may have come
+			// before its high, so it does not trigger the stop
+			name:  "trailing stop never triggers on the bar that moves it",
+			order: broker.Order{Side: broker.OrderSideSell, Type: broker.OrderTypeTrailing, Quantity: 10, TrailPercent: floatPtr(2)},
+			bars:  []Bar{minuteBar(1, 100, 110, 99, 100), minuteBar(2, 100, 101, 99, 100)},
+			want:  []testFill{{10, 100}},
+		},
+		{
+			name:  "trailing buy stop follows the low",
+			order: broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeTrailing, Quantity: 10, TrailAmount: floatPtr(1)},
+			bars:  []Bar{minuteBar(1, 100, 100, 97, 97.5), minuteBar(2, 97.5, 98.5, 97.5, 98)},
+			want:  []testFill{{10, 98}},
+		},
+		{
+			name:   "volume cap fills a limit over several bars trading through it",
+			config: FillConfig{Participation: 0.005},
+			order:  broker.Order{Side: broker.OrderSideBuy, Type: broker.OrderTypeLimit, Quantity: 12, Price: floatPtr(99)},
+			bars:   []Bar{minuteBar(1, 98, 99, 97, 98), minuteBar(2, 100, 100, 99, 100), minuteBar(3, 99.5, 100, 98, 99)},
+			want:   []testFill{{5, 98}, {5, 99}},
+		},
+		{
+			name:   "triggered stop fills the rest of its quantity at market",
+			config: FillConfig{Participation: 0.005},
+			order:  broker.Order{Side: broker.OrderSideSell, Type: broker.OrderTypeStop, Quantity: 8, StopPrice: floatPtr(98)},
+			bars:   []Bar{minuteBar(1, 99, 99, 97, 97), minuteBar(2, 99, 99, 99, 99)},
+			want:   []testFill{{5, 98}, {3, 99}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Model = FillNextOpen
+			b := newTestBroker(t, config)
+			step(b, minuteBar(0, 100, 100, 100, 100))
+			order := tt.order
+			order.Symbol = "AAPL"
+			require.NoError(t, b.PlaceOrder(context.Background(), &order))
+
+			var fills []testFill
+			for _, bar := range tt.bars {
+				step(b, bar)
+				fills = append(fills, fillsOf(drainUpdates(b))...)
+			}
+			require.Len(t, fills, len(tt.want))
+			for i, want := range tt.want {
+				assert.Equal(t, want.quantity, fills[i].quantity)
+				assert.InDelta(t, want.price, fills[i].price, 1e-9)
+			}
+		})
+	}
+}
+
+func TestOrderBook_PartialFillsReportTheirStatus(t *testing.T) {
+	b := newTestBroker(t, FillConfig{Model: FillNextOpen, Participation: 0.005})
+	step(b, minuteBar(0, 100, 100, 100, 100))
+	require.NoError(t, b.PlaceOrder(context.Background(), &broker.Order{
+		Symbol: "AAPL", Side: broker.OrderSideBuy, Type: broker.OrderTypeLimit, Quantity: 8, Price: floatPtr(99),
+	}))
+
+	step(b, minuteBar(1, 98, 98, 98, 98))
+	updates := drainUpdates(b)
+	require.Len(t, updates, 1)
+	assert.Equal(t, broker.OrderStatusPartial, updates[0].Order.Status)
+	assert.Equal(t, 5.0, updates[0].Order.FilledQuantity)
+
+	step(b, minuteBar(2, 97, 97, 97, 97))
+	updates = drainUpdates(b)
+	require.Len(t, updates, 1)
+	assert.Equal(t, broker.OrderStatusFilled, updates[0].Order.Status)
+	assert.InDelta(t, (5*98+3*97)/8.0, *updates[0].Order.AvgFillPrice, 1e-9)
+	assert.Empty(t, b.book)
+}
+
+// A bar reaching both a protective stop and a take-profit limit is assumed to
+// have hit the stop first
+func TestOrderBook_StopsFillBeforeLimitsOnTheSameBar(t *testing.T) {
+	b := newTestBroker(t, FillConfig{Model: FillNextOpen})
+	step(b, minuteBar(0, 100, 100, 100, 100))
+	ctx := context.Background()
+	require.NoError(t, b.PlaceOrder(ctx, &broker.Order{
+		Symbol: "AAPL", Side: broker.OrderSideSell, Type: broker.OrderTypeLimit, Quantity: 10, Price: floatPtr(105),
+	}))
+	require.NoError(t, b.PlaceOrder(ctx, &broker.Order{
+		Symbol: "AAPL", Side: broker.OrderSideSell, Type: broker.OrderTypeStop, Quantity: 10, StopPrice: floatPtr(95),
+	}))
+
+	step(b, minuteBar(1, 100, 106, 94, 100))
+	assert.Equal(t, []testFill{{10, 95}, {10, 105}}, fillsOf(drainUpdates(b)))
+}
+
+func TestOrderBook_DayOrdersExpireAtTheEndOfTheTradingDay(t *testing.T) {
+	lastBar := time.Date(2024, 1, 2, 20, 59, 0, 0, time.UTC) // 15:59 New York
+	nextOpen := time.Date(2024, 1, 3, 14, 30, 0, 0, time.UTC)
+	at := func(t time.Time, open, high, low, close float64) Bar {
+		return Bar{Timestamp: t, Open: open, High: high, Low: low, Close: close, Volume: 1000}
+	}
+
+	b := newTestBroker(t, FillConfig{})
+	step(b, at(lastBar, 100, 100, 100, 100))
+	ctx := context.Background()
+	for _, tif := range []broker.TimeInForce{broker.TimeInForceDay, broker.TimeInForceGTC} {
+		require.NoError(t, b.PlaceOrder(ctx, &broker.Order{
+			Symbol: "AAPL", Side: broker.OrderSideBuy, Type: broker.OrderTypeLimit, Quantity: 10, Price: floatPtr(90), TimeInForce: tif,
+		}))
+	}
+
+	// Placed after the close, the DAY order works through the next day
+	step(b, at(nextOpen, 100, 100, 95, 96))
+	step(b, at(nextOpen.Add(389*time.Minute), 96, 97, 95, 96))
+	assert.Empty(t, drainUpdates(b))
+	require.Len(t, b.book, 2)
+
+	step(b, at(nextOpen.AddDate(0, 0, 1), 92, 93, 89, 90))
+	updates := drainUpdates(b)
+	require.Len(t, updates, 2)
+	assert.Equal(t, broker.TimeInForceDay, updates[0].Order.TimeInForce)
+	assert.Equal(t, broker.OrderStatusCancelled, updates[0].Order.Status)
+	assert.Nil(t, updates[0].Fill)
+	assert.Equal(t, broker.TimeInForceGTC, updates[1].Order.TimeInForce)
+	assert.Equal(t, testFill{10, 90}, fillsOf(updates[1:])[0])
+	assert.Empty(t, b.book)
+}
+
+// Under the close fill model orders work against the close of the bar that
+// placed them before resting: marketable ones fill there as market orders
+func TestOrderBook_CloseModelFillsMarketableOrdersAtPlacement(t *testing.T) {
+	b := newTestBroker(t, FillConfig{})
+	step(b, minuteBar(0, 98, 101, 97, 100))
+	ctx := context.Background()
+	for _, order := range []broker.Order{
+		{Type: broker.OrderTypeLimit, Price: floatPtr(101)},
+		{Type: broker.OrderTypeStop, StopPrice: floatPtr(99)},
+		{Type: broker.OrderTypeLimit, Price: floatPtr(99)},
+		{Type: broker.OrderTypeStop, StopPrice: floatPtr(102)},
+	} {
+		order.Symbol, order.Side, order.Quantity = "AAPL", broker.OrderSideBuy, 1
+		require.NoError(t, b.PlaceOrder(ctx, &order))
+	}
+
+	assert.Equal(t, []testFill{{1, 100}, {1, 100}}, fillsOf(drainUpdates(b)))
+	assert.Len(t, b.book, 2)
+}
@@ -0,0 +1,820 @@
+package backtest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/analytics"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/redis"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// DefaultInitialBalance is the balance queued backtests start with unless
+// their configuration sets one
+const DefaultInitialBalance = 100000
+
+// ErrCancelled is the cause of the context of a backtest cancelled while it
+// runs
+var ErrCancelled = errors.New("backtest cancelled")
+
+// RunConfig holds the settings a queued backtest runs with besides its
+// strategy version, symbols, dates and parameters
+type RunConfig struct {
+	InitialBalance float64          `json:"initial_balance,omitempty"`
+	Timeframe      string           `json:"timeframe,omitempty"`    // of the bars the strategy sees; 1m by default
+	Mode           string           `json:"mode,omitempty"`         // symbol (default) or portfolio
+	MissingBars    string           `json:"missing_bars,omitempty"` // missing bar policy of portfolio strategies
+	Fill           FillConfig       `json:"fill"`
+	Commission     CommissionConfig `json:"commission"`
+	Margin         MarginConfig     `json:"margin"`
+	Analytics      AnalyticsConfig  `json:"analytics"`
+
+	Optimization *OptimizationConfig `json:"optimization,omitempty"` // set for optimizations and walk-forward analyses
+	WalkForward  *WalkForwardConfig  `json:"walk_forward,omitempty"` // set for walk-forward analyses
+}
+
+// Validate checks a run configuration
+func (config *RunConfig) Validate() error {
+	if config.InitialBalance < 0 {
+		return fmt.Errorf("initial balance must not be negative")
+	}
+	if _, err := strategy.ParseTimeframe(config.Timeframe); err != nil {
+		return err
+	}
+	switch config.Mode {
+	case "", strategy.ModeSymbol, strategy.ModePortfolio:
+	default:
+		return fmt.Errorf("invalid mode %q: must be symbol or portfolio", config.Mode)
+	}
+	if _, err := strategy.ParseMissingBarPolicy(config.MissingBars); err != nil {
+		return err
+	}
+	if _, err := NewFillModel(config.Fill); err != nil {
+		return err
+	}
+	if _, err := NewCommissionModel(config.Commission); err != nil {
+		return err
+	}
+	if err := config.Margin.validate(); err != nil {
+		return err
+	}
+	if _, err := config.Analytics.config(); err != nil {
+		return err
+	}
+	if config.WalkForward != nil {
+		if config.Optimization == nil {
+			return fmt.Errorf("walk-forward analyses need optimization settings")
+		}
+		if err := config.WalkForward.Validate(); err != nil {
+			return err
+		}
+	}
+	if config.Optimization != nil {
+		return config.Optimization.Validate()
+	}
+	return nil
+}
+
+// Report is the outcome of a queued backtest, stored as its results: a
+// result per symbol for strategies running per symbol, or one for a
+// portfolio strategy
+type Report struct {
+	Results []*BacktestResult `json:"results"`
+}
+
+// MonteCarlo runs a Monte Carlo analysis of the trades of a report. The
+// trades of its results are resampled together, starting from the sum of
+// their initial balances.
+func (report *Report) MonteCarlo(config analytics.MonteCarloConfig) (*analytics.MonteCarloResult, error) {
+	var trades []Trade
+	initial := 0.0
+	for _, result := range report.Results {
+		trades = append(trades, result.Trades...)
+		initial += result.Config.InitialBalance
+	}
+	return analytics.MonteCarlo(initial, trades, config)
+}
+
+// BacktestStore is where the runner reads queued backtests and records their
+// progress and results, and where optimizations keep their child backtests
+type BacktestStore interface {
+	CreateBacktest(ctx context.Context, backtest *database.Backtest) error
+	GetBacktestByID(ctx context.Context, id string) (*database.Backtest, error)
+	ListBacktests(ctx context.Context, strategyID, status, parentID *string, limit, offset int) ([]*database.Backtest, error)
+	UpdateBacktestStatus(ctx context.Context, id, status string, progress float64, results json.RawMessage, errMsg *string) error
+}
+
+// StrategyStore provides the strategy versions backtests run
+type StrategyStore interface {
+	GetVersionByID(ctx context.Context, id string) (*database.StrategyVersion, error)
+	GetParamsByVersionID(ctx context.Context, versionID string) ([]*database.StrategyParam, error)
+}
+
+// RunnerConfig sets how many backtests a process runs and how workers share
+// the queue
+type RunnerConfig struct {
+	Workers   int           // backtests this process runs at once
+	Consumer  string        // name of this process in the consumer group; the host name by default
+	ClaimIdle time.Duration // time a backtest may go without a heartbeat before another worker takes it over
+}
+
+// Runner runs queued backtests. Workers take backtests off a Redis stream,
+// run them and store their report as the backtest's results. While a
+// backtest runs its worker heartbeats it on the stream, records its progress
+// and checks whether it was cancelled, every quarter of the claim idle time.
+// A backtest whose worker stops heartbeating, e.g. because its process
+// crashed, is reclaimed and run again by another worker.
+type Runner struct {
+	engine     *BacktestEngine
+	queue      *redis.JobQueue
+	backtests  BacktestStore
+	strategies StrategyStore
+	config     RunnerConfig
+
+	mu      sync.Mutex
+	running map[string]*runningJob // backtests running in this process by ID
+}
+
+// runningJob is a backtest running on a worker
+type runningJob struct {
+	job      *redis.Job
+	consumer string
+	id       string
+	ctx      context.Context // done once the backtest is cancelled or the runner stops
+	cancel   context.CancelCauseFunc
+
+	mu       sync.Mutex
+	progress float64
+}
+
+func (j *runningJob) setProgress(progress float64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress = progress
+}
+
+func (j *runningJob) getProgress() float64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// NewRunner creates a runner of the backtests queued on queue
+func NewRunner(engine *BacktestEngine, queue *redis.JobQueue, backtests BacktestStore, strategies StrategyStore, config RunnerConfig) *Runner {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.ClaimIdle <= 0 {
+		config.ClaimIdle = time.Minute
+	}
+	if config.Consumer == "" {
+		config.Consumer, _ = os.Hostname()
+	}
+	return &Runner{
+		engine:     engine,
+		queue:      queue,
+		backtests:  backtests,
+		strategies: strategies,
+		config:     config,
+		running:    make(map[string]*runningJob),
+	}
+}
+
+// Enqueue queues a backtest for the workers
+func (r *Runner) Enqueue(ctx context.Context, backtestID string) error {
+	_, err := r.queue.Push(ctx, map[string]interface{}{"backtest_id": backtestID})
+	return err
+}
+
+// Cancel stops a backtest. The backtest is flagged as cancelled for the
+// worker running it, in whichever process, to stop at its next heartbeat, and
+// stopped at once if it runs in this process. Workers drop queued backtests
+// that were cancelled before they started.
+func (r *Runner) Cancel(ctx context.Context, backtestID string) error {
+	if err := r.queue.Cancel(ctx, backtestID); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	job := r.running[backtestID]
+	r.mu.Unlock()
+	if job != nil {
+		job.cancel(ErrCancelled)
+	}
+	return nil
+}
+
+// Run starts the workers and blocks until ctx is done. Backtests still
+// running then are left on the queue for other workers to reclaim.
+func (r *Runner) Run(ctx context.Context) error {
+	if err := r.queue.Init(ctx); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= r.config.Workers; i++ {
+		wg.Add(1)
+		go func(consumer string) {
+			defer wg.Done()
+			r.work(ctx, consumer)
+		}(fmt.Sprintf("%s-%d", r.config.Consumer, i))
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// heartbeat returns the period of heartbeats
+func (r *Runner) heartbeat() time.Duration {
+	return r.config.ClaimIdle / 4
+}
+
+// work takes backtests off the queue and runs them until ctx is done
+func (r *Runner) work(ctx context.Context, consumer string) {
+	for ctx.Err() == nil {
+		job, err := r.queue.Next(ctx, consumer, r.config.ClaimIdle, r.heartbeat())
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Backtest worker %s failed to take a job: %v", consumer, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(r.heartbeat()):
+				}
+			}
+			continue
+		}
+		if job != nil {
+			r.process(ctx, consumer, job)
+		}
+	}
+}
+
+// process runs the backtest of a job and records how it ended. Jobs are
+// acknowledged once their outcome is stored; those left unacknowledged are
+// reclaimed and run again.
+func (r *Runner) process(ctx context.Context, consumer string, job *redis.Job) {
+	id, _ := job.Values["backtest_id"].(string)
+	backtest, err := r.backtests.GetBacktestByID(ctx, id)
+	if err == sql.ErrNoRows {
+		r.ack(ctx, job) // deleted while queued
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load backtest %s: %v", id, err)
+		return
+	}
+	if backtest.Status != "pending" && backtest.Status != "running" {
+		r.ack(ctx, job) // finished, or cancelled while queued
+		return
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	running := &runningJob{job: job, consumer: consumer, id: id, ctx: runCtx, cancel: cancel}
+	r.mu.Lock()
+	r.running[id] = running
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, id)
+		r.mu.Unlock()
+	}()
+
+	if err := r.backtests.UpdateBacktestStatus(ctx, id, "running", 0, nil, nil); err != nil {
+		log.Printf("Failed to update status of backtest %s: %v", id, err)
+	}
+	done := make(chan struct{})
+	var heartbeats sync.WaitGroup
+	heartbeats.Add(1)
+	go func() {
+		defer heartbeats.Done()
+		r.heartbeats(done, running)
+	}()
+	results, err := r.execute(runCtx, backtest, running.setProgress)
+	close(done)
+	heartbeats.Wait()
+
+	switch {
+	case ctx.Err() != nil:
+		// Shutting down: another worker reclaims the backtest
+	case errors.Is(context.Cause(runCtx), ErrCancelled):
+		r.finish(ctx, job, id, "cancelled", running.getProgress(), nil, nil)
+	case err != nil:
+		message := err.Error()
+		r.finish(ctx, job, id, "failed", running.getProgress(), nil, &message)
+	default:
+		results, err := json.Marshal(results)
+		if err != nil {
+			message := fmt.Sprintf("failed to encode results: %v", err)
+			r.finish(ctx, job, id, "failed", running.getProgress(), nil, &message)
+			return
+		}
+		r.finish(ctx, job, id, "completed", 1, results, nil)
+	}
+}
+
+// heartbeats keeps a running backtest claimed, records its progress and
+// stops it once cancelled, every heartbeat period until done is closed
+func (r *Runner) heartbeats(done <-chan struct{}, running *runningJob) {
+	ctx := running.ctx
+	ticker := time.NewTicker(r.heartbeat())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.queue.Heartbeat(ctx, running.consumer, running.job.ID); err != nil {
+			log.Printf("Failed to heartbeat backtest %s: %v", running.id, err)
+		}
+		cancelled, err := r.queue.Cancelled(ctx, running.id)
+		if err != nil {
+			log.Printf("Failed to check cancellation of backtest %s: %v", running.id, err)
+		}
+		if cancelled {
+			running.cancel(ErrCancelled)
+			return
+		}
+		if err := r.backtests.UpdateBacktestStatus(ctx, running.id, "running", running.getProgress(), nil, nil); err != nil {
+			log.Printf("Failed to update progress of backtest %s: %v", running.id, err)
+		}
+	}
+}
+
+// finish records the outcome of a backtest and acknowledges its job
+func (r *Runner) finish(ctx context.Context, job *redis.Job, id, status string, progress float64, results json.RawMessage, errMsg *string) {
+	if err := r.backtests.UpdateBacktestStatus(ctx, id, status, progress, results, errMsg); err != nil {
+		log.Printf("Failed to record %s backtest %s: %v", status, id, err)
+		return
+	}
+	r.ack(ctx, job)
+}
+
+func (r *Runner) ack(ctx context.Context, job *redis.Job) {
+	if err := r.queue.Ack(ctx, job.ID); err != nil {
+		log.Printf("Failed to acknowledge backtest job %s: %v", job.ID, err)
+	}
+}
+
+// execute runs a queued backtest, optimization or walk-forward analysis and
+// returns its results
+func (r *Runner) execute(ctx context.Context, backtest *database.Backtest, progress func(float64)) (interface{}, error) {
+	switch backtest.Kind {
+	case KindOptimization:
+		return r.optimize(ctx, backtest, progress)
+	case KindWalkForward:
+		return r.walkForward(ctx, backtest, progress)
+	}
+	return r.run(ctx, backtest, progress)
+}
+
+// run runs a backtest: once per symbol for strategies running per symbol, or
+// once on all of its symbols for a portfolio strategy. Progress is reported
+// as the share of bars replayed, from 0 to 1.
+func (r *Runner) run(ctx context.Context, backtest *database.Backtest, progress func(float64)) (*Report, error) {
+	configs, err := r.configs(ctx, backtest)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	for i, config := range configs {
+		config.Progress = func(done, total int) {
+			progress((float64(i) + float64(done)/float64(total)) / float64(len(configs)))
+		}
+		result, err := r.engine.RunBacktest(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// configs returns the engine configurations of the runs of a backtest. Its
+// parameters are converted to the types of the version's definitions again,
+// as JSON does not keep them.
+func (r *Runner) configs(ctx context.Context, backtest *database.Backtest) ([]*BacktestConfig, error) {
+	config, err := runConfig(backtest)
+	if err != nil {
+		return nil, err
+	}
+	if backtest.VersionID == nil {
+		return nil, fmt.Errorf("backtest has no strategy version")
+	}
+
+	version, err := r.strategies.GetVersionByID(ctx, *backtest.VersionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load strategy version: %w", err)
+	}
+	params, err := r.strategies.GetParamsByVersionID(ctx, version.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load strategy parameters: %w", err)
+	}
+	values, err := parameters(backtest)
+	if err != nil {
+		return nil, err
+	}
+	if values, err = strategy.CoerceParams(params, values); err != nil {
+		return nil, err
+	}
+
+	s := &strategy.Strategy{
+		ID:          backtest.StrategyID,
+		VersionID:   version.ID,
+		Code:        version.Code,
+		Parameters:  values,
+		Symbols:     backtest.Symbols,
+		Timeframe:   config.Timeframe,
+		Mode:        config.Mode,
+		MissingBars: strategy.MissingBarPolicy(config.MissingBars),
+	}
+	run := func(symbol string) *BacktestConfig {
+		return &BacktestConfig{
+			Symbol:         symbol,
+			StartDate:      backtest.StartDate,
+			EndDate:        backtest.EndDate.AddDate(0, 0, 1), // end dates are inclusive
+			InitialBalance: config.InitialBalance,
+			Strategy:       s,
+			Fill:           config.Fill,
+			Commission:     config.Commission,
+			Margin:         config.Margin,
+			Analytics:      config.Analytics,
+		}
+	}
+	if s.IsPortfolio() {
+		return []*BacktestConfig{run("")}, nil
+	}
+	configs := make([]*BacktestConfig, len(backtest.Symbols))
+	for i, symbol := range backtest.Symbols {
+		configs[i] = run(symbol)
+	}
+	return configs, nil
+}
+
+// runConfig returns the run configuration of a backtest
+func runConfig(backtest *database.Backtest) (RunConfig, error) {
+	var config RunConfig
+	if len(backtest.Config) > 0 {
+		if err := json.Unmarshal(backtest.Config, &config); err != nil {
+			return config, fmt.Errorf("invalid backtest config: %w", err)
+		}
+	}
+	if config.InitialBalance == 0 {
+		config.InitialBalance = DefaultInitialBalance
+	}
+	return config, nil
+}
+
+// parameters returns the parameters of a backtest as stored
+func parameters(backtest *database.Backtest) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	if len(backtest.Parameters) > 0 {
+		if err := json.Unmarshal(backtest.Parameters, &values); err != nil {
+			return nil, fmt.Errorf("invalid backtest parameters: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// optimize runs an optimization: a child backtest per parameter set, up to
+// its concurrency at once, ranked once all have run. Progress is the mean
+// progress of the children. A reclaimed optimization reuses the children it
+// created before and runs only those that had not finished.
+func (r *Runner) optimize(ctx context.Context, parent *database.Backtest, progress func(float64)) (*OptimizationReport, error) {
+	config, err := runConfig(parent)
+	if err != nil {
+		return nil, err
+	}
+	optimization := config.Optimization
+	if optimization == nil {
+		return nil, fmt.Errorf("optimization has no parameters to optimize")
+	}
+	grid, combinations, err := optimization.combinations()
+	if err != nil {
+		return nil, err
+	}
+	children, err := r.children(ctx, parent, config, grid, combinations)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := newProgressShares(len(children), progress)
+	runs := make([]*OptimizationRun, len(children))
+	var pending []int
+	for i, child := range children {
+		run := &OptimizationRun{
+			BacktestID:  child.ID,
+			Parameters:  grid.params(combinations[i]),
+			Status:      child.Status,
+			combination: combinations[i],
+		}
+		runs[i] = run
+		switch child.Status {
+		case "failed":
+			if child.Error != nil {
+				run.Error = *child.Error
+			}
+			shares.set(i, 1)
+			continue
+		case "completed":
+			var childReport Report
+			if err := json.Unmarshal(child.Results, &childReport); err == nil {
+				run.measure(&childReport, optimization.objective())
+				shares.set(i, 1)
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+	parallel(ctx, len(pending), optimization.concurrency(), func(k int) {
+		i := pending[k]
+		r.runChild(ctx, children[i], runs[i], optimization.objective(), func(done float64) { shares.set(i, done) })
+	})
+
+	if ctx.Err() != nil {
+		if errors.Is(context.Cause(ctx), ErrCancelled) {
+			for _, run := range runs {
+				if run.Status != "completed" && run.Status != "failed" {
+					r.update(context.WithoutCancel(ctx), run.BacktestID, "cancelled", 0, nil, nil)
+				}
+			}
+		}
+		return nil, ctx.Err()
+	}
+	return newOptimizationReport(optimization, grid, runs), nil
+}
+
+// children returns the child backtests of the parameter sets of an
+// optimization, creating those that don't exist yet
+func (r *Runner) children(ctx context.Context, parent *database.Backtest, config RunConfig, grid *paramGrid, combinations [][]int) ([]*database.Backtest, error) {
+	existing, err := r.backtests.ListBacktests(ctx, nil, nil, &parent.ID, MaxOptimizationRuns, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load child backtests: %w", err)
+	}
+	// Children are told apart by their parameters, compared in a canonical
+	// encoding as the database reformats JSON
+	byParams := make(map[string]*database.Backtest, len(existing))
+	for _, child := range existing {
+		values, err := parameters(child)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(values)
+		byParams[string(key)] = child
+	}
+
+	base, err := parameters(parent)
+	if err != nil {
+		return nil, err
+	}
+	config.Optimization = nil
+	childConfig, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]*database.Backtest, len(combinations))
+	for i, combination := range combinations {
+		values, err := json.Marshal(mergeParams(base, grid.params(combination)))
+		if err != nil {
+			return nil, err
+		}
+		if child, ok := byParams[string(values)]; ok {
+			children[i] = child
+			continue
+		}
+		child := &database.Backtest{
+			Name:       fmt.Sprintf("%s #%d", parent.Name, i+1),
+			StrategyID: parent.StrategyID,
+			VersionID:  parent.VersionID,
+			Kind:       KindBacktest,
+			ParentID:   &parent.ID,
+			Symbols:    parent.Symbols,
+			StartDate:  parent.StartDate,
+			EndDate:    parent.EndDate,
+			Parameters: values,
+			Config:     childConfig,
+		}
+		if err := r.backtests.CreateBacktest(ctx, child); err != nil {
+			return nil, fmt.Errorf("failed to create child backtest: %w", err)
+		}
+		children[i] = child
+	}
+	return children, nil
+}
+
+// runChild runs a child backtest of an optimization and records its outcome
+// on it and on its run. A child stopped by ctx is left for its optimization
+// to record.
+func (r *Runner) runChild(ctx context.Context, child *database.Backtest, run *OptimizationRun, objective string, progress func(float64)) {
+	run.Status = "running"
+	r.update(ctx, child.ID, "running", 0, nil, nil)
+	report, err := r.run(ctx, child, progress)
+	if ctx.Err() != nil {
+		return
+	}
+	var results json.RawMessage
+	if err == nil {
+		results, err = json.Marshal(report)
+	}
+	if err != nil {
+		run.Status, run.Error = "failed", err.Error()
+		r.update(ctx, child.ID, "failed", 0, nil, &run.Error)
+	} else {
+		run.Status = "completed"
+		run.measure(report, objective)
+		r.update(ctx, child.ID, "completed", 1, results, nil)
+	}
+	progress(1)
+}
+
+// update records the status of a backtest, logging failures
+func (r *Runner) update(ctx context.Context, id, status string, progress float64, results json.RawMessage, errMsg *string) {
+	if err := r.backtests.UpdateBacktestStatus(ctx, id, status, progress, results, errMsg); err != nil {
+		log.Printf("Failed to update status of backtest %s: %v", id, err)
+	}
+}
+
+// walkForward runs a walk-forward analysis. The parameter sets of its
+// optimization are backtested on each train window, and the best of them on
+// the test window after it. Its backtests run in memory; progress is the
+// share of them done.
+func (r *Runner) walkForward(ctx context.Context, backtest *database.Backtest, progress func(float64)) (*WalkForwardReport, error) {
+	config, err := runConfig(backtest)
+	if err != nil {
+		return nil, err
+	}
+	optimization, walkForward := config.Optimization, config.WalkForward
+	if optimization == nil || walkForward == nil {
+		return nil, fmt.Errorf("walk-forward analysis has no optimization or walk-forward settings")
+	}
+	windows, err := walkForward.Windows(backtest.StartDate, backtest.EndDate.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	grid, combinations, err := optimization.combinations()
+	if err != nil {
+		return nil, err
+	}
+	base, err := parameters(backtest)
+	if err != nil {
+		return nil, err
+	}
+	config.Optimization, config.WalkForward = nil, nil
+	windowConfig, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	// window returns the backtest of a parameter set over a window
+	window := func(start, end time.Time, params map[string]interface{}) (*database.Backtest, error) {
+		values, err := json.Marshal(mergeParams(base, params))
+		if err != nil {
+			return nil, err
+		}
+		windowed := *backtest
+		windowed.StartDate, windowed.EndDate = start, end.AddDate(0, 0, -1) // end dates are inclusive
+		windowed.Parameters, windowed.Config = values, windowConfig
+		return &windowed, nil
+	}
+
+	// Each window backtests every parameter set, then the best one
+	parts := len(combinations) + 1
+	shares := newProgressShares(len(windows)*parts, progress)
+	report := &WalkForwardReport{
+		Mode:        walkForward.mode(),
+		Objective:   optimization.objective(),
+		Windows:     make([]*WalkForwardWindow, len(windows)),
+		OutOfSample: []*BacktestResult{},
+	}
+	var inSample, outOfSample float64
+	tested := 0
+	for w, bounds := range windows {
+		result := &WalkForwardWindow{Window: bounds}
+		report.Windows[w] = result
+
+		runs := make([]*OptimizationRun, len(combinations))
+		parallel(ctx, len(combinations), optimization.concurrency(), func(i int) {
+			run := &OptimizationRun{Parameters: grid.params(combinations[i]), combination: combinations[i]}
+			runs[i] = run
+			train, err := window(bounds.TrainStart, bounds.TrainEnd, run.Parameters)
+			if err == nil {
+				var trainReport *Report
+				if trainReport, err = r.run(ctx, train, func(done float64) { shares.set(w*parts+i, done) }); err == nil {
+					run.Status = "completed"
+					run.measure(trainReport, optimization.objective())
+				}
+			}
+			if err != nil {
+				run.Status, run.Error = "failed", err.Error()
+			}
+			shares.set(w*parts+i, 1)
+		})
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		best := newOptimizationReport(optimization, grid, runs).Best()
+		if best == nil {
+			// Sets all failing is the strategy failing, not a lack of trades
+			if allFailed(runs) {
+				return nil, fmt.Errorf("window %d: %s", w+1, runs[0].Error)
+			}
+			shares.set(w*parts+len(combinations), 1)
+			continue
+		}
+		metrics := best.Metrics
+		result.Parameters, result.InSample = best.Parameters, &metrics
+
+		test, err := window(bounds.TestStart, bounds.TestEnd, best.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		testReport, err := r.run(ctx, test, func(done float64) { shares.set(w*parts+len(combinations), done) })
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", w+1, err)
+		}
+		result.OutOfSample = &Metrics{}
+		result.OutOfSample.measure(testReport, optimization.objective())
+		result.Efficiency = efficiency(result.InSample.AnnualizedReturn, result.OutOfSample.AnnualizedReturn)
+		inSample += result.InSample.AnnualizedReturn
+		outOfSample += result.OutOfSample.AnnualizedReturn
+		tested++
+		report.OutOfSample = stitch(report.OutOfSample, testReport)
+	}
+
+	if tested > 0 {
+		report.Efficiency = efficiency(inSample/float64(tested), outOfSample/float64(tested))
+	}
+	if err := measureStitched(report.OutOfSample); err != nil {
+		return nil, err
+	}
+	report.Stability = stability(grid, report.Windows)
+	return report, nil
+}
+
+// allFailed reports whether every run failed
+func allFailed(runs []*OptimizationRun) bool {
+	for _, run := range runs {
+		if run.Status != "failed" {
+			return false
+		}
+	}
+	return true
+}
+
+// parallel calls fn with each index from 0 to n-1, running up to limit calls
+// at once, and returns once they return. No calls start once ctx is done.
+func parallel(ctx context.Context, n, limit int, fn func(i int)) {
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// progressShares reports the progress of work made of parts done in
+// parallel, as the mean progress of the parts
+type progressShares struct {
+	mu     sync.Mutex
+	shares []float64
+	report func(float64)
+}
+
+func newProgressShares(parts int, report func(float64)) *progressShares {
+	return &progressShares{shares: make([]float64, parts), report: report}
+}
+
+// set records the progress of a part, from 0 to 1
+func (p *progressShares) set(part int, done float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.shares[part] = done
+	total := 0.0
+	for _, share := range p.shares {
+		total += share
+	}
+	p.report(total / float64(len(p.shares)))
+}
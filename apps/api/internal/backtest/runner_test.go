@@ -0,0 +1,433 @@
+package backtest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/redis"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBacktests keeps backtests in memory
+type fakeBacktests struct {
+	mu        sync.Mutex
+	backtests map[string]*database.Backtest
+	order     []string // IDs in the order backtests were added
+	created   int      // backtests created by the runner
+}
+
+func (f *fakeBacktests) add(backtest *database.Backtest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.backtests == nil {
+		f.backtests = make(map[string]*database.Backtest)
+	}
+	f.backtests[backtest.ID] = backtest
+	f.order = append(f.order, backtest.ID)
+}
+
+func (f *fakeBacktests) CreateBacktest(ctx context.Context, backtest *database.Backtest) error {
+	f.mu.Lock()
+	f.created++
+	backtest.ID = fmt.Sprintf("child-%d", f.created)
+	f.mu.Unlock()
+	backtest.Status = "pending"
+	copied := *backtest
+	f.add(&copied)
+	return nil
+}
+
+func (f *fakeBacktests) ListBacktests(ctx context.Context, strategyID, status, parentID *string, limit, offset int) ([]*database.Backtest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var backtests []*database.Backtest
+	for _, id := range f.order {
+		backtest := f.backtests[id]
+		if parentID != nil && (backtest.ParentID == nil || *backtest.ParentID != *parentID) {
+			continue
+		}
+		copied := *backtest
+		backtests = append(backtests, &copied)
+	}
+	return backtests, nil
+}
+
+func (f *fakeBacktests) get(id string) database.Backtest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.backtests[id]
+}
+
+func (f *fakeBacktests) GetBacktestByID(ctx context.Context, id string) (*database.Backtest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	backtest, ok := f.backtests[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *backtest
+	return &copied, nil
+}
+
+func (f *fakeBacktests) UpdateBacktestStatus(ctx context.Context, id, status string, progress float64, results json.RawMessage, errMsg *string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	backtest := f.backtests[id]
+	backtest.Status, backtest.Progress, backtest.Results, backtest.Error = status, progress, results, errMsg
+	return nil
+}
+
+// fakeStrategies serves one version of the threshold strategy with a typed
+// quantity parameter
+type fakeStrategies struct {
+	code string
+}
+
+func (f fakeStrategies) GetVersionByID(ctx context.Context, id string) (*database.StrategyVersion, error) {
+	return &database.StrategyVersion{ID: id, PackageID: "threshold", Code: f.code}, nil
+}
+
+func (f fakeStrategies) GetParamsByVersionID(ctx context.Context, versionID string) ([]*database.StrategyParam, error) {
+	return []*database.StrategyParam{
+		{ParamName: "buy_below", ParamType: "float", IsRequired: true},
+		{ParamName: "sell_above", ParamType: "float", IsRequired: true},
+		{ParamName: "quantity", ParamType: "int", IsRequired: true},
+	}, nil
+}
+
+// gatedData serves staticData once release is closed
+type gatedData struct {
+	staticData
+	release chan struct{}
+}
+
+func (d gatedData) GetHistoricalData(symbol string, startDate, endDate time.Time, interval string) ([]Bar, error) {
+	<-d.release
+	return d.staticData.GetHistoricalData(symbol, startDate, endDate, interval)
+}
+
+// dailyData serves fixed closes per symbol as a bar a day from testStart,
+// within the dates asked for
+type dailyData map[string][]float64
+
+func (d dailyData) GetHistoricalData(symbol string, startDate, endDate time.Time, interval string) ([]Bar, error) {
+	var bars []Bar
+	for i, close := range d[symbol] {
+		timestamp := testStart.AddDate(0, 0, i)
+		if timestamp.Before(startDate) || !timestamp.Before(endDate) {
+			continue
+		}
+		bars = append(bars, Bar{Timestamp: timestamp, Open: close, High: close, Low: close, Close: close, Volume: 1000})
+	}
+	return bars, nil
+}
+
+func queuedBacktest(id string, symbols ...string) *database.Backtest {
+	version := "version-1"
+	return &database.Backtest{
+		ID:         id,
+		StrategyID: "threshold",
+		VersionID:  &version,
+		Symbols:    symbols,
+		StartDate:  testStart,
+		EndDate:    testStart,
+		Parameters: json.RawMessage(`{"buy_below": 100, "sell_above": 110, "quantity": 10}`),
+		Config:     json.RawMessage(`{"initial_balance": 10000, "commission": {"model": "per_share", "rate": 0.01}}`),
+		Status:     "pending",
+	}
+}
+
+// startRunner runs a runner until the end of the test and returns it with
+// its queue and the Redis server behind it
+func startRunner(t *testing.T, data DataProvider, backtests *fakeBacktests, code string) (*Runner, *redis.JobQueue, *testutil.Redis) {
+	t.Helper()
+	client, server := testutil.NewRedis(t)
+	queue := redis.NewJobQueue(client, redis.BacktestJobsStream, redis.BacktestWorkersGroup)
+	require.NoError(t, queue.Init(context.Background()))
+
+	runner := NewRunner(NewBacktestEngine(data, nil), queue, backtests, fakeStrategies{code: code}, RunnerConfig{
+		Workers:   2,
+		Consumer:  "test",
+		ClaimIdle: 100 * time.Millisecond,
+	})
+	runUntilCleanup(t, runner)
+	return runner, queue, server
+}
+
+// runUntilCleanup runs a runner until the end of the test
+func runUntilCleanup(t *testing.T, runner *Runner) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		runner.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func waitForStatus(t *testing.T, backtests *fakeBacktests, id, status string) database.Backtest {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		backtest := backtests.get(id)
+		if backtest.Status == status {
+			return backtest
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("backtest %s is %s, not %s (error: %s)", id, backtest.Status, status, stringOf(backtest.Error))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunner_RunsQueuedBacktestsPerSymbol(t *testing.T) {
+	backtests := &fakeBacktests{}
+	backtests.add(queuedBacktest("bt-1", "AAPL", "MSFT"))
+	runner, _, server := startRunner(t, staticData{"AAPL": {100, 110}, "MSFT": {95, 112}}, backtests, thresholdStrategy)
+
+	require.NoError(t, runner.Enqueue(context.Background(), "bt-1"))
+	backtest := waitForStatus(t, backtests, "bt-1", "completed")
+	assert.Equal(t, 1.0, backtest.Progress)
+	assert.Nil(t, backtest.Error)
+	assert.Eventually(t, func() bool {
+		return len(server.Pending(redis.BacktestJobsStream, redis.BacktestWorkersGroup)) == 0
+	}, time.Second, 10*time.Millisecond, "the job is acknowledged")
+
+	var report Report
+	require.NoError(t, json.Unmarshal(backtest.Results, &report))
+	require.Len(t, report.Results, 2)
+	for i, symbol := range []string{"AAPL", "MSFT"} {
+		result := report.Results[i]
+		assert.Equal(t, symbol, result.Config.Symbol)
+		assert.Equal(t, 10000.0, result.Config.InitialBalance)
+		assert.Equal(t, "per_share(0.01/share)", result.CommissionModel)
+		require.NotEmpty(t, result.Trades)
+		assert.Equal(t, 10.0, result.Trades[0].Quantity, "parameters take their declared types again")
+	}
+}
+
+func TestRunner_FailedBacktestsRecordTheError(t *testing.T) {
+	backtests := &fakeBacktests{}
+	backtests.add(queuedBacktest("bt-1", "AAPL"))
+	runner, _, _ := startRunner(t, staticData{"AAPL": {100}}, backtests, "def on_bar(symbol, bar):\n    undefined()\n")
+
+	require.NoError(t, runner.Enqueue(context.Background(), "bt-1"))
+	backtest := waitForStatus(t, backtests, "bt-1", "failed")
+	require.NotNil(t, backtest.Error)
+	assert.Contains(t, *backtest.Error, "undefined")
+	assert.Nil(t, backtest.Results)
+}
+
+func TestRunner_CancelStopsTheWorkerOfAnotherProcess(t *testing.T) {
+	backtests := &fakeBacktests{}
+	backtests.add(queuedBacktest("bt-1", "AAPL"))
+	data := gatedData{staticData: staticData{"AAPL": {100, 110, 100, 110}}, release: make(chan struct{})}
+	runner, queue, _ := startRunner(t, data, backtests, thresholdStrategy)
+
+	require.NoError(t, runner.Enqueue(context.Background(), "bt-1"))
+	waitForStatus(t, backtests, "bt-1", "running")
+
+	// The API process only shares the queue with the worker
+	api := NewRunner(nil, queue, backtests, nil, RunnerConfig{})
+	require.NoError(t, api.Cancel(context.Background(), "bt-1"))
+	require.Eventually(t, func() bool {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+		job := runner.running["bt-1"]
+		return context.Cause(job.ctx) == ErrCancelled
+	}, 5*time.Second, 10*time.Millisecond)
+	close(data.release)
+
+	backtest := waitForStatus(t, backtests, "bt-1", "cancelled")
+	assert.Nil(t, backtest.Results)
+}
+
+func TestRunner_SkipsBacktestsCancelledWhileQueued(t *testing.T) {
+	backtests := &fakeBacktests{}
+	cancelled := queuedBacktest("bt-1", "AAPL")
+	cancelled.Status = "cancelled"
+	backtests.add(cancelled)
+	backtests.add(queuedBacktest("bt-2", "AAPL"))
+	runner, _, server := startRunner(t, staticData{"AAPL": {100, 110}}, backtests, thresholdStrategy)
+
+	require.NoError(t, runner.Enqueue(context.Background(), "bt-1"))
+	require.NoError(t, runner.Enqueue(context.Background(), "bt-2"))
+	waitForStatus(t, backtests, "bt-2", "completed")
+	assert.Equal(t, "cancelled", backtests.get("bt-1").Status)
+	assert.Eventually(t, func() bool {
+		return len(server.Pending(redis.BacktestJobsStream, redis.BacktestWorkersGroup)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRunner_ReclaimsJobsOfCrashedWorkers(t *testing.T) {
+	backtests := &fakeBacktests{}
+	orphan := queuedBacktest("bt-1", "AAPL")
+	orphan.Status, orphan.Progress = "running", 0.5
+	backtests.add(orphan)
+	client, server := testutil.NewRedis(t)
+	queue := redis.NewJobQueue(client, redis.BacktestJobsStream, redis.BacktestWorkersGroup)
+	require.NoError(t, queue.Init(context.Background()))
+
+	// A worker took the job and crashed
+	_, err := queue.Push(context.Background(), map[string]interface{}{"backtest_id": "bt-1"})
+	require.NoError(t, err)
+	job, err := queue.Next(context.Background(), "crashed-1", time.Minute, 0)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+
+	runner := NewRunner(NewBacktestEngine(staticData{"AAPL": {100, 110}}, nil), queue, backtests, fakeStrategies{code: thresholdStrategy}, RunnerConfig{
+		Consumer:  "test",
+		ClaimIdle: 50 * time.Millisecond,
+	})
+	runUntilCleanup(t, runner)
+
+	waitForStatus(t, backtests, "bt-1", "completed")
+	assert.Eventually(t, func() bool {
+		return len(server.Pending(redis.BacktestJobsStream, redis.BacktestWorkersGroup)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func queuedOptimization(id string) *database.Backtest {
+	optimization := queuedBacktest(id, "AAPL")
+	optimization.Name = "tuning"
+	optimization.Kind = KindOptimization
+	optimization.Parameters = json.RawMessage(`{"quantity": 10}`)
+	optimization.Config = json.RawMessage(`{"initial_balance": 10000, "optimization": {
+		"parameters": [{"name": "buy_below", "values": [95, 100]}, {"name": "sell_above", "values": [105, 110]}],
+		"objective": "total_return", "min_trades": 2, "concurrency": 2}}`)
+	return optimization
+}
+
+func TestRunner_OptimizesOverChildBacktests(t *testing.T) {
+	backtests := &fakeBacktests{}
+	backtests.add(queuedOptimization("opt-1"))
+	runner, _, _ := startRunner(t, staticData{"AAPL": {100, 102, 110, 104, 95, 97}}, backtests, thresholdStrategy)
+
+	require.NoError(t, runner.Enqueue(context.Background(), "opt-1"))
+	optimization := waitForStatus(t, backtests, "opt-1", "completed")
+	assert.Equal(t, 1.0, optimization.Progress)
+
+	children, err := backtests.ListBacktests(context.Background(), nil, nil, &optimization.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, children, 4)
+	for i, child := range children {
+		assert.Equal(t, "completed", child.Status)
+		assert.Equal(t, KindBacktest, child.Kind)
+		assert.Equal(t, fmt.Sprintf("tuning #%d", i+1), child.Name)
+		assert.NotContains(t, string(child.Config), "optimization")
+	}
+
+	var report OptimizationReport
+	require.NoError(t, json.Unmarshal(optimization.Results, &report))
+	assert.Equal(t, ObjectiveTotalReturn, report.Objective)
+	require.Len(t, report.Runs, 4)
+	// Buying at 100 trades both reversals; buying at 95 only the second
+	best := report.Runs[0]
+	assert.Equal(t, 1, best.Rank)
+	assert.Equal(t, children[2].ID, best.BacktestID)
+	assert.Equal(t, map[string]interface{}{"buy_below": 100.0, "sell_above": 105.0}, best.Parameters)
+	assert.InDelta(t, 0.027, best.Objective, 1e-9)
+	assert.Equal(t, 2, best.TotalTrades)
+	assert.Equal(t, 2, report.Runs[1].Rank)
+	assert.Zero(t, report.Runs[2].Rank, "one trade is too few")
+	assert.Equal(t, 1, report.Runs[2].TotalTrades)
+
+	require.Len(t, report.Heatmaps, 1)
+	heatmap := report.Heatmaps[0]
+	assert.Nil(t, heatmap.Values[0][0])
+	assert.InDelta(t, 0.027, *heatmap.Values[1][1], 1e-9)
+}
+
+func TestRunner_ReclaimedOptimizationsReuseTheirChildren(t *testing.T) {
+	backtests := &fakeBacktests{}
+	optimization := queuedOptimization("opt-1")
+	optimization.Status = "running"
+	backtests.add(optimization)
+	// The crashed worker finished the first child and created the second
+	done := queuedBacktest("done", "AAPL")
+	done.ParentID, done.Status = &optimization.ID, "completed"
+	done.Parameters = json.RawMessage(`{"buy_below": 95, "quantity": 10, "sell_above": 105}`)
+	done.Results = json.RawMessage(`{"results": [{"trades": [], "equity": [], "performance": {"total_return": 0.5, "total_trades": 3}}]}`)
+	backtests.add(done)
+	started := queuedBacktest("started", "AAPL")
+	started.ParentID, started.Status = &optimization.ID, "running"
+	started.Parameters = json.RawMessage(`{"buy_below": 95, "quantity": 10, "sell_above": 110}`)
+	backtests.add(started)
+	runner, _, _ := startRunner(t, staticData{"AAPL": {100, 102, 110, 104, 95, 97}}, backtests, thresholdStrategy)
+
+	require.NoError(t, runner.Enqueue(context.Background(), "opt-1"))
+	completed := waitForStatus(t, backtests, "opt-1", "completed")
+	assert.Equal(t, 2, backtests.created, "the other two children are created")
+	assert.Equal(t, "completed", backtests.get("started").Status)
+
+	var report OptimizationReport
+	require.NoError(t, json.Unmarshal(completed.Results, &report))
+	assert.Equal(t, "done", report.Runs[0].BacktestID, "its stored results are ranked")
+	assert.Equal(t, 0.5, report.Runs[0].Objective)
+}
+
+func TestRunner_WalksForwardOverRollingWindows(t *testing.T) {
+	backtests := &fakeBacktests{}
+	analysis := queuedBacktest("wf-1", "AAPL")
+	analysis.Kind = KindWalkForward
+	analysis.StartDate = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	analysis.EndDate = analysis.StartDate.AddDate(0, 0, 9)
+	analysis.Parameters = json.RawMessage(`{"quantity": 10, "sell_above": 110}`)
+	analysis.Config = json.RawMessage(`{"initial_balance": 10000,
+		"optimization": {"parameters": [{"name": "buy_below", "values": [95, 100]}], "objective": "total_return"},
+		"walk_forward": {"train_days": 4, "test_days": 2}}`)
+	backtests.add(analysis)
+	data := dailyData{"AAPL": {100, 110, 100, 110, 95, 110, 100, 110, 95, 110}}
+	runner, _, _ := startRunner(t, data, backtests, thresholdStrategy)
+
+	require.NoError(t, runner.Enqueue(context.Background(), "wf-1"))
+	completed := waitForStatus(t, backtests, "wf-1", "completed")
+	assert.Equal(t, 1.0, completed.Progress)
+
+	var report WalkForwardReport
+	require.NoError(t, json.Unmarshal(completed.Results, &report))
+	assert.Equal(t, WalkForwardRolling, report.Mode)
+	require.Len(t, report.Windows, 3)
+	// Buying at 100 trades every swing of each train window
+	for i, window := range report.Windows {
+		assert.Equal(t, analysis.StartDate.AddDate(0, 0, 2*i), window.TrainStart)
+		assert.Equal(t, analysis.StartDate.AddDate(0, 0, 4+2*i), window.TestStart)
+		assert.Equal(t, map[string]interface{}{"buy_below": 100.0}, window.Parameters)
+		require.NotNil(t, window.OutOfSample)
+		assert.Equal(t, 1, window.OutOfSample.TotalTrades)
+		assert.Greater(t, window.Efficiency, 0.0)
+	}
+	assert.InDelta(t, 0.03, report.Windows[0].InSample.TotalReturn, 1e-9)
+	assert.InDelta(t, 0.015, report.Windows[0].OutOfSample.TotalReturn, 1e-9)
+	assert.Greater(t, report.Efficiency, 0.0)
+
+	// The test windows gain 150, 100 and 150
+	require.Len(t, report.OutOfSample, 1)
+	stitched := report.OutOfSample[0]
+	require.Len(t, stitched.Equity, 6)
+	assert.Equal(t, 10400.0, stitched.Equity[5].Equity)
+	assert.Len(t, stitched.Trades, 3)
+	assert.InDelta(t, 0.04, stitched.Performance.TotalReturn, 1e-9)
+
+	require.Len(t, report.Stability, 1)
+	assert.Equal(t, []interface{}{100.0, 100.0, 100.0}, report.Stability[0].Values)
+	assert.Zero(t, report.Stability[0].Changes)
+}
+
+func stringOf(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
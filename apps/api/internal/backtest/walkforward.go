@@ -0,0 +1,231 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/analytics"
+)
+
+// KindWalkForward is the kind of walk-forward analyses
+const KindWalkForward = "walk_forward"
+
+// Walk-forward window modes
+const (
+	WalkForwardRolling  = "rolling"
+	WalkForwardAnchored = "anchored"
+)
+
+// MaxWalkForwardWindows bounds the windows of a walk-forward analysis
+const MaxWalkForwardWindows = 100
+
+// WalkForwardConfig splits the period of a walk-forward analysis into train
+// and test windows. The test windows follow each other from the end of the
+// first train window. Rolling train windows are the TrainDays before their
+// test window; anchored ones all start at the start of the period.
+type WalkForwardConfig struct {
+	Mode      string `json:"mode,omitempty"` // rolling (default) or anchored
+	TrainDays int    `json:"train_days"`
+	TestDays  int    `json:"test_days"`
+}
+
+// Validate checks a walk-forward configuration
+func (config *WalkForwardConfig) Validate() error {
+	switch config.Mode {
+	case "", WalkForwardRolling, WalkForwardAnchored:
+	default:
+		return fmt.Errorf("invalid walk-forward mode %q: must be rolling or anchored", config.Mode)
+	}
+	if config.TrainDays <= 0 || config.TestDays <= 0 {
+		return fmt.Errorf("walk-forward train and test days must be positive")
+	}
+	return nil
+}
+
+func (config *WalkForwardConfig) mode() string {
+	if config.Mode == "" {
+		return WalkForwardRolling
+	}
+	return config.Mode
+}
+
+// Window is a train window and the test window after it. Windows end at the
+// start of their end day, so that a test window ends where the next starts.
+type Window struct {
+	TrainStart time.Time `json:"train_start"`
+	TrainEnd   time.Time `json:"train_end"`
+	TestStart  time.Time `json:"test_start"`
+	TestEnd    time.Time `json:"test_end"`
+}
+
+// Windows splits the period from start up to end into windows. The last test
+// window is cut short at end.
+func (config *WalkForwardConfig) Windows(start, end time.Time) ([]Window, error) {
+	var windows []Window
+	for testStart := start.AddDate(0, 0, config.TrainDays); testStart.Before(end); testStart = testStart.AddDate(0, 0, config.TestDays) {
+		if len(windows) == MaxWalkForwardWindows {
+			return nil, fmt.Errorf("walk-forward analysis has more than %d windows", MaxWalkForwardWindows)
+		}
+		window := Window{
+			TrainStart: testStart.AddDate(0, 0, -config.TrainDays),
+			TrainEnd:   testStart,
+			TestStart:  testStart,
+			TestEnd:    testStart.AddDate(0, 0, config.TestDays),
+		}
+		if config.mode() == WalkForwardAnchored {
+			window.TrainStart = start
+		}
+		if window.TestEnd.After(end) {
+			window.TestEnd = end
+		}
+		windows = append(windows, window)
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("period is too short for a %d day train window and a test window", config.TrainDays)
+	}
+	return windows, nil
+}
+
+// WalkForwardWindow is the outcome of a window: the best parameter set of the
+// train window and how it did on the test window. Windows whose train window
+// ranked no parameter set have no parameters and are not tested.
+type WalkForwardWindow struct {
+	Window
+	Parameters  map[string]interface{} `json:"parameters"` // the optimized parameters
+	InSample    *Metrics               `json:"in_sample"`
+	OutOfSample *Metrics               `json:"out_of_sample"`
+	Efficiency  float64                `json:"efficiency"` // out-of-sample over in-sample geometric annualized return
+}
+
+// ParameterStability shows how the value chosen for an optimized parameter
+// varies across windows. Numeric values have their mean, standard deviation
+// and coefficient of variation.
+type ParameterStability struct {
+	Name                   string        `json:"name"`
+	Values                 []interface{} `json:"values"`  // by window; null for windows without parameters
+	Changes                int           `json:"changes"` // windows choosing another value than the window before
+	Mean                   float64       `json:"mean"`
+	StdDev                 float64       `json:"std_dev"`
+	CoefficientOfVariation float64       `json:"coefficient_of_variation"` // standard deviation over the absolute mean
+}
+
+// WalkForwardReport is the outcome of a walk-forward analysis, stored as its
+// results. OutOfSample stitches the test windows together, a result per
+// symbol, or one for a portfolio strategy.
+type WalkForwardReport struct {
+	Mode        string                `json:"mode"`
+	Objective   string                `json:"objective"`
+	Windows     []*WalkForwardWindow  `json:"windows"`
+	OutOfSample []*BacktestResult     `json:"out_of_sample"`
+	Efficiency  float64               `json:"efficiency"` // mean out-of-sample over mean in-sample annualized return
+	Stability   []*ParameterStability `json:"stability"`
+}
+
+// efficiency returns the walk-forward efficiency of annualized returns, zero
+// when the in-sample return is not positive. The returns are compounded over
+// a year, so windows of different lengths compare alike: a test window
+// growing at the rate of its train window has an efficiency of 1, however
+// volatile either is.
+func efficiency(inSample, outOfSample float64) float64 {
+	if inSample <= 0 {
+		return 0
+	}
+	return outOfSample / inSample
+}
+
+// stitch appends the results of a test window to the out-of-sample results.
+// Each test window starts with the initial balance; its gains and losses are
+// added to the equity the windows before it ended with.
+func stitch(stitched []*BacktestResult, report *Report) []*BacktestResult {
+	for i, result := range report.Results {
+		if i == len(stitched) {
+			config := *result.Config
+			config.Strategy, config.Progress = nil, nil
+			stitched = append(stitched, &BacktestResult{
+				Config:          &config,
+				Trades:          []Trade{},
+				Equity:          []EquityPoint{},
+				FillModel:       result.FillModel,
+				CommissionModel: result.CommissionModel,
+			})
+		}
+		out := stitched[i]
+		offset := 0.0
+		if len(out.Equity) > 0 {
+			offset = out.Equity[len(out.Equity)-1].Equity - out.Config.InitialBalance
+		}
+		for _, point := range result.Equity {
+			point.Equity += offset
+			out.Equity = append(out.Equity, point)
+		}
+		for _, trade := range result.Trades {
+			trade.ID = fmt.Sprintf("trade-%d", len(out.Trades)+1)
+			out.Trades = append(out.Trades, trade)
+		}
+		out.Config.EndDate = result.Config.EndDate
+		out.CompletedAt = result.CompletedAt
+	}
+	return stitched
+}
+
+// measureStitched recomputes the drawdowns and performance of stitched
+// results over their whole equity curves
+func measureStitched(stitched []*BacktestResult) error {
+	for _, result := range stitched {
+		peak := result.Config.InitialBalance
+		for i := range result.Equity {
+			point := &result.Equity[i]
+			peak = math.Max(peak, point.Equity)
+			point.Drawdown = 0
+			if peak > 0 {
+				point.Drawdown = (peak - point.Equity) / peak
+			}
+		}
+		config, err := result.Config.Analytics.config()
+		if err != nil {
+			return err
+		}
+		result.Performance = analytics.Compute(result.Config.InitialBalance, result.Equity, result.Trades, config)
+	}
+	return nil
+}
+
+// stability returns the stability of each optimized parameter across windows
+func stability(grid *paramGrid, windows []*WalkForwardWindow) []*ParameterStability {
+	stabilities := make([]*ParameterStability, len(grid.names))
+	for i, name := range grid.names {
+		stability := &ParameterStability{Name: name, Values: make([]interface{}, len(windows))}
+		var numbers []float64
+		var previous interface{}
+		for w, window := range windows {
+			if window.Parameters == nil {
+				continue
+			}
+			value := window.Parameters[name]
+			stability.Values[w] = value
+			if previous != nil && !reflect.DeepEqual(previous, value) {
+				stability.Changes++
+			}
+			previous = value
+			if number, ok := value.(float64); ok {
+				numbers = append(numbers, number)
+			}
+		}
+		if len(numbers) > 0 {
+			for _, number := range numbers {
+				stability.Mean += number / float64(len(numbers))
+			}
+			for _, number := range numbers {
+				stability.StdDev += (number - stability.Mean) * (number - stability.Mean) / float64(len(numbers))
+			}
+			stability.StdDev = math.Sqrt(stability.StdDev)
+			if stability.Mean != 0 {
+				stability.CoefficientOfVariation = stability.StdDev / math.Abs(stability.Mean)
+			}
+		}
+		stabilities[i] = stability
+	}
+	return stabilities
+}
@@ -0,0 +1,122 @@
+package backtest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/analytics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(d int) time.Time {
+	return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestWalkForwardConfig_Windows(t *testing.T) {
+	rolling := &WalkForwardConfig{TrainDays: 10, TestDays: 5}
+	windows, err := rolling.Windows(day(1), day(23))
+	require.NoError(t, err)
+	assert.Equal(t, []Window{
+		{TrainStart: day(1), TrainEnd: day(11), TestStart: day(11), TestEnd: day(16)},
+		{TrainStart: day(6), TrainEnd: day(16), TestStart: day(16), TestEnd: day(21)},
+		{TrainStart: day(11), TrainEnd: day(21), TestStart: day(21), TestEnd: day(23)},
+	}, windows, "the last test window is cut short")
+
+	anchored := &WalkForwardConfig{Mode: WalkForwardAnchored, TrainDays: 10, TestDays: 5}
+	windows, err = anchored.Windows(day(1), day(23))
+	require.NoError(t, err)
+	require.Len(t, windows, 3)
+	for _, window := range windows {
+		assert.Equal(t, day(1), window.TrainStart)
+	}
+	assert.Equal(t, day(21), windows[2].TrainEnd)
+
+	_, err = rolling.Windows(day(1), day(11))
+	assert.Error(t, err, "no room for a test window")
+	assert.Error(t, (&WalkForwardConfig{Mode: "expanding", TrainDays: 10, TestDays: 5}).Validate())
+	assert.Error(t, (&WalkForwardConfig{TrainDays: 10}).Validate())
+}
+
+func TestStitch_AddsTheGainsOfEachTestWindow(t *testing.T) {
+	window := func(start time.Time, equity ...float64) *Report {
+		result := &BacktestResult{
+			Config: &BacktestConfig{Symbol: "AAPL", StartDate: start, EndDate: start.AddDate(0, 0, 1), InitialBalance: 1000},
+			Trades: []Trade{{ID: "trade-1", PnL: equity[len(equity)-1] - 1000}},
+		}
+		for i, e := range equity {
+			result.Equity = append(result.Equity, EquityPoint{Timestamp: start.Add(time.Duration(i) * time.Hour), Equity: e})
+		}
+		return &Report{Results: []*BacktestResult{result}}
+	}
+
+	stitched := stitch(nil, window(day(1), 1000, 1100))
+	stitched = stitch(stitched, window(day(2), 950, 1050))
+	require.NoError(t, measureStitched(stitched))
+
+	require.Len(t, stitched, 1)
+	result := stitched[0]
+	var equity, drawdowns []float64
+	for _, point := range result.Equity {
+		equity = append(equity, point.Equity)
+		drawdowns = append(drawdowns, point.Drawdown)
+	}
+	assert.Equal(t, []float64{1000, 1100, 1050, 1150}, equity)
+	assert.InDeltaSlice(t, []float64{0, 0, 50.0 / 1100, 0}, drawdowns, 1e-9)
+	assert.Equal(t, day(1), result.Config.StartDate)
+	assert.Equal(t, day(3), result.Config.EndDate)
+	require.Len(t, result.Trades, 2)
+	assert.Equal(t, "trade-2", result.Trades[1].ID)
+	assert.InDelta(t, 0.15, result.Performance.TotalReturn, 1e-9)
+}
+
+func TestEfficiency_ComparesWindowsOfDifferentLengths(t *testing.T) {
+	measured := func(growth ...float64) *Metrics {
+		equity := []EquityPoint{}
+		value := 1000.0
+		for i, g := range growth {
+			value *= g
+			equity = append(equity, EquityPoint{Timestamp: day(i + 1), Equity: value})
+		}
+		result := &BacktestResult{Performance: analytics.Compute(1000, equity, nil, analytics.Config{})}
+		metrics := &Metrics{}
+		metrics.measure(&Report{Results: []*BacktestResult{result}}, ObjectiveSharpe)
+		return metrics
+	}
+
+	// A volatile train window and a short steady test window growing 4.5%
+	// every two days
+	train := measured(1.1, 0.95, 1.1, 0.95, 1.1, 0.95, 1.1, 0.95, 1.1, 0.95)
+	test := measured(math.Sqrt(1.045), math.Sqrt(1.045))
+
+	assert.InDelta(t, 1, efficiency(train.AnnualizedReturn, test.AnnualizedReturn), 1e-9)
+}
+
+func TestStability_ComparesTheValuesOfEachWindow(t *testing.T) {
+	config := &OptimizationConfig{Parameters: []ParamRange{
+		{Name: "period", Values: []interface{}{10.0, 20.0}},
+		{Name: "mode", Values: []interface{}{"fast", "slow"}},
+	}}
+	grid, err := config.grid()
+	require.NoError(t, err)
+
+	stabilities := stability(grid, []*WalkForwardWindow{
+		{Parameters: map[string]interface{}{"period": 10.0, "mode": "fast"}},
+		{},
+		{Parameters: map[string]interface{}{"period": 20.0, "mode": "fast"}},
+		{Parameters: map[string]interface{}{"period": 20.0, "mode": "slow"}},
+	})
+
+	require.Len(t, stabilities, 2)
+	period := stabilities[0]
+	assert.Equal(t, []interface{}{10.0, nil, 20.0, 20.0}, period.Values)
+	assert.Equal(t, 1, period.Changes, "windows without parameters are skipped")
+	assert.InDelta(t, 50.0/3, period.Mean, 1e-9)
+	assert.InDelta(t, 4.714045, period.StdDev, 1e-6)
+	assert.InDelta(t, 0.282843, period.CoefficientOfVariation, 1e-6)
+	mode := stabilities[1]
+	assert.Equal(t, 1, mode.Changes)
+	assert.Zero(t, mode.Mean)
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/moomoo-trading/api/internal/config"
 )
 
@@ -41,6 +42,14 @@ const (
 	OrderStatusRejected  OrderStatus = "REJECTED"
 )
 
+// TimeInForce represents how long an order stays working
+type TimeInForce string
+
+const (
+	TimeInForceDay TimeInForce = "DAY" // until the end of the trading day
+	TimeInForceGTC TimeInForce = "GTC" // until filled or cancelled
+)
+
 // Order represents a trading order
 type Order struct {
 	ID             string      `json:"id"`
@@ -51,6 +60,9 @@ type Order struct {
 	Quantity       float64     `json:"quantity"`
 	Price          *float64    `json:"price,omitempty"`
 	StopPrice      *float64    `json:"stop_price,omitempty"`
+	TrailAmount    *float64    `json:"trail_amount,omitempty"`
+	TrailPercent   *float64    `json:"trail_percent,omitempty"`
+	TimeInForce    TimeInForce `json:"time_in_force,omitempty"`
 	Status         OrderStatus `json:"status"`
 	FilledQuantity float64     `json:"filled_quantity"`
 	AvgFillPrice   *float64    `json:"avg_fill_price,omitempty"`
@@ -79,14 +91,94 @@ type MarketData struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// OrderUpdate reports a change in the state of an order. Order is a snapshot
+// taken after the change; Fill is set for executions and Reason for rejects.
+type OrderUpdate struct {
+	Order     Order     `json:"order"`
+	Fill      *Trade    `json:"fill,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 // MoomooAdapter represents the Moomoo broker adapter
 type MoomooAdapter struct {
 	config     *config.MoomooConfig
 	connection *MoomooConnection
 	mu         sync.RWMutex
 	orders     map[string]*Order
+	clientOrders map[string]*Order
 	trades     map[string]*Trade
 	subscribers map[string][]chan MarketData
+	lastTicks  map[string]MarketData
+	orderSubscribers []*orderSubscription
+	positions  PositionBook
+}
+
+// PositionBook records executions by symbol. risk.RiskManager implements it.
+type PositionBook interface {
+	UpdatePosition(symbol string, quantity float64, price float64, side string)
+}
+
+// orderSubscription queues order updates for one subscriber without bound,
+// so a slow consumer delays its updates but never loses them
+type orderSubscription struct {
+	out    chan OrderUpdate
+	mu     sync.Mutex
+	queue  []OrderUpdate
+	signal chan struct{}
+	done   chan struct{}
+}
+
+func newOrderSubscription() *orderSubscription {
+	sub := &orderSubscription{
+		out:    make(chan OrderUpdate),
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go sub.run()
+	return sub
+}
+
+// push queues an update and wakes the delivery goroutine
+func (sub *orderSubscription) push(update OrderUpdate) {
+	sub.mu.Lock()
+	sub.queue = append(sub.queue, update)
+	sub.mu.Unlock()
+
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued updates in order until the subscription is closed
+func (sub *orderSubscription) run() {
+	defer close(sub.out)
+	for {
+		select {
+		case <-sub.signal:
+		case <-sub.done:
+			return
+		}
+
+		for {
+			sub.mu.Lock()
+			if len(sub.queue) == 0 {
+				sub.mu.Unlock()
+				break
+			}
+			update := sub.queue[0]
+			sub.queue[0] = OrderUpdate{}
+			sub.queue = sub.queue[1:]
+			sub.mu.Unlock()
+
+			select {
+			case sub.out <- update:
+			case <-sub.done:
+				return
+			}
+		}
+	}
 }
 
 // MoomooConnection represents the connection to Moomoo OpenD
@@ -114,8 +206,10 @@ func NewMoomooAdapter(cfg *config.MoomooConfig) *MoomooAdapter {
 			appKey:   cfg.AppKey,
 		},
 		orders:      make(map[string]*Order),
+		clientOrders: make(map[string]*Order),
 		trades:      make(map[string]*Trade),
 		subscribers: make(map[string][]chan MarketData),
+		lastTicks:   make(map[string]MarketData),
 	}
 }
 
@@ -159,7 +253,8 @@ func (ma *MoomooAdapter) IsConnected() bool {
 	return ma.connection.connected
 }
 
-// PlaceOrder places a new order
+// PlaceOrder places a new order. Orders are idempotent on ClientOrderID: a
+// repeated submission returns the existing order's state instead of placing it again.
 func (ma *MoomooAdapter) PlaceOrder(ctx context.Context, order *Order) error {
 	if !ma.IsConnected() {
 		return fmt.Errorf("not connected to Moomoo OpenD")
@@ -168,6 +263,16 @@ func (ma *MoomooAdapter) PlaceOrder(ctx context.Context, order *Order) error {
 	ma.mu.Lock()
 	defer ma.mu.Unlock()
 
+	if order.ClientOrderID != "" {
+		if existing, exists := ma.clientOrders[order.ClientOrderID]; exists {
+			*order = *existing
+			return nil
+		}
+	}
+	if order.ID == "" {
+		order.ID = uuid.New().String()
+	}
+
 	// TODO: Implement actual order placement logic
 	// This is a placeholder for the actual order placement
 	
@@ -179,6 +284,9 @@ func (ma *MoomooAdapter) PlaceOrder(ctx context.Context, order *Order) error {
 	order.UpdatedAt = time.Now()
 	
 	ma.orders[order.ID] = order
+	if order.ClientOrderID != "" {
+		ma.clientOrders[order.ClientOrderID] = order
+	}
 	
 	return nil
 }
@@ -203,10 +311,117 @@ func (ma *MoomooAdapter) CancelOrder(ctx context.Context, orderID string) error
 	
 	order.Status = OrderStatusCancelled
 	order.UpdatedAt = time.Now()
+	ma.publishOrderUpdate(OrderUpdate{Order: *order, Timestamp: order.UpdatedAt})
 	
 	return nil
 }
 
+// SetPositionBook sets the book every fill is recorded in before subscribers
+// are notified, so positions do not depend on a consumer reading the update
+func (ma *MoomooAdapter) SetPositionBook(book PositionBook) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	ma.positions = book
+}
+
+// FillOrder records an execution against an order, books it in the position
+// book and notifies order subscribers
+func (ma *MoomooAdapter) FillOrder(ctx context.Context, orderID string, quantity, price float64) (*Trade, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	order, exists := ma.orders[orderID]
+	if !exists {
+		return nil, fmt.Errorf("order not found: %s", orderID)
+	}
+	remaining := order.Quantity - order.FilledQuantity
+	if quantity <= 0 || quantity > remaining {
+		return nil, fmt.Errorf("invalid fill quantity %.2f for order %s with %.2f remaining", quantity, orderID, remaining)
+	}
+
+	now := time.Now()
+	avg := price
+	if order.AvgFillPrice != nil {
+		avg = (*order.AvgFillPrice*order.FilledQuantity + price*quantity) / (order.FilledQuantity + quantity)
+	}
+	order.FilledQuantity += quantity
+	order.AvgFillPrice = &avg
+	order.Status = OrderStatusPartial
+	if order.FilledQuantity >= order.Quantity {
+		order.Status = OrderStatusFilled
+	}
+	order.UpdatedAt = now
+
+	trade := &Trade{
+		ID:        uuid.New().String(),
+		OrderID:   order.ID,
+		Symbol:    order.Symbol,
+		Side:      order.Side,
+		Quantity:  quantity,
+		Price:     price,
+		TradeTime: now,
+	}
+	ma.trades[trade.ID] = trade
+	if ma.positions != nil {
+		side := "LONG"
+		if trade.Side == OrderSideSell {
+			side = "SHORT"
+		}
+		ma.positions.UpdatePosition(trade.Symbol, trade.Quantity, trade.Price, side)
+	}
+	ma.publishOrderUpdate(OrderUpdate{Order: *order, Fill: trade, Timestamp: now})
+
+	return trade, nil
+}
+
+// RejectOrder marks an order as rejected by the exchange and notifies order subscribers
+func (ma *MoomooAdapter) RejectOrder(ctx context.Context, orderID, reason string) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	order, exists := ma.orders[orderID]
+	if !exists {
+		return fmt.Errorf("order not found: %s", orderID)
+	}
+
+	order.Status = OrderStatusRejected
+	order.UpdatedAt = time.Now()
+	ma.publishOrderUpdate(OrderUpdate{Order: *order, Reason: reason, Timestamp: order.UpdatedAt})
+
+	return nil
+}
+
+// SubscribeOrderUpdates subscribes to state changes of all orders
+func (ma *MoomooAdapter) SubscribeOrderUpdates(ctx context.Context) (<-chan OrderUpdate, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	sub := newOrderSubscription()
+	ma.orderSubscribers = append(ma.orderSubscribers, sub)
+	return sub.out, nil
+}
+
+// UnsubscribeOrderUpdates removes an order update subscription
+func (ma *MoomooAdapter) UnsubscribeOrderUpdates(updates <-chan OrderUpdate) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	for i, sub := range ma.orderSubscribers {
+		if sub.out == updates {
+			ma.orderSubscribers = append(ma.orderSubscribers[:i], ma.orderSubscribers[i+1:]...)
+			close(sub.done)
+			break
+		}
+	}
+}
+
+// publishOrderUpdate queues an update for every order subscriber; callers hold ma.mu
+func (ma *MoomooAdapter) publishOrderUpdate(update OrderUpdate) {
+	for _, sub := range ma.orderSubscribers {
+		sub.push(update)
+	}
+}
+
 // GetOrder retrieves an order by ID
 func (ma *MoomooAdapter) GetOrder(ctx context.Context, orderID string) (*Order, error) {
 	ma.mu.RLock()
@@ -248,12 +463,38 @@ func (ma *MoomooAdapter) SubscribeMarketData(ctx context.Context, symbol string)
 	// Add to subscribers
 	ma.subscribers[symbol] = append(ma.subscribers[symbol], dataChan)
 	
-	// TODO: Implement actual market data subscription
+	// TODO: Implement actual market data subscription. Quote pushes must be
+	// delivered through PublishMarketData so GetLastTick sees them.
 	log.Printf("Subscribing to market data for %s", symbol)
 	
 	return dataChan, nil
 }
 
+// PublishMarketData records the latest tick for a symbol and delivers it to
+// its subscribers. Subscribers that are not keeping up miss the tick rather
+// than blocking the feed.
+func (ma *MoomooAdapter) PublishMarketData(data MarketData) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.lastTicks[data.Symbol] = data
+	for _, ch := range ma.subscribers[data.Symbol] {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// GetLastTick returns the most recent tick received for a symbol
+func (ma *MoomooAdapter) GetLastTick(symbol string) (MarketData, bool) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	tick, exists := ma.lastTicks[symbol]
+	return tick, exists
+}
+
 // UnsubscribeMarketData unsubscribes from market data for a symbol
 func (ma *MoomooAdapter) UnsubscribeMarketData(ctx context.Context, symbol string, dataChan <-chan MarketData) error {
 	ma.mu.Lock()
@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/moomoo-trading/api/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingBook struct {
+	fills []string
+}
+
+func (b *recordingBook) UpdatePosition(symbol string, quantity float64, price float64, side string) {
+	b.fills = append(b.fills, side)
+}
+
+func TestMoomooAdapter_OrderUpdatesAreNeverDropped(t *testing.T) {
+	adapter := NewMoomooAdapter(&config.MoomooConfig{})
+	require.NoError(t, adapter.Connect(context.Background()))
+	book := &recordingBook{}
+	adapter.SetPositionBook(book)
+
+	updates, err := adapter.SubscribeOrderUpdates(context.Background())
+	require.NoError(t, err)
+	defer adapter.UnsubscribeOrderUpdates(updates)
+
+	const fills = 500
+	order := &Order{Symbol: "AAPL", Side: OrderSideBuy, Type: OrderTypeMarket, Quantity: fills}
+	require.NoError(t, adapter.PlaceOrder(context.Background(), order))
+	for i := 0; i < fills; i++ {
+		_, err := adapter.FillOrder(context.Background(), order.ID, 1, 100)
+		require.NoError(t, err)
+	}
+	assert.Len(t, book.fills, fills, "fills are booked without waiting for subscribers")
+
+	for i := 1; i <= fills; i++ {
+		update := <-updates
+		require.NotNil(t, update.Fill)
+		assert.Equal(t, float64(i), update.Order.FilledQuantity)
+	}
+}
+
+func TestMoomooAdapter_UnsubscribeClosesOrderUpdates(t *testing.T) {
+	adapter := NewMoomooAdapter(&config.MoomooConfig{})
+	require.NoError(t, adapter.Connect(context.Background()))
+	updates, err := adapter.SubscribeOrderUpdates(context.Background())
+	require.NoError(t, err)
+
+	order := &Order{Symbol: "AAPL", Side: OrderSideBuy, Type: OrderTypeMarket, Quantity: 1}
+	require.NoError(t, adapter.PlaceOrder(context.Background(), order))
+	require.NoError(t, adapter.RejectOrder(context.Background(), order.ID, "halted"))
+	adapter.UnsubscribeOrderUpdates(updates)
+
+	for range updates {
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -9,6 +11,9 @@ type Config struct {
 	Database    DatabaseConfig
 	Redis       RedisConfig
 	Moomoo      MoomooConfig
+	Risk        RiskConfig
+	Archive     ArchiveConfig
+	Backtest    BacktestConfig
 }
 
 type DatabaseConfig struct {
@@ -35,6 +40,30 @@ type MoomooConfig struct {
 	AppKey   string
 }
 
+// RiskConfig holds the limits live strategy orders are checked against.
+// Percentages are of the account balance.
+type RiskConfig struct {
+	MaxPositionSize        float64
+	MaxDailyLoss           float64
+	MaxWeeklyLoss          float64
+	MaxDrawdown            float64
+	MaxConcurrentPositions int
+	ATRRiskPerTrade        float64
+}
+
+// ArchiveConfig holds the key strategy archives are signed with. Environments
+// that exchange archives must share the key.
+type ArchiveConfig struct {
+	SigningKey string
+}
+
+// BacktestConfig sizes the backtest workers of this process. A backtest
+// whose worker goes ClaimIdle without a heartbeat is taken over by another.
+type BacktestConfig struct {
+	Workers   int
+	ClaimIdle time.Duration
+}
+
 func Load() *Config {
 	return &Config{
 		Environment: getEnv("ENVIRONMENT", "development"),
@@ -59,6 +88,21 @@ func Load() *Config {
 			AppID:    getEnv("MOOMOO_APP_ID", ""),
 			AppKey:   getEnv("MOOMOO_APP_KEY", ""),
 		},
+		Risk: RiskConfig{
+			MaxPositionSize:        getEnvFloat("RISK_MAX_POSITION_SIZE", 10),
+			MaxDailyLoss:           getEnvFloat("RISK_MAX_DAILY_LOSS", 2),
+			MaxWeeklyLoss:          getEnvFloat("RISK_MAX_WEEKLY_LOSS", 5),
+			MaxDrawdown:            getEnvFloat("RISK_MAX_DRAWDOWN", 10),
+			MaxConcurrentPositions: int(getEnvFloat("RISK_MAX_CONCURRENT_POSITIONS", 5)),
+			ATRRiskPerTrade:        getEnvFloat("RISK_ATR_RISK_PER_TRADE", 0.25),
+		},
+		Archive: ArchiveConfig{
+			SigningKey: getEnv("STRATEGY_ARCHIVE_KEY", ""),
+		},
+		Backtest: BacktestConfig{
+			Workers:   int(getEnvFloat("BACKTEST_WORKERS", 2)),
+			ClaimIdle: time.Duration(getEnvFloat("BACKTEST_CLAIM_IDLE_SECONDS", 60) * float64(time.Second)),
+		},
 	}
 }
 
@@ -67,4 +111,11 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
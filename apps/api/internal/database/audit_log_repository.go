@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit log levels
+const (
+	AuditLevelInfo  = "info"
+	AuditLevelWarn  = "warn"
+	AuditLevelError = "error"
+)
+
+// AuditLogRepository handles database operations for audit log entries
+type AuditLogRepository struct {
+	db *sql.DB
+}
+
+// NewAuditLogRepository creates a new audit log repository
+func NewAuditLogRepository(db *sql.DB) *AuditLogRepository {
+	return &AuditLogRepository{db: db}
+}
+
+// CreateAuditLog records an audit log entry
+func (r *AuditLogRepository) CreateAuditLog(ctx context.Context, entry *AuditLog) error {
+	entry.ID = uuid.New().String()
+	entry.CreatedAt = time.Now()
+
+	query := `
+		INSERT INTO audit_logs (id, level, category, message, strategy_id, symbol, order_id, trade_id, metadata, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	var metadata interface{}
+	if entry.Metadata != nil {
+		metadata = []byte(entry.Metadata)
+	}
+	_, err := r.db.ExecContext(ctx, query,
+		entry.ID, entry.Level, entry.Category, entry.Message, entry.StrategyID, entry.Symbol, entry.OrderID, entry.TradeID, metadata, entry.CreatedAt)
+	return err
+}
@@ -14,10 +14,14 @@ type Backtest struct {
 	ID              string          `json:"id" db:"id"`
 	Name            string          `json:"name" db:"name"`
 	StrategyID      string          `json:"strategy_id" db:"strategy_id"`
+	VersionID       *string         `json:"version_id" db:"version_id"`
+	Kind            string          `json:"kind" db:"kind"`           // backtest, optimization or walk_forward
+	ParentID        *string         `json:"parent_id" db:"parent_id"` // the optimization a child backtest runs for
 	Symbols         []string        `json:"symbols" db:"symbols"`
 	StartDate       time.Time       `json:"start_date" db:"start_date"`
 	EndDate         time.Time       `json:"end_date" db:"end_date"`
 	Parameters      json.RawMessage `json:"parameters" db:"parameters"`
+	Config          json.RawMessage `json:"config" db:"config"`
 	Status          string          `json:"status" db:"status"`
 	Progress        float64         `json:"progress" db:"progress"`
 	Results         json.RawMessage `json:"results" db:"results"`
@@ -44,6 +48,9 @@ func (r *BacktestRepository) CreateBacktest(ctx context.Context, backtest *Backt
 	backtest.UpdatedAt = time.Now()
 	backtest.Status = "pending"
 	backtest.Progress = 0
+	if backtest.Kind == "" {
+		backtest.Kind = "backtest"
+	}
 
 	// Convert symbols slice to JSON
 	symbolsJSON, err := json.Marshal(backtest.Symbols)
@@ -52,13 +59,13 @@ func (r *BacktestRepository) CreateBacktest(ctx context.Context, backtest *Backt
 	}
 
 	query := `
-		INSERT INTO backtests (id, name, strategy_id, symbols, start_date, end_date, parameters, status, progress, results, error, created_at, updated_at, completed_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO backtests (id, name, strategy_id, version_id, kind, parent_id, symbols, start_date, end_date, parameters, config, status, progress, results, error, created_at, updated_at, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	_, err = r.db.ExecContext(ctx, query,
-		backtest.ID, backtest.Name, backtest.StrategyID, symbolsJSON, backtest.StartDate, backtest.EndDate,
-		backtest.Parameters, backtest.Status, backtest.Progress, backtest.Results, backtest.Error,
+		backtest.ID, backtest.Name, backtest.StrategyID, backtest.VersionID, backtest.Kind, backtest.ParentID, symbolsJSON, backtest.StartDate, backtest.EndDate,
+		backtest.Parameters, backtest.Config, backtest.Status, backtest.Progress, backtest.Results, backtest.Error,
 		backtest.CreatedAt, backtest.UpdatedAt, backtest.CompletedAt)
 	return err
 }
@@ -66,19 +73,20 @@ func (r *BacktestRepository) CreateBacktest(ctx context.Context, backtest *Backt
 // GetBacktestByID retrieves a backtest by ID
 func (r *BacktestRepository) GetBacktestByID(ctx context.Context, id string) (*Backtest, error) {
 	query := `
-		SELECT id, name, strategy_id, symbols, start_date, end_date, parameters, status, progress, results, error, created_at, updated_at, completed_at
+		SELECT id, name, strategy_id, version_id, kind, parent_id, symbols, start_date, end_date, parameters, config, status, progress, results, error, created_at, updated_at, completed_at
 		FROM backtests WHERE id = ?
 	`
 
 	var backtest Backtest
-	var symbolsJSON []byte
+	var symbolsJSON, parameters, config, results []byte
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&backtest.ID, &backtest.Name, &backtest.StrategyID, &symbolsJSON, &backtest.StartDate, &backtest.EndDate,
-		&backtest.Parameters, &backtest.Status, &backtest.Progress, &backtest.Results, &backtest.Error,
+		&backtest.ID, &backtest.Name, &backtest.StrategyID, &backtest.VersionID, &backtest.Kind, &backtest.ParentID, &symbolsJSON, &backtest.StartDate, &backtest.EndDate,
+		&parameters, &config, &backtest.Status, &backtest.Progress, &results, &backtest.Error,
 		&backtest.CreatedAt, &backtest.UpdatedAt, &backtest.CompletedAt)
 	if err != nil {
 		return nil, err
 	}
+	backtest.Parameters, backtest.Config, backtest.Results = parameters, config, results
 
 	// Parse symbols JSON
 	if err := json.Unmarshal(symbolsJSON, &backtest.Symbols); err != nil {
@@ -88,10 +96,11 @@ func (r *BacktestRepository) GetBacktestByID(ctx context.Context, id string) (*B
 	return &backtest, nil
 }
 
-// ListBacktests retrieves backtests with filtering
-func (r *BacktestRepository) ListBacktests(ctx context.Context, strategyID, status *string, limit, offset int) ([]*Backtest, error) {
+// ListBacktests retrieves backtests with filtering; parentID selects the
+// child backtests of an optimization
+func (r *BacktestRepository) ListBacktests(ctx context.Context, strategyID, status, parentID *string, limit, offset int) ([]*Backtest, error) {
 	query := `
-		SELECT id, name, strategy_id, symbols, start_date, end_date, parameters, status, progress, results, error, created_at, updated_at, completed_at
+		SELECT id, name, strategy_id, version_id, kind, parent_id, symbols, start_date, end_date, parameters, config, status, progress, results, error, created_at, updated_at, completed_at
 		FROM backtests WHERE 1=1
 	`
 	var args []interface{}
@@ -104,6 +113,10 @@ func (r *BacktestRepository) ListBacktests(ctx context.Context, strategyID, stat
 		query += " AND status = ?"
 		args = append(args, *status)
 	}
+	if parentID != nil {
+		query += " AND parent_id = ?"
+		args = append(args, *parentID)
+	}
 
 	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
@@ -117,14 +130,15 @@ func (r *BacktestRepository) ListBacktests(ctx context.Context, strategyID, stat
 	var backtests []*Backtest
 	for rows.Next() {
 		var backtest Backtest
-		var symbolsJSON []byte
+		var symbolsJSON, parameters, config, results []byte
 		err := rows.Scan(
-			&backtest.ID, &backtest.Name, &backtest.StrategyID, &symbolsJSON, &backtest.StartDate, &backtest.EndDate,
-			&backtest.Parameters, &backtest.Status, &backtest.Progress, &backtest.Results, &backtest.Error,
+			&backtest.ID, &backtest.Name, &backtest.StrategyID, &backtest.VersionID, &backtest.Kind, &backtest.ParentID, &symbolsJSON, &backtest.StartDate, &backtest.EndDate,
+			&parameters, &config, &backtest.Status, &backtest.Progress, &results, &backtest.Error,
 			&backtest.CreatedAt, &backtest.UpdatedAt, &backtest.CompletedAt)
 		if err != nil {
 			return nil, err
 		}
+		backtest.Parameters, backtest.Config, backtest.Results = parameters, config, results
 
 		// Parse symbols JSON
 		if err := json.Unmarshal(symbolsJSON, &backtest.Symbols); err != nil {
@@ -149,13 +163,13 @@ func (r *BacktestRepository) UpdateBacktest(ctx context.Context, backtest *Backt
 
 	query := `
 		UPDATE backtests
-		SET name = ?, strategy_id = ?, symbols = ?, start_date = ?, end_date = ?, parameters = ?, status = ?, progress = ?, results = ?, error = ?, updated_at = ?, completed_at = ?
+		SET name = ?, strategy_id = ?, version_id = ?, kind = ?, parent_id = ?, symbols = ?, start_date = ?, end_date = ?, parameters = ?, config = ?, status = ?, progress = ?, results = ?, error = ?, updated_at = ?, completed_at = ?
 		WHERE id = ?
 	`
 
 	_, err = r.db.ExecContext(ctx, query,
-		backtest.Name, backtest.StrategyID, symbolsJSON, backtest.StartDate, backtest.EndDate,
-		backtest.Parameters, backtest.Status, backtest.Progress, backtest.Results, backtest.Error,
+		backtest.Name, backtest.StrategyID, backtest.VersionID, backtest.Kind, backtest.ParentID, symbolsJSON, backtest.StartDate, backtest.EndDate,
+		backtest.Parameters, backtest.Config, backtest.Status, backtest.Progress, backtest.Results, backtest.Error,
 		backtest.UpdatedAt, backtest.CompletedAt, backtest.ID)
 	return err
 }
@@ -178,6 +192,45 @@ func (r *BacktestRepository) UpdateBacktestStatus(ctx context.Context, id, statu
 	return err
 }
 
+// CancelBacktest marks a pending or running backtest cancelled. It reports
+// false, changing nothing, when the backtest has finished in the meantime.
+func (r *BacktestRepository) CancelBacktest(ctx context.Context, id string) (bool, error) {
+	query := `
+		UPDATE backtests
+		SET status = 'cancelled', updated_at = ?
+		WHERE id = ? AND status IN ('pending', 'running')
+	`
+
+	result, err := r.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// SaveMonteCarlo stores the Monte Carlo analysis of a backtest, replacing
+// the one stored before
+func (r *BacktestRepository) SaveMonteCarlo(ctx context.Context, id string, analysis json.RawMessage) error {
+	query := `UPDATE backtests SET monte_carlo = ?, updated_at = ? WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, query, analysis, time.Now(), id)
+	return err
+}
+
+// GetMonteCarlo retrieves the Monte Carlo analysis of a backtest, nil when it
+// has none
+func (r *BacktestRepository) GetMonteCarlo(ctx context.Context, id string) (json.RawMessage, error) {
+	query := `SELECT monte_carlo FROM backtests WHERE id = ?`
+	var analysis []byte
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&analysis); err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
+
 // DeleteBacktest deletes a backtest
 func (r *BacktestRepository) DeleteBacktest(ctx context.Context, id string) error {
 	query := `DELETE FROM backtests WHERE id = ?`
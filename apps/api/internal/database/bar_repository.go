@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Bar represents a stored minute bar
+type Bar struct {
+	Symbol    string    `json:"symbol" db:"symbol"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+	Open      float64   `json:"open" db:"open"`
+	High      float64   `json:"high" db:"high"`
+	Low       float64   `json:"low" db:"low"`
+	Close     float64   `json:"close" db:"close"`
+	Volume    float64   `json:"volume" db:"volume"`
+}
+
+// BarRepository handles database operations for minute bars
+type BarRepository struct {
+	db *sql.DB
+}
+
+// NewBarRepository creates a new bar repository
+func NewBarRepository(db *sql.DB) *BarRepository {
+	return &BarRepository{db: db}
+}
+
+// SaveBars stores bars, replacing those already stored for their symbol and
+// timestamp
+func (r *BarRepository) SaveBars(ctx context.Context, bars []*Bar) error {
+	query := `
+		INSERT INTO bars (symbol, timestamp, open, high, low, close, volume)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE open = VALUES(open), high = VALUES(high), low = VALUES(low), close = VALUES(close), volume = VALUES(volume)
+	`
+
+	for _, bar := range bars {
+		if _, err := r.db.ExecContext(ctx, query,
+			bar.Symbol, bar.Timestamp, bar.Open, bar.High, bar.Low, bar.Close, bar.Volume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBars retrieves the bars of a symbol from start up to but excluding end,
+// in time order
+func (r *BarRepository) GetBars(ctx context.Context, symbol string, start, end time.Time) ([]*Bar, error) {
+	query := `
+		SELECT symbol, timestamp, open, high, low, close, volume
+		FROM bars WHERE symbol = ? AND timestamp >= ? AND timestamp < ?
+		ORDER BY timestamp
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, symbol, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bars []*Bar
+	for rows.Next() {
+		var bar Bar
+		if err := rows.Scan(&bar.Symbol, &bar.Timestamp, &bar.Open, &bar.High, &bar.Low, &bar.Close, &bar.Volume); err != nil {
+			return nil, err
+		}
+		bars = append(bars, &bar)
+	}
+	return bars, rows.Err()
+}
@@ -0,0 +1,16 @@
+-- Create strategy_state table
+-- Snapshots of the state.set() store; Redis holds the hot copy
+CREATE TABLE IF NOT EXISTS strategy_state (
+    id VARCHAR(36) PRIMARY KEY,
+    strategy_id VARCHAR(36) NOT NULL,
+    symbol VARCHAR(50) NOT NULL,
+    state JSON NOT NULL,
+    size_bytes INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_strategy_symbol (strategy_id, symbol),
+    INDEX idx_strategy_id (strategy_id),
+
+    FOREIGN KEY (strategy_id) REFERENCES strategy_packages(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
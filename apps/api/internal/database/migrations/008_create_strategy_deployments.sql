@@ -0,0 +1,37 @@
+-- Create strategy_deployments table
+-- The version, parameters and symbols a strategy runs with in the engine
+CREATE TABLE IF NOT EXISTS strategy_deployments (
+    id VARCHAR(36) PRIMARY KEY,
+    strategy_id VARCHAR(36) NOT NULL,
+    version_id VARCHAR(36) NOT NULL,
+    parameters JSON NOT NULL,
+    symbols JSON NOT NULL,
+    timeframe VARCHAR(10) NOT NULL DEFAULT '1m',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_strategy_id (strategy_id),
+
+    FOREIGN KEY (strategy_id) REFERENCES strategy_packages(id) ON DELETE CASCADE,
+    FOREIGN KEY (version_id) REFERENCES strategy_versions(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Create strategy_executions table
+-- Last known status of each symbol of a deployment; RUNNING rows are restarted on boot
+CREATE TABLE IF NOT EXISTS strategy_executions (
+    id VARCHAR(36) PRIMARY KEY,
+    strategy_id VARCHAR(36) NOT NULL,
+    symbol VARCHAR(50) NOT NULL,
+    version_id VARCHAR(36) NOT NULL,
+    status ENUM('RUNNING', 'STOPPED', 'ERROR') NOT NULL,
+    error TEXT NULL,
+    started_at TIMESTAMP NULL,
+    stopped_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    UNIQUE KEY uk_strategy_symbol (strategy_id, symbol),
+    INDEX idx_status (status),
+
+    FOREIGN KEY (strategy_id) REFERENCES strategy_packages(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,5 @@
+-- Add portfolio mode to strategy_deployments
+-- Portfolio deployments run one execution on all symbols, recorded under symbol '*'
+ALTER TABLE strategy_deployments
+    ADD COLUMN mode ENUM('symbol', 'portfolio') NOT NULL DEFAULT 'symbol' AFTER timeframe,
+    ADD COLUMN missing_bars ENUM('omit', 'forward_fill', 'skip') NOT NULL DEFAULT 'omit' AFTER mode;
@@ -0,0 +1,18 @@
+-- Typed strategy parameters with constraints
+-- number and boolean parameters become float and bool
+ALTER TABLE strategy_params
+    MODIFY COLUMN param_type ENUM('string', 'number', 'boolean', 'array', 'object', 'int', 'float', 'bool', 'enum', 'symbol') NOT NULL;
+
+UPDATE strategy_params SET param_type = 'float' WHERE param_type = 'number';
+UPDATE strategy_params SET param_type = 'bool' WHERE param_type = 'boolean';
+
+ALTER TABLE strategy_params
+    MODIFY COLUMN param_type ENUM('int', 'float', 'bool', 'string', 'enum', 'symbol', 'array', 'object') NOT NULL,
+    ADD COLUMN min_value DOUBLE NULL AFTER default_value,
+    ADD COLUMN max_value DOUBLE NULL AFTER min_value,
+    ADD COLUMN step DOUBLE NULL AFTER max_value,
+    ADD COLUMN enum_values JSON NULL AFTER step;
+
+-- Record the version a backtest ran, its parameters having been validated against it
+ALTER TABLE backtests
+    ADD COLUMN version_id VARCHAR(36) NULL AFTER strategy_id;
@@ -0,0 +1,15 @@
+-- Create strategy_version_activations table
+-- Records which version each activation replaced, so activations can be rolled back
+CREATE TABLE IF NOT EXISTS strategy_version_activations (
+    id VARCHAR(36) PRIMARY KEY,
+    package_id VARCHAR(36) NOT NULL,
+    version_id VARCHAR(36) NOT NULL,
+    previous_version_id VARCHAR(36) NULL,
+    activated_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+    INDEX idx_package_activated_at (package_id, activated_at),
+
+    FOREIGN KEY (package_id) REFERENCES strategy_packages(id) ON DELETE CASCADE,
+    FOREIGN KEY (version_id) REFERENCES strategy_versions(id) ON DELETE CASCADE,
+    FOREIGN KEY (previous_version_id) REFERENCES strategy_versions(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
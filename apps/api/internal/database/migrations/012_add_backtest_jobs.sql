@@ -0,0 +1,16 @@
+-- Queued backtests keep the settings their worker runs them with
+ALTER TABLE backtests
+    ADD COLUMN config JSON NULL AFTER parameters;
+
+-- Create bars table: the minute bars backtests replay
+CREATE TABLE IF NOT EXISTS bars (
+    symbol VARCHAR(50) NOT NULL,
+    timestamp TIMESTAMP NOT NULL,
+    open DECIMAL(10, 4) NOT NULL,
+    high DECIMAL(10, 4) NOT NULL,
+    low DECIMAL(10, 4) NOT NULL,
+    close DECIMAL(10, 4) NOT NULL,
+    volume DECIMAL(20, 2) NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (symbol, timestamp)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,6 @@
+-- Optimizations are backtests that run a child backtest per parameter set
+ALTER TABLE backtests
+    ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'backtest' AFTER version_id,
+    ADD COLUMN parent_id VARCHAR(36) NULL AFTER kind,
+    ADD INDEX idx_parent_id (parent_id),
+    ADD FOREIGN KEY (parent_id) REFERENCES backtests(id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+-- Monte Carlo analyses are stored with the backtest whose trades they resample
+ALTER TABLE backtests
+    ADD COLUMN monte_carlo JSON NULL AFTER results;
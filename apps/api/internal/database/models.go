@@ -30,15 +30,19 @@ type StrategyVersion struct {
 
 // StrategyParam represents a strategy parameter
 type StrategyParam struct {
-	ID           string  `json:"id" db:"id"`
-	VersionID    string  `json:"version_id" db:"version_id"`
-	ParamName    string  `json:"param_name" db:"param_name"`
-	ParamType    string  `json:"param_type" db:"param_type"`
-	DefaultValue *string `json:"default_value" db:"default_value"`
-	Description  *string `json:"description" db:"description"`
-	IsRequired   bool    `json:"is_required" db:"is_required"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID           string          `json:"id" db:"id"`
+	VersionID    string          `json:"version_id" db:"version_id"`
+	ParamName    string          `json:"param_name" db:"param_name"`
+	ParamType    string          `json:"param_type" db:"param_type"`
+	DefaultValue *string         `json:"default_value" db:"default_value"`
+	MinValue     *float64        `json:"min_value" db:"min_value"`
+	MaxValue     *float64        `json:"max_value" db:"max_value"`
+	Step         *float64        `json:"step" db:"step"`
+	EnumValues   json.RawMessage `json:"enum_values" db:"enum_values"`
+	Description  *string         `json:"description" db:"description"`
+	IsRequired   bool            `json:"is_required" db:"is_required"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at" db:"updated_at"`
 }
 
 // Order represents a trading order
@@ -103,4 +107,43 @@ type UniverseSymbol struct {
 	LastUpdated *time.Time `json:"last_updated" db:"last_updated"`
 	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
-}
\ No newline at end of file
+}
+
+// StrategyState represents a snapshot of a strategy's persistent state for one symbol
+type StrategyState struct {
+	ID         string          `json:"id" db:"id"`
+	StrategyID string          `json:"strategy_id" db:"strategy_id"`
+	Symbol     string          `json:"symbol" db:"symbol"`
+	State      json.RawMessage `json:"state" db:"state"`
+	SizeBytes  int             `json:"size_bytes" db:"size_bytes"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// StrategyDeployment represents the version, parameters and symbols a strategy runs with
+type StrategyDeployment struct {
+	ID          string          `json:"id" db:"id"`
+	StrategyID  string          `json:"strategy_id" db:"strategy_id"`
+	VersionID   string          `json:"version_id" db:"version_id"`
+	Parameters  json.RawMessage `json:"parameters" db:"parameters"`
+	Symbols     json.RawMessage `json:"symbols" db:"symbols"`
+	Timeframe   string          `json:"timeframe" db:"timeframe"`
+	Mode        string          `json:"mode" db:"mode"`
+	MissingBars string          `json:"missing_bars" db:"missing_bars"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// StrategyExecution represents the last known status of a strategy on one symbol
+type StrategyExecution struct {
+	ID         string     `json:"id" db:"id"`
+	StrategyID string     `json:"strategy_id" db:"strategy_id"`
+	Symbol     string     `json:"symbol" db:"symbol"`
+	VersionID  string     `json:"version_id" db:"version_id"`
+	Status     string     `json:"status" db:"status"`
+	Error      *string    `json:"error" db:"error"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	StoppedAt  *time.Time `json:"stopped_at" db:"stopped_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StrategyDeploymentRepository handles database operations for strategy deployments and their executions
+type StrategyDeploymentRepository struct {
+	db *sql.DB
+}
+
+// NewStrategyDeploymentRepository creates a new strategy deployment repository
+func NewStrategyDeploymentRepository(db *sql.DB) *StrategyDeploymentRepository {
+	return &StrategyDeploymentRepository{db: db}
+}
+
+// SaveDeployment writes the deployment of a strategy, replacing any previous one
+func (r *StrategyDeploymentRepository) SaveDeployment(ctx context.Context, deployment *StrategyDeployment) error {
+	deployment.ID = uuid.New().String()
+	deployment.CreatedAt = time.Now()
+	deployment.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO strategy_deployments (id, strategy_id, version_id, parameters, symbols, timeframe, mode, missing_bars, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE version_id = VALUES(version_id), parameters = VALUES(parameters),
+			symbols = VALUES(symbols), timeframe = VALUES(timeframe), mode = VALUES(mode),
+			missing_bars = VALUES(missing_bars), updated_at = VALUES(updated_at)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		deployment.ID, deployment.StrategyID, deployment.VersionID, []byte(deployment.Parameters), []byte(deployment.Symbols),
+		deployment.Timeframe, deployment.Mode, deployment.MissingBars, deployment.CreatedAt, deployment.UpdatedAt)
+	return err
+}
+
+// GetDeployment retrieves the deployment of a strategy
+func (r *StrategyDeploymentRepository) GetDeployment(ctx context.Context, strategyID string) (*StrategyDeployment, error) {
+	query := `
+		SELECT id, strategy_id, version_id, parameters, symbols, timeframe, mode, missing_bars, created_at, updated_at
+		FROM strategy_deployments WHERE strategy_id = ?
+	`
+
+	var deployment StrategyDeployment
+	err := r.db.QueryRowContext(ctx, query, strategyID).Scan(
+		&deployment.ID, &deployment.StrategyID, &deployment.VersionID, &deployment.Parameters, &deployment.Symbols,
+		&deployment.Timeframe, &deployment.Mode, &deployment.MissingBars, &deployment.CreatedAt, &deployment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deployment, nil
+}
+
+// SaveExecution writes the status of a strategy on a symbol, replacing any previous one
+func (r *StrategyDeploymentRepository) SaveExecution(ctx context.Context, execution *StrategyExecution) error {
+	execution.ID = uuid.New().String()
+	execution.CreatedAt = time.Now()
+	execution.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO strategy_executions (id, strategy_id, symbol, version_id, status, error, started_at, stopped_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE version_id = VALUES(version_id), status = VALUES(status), error = VALUES(error),
+			started_at = VALUES(started_at), stopped_at = VALUES(stopped_at), updated_at = VALUES(updated_at)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		execution.ID, execution.StrategyID, execution.Symbol, execution.VersionID, execution.Status, execution.Error,
+		execution.StartedAt, execution.StoppedAt, execution.CreatedAt, execution.UpdatedAt)
+	return err
+}
+
+// ListExecutionsByStrategyID retrieves the executions of every symbol of a strategy
+func (r *StrategyDeploymentRepository) ListExecutionsByStrategyID(ctx context.Context, strategyID string) ([]*StrategyExecution, error) {
+	query := `
+		SELECT id, strategy_id, symbol, version_id, status, error, started_at, stopped_at, created_at, updated_at
+		FROM strategy_executions WHERE strategy_id = ?
+		ORDER BY symbol
+	`
+
+	return r.listExecutions(ctx, query, strategyID)
+}
+
+// ListExecutionsByStatus retrieves the executions of every strategy in a status
+func (r *StrategyDeploymentRepository) ListExecutionsByStatus(ctx context.Context, status string) ([]*StrategyExecution, error) {
+	query := `
+		SELECT id, strategy_id, symbol, version_id, status, error, started_at, stopped_at, created_at, updated_at
+		FROM strategy_executions WHERE status = ?
+		ORDER BY strategy_id, symbol
+	`
+
+	return r.listExecutions(ctx, query, status)
+}
+
+func (r *StrategyDeploymentRepository) listExecutions(ctx context.Context, query string, args ...interface{}) ([]*StrategyExecution, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var executions []*StrategyExecution
+	for rows.Next() {
+		var execution StrategyExecution
+		err := rows.Scan(&execution.ID, &execution.StrategyID, &execution.Symbol, &execution.VersionID, &execution.Status,
+			&execution.Error, &execution.StartedAt, &execution.StoppedAt, &execution.CreatedAt, &execution.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		executions = append(executions, &execution)
+	}
+
+	return executions, rows.Err()
+}
@@ -13,6 +13,11 @@ type StrategyRepository struct {
 	db *sql.DB
 }
 
+// execer runs statements on a database or in a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 // NewStrategyRepository creates a new strategy repository
 func NewStrategyRepository(db *sql.DB) *StrategyRepository {
 	return &StrategyRepository{db: db}
@@ -20,6 +25,10 @@ func NewStrategyRepository(db *sql.DB) *StrategyRepository {
 
 // CreatePackage creates a new strategy package
 func (r *StrategyRepository) CreatePackage(ctx context.Context, pkg *StrategyPackage) error {
+	return createPackage(ctx, r.db, pkg)
+}
+
+func createPackage(ctx context.Context, db execer, pkg *StrategyPackage) error {
 	pkg.ID = uuid.New().String()
 	pkg.CreatedAt = time.Now()
 	pkg.UpdatedAt = time.Now()
@@ -29,7 +38,7 @@ func (r *StrategyRepository) CreatePackage(ctx context.Context, pkg *StrategyPac
 		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := r.db.ExecContext(ctx, query,
+	_, err := db.ExecContext(ctx, query,
 		pkg.ID, pkg.Name, pkg.Description, pkg.Author, pkg.IsPublic, pkg.CreatedAt, pkg.UpdatedAt)
 	return err
 }
@@ -51,6 +60,25 @@ func (r *StrategyRepository) GetPackageByID(ctx context.Context, id string) (*St
 	return &pkg, nil
 }
 
+// GetPackageByNameAndAuthor retrieves the oldest strategy package with a name and author
+func (r *StrategyRepository) GetPackageByNameAndAuthor(ctx context.Context, name, author string) (*StrategyPackage, error) {
+	query := `
+		SELECT id, name, description, author, is_public, created_at, updated_at
+		FROM strategy_packages WHERE name = ? AND author = ?
+		ORDER BY created_at
+		LIMIT 1
+	`
+
+	var pkg StrategyPackage
+	err := r.db.QueryRowContext(ctx, query, name, author).Scan(
+		&pkg.ID, &pkg.Name, &pkg.Description, &pkg.Author, &pkg.IsPublic, &pkg.CreatedAt, &pkg.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkg, nil
+}
+
 // ListPackages retrieves all strategy packages
 func (r *StrategyRepository) ListPackages(ctx context.Context, limit, offset int) ([]*StrategyPackage, error) {
 	query := `
@@ -81,6 +109,10 @@ func (r *StrategyRepository) ListPackages(ctx context.Context, limit, offset int
 
 // UpdatePackage updates a strategy package
 func (r *StrategyRepository) UpdatePackage(ctx context.Context, pkg *StrategyPackage) error {
+	return updatePackage(ctx, r.db, pkg)
+}
+
+func updatePackage(ctx context.Context, db execer, pkg *StrategyPackage) error {
 	pkg.UpdatedAt = time.Now()
 
 	query := `
@@ -89,7 +121,7 @@ func (r *StrategyRepository) UpdatePackage(ctx context.Context, pkg *StrategyPac
 		WHERE id = ?
 	`
 
-	_, err := r.db.ExecContext(ctx, query,
+	_, err := db.ExecContext(ctx, query,
 		pkg.Name, pkg.Description, pkg.Author, pkg.IsPublic, pkg.UpdatedAt, pkg.ID)
 	return err
 }
@@ -103,6 +135,10 @@ func (r *StrategyRepository) DeletePackage(ctx context.Context, id string) error
 
 // CreateVersion creates a new strategy version
 func (r *StrategyRepository) CreateVersion(ctx context.Context, version *StrategyVersion) error {
+	return createVersion(ctx, r.db, version)
+}
+
+func createVersion(ctx context.Context, db execer, version *StrategyVersion) error {
 	version.ID = uuid.New().String()
 	version.CreatedAt = time.Now()
 	version.UpdatedAt = time.Now()
@@ -112,7 +148,7 @@ func (r *StrategyRepository) CreateVersion(ctx context.Context, version *Strateg
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := r.db.ExecContext(ctx, query,
+	_, err := db.ExecContext(ctx, query,
 		version.ID, version.PackageID, version.Version, version.Code, version.Description, version.IsActive, version.CreatedAt, version.UpdatedAt)
 	return err
 }
@@ -200,26 +236,155 @@ func (r *StrategyRepository) DeleteVersion(ctx context.Context, id string) error
 	return err
 }
 
+// ActivateVersion makes a version the only active version of its package and
+// records the activation. It returns the ID of the version that was active
+// before, or an empty string if there was none.
+func (r *StrategyRepository) ActivateVersion(ctx context.Context, packageID, versionID string) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	previous, err := activateVersion(ctx, tx, packageID, versionID)
+	if err != nil {
+		return "", err
+	}
+	return previous, tx.Commit()
+}
+
+// activateVersion activates a version within tx. When the version is already
+// active it changes nothing and returns its own ID.
+func activateVersion(ctx context.Context, tx *sql.Tx, packageID, versionID string) (string, error) {
+	var previous sql.NullString
+	query := `
+		SELECT id FROM strategy_versions
+		WHERE package_id = ? AND is_active = true
+		ORDER BY updated_at DESC
+		LIMIT 1
+		FOR UPDATE
+	`
+	if err := tx.QueryRowContext(ctx, query, packageID).Scan(&previous); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if previous.String == versionID {
+		return versionID, nil
+	}
+
+	now := time.Now()
+	query = `UPDATE strategy_versions SET is_active = false, updated_at = ? WHERE package_id = ? AND is_active = true`
+	if _, err := tx.ExecContext(ctx, query, now, packageID); err != nil {
+		return "", err
+	}
+	query = `UPDATE strategy_versions SET is_active = true, updated_at = ? WHERE id = ? AND package_id = ?`
+	if _, err := tx.ExecContext(ctx, query, now, versionID, packageID); err != nil {
+		return "", err
+	}
+
+	query = `
+		INSERT INTO strategy_version_activations (id, package_id, version_id, previous_version_id, activated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	if _, err := tx.ExecContext(ctx, query, uuid.New().String(), packageID, versionID, previous, now); err != nil {
+		return "", err
+	}
+	return previous.String, nil
+}
+
+// GetPreviousActiveVersionID returns the ID of the version that was active
+// before the last activation of a package, or sql.ErrNoRows if there is none
+func (r *StrategyRepository) GetPreviousActiveVersionID(ctx context.Context, packageID string) (string, error) {
+	query := `
+		SELECT previous_version_id FROM strategy_version_activations
+		WHERE package_id = ?
+		ORDER BY activated_at DESC
+		LIMIT 1
+	`
+
+	var previous sql.NullString
+	if err := r.db.QueryRowContext(ctx, query, packageID).Scan(&previous); err != nil {
+		return "", err
+	}
+	if !previous.Valid {
+		return "", sql.ErrNoRows
+	}
+	return previous.String, nil
+}
+
 // CreateParam creates a new strategy parameter
 func (r *StrategyRepository) CreateParam(ctx context.Context, param *StrategyParam) error {
+	return createParam(ctx, r.db, param)
+}
+
+func createParam(ctx context.Context, db execer, param *StrategyParam) error {
 	param.ID = uuid.New().String()
 	param.CreatedAt = time.Now()
 	param.UpdatedAt = time.Now()
 
 	query := `
-		INSERT INTO strategy_params (id, version_id, param_name, param_type, default_value, description, is_required, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO strategy_params (id, version_id, param_name, param_type, default_value, min_value, max_value, step, enum_values, description, is_required, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := r.db.ExecContext(ctx, query,
-		param.ID, param.VersionID, param.ParamName, param.ParamType, param.DefaultValue, param.Description, param.IsRequired, param.CreatedAt, param.UpdatedAt)
+	_, err := db.ExecContext(ctx, query,
+		param.ID, param.VersionID, param.ParamName, param.ParamType, param.DefaultValue, param.MinValue, param.MaxValue, param.Step, param.EnumValues,
+		param.Description, param.IsRequired, param.CreatedAt, param.UpdatedAt)
+	return err
+}
+
+// CreatePackageWithVersion creates a package with its first version and the
+// version's parameters in one transaction
+func (r *StrategyRepository) CreatePackageWithVersion(ctx context.Context, pkg *StrategyPackage, version *StrategyVersion, params []*StrategyParam) error {
+	_, err := r.SavePackageVersions(ctx, pkg, []*StrategyVersion{version}, [][]*StrategyParam{params}, nil)
 	return err
 }
 
+// SavePackageVersions creates a package, or updates it if it has an ID, and
+// adds versions with their parameters in one transaction. params[i] are the
+// parameters of versions[i]. When active is set, the same transaction
+// activates it, as ActivateVersion does, and the ID of the version active
+// before is returned.
+func (r *StrategyRepository) SavePackageVersions(ctx context.Context, pkg *StrategyPackage, versions []*StrategyVersion, params [][]*StrategyParam, active *StrategyVersion) (string, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if pkg.ID == "" {
+		err = createPackage(ctx, tx, pkg)
+	} else {
+		err = updatePackage(ctx, tx, pkg)
+	}
+	if err != nil {
+		return "", err
+	}
+	for i, version := range versions {
+		version.PackageID = pkg.ID
+		if err := createVersion(ctx, tx, version); err != nil {
+			return "", err
+		}
+		for _, param := range params[i] {
+			param.VersionID = version.ID
+			if err := createParam(ctx, tx, param); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	var previous string
+	if active != nil {
+		if previous, err = activateVersion(ctx, tx, pkg.ID, active.ID); err != nil {
+			return "", err
+		}
+	}
+	return previous, tx.Commit()
+}
+
 // GetParamsByVersionID retrieves all parameters for a version
 func (r *StrategyRepository) GetParamsByVersionID(ctx context.Context, versionID string) ([]*StrategyParam, error) {
 	query := `
-		SELECT id, version_id, param_name, param_type, default_value, description, is_required, created_at, updated_at
+		SELECT id, version_id, param_name, param_type, default_value, min_value, max_value, step, enum_values, description, is_required, created_at, updated_at
 		FROM strategy_params WHERE version_id = ?
 		ORDER BY param_name
 	`
@@ -233,12 +398,58 @@ func (r *StrategyRepository) GetParamsByVersionID(ctx context.Context, versionID
 	var params []*StrategyParam
 	for rows.Next() {
 		var param StrategyParam
-		err := rows.Scan(&param.ID, &param.VersionID, &param.ParamName, &param.ParamType, &param.DefaultValue, &param.Description, &param.IsRequired, &param.CreatedAt, &param.UpdatedAt)
+		var enumValues []byte
+		err := rows.Scan(&param.ID, &param.VersionID, &param.ParamName, &param.ParamType, &param.DefaultValue, &param.MinValue, &param.MaxValue, &param.Step, &enumValues,
+			&param.Description, &param.IsRequired, &param.CreatedAt, &param.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
+		param.EnumValues = enumValues
 		params = append(params, &param)
 	}
 
 	return params, nil
-}
\ No newline at end of file
+}
+
+// GetParamByID retrieves a strategy parameter by ID
+func (r *StrategyRepository) GetParamByID(ctx context.Context, id string) (*StrategyParam, error) {
+	query := `
+		SELECT id, version_id, param_name, param_type, default_value, min_value, max_value, step, enum_values, description, is_required, created_at, updated_at
+		FROM strategy_params WHERE id = ?
+	`
+
+	var param StrategyParam
+	var enumValues []byte
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&param.ID, &param.VersionID, &param.ParamName, &param.ParamType, &param.DefaultValue, &param.MinValue, &param.MaxValue, &param.Step, &enumValues,
+		&param.Description, &param.IsRequired, &param.CreatedAt, &param.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	param.EnumValues = enumValues
+
+	return &param, nil
+}
+
+// UpdateParam updates a strategy parameter
+func (r *StrategyRepository) UpdateParam(ctx context.Context, param *StrategyParam) error {
+	param.UpdatedAt = time.Now()
+
+	query := `
+		UPDATE strategy_params
+		SET param_name = ?, param_type = ?, default_value = ?, min_value = ?, max_value = ?, step = ?, enum_values = ?, description = ?, is_required = ?, updated_at = ?
+		WHERE id = ?
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		param.ParamName, param.ParamType, param.DefaultValue, param.MinValue, param.MaxValue, param.Step, param.EnumValues,
+		param.Description, param.IsRequired, param.UpdatedAt, param.ID)
+	return err
+}
+
+// DeleteParam deletes a strategy parameter
+func (r *StrategyRepository) DeleteParam(ctx context.Context, id string) error {
+	query := `DELETE FROM strategy_params WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
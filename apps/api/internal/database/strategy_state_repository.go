@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StrategyStateRepository handles database operations for strategy state snapshots
+type StrategyStateRepository struct {
+	db *sql.DB
+}
+
+// NewStrategyStateRepository creates a new strategy state repository
+func NewStrategyStateRepository(db *sql.DB) *StrategyStateRepository {
+	return &StrategyStateRepository{db: db}
+}
+
+// SaveState writes the snapshot for a strategy and symbol, replacing any previous one
+func (r *StrategyStateRepository) SaveState(ctx context.Context, state *StrategyState) error {
+	state.ID = uuid.New().String()
+	state.CreatedAt = time.Now()
+	state.UpdatedAt = time.Now()
+
+	query := `
+		INSERT INTO strategy_state (id, strategy_id, symbol, state, size_bytes, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE state = VALUES(state), size_bytes = VALUES(size_bytes), updated_at = VALUES(updated_at)
+	`
+
+	_, err := r.db.ExecContext(ctx, query,
+		state.ID, state.StrategyID, state.Symbol, []byte(state.State), state.SizeBytes, state.CreatedAt, state.UpdatedAt)
+	return err
+}
+
+// GetState retrieves the snapshot for a strategy and symbol
+func (r *StrategyStateRepository) GetState(ctx context.Context, strategyID, symbol string) (*StrategyState, error) {
+	query := `
+		SELECT id, strategy_id, symbol, state, size_bytes, created_at, updated_at
+		FROM strategy_state WHERE strategy_id = ? AND symbol = ?
+	`
+
+	var state StrategyState
+	err := r.db.QueryRowContext(ctx, query, strategyID, symbol).Scan(
+		&state.ID, &state.StrategyID, &state.Symbol, &state.State, &state.SizeBytes, &state.CreatedAt, &state.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// ListStatesByStrategyID retrieves the snapshots of every symbol of a strategy
+func (r *StrategyStateRepository) ListStatesByStrategyID(ctx context.Context, strategyID string) ([]*StrategyState, error) {
+	query := `
+		SELECT id, strategy_id, symbol, state, size_bytes, created_at, updated_at
+		FROM strategy_state WHERE strategy_id = ?
+		ORDER BY symbol
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, strategyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []*StrategyState
+	for rows.Next() {
+		var state StrategyState
+		err := rows.Scan(&state.ID, &state.StrategyID, &state.Symbol, &state.State, &state.SizeBytes, &state.CreatedAt, &state.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, &state)
+	}
+
+	return states, nil
+}
+
+// DeleteState deletes the snapshot for a strategy and symbol, or for every
+// symbol of the strategy when symbol is empty
+func (r *StrategyStateRepository) DeleteState(ctx context.Context, strategyID, symbol string) error {
+	if symbol == "" {
+		_, err := r.db.ExecContext(ctx, `DELETE FROM strategy_state WHERE strategy_id = ?`, strategyID)
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, `DELETE FROM strategy_state WHERE strategy_id = ? AND symbol = ?`, strategyID, symbol)
+	return err
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var strategyStateColumns = []string{"id", "strategy_id", "symbol", "state", "size_bytes", "created_at", "updated_at"}
+
+func TestStrategyStateRepository_SaveStateUpserts(t *testing.T) {
+	db, mock := testutil.NewSQLMock(t)
+	repo := NewStrategyStateRepository(db)
+
+	mock.ExpectExec(`INSERT INTO strategy_state .* ON DUPLICATE KEY UPDATE state = VALUES\(state\)`).
+		WithArgs(testutil.AnyArg, "s1", "AAPL", []byte(`{"count":1}`), 11, testutil.AnyArg, testutil.AnyArg)
+
+	state := &StrategyState{StrategyID: "s1", Symbol: "AAPL", State: json.RawMessage(`{"count":1}`), SizeBytes: 11}
+	require.NoError(t, repo.SaveState(context.Background(), state))
+	assert.NotEmpty(t, state.ID)
+	assert.False(t, state.UpdatedAt.IsZero())
+}
+
+func TestStrategyStateRepository_GetState(t *testing.T) {
+	db, mock := testutil.NewSQLMock(t)
+	repo := NewStrategyStateRepository(db)
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT .* FROM strategy_state WHERE strategy_id = \? AND symbol = \?`).
+		WithArgs("s1", "AAPL").
+		WillReturnRows(strategyStateColumns, []interface{}{"id1", "s1", "AAPL", []byte(`{"count":1}`), 11, now, now})
+	mock.ExpectQuery(`FROM strategy_state`).
+		WithArgs("s1", "MSFT").
+		WillReturnRows(strategyStateColumns)
+
+	state, err := repo.GetState(context.Background(), "s1", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, &StrategyState{ID: "id1", StrategyID: "s1", Symbol: "AAPL", State: json.RawMessage(`{"count":1}`), SizeBytes: 11, CreatedAt: now, UpdatedAt: now}, state)
+
+	_, err = repo.GetState(context.Background(), "s1", "MSFT")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestStrategyStateRepository_ListAndDelete(t *testing.T) {
+	db, mock := testutil.NewSQLMock(t)
+	repo := NewStrategyStateRepository(db)
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`FROM strategy_state WHERE strategy_id = \? ORDER BY symbol`).
+		WithArgs("s1").
+		WillReturnRows(strategyStateColumns,
+			[]interface{}{"id1", "s1", "AAPL", []byte(`{}`), 2, now, now},
+			[]interface{}{"id2", "s1", "MSFT", []byte(`{"a":1}`), 7, now, now})
+	mock.ExpectExec(`DELETE FROM strategy_state WHERE strategy_id = \?$`).WithArgs("s1")
+	mock.ExpectExec(`DELETE FROM strategy_state WHERE strategy_id = \? AND symbol = \?`).WithArgs("s1", "AAPL")
+
+	states, err := repo.ListStatesByStrategyID(context.Background(), "s1")
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+	assert.Equal(t, "MSFT", states[1].Symbol)
+	assert.JSONEq(t, `{"a":1}`, string(states[1].State))
+
+	require.NoError(t, repo.DeleteState(context.Background(), "s1", ""))
+	require.NoError(t, repo.DeleteState(context.Background(), "s1", "AAPL"))
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/moomoo-trading/api/internal/broker"
 )
@@ -307,10 +306,6 @@ func (te *TaxExporter) ExportForm8949(ctx context.Context, trades []broker.Trade
 	for _, trade := range trades {
 		if trade.Side == broker.OrderSideSell {
 			// Only include sell transactions
-			proceeds := trade.Quantity * trade.Price
-			costBasis := proceeds // Simplified
-			gainLoss := proceeds - costBasis - trade.Commission
-
 			row := []string{
 				"", // 1a - Description of property
 				"", // 1b - Date acquired
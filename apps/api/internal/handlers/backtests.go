@@ -8,17 +8,23 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/analytics"
+	"github.com/moomoo-trading/api/internal/backtest"
 	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
 )
 
-// BacktestHandler handles backtest-related HTTP requests
+// BacktestHandler handles backtest-related HTTP requests. Backtests are
+// queued for the runner's workers, which run them in the background.
 type BacktestHandler struct {
-	repo *database.BacktestRepository
+	repo       *database.BacktestRepository
+	strategies *database.StrategyRepository
+	runner     *backtest.Runner
 }
 
 // NewBacktestHandler creates a new backtest handler
-func NewBacktestHandler(repo *database.BacktestRepository) *BacktestHandler {
-	return &BacktestHandler{repo: repo}
+func NewBacktestHandler(repo *database.BacktestRepository, strategies *database.StrategyRepository, runner *backtest.Runner) *BacktestHandler {
+	return &BacktestHandler{repo: repo, strategies: strategies, runner: runner}
 }
 
 // GetBacktests retrieves backtests with filtering
@@ -27,6 +33,7 @@ func (h *BacktestHandler) GetBacktests(c *gin.Context) {
 	offsetStr := c.DefaultQuery("offset", "0")
 	strategyID := c.Query("strategy_id")
 	status := c.Query("status")
+	parentID := c.Query("parent_id")
 
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil {
@@ -40,15 +47,18 @@ func (h *BacktestHandler) GetBacktests(c *gin.Context) {
 		return
 	}
 
-	var strategyIDPtr, statusPtr *string
+	var strategyIDPtr, statusPtr, parentIDPtr *string
 	if strategyID != "" {
 		strategyIDPtr = &strategyID
 	}
 	if status != "" {
 		statusPtr = &status
 	}
+	if parentID != "" {
+		parentIDPtr = &parentID
+	}
 
-	backtests, err := h.repo.ListBacktests(c.Request.Context(), strategyIDPtr, statusPtr, limit, offset)
+	backtests, err := h.repo.ListBacktests(c.Request.Context(), strategyIDPtr, statusPtr, parentIDPtr, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve backtests"})
 		return
@@ -61,21 +71,28 @@ func (h *BacktestHandler) GetBacktests(c *gin.Context) {
 	})
 }
 
-// CreateBacktest creates a new backtest
+// CreateBacktest creates a new backtest, or an optimization or walk-forward
+// analysis when the request has their settings
 func (h *BacktestHandler) CreateBacktest(c *gin.Context) {
 	var req struct {
 		Name       string          `json:"name" binding:"required"`
 		StrategyID string          `json:"strategy_id" binding:"required"`
+		VersionID  string          `json:"version_id"`
 		Symbols    []string        `json:"symbols" binding:"required"`
 		StartDate  string          `json:"start_date" binding:"required"`
 		EndDate    string          `json:"end_date" binding:"required"`
 		Parameters json.RawMessage `json:"parameters"`
+		backtest.RunConfig
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
 		return
 	}
+	if err := req.RunConfig.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Parse dates
 	startDate, err := time.Parse("2006-01-02", req.StartDate)
@@ -94,25 +111,109 @@ func (h *BacktestHandler) CreateBacktest(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "End date must be after start date"})
 		return
 	}
+	if req.WalkForward != nil {
+		if _, err := req.WalkForward.Windows(startDate, endDate.AddDate(0, 0, 1)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var values map[string]interface{}
+	if len(req.Parameters) > 0 && string(req.Parameters) != "null" {
+		if err := json.Unmarshal(req.Parameters, &values); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parameters must be an object"})
+			return
+		}
+	}
+
+	// Validate the parameters against the version the backtest runs:
+	// version_id, else the strategy's active version
+	var version *database.StrategyVersion
+	if req.VersionID != "" {
+		version, err = h.strategies.GetVersionByID(c.Request.Context(), req.VersionID)
+		if err == nil && version.PackageID != req.StrategyID {
+			err = sql.ErrNoRows
+		}
+	} else {
+		version, err = h.strategies.GetActiveVersionByPackageID(c.Request.Context(), req.StrategyID)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy version not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy version"})
+		return
+	}
 
-	backtest := &database.Backtest{
+	params, err := h.strategies.GetParamsByVersionID(c.Request.Context(), version.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+	kind := backtest.KindBacktest
+	if req.Optimization != nil {
+		// Every parameter set must be valid; the parameters given are stored
+		// as they are, as the base of the sets
+		kind = backtest.KindOptimization
+		if req.WalkForward != nil {
+			kind = backtest.KindWalkForward
+		}
+		sets, err := req.Optimization.ParameterSets(values)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, set := range sets {
+			if _, err := strategy.CoerceParams(params, set); err != nil {
+				c.JSON(http.StatusBadRequest, paramErrorResponse(err))
+				return
+			}
+		}
+	} else {
+		values, err = strategy.CoerceParams(params, values)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, paramErrorResponse(err))
+			return
+		}
+	}
+	parameters, err := json.Marshal(values)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode parameters"})
+		return
+	}
+	config, err := json.Marshal(req.RunConfig)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode config"})
+		return
+	}
+
+	record := &database.Backtest{
 		Name:       req.Name,
 		StrategyID: req.StrategyID,
+		VersionID:  &version.ID,
+		Kind:       kind,
 		Symbols:    req.Symbols,
 		StartDate:  startDate,
 		EndDate:    endDate,
-		Parameters: req.Parameters,
+		Parameters: parameters,
+		Config:     config,
 	}
 
-	if err := h.repo.CreateBacktest(c.Request.Context(), backtest); err != nil {
+	if err := h.repo.CreateBacktest(c.Request.Context(), record); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backtest"})
 		return
 	}
 
-	// TODO: Start backtest execution in background
-	// This would typically be done by sending a message to a job queue
+	// Queue the backtest; one that cannot be queued would never run
+	if err := h.runner.Enqueue(c.Request.Context(), record.ID); err != nil {
+		message := err.Error()
+		h.repo.UpdateBacktestStatus(c.Request.Context(), record.ID, "failed", 0, nil, &message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue backtest"})
+		return
+	}
 
-	c.JSON(http.StatusCreated, gin.H{"data": backtest})
+	c.JSON(http.StatusCreated, gin.H{"data": record})
 }
 
 // GetBacktest retrieves a backtest by ID
@@ -196,17 +297,114 @@ func (h *BacktestHandler) CancelBacktest(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Backtest cannot be cancelled"})
 		return
 	}
+	if backtest.ParentID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Backtests of an optimization are cancelled with the optimization"})
+		return
+	}
 
-	// Update status to cancelled
-	if err := h.repo.UpdateBacktestStatus(c.Request.Context(), id, "cancelled", backtest.Progress, backtest.Results, nil); err != nil {
+	// Stop the worker running it, then update status to cancelled
+	if err := h.runner.Cancel(c.Request.Context(), id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel backtest"})
 		return
 	}
-
-	// TODO: Cancel actual backtest execution
+	cancelled, err := h.repo.CancelBacktest(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel backtest"})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Backtest finished before it could be cancelled"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Backtest cancelled successfully",
 		"data": gin.H{"id": id, "status": "cancelled"},
 	})
-}
\ No newline at end of file
+}
+
+// RunMonteCarlo runs a Monte Carlo analysis of the trades of a completed
+// backtest and stores it with the backtest
+func (h *BacktestHandler) RunMonteCarlo(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Backtest ID is required"})
+		return
+	}
+
+	var config analytics.MonteCarloConfig
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&config); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if err := config.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, err := h.repo.GetBacktestByID(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Backtest not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve backtest"})
+		return
+	}
+
+	// Only backtests have trades; optimizations and walk-forward analyses
+	// have reports of their own
+	if record.Status != "completed" || (record.Kind != "" && record.Kind != backtest.KindBacktest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Monte Carlo analysis needs a completed backtest"})
+		return
+	}
+	var report backtest.Report
+	if err := json.Unmarshal(record.Results, &report); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode backtest results"})
+		return
+	}
+	result, err := report.MonteCarlo(config)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	analysis, err := json.Marshal(result)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode Monte Carlo analysis"})
+		return
+	}
+	if err := h.repo.SaveMonteCarlo(c.Request.Context(), id, analysis); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save Monte Carlo analysis"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// GetMonteCarlo retrieves the Monte Carlo analysis stored with a backtest
+func (h *BacktestHandler) GetMonteCarlo(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Backtest ID is required"})
+		return
+	}
+
+	analysis, err := h.repo.GetMonteCarlo(c.Request.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Backtest not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve Monte Carlo analysis"})
+		return
+	}
+	if analysis == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backtest has no Monte Carlo analysis"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": analysis})
+}
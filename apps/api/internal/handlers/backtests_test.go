@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/analytics"
+	"github.com/moomoo-trading/api/internal/backtest"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/redis"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var backtestColumns = []string{"id", "name", "strategy_id", "version_id", "kind", "parent_id", "symbols", "start_date", "end_date", "parameters", "config", "status", "progress", "results", "error", "created_at", "updated_at", "completed_at"}
+
+func newBacktestRouter(t *testing.T) (*gin.Engine, *testutil.SQLMock, *redis.JobQueue) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, mock := testutil.NewSQLMock(t)
+	client, _ := testutil.NewRedis(t)
+	queue := redis.NewJobQueue(client, redis.BacktestJobsStream, redis.BacktestWorkersGroup)
+	require.NoError(t, queue.Init(context.Background()))
+
+	backtests, strategies := database.NewBacktestRepository(db), database.NewStrategyRepository(db)
+	handler := NewBacktestHandler(backtests, strategies, backtest.NewRunner(nil, queue, backtests, strategies, backtest.RunnerConfig{}))
+
+	router := gin.New()
+	router.POST("/backtests", handler.CreateBacktest)
+	router.POST("/backtests/:id/cancel", handler.CancelBacktest)
+	router.POST("/backtests/:id/montecarlo", handler.RunMonteCarlo)
+	router.GET("/backtests/:id/montecarlo", handler.GetMonteCarlo)
+	return router, mock, queue
+}
+
+func TestBacktestHandler_CreateQueuesTheBacktestWithItsConfig(t *testing.T) {
+	router, mock, queue := newBacktestRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE package_id = \? AND is_active = true`).
+		WithArgs("p1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, true, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns)
+	args := make([]interface{}, len(backtestColumns))
+	for i := range args {
+		args[i] = testutil.AnyArg
+	}
+	args[10] = []byte(`{"initial_balance":5000,"fill":{"model":"next_open"},"commission":{},"margin":{},"analytics":{}}`)
+	mock.ExpectExec(`INSERT INTO backtests`).WithArgs(args...)
+
+	w := postJSON(router, "/backtests", `{"name": "bt", "strategy_id": "p1", "symbols": ["AAPL"], "start_date": "2024-01-02", "end_date": "2024-01-05", "initial_balance": 5000, "fill": {"model": "next_open"}}`)
+
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var response struct {
+		Data database.Backtest `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "pending", response.Data.Status)
+
+	job, err := queue.Next(context.Background(), "worker", time.Minute, 0)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, response.Data.ID, job.Values["backtest_id"])
+}
+
+func TestBacktestHandler_CreateRejectsAnInvalidConfig(t *testing.T) {
+	router, _, _ := newBacktestRouter(t)
+
+	w := postJSON(router, "/backtests", `{"name": "bt", "strategy_id": "p1", "symbols": ["AAPL"], "start_date": "2024-01-02", "end_date": "2024-01-05", "commission": {"model": "flat"}}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "flat")
+}
+
+func TestBacktestHandler_CreateChecksEveryParameterSetOfAnOptimization(t *testing.T) {
+	router, mock, _ := newBacktestRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE package_id = \? AND is_active = true`).
+		WithArgs("p1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, true, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns, []interface{}{"param1", "v1", "period", "int", "14", 1.0, 50.0, nil, nil, nil, true, now, now})
+
+	w := postJSON(router, "/backtests", `{"name": "bt", "strategy_id": "p1", "symbols": ["AAPL"], "start_date": "2024-01-02", "end_date": "2024-01-05",
+		"optimization": {"parameters": [{"name": "period", "min": 10, "max": 60, "step": 10}]}}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "period")
+}
+
+func TestBacktestHandler_CancelFlagsTheBacktestForItsWorker(t *testing.T) {
+	router, mock, queue := newBacktestRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM backtests WHERE id = \?`).
+		WithArgs("bt-1").
+		WillReturnRows(backtestColumns, []interface{}{"bt-1", "bt", "p1", "v1", "backtest", nil, `["AAPL"]`, now, now, nil, nil, "running", 0.25, nil, nil, now, now, nil})
+	mock.ExpectExec(`UPDATE backtests SET status = 'cancelled', updated_at = \? WHERE id = \? AND status IN \('pending', 'running'\)`).
+		WithArgs(testutil.AnyArg, "bt-1").
+		WillReturnResult(0, 1)
+
+	w := postJSON(router, "/backtests/bt-1/cancel", "")
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	cancelled, err := queue.Cancelled(context.Background(), "bt-1")
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+}
+
+func TestBacktestHandler_CancelConflictsWithABacktestThatJustFinished(t *testing.T) {
+	router, mock, _ := newBacktestRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM backtests WHERE id = \?`).
+		WithArgs("bt-1").
+		WillReturnRows(backtestColumns, []interface{}{"bt-1", "bt", "p1", "v1", "backtest", nil, `["AAPL"]`, now, now, nil, nil, "running", 0.99, nil, nil, now, now, nil})
+	// The worker stores the completed backtest before the update
+	mock.ExpectExec(`UPDATE backtests SET status = 'cancelled'`).
+		WithArgs(testutil.AnyArg, "bt-1").
+		WillReturnResult(0, 0)
+
+	w := postJSON(router, "/backtests/bt-1/cancel", "")
+
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+}
+
+func TestBacktestHandler_RunMonteCarloStoresTheAnalysis(t *testing.T) {
+	router, mock, _ := newBacktestRouter(t)
+	now := time.Now()
+	results := `{"results": [{"config": {"symbol": "AAPL", "initial_balance": 1000}, "trades": [{"quantity": 10, "entry_price": 100, "exit_price": 110, "pnl": 100}, {"quantity": 10, "entry_price": 100, "exit_price": 95, "pnl": -50}]}]}`
+	mock.ExpectQuery(`FROM backtests WHERE id = \?`).
+		WithArgs("bt-1").
+		WillReturnRows(backtestColumns, []interface{}{"bt-1", "bt", "p1", "v1", "backtest", nil, `["AAPL"]`, now, now, nil, nil, "completed", 1.0, results, nil, now, now, now})
+	mock.ExpectExec(`UPDATE backtests SET monte_carlo = \?`).
+		WithArgs(testutil.AnyArg, testutil.AnyArg, "bt-1")
+
+	w := postJSON(router, "/backtests/bt-1/montecarlo", `{"method": "shuffle", "iterations": 100, "seed": 1}`)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response struct {
+		Data analytics.MonteCarloResult `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Data.Trades)
+	assert.Equal(t, 1000.0, response.Data.InitialBalance)
+	assert.Equal(t, 1050.0, response.Data.FinalEquity.Mean, "shuffling keeps the final equity")
+	assert.Len(t, response.Data.ConfidenceLevels, 3)
+}
+
+func TestBacktestHandler_RunMonteCarloNeedsACompletedBacktest(t *testing.T) {
+	router, mock, _ := newBacktestRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM backtests WHERE id = \?`).
+		WithArgs("bt-1").
+		WillReturnRows(backtestColumns, []interface{}{"bt-1", "bt", "p1", "v1", "backtest", nil, `["AAPL"]`, now, now, nil, nil, "running", 0.25, nil, nil, now, now, nil})
+
+	w := postJSON(router, "/backtests/bt-1/montecarlo", "")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBacktestHandler_GetMonteCarlo(t *testing.T) {
+	router, mock, _ := newBacktestRouter(t)
+	mock.ExpectQuery(`SELECT monte_carlo FROM backtests WHERE id = \?`).
+		WithArgs("bt-1").
+		WillReturnRows([]string{"monte_carlo"}, []interface{}{`{"trades": 2}`})
+	mock.ExpectQuery(`SELECT monte_carlo FROM backtests WHERE id = \?`).
+		WithArgs("bt-2").
+		WillReturnRows([]string{"monte_carlo"}, []interface{}{nil})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/backtests/bt-1/montecarlo", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"data": {"trades": 2}}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/backtests/bt-2/montecarlo", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "not analysed yet")
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/strategy"
 )
 
 func HealthCheck(c *gin.Context) {
@@ -40,7 +41,7 @@ func HealthCheck(c *gin.Context) {
 			"lag":             0, // TODO: Implement actual lag calculation
 			"queue_size":      0, // TODO: Implement actual queue size
 			"reconnect_count": 0, // TODO: Implement actual reconnect count
-			"vm_quota_hits":   0, // TODO: Implement actual VM quota hits
+			"vm_quota_hits":   strategy.TotalQuotaHits(),
 		},
 	}
 
@@ -7,16 +7,18 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
 )
 
 // StrategyHandler handles strategy-related HTTP requests
 type StrategyHandler struct {
-	repo *database.StrategyRepository
+	repo      *database.StrategyRepository
+	auditLogs *database.AuditLogRepository
 }
 
 // NewStrategyHandler creates a new strategy handler
-func NewStrategyHandler(repo *database.StrategyRepository) *StrategyHandler {
-	return &StrategyHandler{repo: repo}
+func NewStrategyHandler(repo *database.StrategyRepository, auditLogs *database.AuditLogRepository) *StrategyHandler {
+	return &StrategyHandler{repo: repo, auditLogs: auditLogs}
 }
 
 // GetStrategies retrieves all strategy packages
@@ -194,10 +196,11 @@ func (h *StrategyHandler) CreateStrategyVersion(c *gin.Context) {
 	}
 
 	var req struct {
-		Version     string  `json:"version" binding:"required"`
-		Code        string  `json:"code" binding:"required"`
-		Description *string `json:"description"`
-		IsActive    bool    `json:"is_active"`
+		Version     string   `json:"version" binding:"required"`
+		Code        string   `json:"code" binding:"required"`
+		Description *string  `json:"description"`
+		IsActive    bool     `json:"is_active"`
+		Params      []string `json:"params"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -205,12 +208,25 @@ func (h *StrategyHandler) CreateStrategyVersion(c *gin.Context) {
 		return
 	}
 
+	params, known, err := h.declaredParams(c, id, "", req.Params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+	diagnostics := strategy.Validate(id+".star", req.Code, params)
+	if !known {
+		diagnostics = strategy.WarnUndeclaredParams(diagnostics)
+	}
+	if strategy.HasErrors(diagnostics) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Strategy code is invalid", "diagnostics": diagnostics})
+		return
+	}
+
 	version := &database.StrategyVersion{
 		PackageID:   id,
 		Version:     req.Version,
 		Code:        req.Code,
 		Description: req.Description,
-		IsActive:    req.IsActive,
 	}
 
 	if err := h.repo.CreateVersion(c.Request.Context(), version); err != nil {
@@ -218,5 +234,164 @@ func (h *StrategyHandler) CreateStrategyVersion(c *gin.Context) {
 		return
 	}
 
+	// Activating goes through ActivateVersion so only one version stays active
+	if req.IsActive {
+		if err := h.activate(c, version, "activate"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate strategy version"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"data": version})
-}
\ No newline at end of file
+}
+
+// ValidateStrategyVersion checks strategy code without saving it and returns
+// its diagnostics for the editor
+func (h *StrategyHandler) ValidateStrategyVersion(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	var req struct {
+		Code      string   `json:"code" binding:"required"`
+		VersionID string   `json:"version_id"`
+		Params    []string `json:"params"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	params, known, err := h.declaredParams(c, id, req.VersionID, req.Params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+
+	diagnostics := strategy.Validate(id+".star", req.Code, params)
+	if !known {
+		diagnostics = strategy.WarnUndeclaredParams(diagnostics)
+	}
+	if diagnostics == nil {
+		diagnostics = []strategy.Diagnostic{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"valid":       !strategy.HasErrors(diagnostics),
+		"diagnostics": diagnostics,
+	}})
+}
+
+// declaredParams returns the parameter names code is checked against: the
+// names given in the request, else those of versionID, else those of the
+// package's active version. They are not known for the first version of a
+// package created without names.
+func (h *StrategyHandler) declaredParams(c *gin.Context, packageID, versionID string, names []string) ([]string, bool, error) {
+	if names != nil {
+		return names, true, nil
+	}
+
+	if versionID == "" {
+		version, err := h.repo.GetActiveVersionByPackageID(c.Request.Context(), packageID)
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		versionID = version.ID
+	}
+
+	params, err := h.repo.GetParamsByVersionID(c.Request.Context(), versionID)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, param := range params {
+		names = append(names, param.ParamName)
+	}
+	return names, true, nil
+}
+
+// TestStrategyVersion runs a saved version over scripted bars with simulated
+// orders and returns what the script did on every bar
+func (h *StrategyHandler) TestStrategyVersion(c *gin.Context) {
+	id := c.Param("id")
+	versionID := c.Param("vid")
+	if id == "" || versionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID and version ID are required"})
+		return
+	}
+
+	var req struct {
+		Params           map[string]interface{} `json:"params"`
+		Mode             string                 `json:"mode"`
+		Symbol           string                 `json:"symbol"`
+		Bars             []strategy.HarnessBar  `json:"bars"`
+		CSV              string                 `json:"csv"`
+		Balance          float64                `json:"balance"`
+		FillMarketOrders bool                   `json:"fill_market_orders"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	bars := req.Bars
+	if req.CSV != "" {
+		if len(bars) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either bars or csv, not both"})
+			return
+		}
+		parsed, err := strategy.ParseHarnessCSV(req.CSV)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		bars = parsed
+	}
+	if len(bars) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one bar is required"})
+		return
+	}
+
+	version, err := h.repo.GetVersionByID(c.Request.Context(), versionID)
+	if err == sql.ErrNoRows || (err == nil && version.PackageID != id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy version not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy version"})
+		return
+	}
+
+	params, err := h.repo.GetParamsByVersionID(c.Request.Context(), versionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+	values, err := strategy.CoerceParams(params, req.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, paramErrorResponse(err))
+		return
+	}
+
+	result, err := strategy.RunHarness(c.Request.Context(), &strategy.HarnessConfig{
+		Code:             version.Code,
+		Params:           values,
+		Mode:             req.Mode,
+		Symbol:           req.Symbol,
+		Bars:             bars,
+		Balance:          req.Balance,
+		FillMarketOrders: req.FillMarketOrders,
+	})
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	strategyVersionColumns = []string{"id", "package_id", "version", "code", "description", "is_active", "created_at", "updated_at"}
+	strategyParamColumns   = []string{"id", "version_id", "param_name", "param_type", "default_value", "min_value", "max_value", "step", "enum_values", "description", "is_required", "created_at", "updated_at"}
+)
+
+func newStrategyRouter(t *testing.T) (*gin.Engine, *testutil.SQLMock) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, mock := testutil.NewSQLMock(t)
+	handler := NewStrategyHandler(database.NewStrategyRepository(db), database.NewAuditLogRepository(db))
+
+	router := gin.New()
+	router.POST("/strategies/:id/versions", handler.CreateStrategyVersion)
+	router.POST("/strategies/:id/versions/validate", handler.ValidateStrategyVersion)
+	router.POST("/strategies/:id/versions/:vid/test", handler.TestStrategyVersion)
+	router.POST("/strategies/:id/versions/:vid/params", handler.CreateStrategyParam)
+	router.GET("/strategies/:id/versions/:vid/schema", handler.GetStrategyParamsSchema)
+	router.POST("/strategies/from-template", handler.CreateStrategyFromTemplate)
+	router.POST("/strategies/:id/versions/rollback", handler.RollbackStrategyVersion)
+	router.POST("/strategies/:id/versions/:vid/activate", handler.ActivateStrategyVersion)
+	router.GET("/strategies/:id/versions/:vid/diff/:other", handler.DiffStrategyVersions)
+	return router, mock
+}
+
+func postJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestStrategyHandler_ValidateChecksActiveVersionParams(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE package_id = \? AND is_active = true`).
+		WithArgs("p1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, true, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns, []interface{}{"param1", "v1", "period", "int", "14", nil, nil, nil, nil, nil, true, now, now})
+
+	code := "def on_bar(symbol, bar):\n    x = sma(bar.close, period)\n    y = sma(bar.close, slow)\n"
+	body, err := json.Marshal(map[string]string{"code": code})
+	require.NoError(t, err)
+	w := postJSON(router, "/strategies/p1/versions/validate", string(body))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data struct {
+			Valid       bool                  `json:"valid"`
+			Diagnostics []strategy.Diagnostic `json:"diagnostics"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.False(t, response.Data.Valid)
+	require.Len(t, response.Data.Diagnostics, 1)
+	assert.Equal(t, strategy.DiagUndeclaredParam, response.Data.Diagnostics[0].Code)
+	assert.Equal(t, int32(3), response.Data.Diagnostics[0].Line)
+}
+
+func TestStrategyHandler_CreateVersionRejectsInvalidCode(t *testing.T) {
+	router, _ := newStrategyRouter(t)
+
+	w := postJSON(router, "/strategies/p1/versions", `{"version": "1.0.1", "code": "def on_tick(symbol, bar):\n    pass\n", "params": []}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), strategy.DiagMissingCallback)
+}
+
+func TestStrategyHandler_CreateVersionSavesValidCode(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	mock.ExpectExec(`INSERT INTO strategy_versions`)
+
+	w := postJSON(router, "/strategies/p1/versions", `{"version": "1.0.1", "code": "def on_bar(symbol, bar):\n    pass\n", "params": []}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestStrategyHandler_CreateFirstVersionWarnsOfUndeclaredParams(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	mock.ExpectQuery(`FROM strategy_versions WHERE package_id = \? AND is_active = true`).
+		WithArgs("p1").
+		WillReturnRows(strategyVersionColumns)
+	mock.ExpectExec(`INSERT INTO strategy_versions`)
+
+	code := "def on_bar(symbol, bar):\n    x = sma(bar.close, period)\n"
+	body, err := json.Marshal(map[string]string{"version": "1.0.0", "code": code})
+	require.NoError(t, err)
+	w := postJSON(router, "/strategies/p1/versions", string(body))
+
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestStrategyHandler_TestVersionRunsHarnessWithParamDefaults(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	code := "def on_bar(symbol, bar):\n    if bar.close > threshold:\n        order(symbol, \"buy\", \"market\", size)\n"
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", code, nil, false, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns,
+			[]interface{}{"param1", "v1", "threshold", "float", "100", nil, nil, nil, nil, nil, true, now, now},
+			[]interface{}{"param2", "v1", "size", "int", "1", nil, nil, nil, nil, nil, true, now, now})
+
+	csv := "timestamp,open,high,low,close,volume\n2024-01-02T15:00:00Z,99,99,99,99,1\n2024-01-02T15:01:00Z,101,101,101,101,1\n"
+	body, err := json.Marshal(map[string]interface{}{"symbol": "AAPL", "csv": csv, "params": map[string]interface{}{"size": 5}})
+	require.NoError(t, err)
+	w := postJSON(router, "/strategies/p1/versions/v1/test", string(body))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data strategy.HarnessResult `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data.Steps, 2)
+	assert.Empty(t, response.Data.Steps[0].Orders)
+	require.Len(t, response.Data.Steps[1].Orders, 1)
+	assert.Equal(t, 5.0, response.Data.Steps[1].Orders[0].Quantity)
+}
+
+func TestStrategyHandler_TestVersionOfAnotherStrategyIsNotFound(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p2", "1.0.0", "", nil, false, now, now})
+
+	w := postJSON(router, "/strategies/p1/versions/v1/test", `{"symbol": "AAPL", "bars": [{"timestamp": "2024-01-02T15:00:00Z", "close": 1}]}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestStrategyHandler_TestVersionRejectsInvalidParams(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, false, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns, []interface{}{"param1", "v1", "size", "int", "1", 1.0, 10.0, nil, nil, nil, true, now, now})
+
+	w := postJSON(router, "/strategies/p1/versions/v1/test", `{"symbol": "AAPL", "params": {"size": 20, "other": 1}, "bars": [{"timestamp": "2024-01-02T15:00:00Z", "close": 1}]}`)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var response struct {
+		Fields map[string]string `json:"fields"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Contains(t, response.Fields["size"], "at most 10")
+	assert.Contains(t, response.Fields, "other")
+}
+
+func TestStrategyHandler_CreateParamSavesTypedDefinition(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, false, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns)
+	mock.ExpectExec(`INSERT INTO strategy_params`).
+		WithArgs(testutil.AnyArg, "v1", "side", "enum", "long", nil, nil, nil, []byte(`["long","short"]`), nil, false, testutil.AnyArg, testutil.AnyArg)
+
+	w := postJSON(router, "/strategies/p1/versions/v1/params", `{"param_name": "side", "param_type": "enum", "default_value": "long", "enum_values": ["long", "short"]}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestStrategyHandler_CreateParamRejectsInvalidDefinition(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, false, now, now})
+
+	w := postJSON(router, "/strategies/p1/versions/v1/params", `{"param_name": "period", "param_type": "int", "default_value": 3, "min_value": 5}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "at least 5")
+}
+
+func TestStrategyHandler_ParamsSchema(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, false, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyParamColumns, []interface{}{"param1", "v1", "period", "int", "14", 2.0, 200.0, nil, nil, nil, true, now, now})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/strategies/p1/versions/v1/schema", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data struct {
+			Properties map[string]map[string]interface{} `json:"properties"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "integer", response.Data.Properties["period"]["type"])
+	assert.Equal(t, 14.0, response.Data.Properties["period"]["default"])
+	assert.Equal(t, 200.0, response.Data.Properties["period"]["maximum"])
+}
+
+func TestStrategyHandler_CreateFromTemplateSavesPackageVersionAndParamsInTransaction(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO strategy_packages`).
+		WithArgs(testutil.AnyArg, "My breakout", testutil.AnyArg, "alice", false, testutil.AnyArg, testutil.AnyArg)
+	mock.ExpectExec(`INSERT INTO strategy_versions`).
+		WithArgs(testutil.AnyArg, testutil.AnyArg, "1.0.0", strategy.StrategyTemplates["breakout"], nil, true, testutil.AnyArg, testutil.AnyArg)
+	mock.ExpectExec(`INSERT INTO strategy_params`).
+		WithArgs(testutil.AnyArg, testutil.AnyArg, "lookback_period", "int", "20", 1.0, nil, nil, []byte(nil), testutil.AnyArg, false, testutil.AnyArg, testutil.AnyArg)
+	mock.ExpectExec(`INSERT INTO strategy_params`).
+		WithArgs(testutil.AnyArg, testutil.AnyArg, "quantity", "int", "100", 1.0, nil, nil, []byte(nil), testutil.AnyArg, false, testutil.AnyArg, testutil.AnyArg)
+	mock.ExpectCommit()
+
+	w := postJSON(router, "/strategies/from-template", `{"template": "breakout", "name": "My breakout", "author": "alice"}`)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var response struct {
+		Data struct {
+			Strategy database.StrategyPackage `json:"strategy"`
+			Version  database.StrategyVersion `json:"version"`
+			Params   []database.StrategyParam `json:"params"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, response.Data.Strategy.ID, response.Data.Version.PackageID)
+	require.Len(t, response.Data.Params, 2)
+	assert.Equal(t, response.Data.Version.ID, response.Data.Params[0].VersionID)
+}
+
+func TestStrategyHandler_CreateFromTemplateRollsBackOnFailure(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO strategy_packages`)
+	mock.ExpectExec(`INSERT INTO strategy_versions`).WillReturnError(errors.New("duplicate version"))
+	mock.ExpectRollback()
+
+	w := postJSON(router, "/strategies/from-template", `{"template": "ichimoku", "name": "Cloud", "author": "alice"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestStrategyHandler_CreateFromUnknownTemplateIsNotFound(t *testing.T) {
+	router, _ := newStrategyRouter(t)
+
+	w := postJSON(router, "/strategies/from-template", `{"template": "martingale", "name": "x", "author": "alice"}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestStrategyHandler_ActivateDeactivatesOtherVersionsAndAudits(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v2").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v2", "p1", "1.1.0", "", nil, false, now, now})
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM strategy_versions\s+WHERE package_id = \? AND is_active = true`).
+		WithArgs("p1").
+		WillReturnRows([]string{"id"}, []interface{}{"v1"})
+	mock.ExpectExec(`UPDATE strategy_versions SET is_active = false`).WithArgs(testutil.AnyArg, "p1")
+	mock.ExpectExec(`UPDATE strategy_versions SET is_active = true`).WithArgs(testutil.AnyArg, "v2", "p1")
+	mock.ExpectExec(`INSERT INTO strategy_version_activations`).WithArgs(testutil.AnyArg, "p1", "v2", "v1", testutil.AnyArg)
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_logs`).
+		WithArgs(testutil.AnyArg, "info", "strategy", "Activated version 1.1.0", "p1", nil, nil, nil,
+			[]byte(`{"action":"activate","previous_version_id":"v1","version_id":"v2"}`), testutil.AnyArg)
+
+	w := postJSON(router, "/strategies/p1/versions/v2/activate", "")
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"is_active":true`)
+}
+
+func TestStrategyHandler_RollbackActivatesPreviousVersion(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`SELECT previous_version_id FROM strategy_version_activations`).
+		WithArgs("p1").
+		WillReturnRows([]string{"previous_version_id"}, []interface{}{"v1"})
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "", nil, false, now, now})
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM strategy_versions`).
+		WithArgs("p1").
+		WillReturnRows([]string{"id"}, []interface{}{"v2"})
+	mock.ExpectExec(`UPDATE strategy_versions SET is_active = false`)
+	mock.ExpectExec(`UPDATE strategy_versions SET is_active = true`)
+	mock.ExpectExec(`INSERT INTO strategy_version_activations`).WithArgs(testutil.AnyArg, "p1", "v1", "v2", testutil.AnyArg)
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO audit_logs`).
+		WithArgs(testutil.AnyArg, "info", "strategy", "Rolled back to version 1.0.0", "p1", nil, nil, nil, testutil.AnyArg, testutil.AnyArg)
+
+	w := postJSON(router, "/strategies/p1/versions/rollback", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestStrategyHandler_RollbackWithoutPreviousVersionConflicts(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	mock.ExpectQuery(`SELECT previous_version_id FROM strategy_version_activations`).
+		WithArgs("p1").
+		WillReturnRows([]string{"previous_version_id"}, []interface{}{nil})
+
+	w := postJSON(router, "/strategies/p1/versions/rollback", "")
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestStrategyHandler_DiffVersions(t *testing.T) {
+	router, mock := newStrategyRouter(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v1").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v1", "p1", "1.0.0", "x = 1\n", nil, false, now, now})
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).
+		WithArgs("v2").
+		WillReturnRows(strategyVersionColumns, []interface{}{"v2", "p1", "1.1.0", "x = 2\n", nil, true, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).WithArgs("v1").
+		WillReturnRows(strategyParamColumns)
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).WithArgs("v2").
+		WillReturnRows(strategyParamColumns, []interface{}{"param1", "v2", "size", "int", "1", nil, nil, nil, nil, nil, false, now, now})
+	mock.ExpectExec(`INSERT INTO audit_logs`).
+		WithArgs(testutil.AnyArg, "info", "strategy", "Compared version 1.0.0 with version 1.1.0", "p1", nil, nil, nil, testutil.AnyArg, testutil.AnyArg)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/strategies/p1/versions/v1/diff/v2", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data strategy.VersionDiff `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "--- version 1.0.0\n+++ version 1.1.0\n@@ -1 +1 @@\n-x = 1\n+x = 2\n", response.Data.Code)
+	require.Len(t, response.Data.ParamChanges, 1)
+	assert.Equal(t, strategy.ParamAdded, response.Data.ParamChanges[0].Change)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/archive"
+)
+
+// maxArchiveSize bounds the size of uploaded strategy archives
+const maxArchiveSize = 20 << 20
+
+// StrategyArchiveHandler handles strategy archive export and import requests
+type StrategyArchiveHandler struct {
+	archives *archive.Service
+}
+
+// NewStrategyArchiveHandler creates a new strategy archive handler
+func NewStrategyArchiveHandler(archives *archive.Service) *StrategyArchiveHandler {
+	return &StrategyArchiveHandler{archives: archives}
+}
+
+// ExportStrategy downloads a strategy package with all of its versions as a
+// signed zip archive
+func (h *StrategyArchiveHandler) ExportStrategy(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	data, manifest, err := h.archives.Export(c.Request.Context(), id, c.Query("include_backtest") == "true")
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy not found"})
+		return
+	case errors.Is(err, archive.ErrNoSigningKey):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export strategy"})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.zip", archiveFilename(manifest.Package.Name), manifest.ExportedAt.Format("20060102T150405Z"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/zip", data)
+}
+
+// ImportStrategy creates or updates a strategy package from a signed archive,
+// sent as the request body or as the multipart file "archive"
+func (h *StrategyArchiveHandler) ImportStrategy(c *gin.Context) {
+	var reader io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxArchiveSize)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("archive")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Archive file is required"})
+			return
+		}
+		if file.Size > maxArchiveSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive is too large"})
+			return
+		}
+		opened, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid archive file"})
+			return
+		}
+		defer opened.Close()
+		reader = opened
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive is too large"})
+		return
+	}
+
+	result, err := h.archives.Import(c.Request.Context(), data)
+	switch {
+	case errors.Is(err, archive.ErrInvalidArchive), errors.Is(err, archive.ErrBadSignature):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, archive.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, archive.ErrNoSigningKey):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import strategy"})
+		return
+	}
+
+	status := http.StatusOK
+	if result.PackageCreated {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"data": result})
+}
+
+// archiveFilename turns a package name into a file name
+func archiveFilename(name string) string {
+	filename := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, name)
+	if filename == "" {
+		return "strategy"
+	}
+	return filename
+}
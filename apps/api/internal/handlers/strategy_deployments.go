@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// StrategyDeploymentHandler handles HTTP requests deploying, starting and stopping strategies
+type StrategyDeploymentHandler struct {
+	deployer *strategy.Deployer
+}
+
+// NewStrategyDeploymentHandler creates a new strategy deployment handler
+func NewStrategyDeploymentHandler(deployer *strategy.Deployer) *StrategyDeploymentHandler {
+	return &StrategyDeploymentHandler{deployer: deployer}
+}
+
+// DeployStrategy deploys a version of a strategy with parameters and symbols.
+// Running executions switch to the version at their next bar.
+func (h *StrategyDeploymentHandler) DeployStrategy(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	var req strategy.Deployment
+	if err := c.ShouldBindJSON(&req); err != nil || req.VersionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	deployed, err := h.deployer.Deploy(c.Request.Context(), id, &req)
+	if err != nil {
+		respondDeploymentError(c, err, "Failed to deploy strategy")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"strategy_id":  deployed.ID,
+		"version_id":   deployed.VersionID,
+		"parameters":   deployed.Parameters,
+		"symbols":      deployed.Symbols,
+		"timeframe":    deployed.Timeframe,
+		"mode":         deployed.Mode,
+		"missing_bars": deployed.MissingBars,
+	}})
+}
+
+// StartStrategy starts a deployed strategy on the requested symbols, or on all of them
+func (h *StrategyDeploymentHandler) StartStrategy(c *gin.Context) {
+	h.transition(c, h.deployer.Start, "Failed to start strategy")
+}
+
+// StopStrategy stops a strategy on the requested symbols, or on all of them
+func (h *StrategyDeploymentHandler) StopStrategy(c *gin.Context) {
+	h.transition(c, h.deployer.Stop, "Failed to stop strategy")
+}
+
+// GetStrategyExecutions retrieves the status of a strategy on every symbol
+func (h *StrategyDeploymentHandler) GetStrategyExecutions(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	executions, err := h.deployer.Executions(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy executions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": executions})
+}
+
+// transition applies start or stop to the symbols of the request body and
+// responds with the resulting executions
+func (h *StrategyDeploymentHandler) transition(c *gin.Context, apply func(ctx context.Context, strategyID string, symbols []string) error, failure string) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	var req struct {
+		Symbols []string `json:"symbols"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	if err := apply(c.Request.Context(), id, req.Symbols); err != nil {
+		respondDeploymentError(c, err, failure)
+		return
+	}
+
+	executions, err := h.deployer.Executions(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy executions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": executions})
+}
+
+// respondDeploymentError maps deployer errors to responses
+func respondDeploymentError(c *gin.Context, err error, failure string) {
+	var compileErr *strategy.CompileError
+	switch {
+	case errors.Is(err, strategy.ErrVersionNotFound), errors.Is(err, strategy.ErrNotDeployed):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, strategy.ErrInvalidDeployment):
+		c.JSON(http.StatusBadRequest, paramErrorResponse(err))
+	case errors.As(err, &compileErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// paramRequest is the definition of a strategy parameter. Defaults are given
+// as JSON values of the parameter's type.
+type paramRequest struct {
+	ParamName    string          `json:"param_name" binding:"required"`
+	ParamType    string          `json:"param_type" binding:"required"`
+	DefaultValue json.RawMessage `json:"default_value"`
+	MinValue     *float64        `json:"min_value"`
+	MaxValue     *float64        `json:"max_value"`
+	Step         *float64        `json:"step"`
+	EnumValues   []string        `json:"enum_values"`
+	Description  *string         `json:"description"`
+	IsRequired   bool            `json:"is_required"`
+}
+
+// apply sets the definition of param from the request
+func (req *paramRequest) apply(param *database.StrategyParam) {
+	param.ParamName = req.ParamName
+	param.ParamType = req.ParamType
+	param.DefaultValue = strategy.FormatParamDefault(req.ParamType, req.DefaultValue)
+	param.MinValue = req.MinValue
+	param.MaxValue = req.MaxValue
+	param.Step = req.Step
+	param.EnumValues = nil
+	if req.EnumValues != nil {
+		param.EnumValues, _ = json.Marshal(req.EnumValues)
+	}
+	param.Description = req.Description
+	param.IsRequired = req.IsRequired
+}
+
+// GetStrategyParams retrieves the parameter definitions of a version
+func (h *StrategyHandler) GetStrategyParams(c *gin.Context) {
+	version, ok := h.versionOf(c)
+	if !ok {
+		return
+	}
+
+	params, err := h.repo.GetParamsByVersionID(c.Request.Context(), version.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": params})
+}
+
+// CreateStrategyParam adds a parameter definition to a version
+func (h *StrategyHandler) CreateStrategyParam(c *gin.Context) {
+	var req paramRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	version, ok := h.versionOf(c)
+	if !ok {
+		return
+	}
+
+	param := &database.StrategyParam{VersionID: version.ID}
+	req.apply(param)
+	if !h.checkParam(c, param) {
+		return
+	}
+
+	if err := h.repo.CreateParam(c.Request.Context(), param); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create strategy parameter"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": param})
+}
+
+// UpdateStrategyParam replaces a parameter definition of a version
+func (h *StrategyHandler) UpdateStrategyParam(c *gin.Context) {
+	var req paramRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	param, ok := h.paramOf(c)
+	if !ok {
+		return
+	}
+
+	req.apply(param)
+	if !h.checkParam(c, param) {
+		return
+	}
+
+	if err := h.repo.UpdateParam(c.Request.Context(), param); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update strategy parameter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": param})
+}
+
+// DeleteStrategyParam removes a parameter definition from a version
+func (h *StrategyHandler) DeleteStrategyParam(c *gin.Context) {
+	param, ok := h.paramOf(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeleteParam(c.Request.Context(), param.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete strategy parameter"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Strategy parameter deleted successfully"})
+}
+
+// GetStrategyParamsSchema describes the parameters of a version as a JSON
+// Schema for generating input forms
+func (h *StrategyHandler) GetStrategyParamsSchema(c *gin.Context) {
+	version, ok := h.versionOf(c)
+	if !ok {
+		return
+	}
+
+	params, err := h.repo.GetParamsByVersionID(c.Request.Context(), version.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": strategy.ParamsJSONSchema(params)})
+}
+
+// versionOf retrieves the version :vid of strategy :id, responding with an
+// error if there is none
+func (h *StrategyHandler) versionOf(c *gin.Context) (*database.StrategyVersion, bool) {
+	id := c.Param("id")
+	versionID := c.Param("vid")
+	if id == "" || versionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID and version ID are required"})
+		return nil, false
+	}
+
+	version, err := h.repo.GetVersionByID(c.Request.Context(), versionID)
+	if err == sql.ErrNoRows || (err == nil && version.PackageID != id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy version not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy version"})
+		return nil, false
+	}
+	return version, true
+}
+
+// paramOf retrieves the parameter :pid of version :vid of strategy :id,
+// responding with an error if there is none
+func (h *StrategyHandler) paramOf(c *gin.Context) (*database.StrategyParam, bool) {
+	version, ok := h.versionOf(c)
+	if !ok {
+		return nil, false
+	}
+
+	param, err := h.repo.GetParamByID(c.Request.Context(), c.Param("pid"))
+	if err == sql.ErrNoRows || (err == nil && param.VersionID != version.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy parameter not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameter"})
+		return nil, false
+	}
+	return param, true
+}
+
+// checkParam validates a parameter definition and that no other parameter of
+// its version has the same name, responding with an error if not
+func (h *StrategyHandler) checkParam(c *gin.Context, param *database.StrategyParam) bool {
+	if err := strategy.ValidateParamDefinition(param); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	params, err := h.repo.GetParamsByVersionID(c.Request.Context(), param.VersionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return false
+	}
+	for _, other := range params {
+		if other.ParamName == param.ParamName && other.ID != param.ID {
+			c.JSON(http.StatusConflict, gin.H{"error": "Parameter " + param.ParamName + " already exists"})
+			return false
+		}
+	}
+	return true
+}
+
+// paramErrorResponse is the response to invalid parameter values, listing the
+// reason for each invalid parameter under fields
+func paramErrorResponse(err error) gin.H {
+	response := gin.H{"error": err.Error()}
+	var paramErr *strategy.ParamError
+	if errors.As(err, &paramErr) {
+		response["fields"] = paramErr.Fields
+	}
+	return response
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// StrategyStateHandler handles HTTP requests for persistent strategy state
+type StrategyStateHandler struct {
+	store *strategy.StateStore
+}
+
+// NewStrategyStateHandler creates a new strategy state handler
+func NewStrategyStateHandler(store *strategy.StateStore) *StrategyStateHandler {
+	return &StrategyStateHandler{store: store}
+}
+
+// GetStrategyState retrieves a strategy's state keyed by symbol
+func (h *StrategyStateHandler) GetStrategyState(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	states, err := h.store.Inspect(c.Request.Context(), id, c.Query("symbol"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy state"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": states})
+}
+
+// ClearStrategyState deletes a strategy's state, for one symbol when given
+func (h *StrategyStateHandler) ClearStrategyState(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	if err := h.store.Clear(c.Request.Context(), id, c.Query("symbol")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear strategy state"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Strategy state cleared successfully"})
+}
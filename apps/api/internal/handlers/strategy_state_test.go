@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStrategyStateRouter(t *testing.T) (*gin.Engine, *testutil.Redis, *testutil.SQLMock) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	client, server := testutil.NewRedis(t)
+	db, mock := testutil.NewSQLMock(t)
+	handler := NewStrategyStateHandler(strategy.NewStateStore(client, database.NewStrategyStateRepository(db)))
+
+	router := gin.New()
+	router.GET("/strategies/:id/state", handler.GetStrategyState)
+	router.DELETE("/strategies/:id/state", handler.ClearStrategyState)
+	return router, server, mock
+}
+
+func TestStrategyStateHandler_GetStrategyState(t *testing.T) {
+	router, server, mock := newStrategyStateRouter(t)
+	now := time.Now()
+	server.Set("strategy_state:s1:AAPL", []byte(`{"count":2}`))
+	mock.ExpectQuery(`FROM strategy_state WHERE strategy_id = \? ORDER BY symbol`).
+		WithArgs("s1").
+		WillReturnRows([]string{"id", "strategy_id", "symbol", "state", "size_bytes", "created_at", "updated_at"},
+			[]interface{}{"id1", "s1", "AAPL", []byte(`{"count":1}`), 11, now, now},
+			[]interface{}{"id2", "s1", "MSFT", []byte(`{"count":4}`), 11, now, now})
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/strategies/s1/state?symbol=AAPL", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]json.RawMessage{"AAPL": json.RawMessage(`{"count":2}`)}, response.Data)
+}
+
+func TestStrategyStateHandler_GetStrategyStateFailure(t *testing.T) {
+	router, _, mock := newStrategyStateRouter(t)
+	mock.ExpectQuery(`FROM strategy_state`).WillReturnError(errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/strategies/s1/state", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Failed to retrieve strategy state")
+}
+
+func TestStrategyStateHandler_ClearStrategyState(t *testing.T) {
+	router, server, mock := newStrategyStateRouter(t)
+	server.Set("strategy_state:s1:AAPL", []byte(`{"count":2}`))
+	server.Set("strategy_state:s1:MSFT", []byte(`{"count":3}`))
+	mock.ExpectExec(`DELETE FROM strategy_state WHERE strategy_id = \? AND symbol = \?`).WithArgs("s1", "AAPL")
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodDelete, "/strategies/s1/state?symbol=AAPL", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"strategy_state:s1:MSFT"}, server.Keys())
+}
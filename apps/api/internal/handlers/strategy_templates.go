@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// GetStrategyTemplates lists the strategy templates with their parameters
+func (h *StrategyHandler) GetStrategyTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": strategy.Templates()})
+}
+
+// CreateStrategyFromTemplate creates a strategy package whose first version
+// runs a template, with the template's parameter definitions
+func (h *StrategyHandler) CreateStrategyFromTemplate(c *gin.Context) {
+	var req struct {
+		Template    string  `json:"template" binding:"required"`
+		Name        string  `json:"name" binding:"required"`
+		Description *string `json:"description"`
+		Author      string  `json:"author" binding:"required"`
+		IsPublic    bool    `json:"is_public"`
+		Version     string  `json:"version"`
+		IsActive    *bool   `json:"is_active"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	template, ok := strategy.LookupTemplate(req.Template)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy template not found"})
+		return
+	}
+
+	pkg := &database.StrategyPackage{
+		Name:        req.Name,
+		Description: req.Description,
+		Author:      req.Author,
+		IsPublic:    req.IsPublic,
+	}
+	if pkg.Description == nil {
+		pkg.Description = &template.Description
+	}
+
+	version := &database.StrategyVersion{
+		Version:  req.Version,
+		Code:     template.Code,
+		IsActive: req.IsActive == nil || *req.IsActive,
+	}
+	if version.Version == "" {
+		version.Version = "1.0.0"
+	}
+
+	params := make([]*database.StrategyParam, len(template.Params))
+	for i, param := range template.Params {
+		params[i] = param.Definition()
+	}
+
+	if err := h.repo.CreatePackageWithVersion(c.Request.Context(), pkg, version, params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create strategy"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"strategy": pkg,
+		"version":  version,
+		"params":   params,
+	}})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/strategy"
+)
+
+// ActivateStrategyVersion makes a version the strategy's only active version
+func (h *StrategyHandler) ActivateStrategyVersion(c *gin.Context) {
+	version, ok := h.versionOf(c)
+	if !ok {
+		return
+	}
+
+	if err := h.activate(c, version, "activate"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate strategy version"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": version})
+}
+
+// RollbackStrategyVersion activates the version that was active before the
+// strategy's current one
+func (h *StrategyHandler) RollbackStrategyVersion(c *gin.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Strategy ID is required"})
+		return
+	}
+
+	previousID, err := h.repo.GetPreviousActiveVersionID(c.Request.Context(), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Strategy has no previous active version"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy versions"})
+		return
+	}
+
+	version, err := h.repo.GetVersionByID(c.Request.Context(), previousID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy version"})
+		return
+	}
+
+	if err := h.activate(c, version, "rollback"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back strategy version"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": version})
+}
+
+// DiffStrategyVersions compares the code and parameters of version :vid with
+// version :other
+func (h *StrategyHandler) DiffStrategyVersions(c *gin.Context) {
+	from, ok := h.versionOf(c)
+	if !ok {
+		return
+	}
+	to, err := h.repo.GetVersionByID(c.Request.Context(), c.Param("other"))
+	if err == sql.ErrNoRows || (err == nil && to.PackageID != from.PackageID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Strategy version not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy version"})
+		return
+	}
+
+	fromParams, err := h.repo.GetParamsByVersionID(c.Request.Context(), from.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+	toParams, err := h.repo.GetParamsByVersionID(c.Request.Context(), to.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve strategy parameters"})
+		return
+	}
+
+	diff := strategy.DiffVersions(from, to, fromParams, toParams)
+	h.audit(c, from.PackageID, fmt.Sprintf("Compared version %s with version %s", from.Version, to.Version), map[string]interface{}{
+		"action":          "diff",
+		"from_version_id": from.ID,
+		"to_version_id":   to.ID,
+		"param_changes":   len(diff.ParamChanges),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"data": diff})
+}
+
+// activate makes version the active version of its package and records the
+// action in the audit log
+func (h *StrategyHandler) activate(c *gin.Context, version *database.StrategyVersion, action string) error {
+	previousID, err := h.repo.ActivateVersion(c.Request.Context(), version.PackageID, version.ID)
+	if err != nil {
+		return err
+	}
+	version.IsActive = true
+
+	metadata := map[string]interface{}{
+		"action":     action,
+		"version_id": version.ID,
+	}
+	if previousID != "" {
+		metadata["previous_version_id"] = previousID
+	}
+	message := fmt.Sprintf("Activated version %s", version.Version)
+	if action == "rollback" {
+		message = fmt.Sprintf("Rolled back to version %s", version.Version)
+	}
+	h.audit(c, version.PackageID, message, metadata)
+	return nil
+}
+
+// audit records a strategy version event in the audit log; failures are
+// logged and do not fail the request
+func (h *StrategyHandler) audit(c *gin.Context, strategyID, message string, metadata map[string]interface{}) {
+	if h.auditLogs == nil {
+		return
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("Failed to encode audit log metadata of strategy %s: %v", strategyID, err)
+		return
+	}
+	entry := &database.AuditLog{
+		Level:      database.AuditLevelInfo,
+		Category:   strategy.AuditCategoryStrategy,
+		Message:    message,
+		StrategyID: &strategyID,
+		Metadata:   data,
+	}
+	if err := h.auditLogs.CreateAuditLog(c.Request.Context(), entry); err != nil {
+		log.Printf("Failed to record audit log of strategy %s: %v", strategyID, err)
+	}
+}
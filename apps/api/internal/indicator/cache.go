@@ -0,0 +1,60 @@
+package indicator
+
+import (
+	"sync"
+)
+
+// Key identifies a streaming indicator instance. A Cache belongs to a single
+// strategy instance, so keys only need to separate symbol, input series,
+// indicator and parameters.
+type Key struct {
+	Symbol string
+	Series string
+	Name   string
+	Params string
+}
+
+// Cache stores streaming indicators and memoizes their result per bar
+type Cache struct {
+	mu      sync.Mutex
+	entries map[Key]*cacheEntry
+}
+
+type cacheEntry struct {
+	indicator interface{}
+	seq       int64
+	result    interface{}
+}
+
+// NewCache creates an empty indicator cache
+func NewCache() *Cache {
+	return &Cache{entries: make(map[Key]*cacheEntry)}
+}
+
+// Update feeds the indicator stored under key for bar number seq, creating it
+// with create on first use. Repeated calls for the same seq return the
+// memoized result so a script may evaluate an indicator more than once per bar.
+// update receives the seq of the indicator's previous update, or -1 for a new
+// indicator, so callers that keep history can replay the bars it missed.
+func (c *Cache) Update(key Key, seq int64, create func() interface{}, update func(indicator interface{}, last int64) interface{}) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		entry = &cacheEntry{indicator: create(), seq: -1}
+		c.entries[key] = entry
+	}
+	if entry.seq != seq {
+		entry.result = update(entry.indicator, entry.seq)
+		entry.seq = seq
+	}
+	return entry.result
+}
+
+// Len returns the number of cached indicators
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
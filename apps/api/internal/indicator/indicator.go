@@ -0,0 +1,410 @@
+package indicator
+
+import (
+	"math"
+)
+
+// window is a fixed-size ring buffer of the most recent values
+type window struct {
+	values []float64
+	next   int
+	count  int
+}
+
+func newWindow(size int) *window {
+	return &window{values: make([]float64, size)}
+}
+
+// push adds a value and returns the value it evicted, if any
+func (w *window) push(v float64) (evicted float64, full bool) {
+	full = w.count == len(w.values)
+	evicted = w.values[w.next]
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+	if !full {
+		w.count++
+	}
+	return evicted, full
+}
+
+func (w *window) ready() bool {
+	return w.count == len(w.values)
+}
+
+// SMA is a simple moving average
+type SMA struct {
+	window *window
+	sum    float64
+}
+
+// NewSMA creates a simple moving average over period values
+func NewSMA(period int) *SMA {
+	return &SMA{window: newWindow(clampPeriod(period))}
+}
+
+// Update feeds the next value and returns the average once period values are available
+func (s *SMA) Update(v float64) (float64, bool) {
+	evicted, full := s.window.push(v)
+	s.sum += v
+	if full {
+		s.sum -= evicted
+	}
+	if !s.window.ready() {
+		return 0, false
+	}
+	return s.sum / float64(s.window.count), true
+}
+
+// EMA is an exponential moving average seeded with the SMA of its first period values
+type EMA struct {
+	period int
+	alpha  float64
+	seed   *SMA
+	value  float64
+	ready  bool
+}
+
+// NewEMA creates an exponential moving average with smoothing 2/(period+1)
+func NewEMA(period int) *EMA {
+	period = clampPeriod(period)
+	return &EMA{
+		period: period,
+		alpha:  2.0 / float64(period+1),
+		seed:   NewSMA(period),
+	}
+}
+
+// Update feeds the next value and returns the average once period values are available
+func (e *EMA) Update(v float64) (float64, bool) {
+	if !e.ready {
+		seed, ok := e.seed.Update(v)
+		if !ok {
+			return 0, false
+		}
+		e.value = seed
+		e.ready = true
+		return e.value, true
+	}
+	e.value = e.alpha*v + (1-e.alpha)*e.value
+	return e.value, true
+}
+
+// wilder is Wilder's smoothing: an SMA seed followed by value = (prev*(n-1)+v)/n
+type wilder struct {
+	period int
+	seed   *SMA
+	value  float64
+	ready  bool
+}
+
+func newWilder(period int) *wilder {
+	return &wilder{period: period, seed: NewSMA(period)}
+}
+
+func (w *wilder) update(v float64) (float64, bool) {
+	if !w.ready {
+		seed, ok := w.seed.Update(v)
+		if !ok {
+			return 0, false
+		}
+		w.value = seed
+		w.ready = true
+		return w.value, true
+	}
+	w.value = (w.value*float64(w.period-1) + v) / float64(w.period)
+	return w.value, true
+}
+
+// RSI is Wilder's relative strength index
+type RSI struct {
+	gain    *wilder
+	loss    *wilder
+	prev    float64
+	hasPrev bool
+}
+
+// NewRSI creates a relative strength index over period price changes
+func NewRSI(period int) *RSI {
+	period = clampPeriod(period)
+	return &RSI{gain: newWilder(period), loss: newWilder(period)}
+}
+
+// Update feeds the next price and returns the RSI once period changes are available
+func (r *RSI) Update(v float64) (float64, bool) {
+	if !r.hasPrev {
+		r.prev = v
+		r.hasPrev = true
+		return 0, false
+	}
+
+	change := v - r.prev
+	r.prev = v
+	avgGain, ok := r.gain.update(math.Max(change, 0))
+	avgLoss, _ := r.loss.update(math.Max(-change, 0))
+	if !ok {
+		return 0, false
+	}
+
+	if avgLoss == 0 {
+		if avgGain == 0 {
+			return 50, true
+		}
+		return 100, true
+	}
+	rs := avgGain / avgLoss
+	return 100 - 100/(1+rs), true
+}
+
+// ATR is Wilder's average true range
+type ATR struct {
+	smooth    *wilder
+	prevClose float64
+	hasPrev   bool
+}
+
+// NewATR creates an average true range over period bars
+func NewATR(period int) *ATR {
+	return &ATR{smooth: newWilder(clampPeriod(period))}
+}
+
+// Update feeds the next bar and returns the ATR once period true ranges are available.
+// The first bar has no previous close, so its true range is its high-low range.
+func (a *ATR) Update(high, low, close float64) (float64, bool) {
+	tr := high - low
+	if a.hasPrev {
+		tr = math.Max(tr, math.Max(math.Abs(high-a.prevClose), math.Abs(low-a.prevClose)))
+	}
+	a.prevClose = close
+	a.hasPrev = true
+	return a.smooth.update(tr)
+}
+
+// StdDev is the population standard deviation over a rolling window
+type StdDev struct {
+	window *window
+	sum    float64
+	sumSq  float64
+}
+
+// NewStdDev creates a rolling standard deviation over period values
+func NewStdDev(period int) *StdDev {
+	return &StdDev{window: newWindow(clampPeriod(period))}
+}
+
+// Update feeds the next value and returns the standard deviation once period values are available
+func (s *StdDev) Update(v float64) (float64, bool) {
+	evicted, full := s.window.push(v)
+	s.sum += v
+	s.sumSq += v * v
+	if full {
+		s.sum -= evicted
+		s.sumSq -= evicted * evicted
+	}
+	if !s.window.ready() {
+		return 0, false
+	}
+	n := float64(s.window.count)
+	mean := s.sum / n
+	variance := s.sumSq/n - mean*mean
+	if variance < 0 {
+		variance = 0 // guard against floating point cancellation
+	}
+	return math.Sqrt(variance), true
+}
+
+// extreme tracks the rolling maximum or minimum with a monotonic deque
+type extreme struct {
+	period int
+	index  int
+	values []float64
+	idx    []int
+	better func(a, b float64) bool
+}
+
+func (e *extreme) update(v float64) (float64, bool) {
+	for len(e.values) > 0 && !e.better(e.values[len(e.values)-1], v) {
+		e.values = e.values[:len(e.values)-1]
+		e.idx = e.idx[:len(e.idx)-1]
+	}
+	e.values = append(e.values, v)
+	e.idx = append(e.idx, e.index)
+	if e.idx[0] <= e.index-e.period {
+		e.values = e.values[1:]
+		e.idx = e.idx[1:]
+	}
+	e.index++
+	if e.index < e.period {
+		return 0, false
+	}
+	return e.values[0], true
+}
+
+// Highest is the rolling maximum
+type Highest struct {
+	extreme
+}
+
+// NewHighest creates a rolling maximum over period values
+func NewHighest(period int) *Highest {
+	return &Highest{extreme{period: clampPeriod(period), better: func(a, b float64) bool { return a > b }}}
+}
+
+// Update feeds the next value and returns the maximum once period values are available
+func (h *Highest) Update(v float64) (float64, bool) {
+	return h.update(v)
+}
+
+// Lowest is the rolling minimum
+type Lowest struct {
+	extreme
+}
+
+// NewLowest creates a rolling minimum over period values
+func NewLowest(period int) *Lowest {
+	return &Lowest{extreme{period: clampPeriod(period), better: func(a, b float64) bool { return a < b }}}
+}
+
+// Update feeds the next value and returns the minimum once period values are available
+func (l *Lowest) Update(v float64) (float64, bool) {
+	return l.update(v)
+}
+
+// MACDValue holds the MACD line, its signal line and their difference
+type MACDValue struct {
+	MACD      float64 `json:"macd"`
+	Signal    float64 `json:"signal"`
+	Histogram float64 `json:"histogram"`
+}
+
+// MACD is the moving average convergence divergence
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+}
+
+// NewMACD creates a MACD with the given fast, slow and signal periods
+func NewMACD(fast, slow, signal int) *MACD {
+	return &MACD{fast: NewEMA(fast), slow: NewEMA(slow), signal: NewEMA(signal)}
+}
+
+// Update feeds the next value and returns the MACD once the signal line is available
+func (m *MACD) Update(v float64) (MACDValue, bool) {
+	fast, fastOK := m.fast.Update(v)
+	slow, slowOK := m.slow.Update(v)
+	if !fastOK || !slowOK {
+		return MACDValue{}, false
+	}
+	line := fast - slow
+	signal, ok := m.signal.Update(line)
+	if !ok {
+		return MACDValue{}, false
+	}
+	return MACDValue{MACD: line, Signal: signal, Histogram: line - signal}, true
+}
+
+// BollingerValue holds the three Bollinger bands
+type BollingerValue struct {
+	Upper  float64 `json:"upper"`
+	Middle float64 `json:"middle"`
+	Lower  float64 `json:"lower"`
+}
+
+// Bollinger is a set of Bollinger bands around a simple moving average
+type Bollinger struct {
+	sma *SMA
+	std *StdDev
+	k   float64
+}
+
+// NewBollinger creates Bollinger bands over period values at k standard deviations
+func NewBollinger(period int, k float64) *Bollinger {
+	return &Bollinger{sma: NewSMA(period), std: NewStdDev(period), k: k}
+}
+
+// Update feeds the next value and returns the bands once period values are available
+func (b *Bollinger) Update(v float64) (BollingerValue, bool) {
+	middle, ok := b.sma.Update(v)
+	std, _ := b.std.Update(v)
+	if !ok {
+		return BollingerValue{}, false
+	}
+	return BollingerValue{Upper: middle + b.k*std, Middle: middle, Lower: middle - b.k*std}, true
+}
+
+// IchimokuValue holds the Ichimoku lines for the current bar. SenkouA and
+// SenkouB are the cloud values plotted at the current bar, i.e. computed
+// displacement bars ago.
+type IchimokuValue struct {
+	Tenkan  float64 `json:"tenkan"`
+	Kijun   float64 `json:"kijun"`
+	SenkouA float64 `json:"senkou_a"`
+	SenkouB float64 `json:"senkou_b"`
+}
+
+// Ichimoku is the Ichimoku Kinko Hyo indicator
+type Ichimoku struct {
+	tenkanHigh, tenkanLow *extreme
+	kijunHigh, kijunLow   *extreme
+	senkouHigh, senkouLow *extreme
+	displacement          int
+	pendingA, pendingB    []float64
+}
+
+// NewIchimoku creates an Ichimoku indicator with the given conversion, base
+// and leading span B periods; the leading spans are displaced by base periods
+func NewIchimoku(tenkan, kijun, senkouB int) *Ichimoku {
+	highest := func(period int) *extreme { return &NewHighest(period).extreme }
+	lowest := func(period int) *extreme { return &NewLowest(period).extreme }
+	return &Ichimoku{
+		tenkanHigh:   highest(tenkan),
+		tenkanLow:    lowest(tenkan),
+		kijunHigh:    highest(kijun),
+		kijunLow:     lowest(kijun),
+		senkouHigh:   highest(senkouB),
+		senkouLow:    lowest(senkouB),
+		displacement: clampPeriod(kijun),
+	}
+}
+
+// Update feeds the next bar and returns the Ichimoku lines once the displaced cloud is available
+func (ich *Ichimoku) Update(high, low float64) (IchimokuValue, bool) {
+	th, tOK := ich.tenkanHigh.update(high)
+	tl, _ := ich.tenkanLow.update(low)
+	kh, kOK := ich.kijunHigh.update(high)
+	kl, _ := ich.kijunLow.update(low)
+	sh, sOK := ich.senkouHigh.update(high)
+	sl, _ := ich.senkouLow.update(low)
+
+	var value IchimokuValue
+	if tOK {
+		value.Tenkan = (th + tl) / 2
+	}
+	if kOK {
+		value.Kijun = (kh + kl) / 2
+	}
+	if tOK && kOK {
+		ich.pendingA = append(ich.pendingA, (value.Tenkan+value.Kijun)/2)
+	}
+	if sOK {
+		ich.pendingB = append(ich.pendingB, (sh+sl)/2)
+	}
+
+	// Each span becomes visible displacement bars after it was computed
+	if len(ich.pendingA) <= ich.displacement || len(ich.pendingB) <= ich.displacement {
+		return value, false
+	}
+	value.SenkouA = ich.pendingA[len(ich.pendingA)-1-ich.displacement]
+	value.SenkouB = ich.pendingB[len(ich.pendingB)-1-ich.displacement]
+	ich.pendingA = ich.pendingA[len(ich.pendingA)-ich.displacement-1:]
+	ich.pendingB = ich.pendingB[len(ich.pendingB)-ich.displacement-1:]
+	return value, true
+}
+
+// clampPeriod treats non-positive periods as a period of one
+func clampPeriod(period int) int {
+	if period < 1 {
+		return 1
+	}
+	return period
+}
@@ -0,0 +1,260 @@
+package indicator
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// none marks bars for which an indicator is still warming up
+var none = math.NaN()
+
+// feed runs values through a single-input indicator, recording NaN while it is not ready
+func feed(update func(float64) (float64, bool), values []float64) []float64 {
+	out := make([]float64, len(values))
+	for i, v := range values {
+		result, ok := update(v)
+		if !ok {
+			result = none
+		}
+		out[i] = result
+	}
+	return out
+}
+
+func assertSeries(t *testing.T, want, got []float64, tolerance float64) {
+	t.Helper()
+	require.Len(t, got, len(want))
+	for i := range want {
+		if math.IsNaN(want[i]) {
+			assert.True(t, math.IsNaN(got[i]), "index %d: want warm-up, got %v", i, got[i])
+			continue
+		}
+		assert.InDelta(t, want[i], got[i], tolerance, "index %d", i)
+	}
+}
+
+// StockCharts 10-day EMA example
+var emaPrices = []float64{
+	22.27, 22.19, 22.08, 22.17, 22.18, 22.13, 22.23, 22.43, 22.24, 22.29,
+	22.15, 22.39, 22.38, 22.61, 23.36, 24.05, 23.75, 23.83, 23.95, 23.63,
+	23.82, 23.87, 23.65, 23.19, 23.10, 23.33, 22.68, 23.10, 22.40, 22.17,
+}
+
+// StockCharts 14-day RSI example
+var rsiPrices = []float64{
+	44.3389, 44.0902, 44.1497, 43.6124, 44.3278, 44.8264, 45.0955, 45.4245, 45.8433, 46.0826,
+	45.8931, 46.0328, 45.6140, 46.2820, 46.2820, 46.0028, 46.0328, 46.4116, 46.2222, 45.6439,
+	46.2122, 46.2521, 45.7137, 46.4515, 45.7835, 45.3548, 44.0288, 44.1783, 44.2181, 44.5672,
+	43.4205, 42.6628, 43.1314,
+}
+
+func TestSingleInputIndicators(t *testing.T) {
+	tests := []struct {
+		name      string
+		update    func(float64) (float64, bool)
+		input     []float64
+		want      []float64
+		tolerance float64
+	}{
+		{
+			name:   "sma",
+			update: NewSMA(3).Update,
+			input:  []float64{1, 2, 3, 4, 5, 6},
+			want:   []float64{none, none, 2, 3, 4, 5},
+		},
+		{
+			name:   "ema stockcharts",
+			update: NewEMA(10).Update,
+			input:  emaPrices,
+			want: []float64{
+				none, none, none, none, none, none, none, none, none, 22.22,
+				22.21, 22.24, 22.27, 22.33, 22.52, 22.80, 22.97, 23.13, 23.28, 23.34,
+				23.43, 23.51, 23.53, 23.47, 23.40, 23.39, 23.26, 23.23, 23.08, 22.92,
+			},
+			tolerance: 0.005,
+		},
+		{
+			name:   "rsi stockcharts",
+			update: NewRSI(14).Update,
+			input:  rsiPrices,
+			want: []float64{
+				none, none, none, none, none, none, none, none, none, none, none, none, none, none,
+				70.53, 66.32, 66.55, 69.41, 66.36, 57.97, 62.93, 63.26, 56.06, 62.38,
+				54.71, 50.42, 39.99, 41.46, 41.87, 45.46, 37.30, 33.08, 37.77,
+			},
+			tolerance: 0.005,
+		},
+		{
+			name:   "rsi flat series",
+			update: NewRSI(2).Update,
+			input:  []float64{5, 5, 5, 5},
+			want:   []float64{none, none, 50, 50},
+		},
+		{
+			name:   "stddev population",
+			update: NewStdDev(8).Update,
+			input:  []float64{2, 4, 4, 4, 5, 5, 7, 9},
+			want:   []float64{none, none, none, none, none, none, none, 2},
+		},
+		{
+			name:      "stddev rolling",
+			update:    NewStdDev(3).Update,
+			input:     []float64{1, 2, 3, 4, 10},
+			want:      []float64{none, none, 0.816497, 0.816497, 3.091206},
+			tolerance: 1e-6,
+		},
+		{
+			name:   "highest",
+			update: NewHighest(3).Update,
+			input:  []float64{3, 1, 4, 1, 5, 9, 2, 6},
+			want:   []float64{none, none, 4, 4, 5, 9, 9, 9},
+		},
+		{
+			name:   "lowest",
+			update: NewLowest(3).Update,
+			input:  []float64{3, 1, 4, 1, 5, 9, 2, 6},
+			want:   []float64{none, none, 1, 1, 1, 1, 2, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tolerance := tt.tolerance
+			if tolerance == 0 {
+				tolerance = 1e-9
+			}
+			assertSeries(t, tt.want, feed(tt.update, tt.input), tolerance)
+		})
+	}
+}
+
+func TestATR(t *testing.T) {
+	bars := []struct{ high, low, close float64 }{
+		{10, 8, 9},
+		{11, 9, 10.5},
+		{12, 10, 11},
+		{11.5, 9.5, 10},
+		{13, 10, 12.5},
+		{15, 14, 14.5}, // gap up: true range uses the previous close
+	}
+	want := []float64{none, none, 2, 2, 2.333333, 2.388889}
+
+	atr := NewATR(3)
+	got := make([]float64, len(bars))
+	for i, bar := range bars {
+		value, ok := atr.Update(bar.high, bar.low, bar.close)
+		if !ok {
+			value = none
+		}
+		got[i] = value
+	}
+	assertSeries(t, want, got, 1e-6)
+}
+
+func TestMACD(t *testing.T) {
+	prices := []float64{10, 11, 12, 11, 13, 14, 13, 15, 16, 15, 17, 18}
+	tests := []struct {
+		index int
+		want  MACDValue
+	}{
+		{5, MACDValue{MACD: 0.733333, Signal: 0.666667, Histogram: 0.066667}},
+		{6, MACDValue{MACD: 0.488889, Signal: 0.548148, Histogram: -0.059259}},
+		{9, MACDValue{MACD: 0.515226, Signal: 0.584362, Histogram: -0.069136}},
+		{11, MACDValue{MACD: 0.784545, Signal: 0.738363, Histogram: 0.046182}},
+	}
+
+	macd := NewMACD(3, 5, 2)
+	results := make([]MACDValue, len(prices))
+	ready := make([]bool, len(prices))
+	for i, price := range prices {
+		results[i], ready[i] = macd.Update(price)
+	}
+
+	assert.False(t, ready[4], "signal line needs two MACD values")
+	for _, tt := range tests {
+		require.True(t, ready[tt.index])
+		assert.InDelta(t, tt.want.MACD, results[tt.index].MACD, 1e-6)
+		assert.InDelta(t, tt.want.Signal, results[tt.index].Signal, 1e-6)
+		assert.InDelta(t, tt.want.Histogram, results[tt.index].Histogram, 1e-6)
+	}
+}
+
+func TestBollinger(t *testing.T) {
+	bands := NewBollinger(8, 2)
+	var value BollingerValue
+	var ok bool
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		value, ok = bands.Update(v)
+	}
+	require.True(t, ok)
+	assert.Equal(t, BollingerValue{Upper: 9, Middle: 5, Lower: 1}, value)
+}
+
+func TestIchimoku(t *testing.T) {
+	highs := []float64{10, 12, 11, 13, 14, 12, 15, 16, 14, 17}
+	lows := []float64{8, 9, 9, 10, 11, 10, 12, 13, 12, 14}
+	tests := []struct {
+		index int
+		ready bool
+		want  IchimokuValue
+	}{
+		{5, false, IchimokuValue{Tenkan: 12, Kijun: 12}},
+		{6, true, IchimokuValue{Tenkan: 12.5, Kijun: 12.5, SenkouA: 11, SenkouB: 10.5}},
+		{7, true, IchimokuValue{Tenkan: 14, Kijun: 13, SenkouA: 11.75, SenkouB: 11.5}},
+		{9, true, IchimokuValue{Tenkan: 14.5, Kijun: 14.5, SenkouA: 12.5, SenkouB: 12.5}},
+	}
+
+	ichimoku := NewIchimoku(2, 3, 4)
+	results := make([]IchimokuValue, len(highs))
+	ready := make([]bool, len(highs))
+	for i := range highs {
+		results[i], ready[i] = ichimoku.Update(highs[i], lows[i])
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.ready, ready[tt.index], "index %d", tt.index)
+		if tt.ready {
+			assert.Equal(t, tt.want, results[tt.index], "index %d", tt.index)
+		} else {
+			assert.Equal(t, tt.want.Tenkan, results[tt.index].Tenkan)
+			assert.Equal(t, tt.want.Kijun, results[tt.index].Kijun)
+		}
+	}
+}
+
+func TestCache_MemoizesPerBar(t *testing.T) {
+	cache := NewCache()
+	key := Key{Symbol: "AAPL", Series: "close", Name: "sma", Params: "2"}
+	create := func() interface{} { return NewSMA(2) }
+	update := func(v float64) func(interface{}, int64) interface{} {
+		return func(ind interface{}, last int64) interface{} {
+			value, _ := ind.(*SMA).Update(v)
+			return value
+		}
+	}
+
+	cache.Update(key, 0, create, update(1))
+	assert.Equal(t, 1.5, cache.Update(key, 1, create, update(2)))
+	// A second evaluation within the same bar must not advance the indicator
+	assert.Equal(t, 1.5, cache.Update(key, 1, create, update(100)))
+	assert.Equal(t, 2.5, cache.Update(key, 2, create, update(3)))
+
+	other := key
+	other.Symbol = "MSFT"
+	assert.Equal(t, 0.0, cache.Update(other, 2, create, update(3)))
+	assert.Equal(t, 2, cache.Len())
+
+	// The previous seq is passed so callers can replay skipped bars
+	var lasts []int64
+	record := func(ind interface{}, last int64) interface{} {
+		lasts = append(lasts, last)
+		return nil
+	}
+	third := Key{Symbol: "TSLA", Series: "close", Name: "sma", Params: "2"}
+	cache.Update(third, 3, create, record)
+	cache.Update(third, 7, create, record)
+	assert.Equal(t, []int64{-1, 3}, lasts)
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// cancelTTL is how long a cancellation outlives its request, long enough for
+// a queued job to be picked up and dropped
+const cancelTTL = 24 * time.Hour
+
+// Job is a job taken from a queue
+type Job struct {
+	ID     string // ID of the stream entry, to acknowledge it with
+	Values map[string]interface{}
+}
+
+// JobQueue is a work queue on a stream and its consumer group. Each job is
+// delivered to one consumer and stays pending until acknowledged. Consumers
+// heartbeat the jobs they run; a job whose consumer stops heartbeating, e.g.
+// because its process crashed, is reclaimed by the next consumer looking for
+// work.
+type JobQueue struct {
+	client *redis.Client
+	stream string
+	group  string
+}
+
+// NewJobQueue creates a queue on a stream consumed by group
+func NewJobQueue(client *redis.Client, stream, group string) *JobQueue {
+	return &JobQueue{client: client, stream: stream, group: group}
+}
+
+// Init creates the stream and its consumer group if they don't exist
+func (q *JobQueue) Init(ctx context.Context) error {
+	err := q.client.XGroupCreateMkStream(ctx, q.stream, q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s for stream %s: %w", q.group, q.stream, err)
+	}
+	return nil
+}
+
+// Push adds a job to the queue and returns its ID
+func (q *JobQueue) Push(ctx context.Context, values map[string]interface{}) (string, error) {
+	id, err := q.client.XAdd(ctx, &redis.XAddArgs{Stream: q.stream, Values: values}).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to push job to %s: %w", q.stream, err)
+	}
+	return id, nil
+}
+
+// Next takes the next job for consumer: a job whose consumer has not
+// heartbeated it for minIdle, else a new job, waiting up to block for one.
+// It returns nil when there is none.
+func (q *JobQueue) Next(ctx context.Context, consumer string, minIdle, block time.Duration) (*Job, error) {
+	if block <= 0 {
+		block = -1 // BLOCK 0 would wait forever
+	}
+	claimed, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   q.stream,
+		Group:    q.group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0-0",
+		Count:    1,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reclaim jobs from %s: %w", q.stream, err)
+	}
+	if len(claimed) > 0 {
+		return &Job{ID: claimed[0].ID, Values: claimed[0].Values}, nil
+	}
+
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: consumer,
+		Streams:  []string{q.stream, ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs from %s: %w", q.stream, err)
+	}
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			return &Job{ID: message.ID, Values: message.Values}, nil
+		}
+	}
+	return nil, nil
+}
+
+// Heartbeat marks a job as still running on consumer, so it isn't reclaimed
+func (q *JobQueue) Heartbeat(ctx context.Context, consumer, id string) error {
+	err := q.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   q.stream,
+		Group:    q.group,
+		Consumer: consumer,
+		Messages: []string{id},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to heartbeat job %s: %w", id, err)
+	}
+	return nil
+}
+
+// Ack removes a finished job from the queue
+func (q *JobQueue) Ack(ctx context.Context, id string) error {
+	if err := q.client.XAck(ctx, q.stream, q.group, id).Err(); err != nil {
+		return fmt.Errorf("failed to acknowledge job %s: %w", id, err)
+	}
+	return nil
+}
+
+// Cancel flags the work identified by key as cancelled, for whichever
+// consumer runs it to see on its next heartbeat
+func (q *JobQueue) Cancel(ctx context.Context, key string) error {
+	if err := q.client.Set(ctx, q.cancelKey(key), "1", cancelTTL).Err(); err != nil {
+		return fmt.Errorf("failed to cancel %s: %w", key, err)
+	}
+	return nil
+}
+
+// Cancelled reports whether the work identified by key has been cancelled
+func (q *JobQueue) Cancelled(ctx context.Context, key string) (bool, error) {
+	err := q.client.Get(ctx, q.cancelKey(key)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check cancellation of %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (q *JobQueue) cancelKey(key string) string {
+	return q.stream + ":cancelled:" + key
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobQueue_DeliversEachJobOnce(t *testing.T) {
+	ctx := context.Background()
+	client, server := testutil.NewRedis(t)
+	queue := NewJobQueue(client, BacktestJobsStream, BacktestWorkersGroup)
+	require.NoError(t, queue.Init(ctx))
+	require.NoError(t, queue.Init(ctx), "the group may already exist")
+
+	id, err := queue.Push(ctx, map[string]interface{}{"backtest_id": "bt-1"})
+	require.NoError(t, err)
+
+	job, err := queue.Next(ctx, "worker-1", time.Minute, 0)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, id, job.ID)
+	assert.Equal(t, "bt-1", job.Values["backtest_id"])
+
+	none, err := queue.Next(ctx, "worker-2", time.Minute, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Nil(t, none, "the job is running on worker-1")
+	assert.Equal(t, map[string]string{id: "worker-1"}, server.Pending(BacktestJobsStream, BacktestWorkersGroup))
+
+	require.NoError(t, queue.Ack(ctx, job.ID))
+	assert.Empty(t, server.Pending(BacktestJobsStream, BacktestWorkersGroup))
+}
+
+func TestJobQueue_ReclaimsJobsWithoutHeartbeats(t *testing.T) {
+	ctx := context.Background()
+	client, server := testutil.NewRedis(t)
+	queue := NewJobQueue(client, BacktestJobsStream, BacktestWorkersGroup)
+	require.NoError(t, queue.Init(ctx))
+	_, err := queue.Push(ctx, map[string]interface{}{"backtest_id": "bt-1"})
+	require.NoError(t, err)
+
+	idle := 50 * time.Millisecond
+	job, err := queue.Next(ctx, "crashed", idle, 0)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+
+	// Heartbeats keep the job with its consumer
+	time.Sleep(idle)
+	require.NoError(t, queue.Heartbeat(ctx, "crashed", job.ID))
+	none, err := queue.Next(ctx, "worker", idle, 0)
+	require.NoError(t, err)
+	assert.Nil(t, none)
+
+	time.Sleep(idle)
+	reclaimed, err := queue.Next(ctx, "worker", idle, 0)
+	require.NoError(t, err)
+	require.NotNil(t, reclaimed)
+	assert.Equal(t, job.ID, reclaimed.ID)
+	assert.Equal(t, map[string]string{job.ID: "worker"}, server.Pending(BacktestJobsStream, BacktestWorkersGroup))
+}
+
+func TestJobQueue_Cancel(t *testing.T) {
+	ctx := context.Background()
+	client, _ := testutil.NewRedis(t)
+	queue := NewJobQueue(client, BacktestJobsStream, BacktestWorkersGroup)
+
+	cancelled, err := queue.Cancelled(ctx, "bt-1")
+	require.NoError(t, err)
+	assert.False(t, cancelled)
+
+	require.NoError(t, queue.Cancel(ctx, "bt-1"))
+	cancelled, err = queue.Cancelled(ctx, "bt-1")
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+}
@@ -14,12 +14,14 @@ const (
 	CircuitEventsStream  = "circuit_events"
 	StrategyLogsStream   = "strategy_logs"
 	OrderEventsStream    = "order_events"
+	BacktestJobsStream   = "backtest_jobs"
 	
 	// Consumer group names
 	TradeEventsGroup     = "trade_events_group"
 	CircuitEventsGroup   = "circuit_events_group"
 	StrategyLogsGroup    = "strategy_logs_group"
 	OrderEventsGroup     = "order_events_group"
+	BacktestWorkersGroup = "backtest_workers"
 	
 	// DLQ stream names
 	TradeEventsDLQ       = "trade_events_dlq"
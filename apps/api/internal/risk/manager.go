@@ -265,6 +265,22 @@ func (rm *RiskManager) GetPositions() map[string]*Position {
 	return positions
 }
 
+// NetPosition returns the signed quantity held in a symbol: positive when long,
+// negative when short and zero when flat
+func (rm *RiskManager) NetPosition(symbol string) float64 {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	position, exists := rm.positions[symbol]
+	if !exists {
+		return 0
+	}
+	if position.Side == "SHORT" {
+		return -position.Quantity
+	}
+	return position.Quantity
+}
+
 // GetCircuitBreakers returns all active circuit breakers
 func (rm *RiskManager) GetCircuitBreakers() map[string]*CircuitBreaker {
 	rm.mu.RLock()
@@ -0,0 +1,163 @@
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+)
+
+// DefaultTimeframe is the bar period scripts see unless a strategy sets one.
+// Backtests load history at this resolution.
+const DefaultTimeframe = "1m"
+
+// barCloseGrace is how long live executions wait after a period ends for
+// late ticks before closing its bar
+const barCloseGrace = 2 * time.Second
+
+// ParseTimeframe parses a bar period such as "1m", "15m", "1h" or "1d"
+func ParseTimeframe(timeframe string) (time.Duration, error) {
+	if timeframe == "" {
+		timeframe = DefaultTimeframe
+	}
+
+	var period time.Duration
+	if days, ok := strings.CutSuffix(timeframe, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n != 1 {
+			return 0, fmt.Errorf("invalid timeframe %q: only 1d is supported for daily bars", timeframe)
+		}
+		period = 24 * time.Hour
+	} else {
+		var err error
+		period, err = time.ParseDuration(timeframe)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeframe %q: %w", timeframe, err)
+		}
+	}
+
+	if period < time.Minute || (period < 24*time.Hour && (24*time.Hour)%period != 0) {
+		return 0, fmt.Errorf("invalid timeframe %q: must be at least 1m and divide a day", timeframe)
+	}
+	return period, nil
+}
+
+// BarFeed aggregates ticks or finer bars into bars of a strategy's timeframe.
+// Live executions and backtests both feed scripts through it, so a script sees
+// the same completed bars in either mode.
+type BarFeed struct {
+	period  time.Duration
+	pending map[string]*Bar
+	emitted map[string]time.Time // start of the last bar returned per symbol
+}
+
+// NewBarFeed creates a feed emitting bars of the given timeframe
+func NewBarFeed(timeframe string) (*BarFeed, error) {
+	period, err := ParseTimeframe(timeframe)
+	if err != nil {
+		return nil, err
+	}
+	return &BarFeed{period: period, pending: make(map[string]*Bar), emitted: make(map[string]time.Time)}, nil
+}
+
+// AddTick merges a market data update into the pending bar of its symbol. It
+// returns the previous bar once the tick opens the next period.
+func (f *BarFeed) AddTick(data broker.MarketData) (Bar, bool) {
+	return f.AddBar(data.Symbol, Bar{
+		Timestamp: data.Timestamp,
+		Open:      data.Price,
+		High:      data.Price,
+		Low:       data.Price,
+		Close:     data.Price,
+		Volume:    data.Volume,
+	})
+}
+
+// AddBar merges a bar no longer than the feed's timeframe into the pending
+// bar of its symbol. It returns the previous bar once the bar opens the next
+// period. Data for a period that was already returned is dropped.
+func (f *BarFeed) AddBar(symbol string, bar Bar) (Bar, bool) {
+	start := f.periodStart(bar.Timestamp)
+	if emitted, ok := f.emitted[symbol]; ok && !start.After(emitted) {
+		return Bar{}, false
+	}
+
+	pending, exists := f.pending[symbol]
+	if !exists {
+		bar.Timestamp = start
+		f.pending[symbol] = &bar
+		return Bar{}, false
+	}
+	if start.Before(pending.Timestamp) {
+		return Bar{}, false
+	}
+	if start.After(pending.Timestamp) {
+		completed := *pending
+		f.emitted[symbol] = completed.Timestamp
+		bar.Timestamp = start
+		f.pending[symbol] = &bar
+		return completed, true
+	}
+
+	if bar.High > pending.High {
+		pending.High = bar.High
+	}
+	if bar.Low < pending.Low {
+		pending.Low = bar.Low
+	}
+	pending.Close = bar.Close
+	pending.Volume += bar.Volume
+	return Bar{}, false
+}
+
+// Flush returns the pending bars whose period has ended by now, oldest first,
+// so a bar closes on time even when no tick of the next period arrives. Pass
+// the zero time to flush every pending bar, e.g. at the end of a backtest.
+func (f *BarFeed) Flush(now time.Time) []Bar {
+	_, bars := f.flush(now)
+	return bars
+}
+
+// flush is Flush that also returns the symbol of each bar
+func (f *BarFeed) flush(now time.Time) ([]string, []Bar) {
+	var symbols []string
+	for symbol, pending := range f.pending {
+		if now.IsZero() || !now.Before(f.periodEnd(pending.Timestamp)) {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		a, b := f.pending[symbols[i]], f.pending[symbols[j]]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return symbols[i] < symbols[j]
+	})
+
+	bars := make([]Bar, len(symbols))
+	for i, symbol := range symbols {
+		bars[i] = *f.pending[symbol]
+		f.emitted[symbol] = bars[i].Timestamp
+		delete(f.pending, symbol)
+	}
+	return symbols, bars
+}
+
+// periodStart returns the start of the period containing t. Daily bars start
+// at midnight in the market time zone.
+func (f *BarFeed) periodStart(t time.Time) time.Time {
+	if f.period >= 24*time.Hour {
+		return TradingDay(t)
+	}
+	return t.Truncate(f.period)
+}
+
+func (f *BarFeed) periodEnd(start time.Time) time.Time {
+	if f.period >= 24*time.Hour {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(f.period)
+}
@@ -0,0 +1,74 @@
+package strategy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBarFeed_AggregatesTicksIntoTimeframeBars(t *testing.T) {
+	feed, err := NewBarFeed("5m")
+	require.NoError(t, err)
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	tick := func(offset time.Duration, price, volume float64) (Bar, bool) {
+		return feed.AddTick(broker.MarketData{Symbol: "AAPL", Price: price, Volume: volume, Timestamp: start.Add(offset)})
+	}
+
+	for i, price := range []float64{100, 103, 98, 101} {
+		_, completed := tick(time.Duration(i)*time.Minute, price, 10)
+		assert.False(t, completed)
+	}
+	bar, completed := tick(5*time.Minute, 102, 5)
+	require.True(t, completed)
+	assert.Equal(t, Bar{Timestamp: start, Open: 100, High: 103, Low: 98, Close: 101, Volume: 40}, bar)
+
+	// A late tick for the emitted period is dropped
+	_, completed = tick(4*time.Minute, 50, 1)
+	assert.False(t, completed)
+
+	assert.Empty(t, feed.Flush(start.Add(9*time.Minute)))
+	bars := feed.Flush(start.Add(10 * time.Minute))
+	require.Len(t, bars, 1)
+	assert.Equal(t, Bar{Timestamp: start.Add(5 * time.Minute), Open: 102, High: 102, Low: 102, Close: 102, Volume: 5}, bars[0])
+}
+
+func TestBarFeed_BarsAndTicksAggregateAlike(t *testing.T) {
+	ticks, err := NewBarFeed("15m")
+	require.NoError(t, err)
+	bars, err := NewBarFeed("15m")
+	require.NoError(t, err)
+
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	var fromTicks, fromBars []Bar
+	for i := 0; i < 40; i++ {
+		price := 100 + float64(i%7)
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		if bar, ok := ticks.AddTick(broker.MarketData{Symbol: "AAPL", Price: price, Volume: 1, Timestamp: timestamp}); ok {
+			fromTicks = append(fromTicks, bar)
+		}
+		if bar, ok := bars.AddBar("AAPL", Bar{Timestamp: timestamp, Open: price, High: price, Low: price, Close: price, Volume: 1}); ok {
+			fromBars = append(fromBars, bar)
+		}
+	}
+	fromTicks = append(fromTicks, ticks.Flush(time.Time{})...)
+	fromBars = append(fromBars, bars.Flush(time.Time{})...)
+
+	require.Len(t, fromTicks, 3)
+	assert.Equal(t, fromTicks, fromBars)
+	assert.Equal(t, float64(10), fromTicks[2].Volume)
+}
+
+func TestParseTimeframe(t *testing.T) {
+	for timeframe, want := range map[string]time.Duration{"": time.Minute, "5m": 5 * time.Minute, "1h": time.Hour, "1d": 24 * time.Hour} {
+		period, err := ParseTimeframe(timeframe)
+		require.NoError(t, err, timeframe)
+		assert.Equal(t, want, period, timeframe)
+	}
+	for _, timeframe := range []string{"30s", "7m", "2d", "bogus"} {
+		_, err := ParseTimeframe(timeframe)
+		assert.Error(t, err, timeframe)
+	}
+}
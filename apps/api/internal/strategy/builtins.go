@@ -0,0 +1,200 @@
+package strategy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// number unpacks any Starlark int or float argument as a float64
+type number float64
+
+func (n *number) Unpack(v starlark.Value) error {
+	f, ok := asFloat(v)
+	if !ok {
+		return fmt.Errorf("got %s, want number", v.Type())
+	}
+	*n = number(f)
+	return nil
+}
+
+// Globals returns the builtins exposed to every strategy script
+func (bf *BuiltinFunctions) Globals() starlark.StringDict {
+	globals := starlark.StringDict{
+		"order":    starlark.NewBuiltin("order", bf.starlarkOrder),
+		"log":      starlark.NewBuiltin("log", bf.starlarkLog),
+		"price":    starlark.NewBuiltin("price", bf.starlarkPrice),
+		"position": starlark.NewBuiltin("position", bf.starlarkPosition),
+	}
+	for _, extra := range []starlark.StringDict{indicatorGlobals(), eventGlobals(), stateGlobals()} {
+		for name, value := range extra {
+			globals[name] = value
+		}
+	}
+	return globals
+}
+
+// globalNames returns the sorted names of a set of globals
+func globalNames(globals starlark.StringDict) []string {
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// starlarkOrder implements order(symbol, side, order_type, quantity, price=None, stop_price=None,
+// trail_amount=None, trail_percent=None, time_in_force="DAY").
+// It returns an order_result struct; a rejected order does not stop the script.
+func (bf *BuiltinFunctions) starlarkOrder(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		symbol, side, orderType   string
+		quantity                  number
+		price, stopPrice          starlark.Value = starlark.None, starlark.None
+		trailAmount, trailPercent starlark.Value = starlark.None, starlark.None
+		timeInForce                              = string(broker.TimeInForceDay)
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs,
+		"symbol", &symbol, "side", &side, "order_type", &orderType, "quantity", &quantity,
+		"price?", &price, "stop_price?", &stopPrice,
+		"trail_amount?", &trailAmount, "trail_percent?", &trailPercent, "time_in_force?", &timeInForce); err != nil {
+		return nil, err
+	}
+
+	limitPrice, err := optionalPrice(fn, "price", price)
+	if err != nil {
+		return nil, err
+	}
+	triggerPrice, err := optionalPrice(fn, "stop_price", stopPrice)
+	if err != nil {
+		return nil, err
+	}
+	trail, err := optionalPrice(fn, "trail_amount", trailAmount)
+	if err != nil {
+		return nil, err
+	}
+	trailRatio, err := optionalPrice(fn, "trail_percent", trailPercent)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &broker.Order{
+		ClientOrderID: clientOrderID(thread, symbol),
+		Symbol:        symbol,
+		Side:          broker.OrderSide(strings.ToUpper(side)),
+		Type:          broker.OrderType(strings.ToUpper(orderType)),
+		Quantity:      float64(quantity),
+		Price:         limitPrice,
+		StopPrice:     triggerPrice,
+		TrailAmount:   trail,
+		TrailPercent:  trailRatio,
+		TimeInForce:   broker.TimeInForce(strings.ToUpper(timeInForce)),
+		Status:        broker.OrderStatusPending,
+	}
+	result := bf.Order(threadContext(thread), order)
+	if result.Accepted {
+		scriptContextOf(thread).orderIDs[result.ClientOrderID] = true
+	}
+	if bf.observer != nil {
+		bf.observer.observeOrder(order, result)
+	}
+	return newOrderResultValue(result), nil
+}
+
+// optionalPrice unpacks an optional numeric price argument
+func optionalPrice(fn *starlark.Builtin, name string, v starlark.Value) (*float64, error) {
+	if v == starlark.None {
+		return nil, nil
+	}
+	p, ok := asFloat(v)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s for %s, want number", fn.Name(), v.Type(), name)
+	}
+	return &p, nil
+}
+
+// clientOrderID derives a deterministic client order ID from the strategy,
+// the bar being processed and the order's position within that bar, so a
+// replayed bar resubmits the same IDs and the broker can deduplicate them
+func clientOrderID(thread *starlark.Thread, symbol string) string {
+	script := scriptContextOf(thread)
+	script.orders++
+
+	strategyID := thread.Name
+	if execution, ok := thread.Local(threadLocalExecution).(*StrategyExecution); ok {
+		strategyID = execution.StrategyID
+	}
+	key := fmt.Sprintf("%s|%s|%d|%s|%d", strategyID, script.symbol, script.bar.Timestamp.UnixNano(), symbol, script.orders)
+	sum := sha256.Sum256([]byte(key))
+	return "stg-" + hex.EncodeToString(sum[:12])
+}
+
+// threadContext returns the context of the execution running on thread
+func threadContext(thread *starlark.Thread) context.Context {
+	if execution, ok := thread.Local(threadLocalExecution).(*StrategyExecution); ok && execution.Context != nil {
+		return execution.Context
+	}
+	return context.Background()
+}
+
+// newOrderResultValue converts an order result into the struct returned by order()
+func newOrderResultValue(result *OrderResult) starlark.Value {
+	optional := func(s string) starlark.Value {
+		if s == "" {
+			return starlark.None
+		}
+		return starlark.String(s)
+	}
+	return starlarkstruct.FromStringDict(starlark.String("order_result"), starlark.StringDict{
+		"accepted":        starlark.Bool(result.Accepted),
+		"order_id":        optional(result.OrderID),
+		"client_order_id": starlark.String(result.ClientOrderID),
+		"status":          starlark.String(result.Status),
+		"reason":          optional(result.Reason),
+	})
+}
+
+// starlarkLog implements log(message)
+func (bf *BuiltinFunctions) starlarkLog(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var message string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &message); err != nil {
+		return nil, err
+	}
+	bf.Log(message)
+	return starlark.None, nil
+}
+
+// starlarkPrice implements price(symbol). It returns the latest tick cached by
+// the broker, falling back to the close of the latest bar the script has seen
+// for the symbol, and None when neither exists.
+func (bf *BuiltinFunctions) starlarkPrice(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var symbol string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &symbol); err != nil {
+		return nil, err
+	}
+	price, ok := bf.GetPrice(symbol)
+	if !ok {
+		price, ok = scriptContextOf(thread).lastClose(symbol)
+	}
+	if !ok {
+		return starlark.None, nil
+	}
+	return starlark.Float(price), nil
+}
+
+// starlarkPosition implements position(symbol)
+func (bf *BuiltinFunctions) starlarkPosition(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var symbol string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &symbol); err != nil {
+		return nil, err
+	}
+	return starlark.Float(bf.GetPosition(symbol)), nil
+}
@@ -0,0 +1,156 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/config"
+	"github.com/moomoo-trading/api/internal/risk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func newTestBuiltins(t *testing.T, riskConfig *risk.RiskConfig) *BuiltinFunctions {
+	t.Helper()
+	adapter := broker.NewMoomooAdapter(&config.MoomooConfig{})
+	require.NoError(t, adapter.Connect(context.Background()))
+	riskManager := risk.NewRiskManager(riskConfig)
+	adapter.SetPositionBook(riskManager)
+	return &BuiltinFunctions{broker: adapter, riskManager: riskManager}
+}
+
+// adapterOf returns the Moomoo adapter behind builtins created by newTestBuiltins
+func adapterOf(bf *BuiltinFunctions) *broker.MoomooAdapter {
+	return bf.broker.(*broker.MoomooAdapter)
+}
+
+func TestBuiltins_OrderReturnsStructuredResults(t *testing.T) {
+	bf := newTestBuiltins(t, &risk.RiskConfig{
+		MaxPositionSize:        10,
+		MaxDailyLoss:           5,
+		MaxWeeklyLoss:          10,
+		MaxConcurrentPositions: 5,
+	})
+	code := `
+results = []
+
+def on_bar(symbol, bar):
+    results.append(order(symbol, "buy", "limit", 10, price=bar.close))
+    results.append(order(symbol, "buy", "limit", 1000, price=bar.close))
+    results.append(order(symbol, "hold", "market", 1))
+`
+	instance := newTestInstance(t, code, bf.Globals(), DefaultQuota)
+	bar := Bar{Timestamp: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC), Close: 100}
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", bar))
+
+	results := instance.globals["results"].(*starlark.List)
+	require.Equal(t, 3, results.Len())
+	attr := func(i int, name string) starlark.Value {
+		value, err := results.Index(i).(starlark.HasAttrs).Attr(name)
+		require.NoError(t, err)
+		return value
+	}
+
+	assert.Equal(t, starlark.True, attr(0, "accepted"))
+	assert.Equal(t, starlark.String(broker.OrderStatusSubmitted), attr(0, "status"))
+	assert.NotEqual(t, starlark.None, attr(0, "order_id"))
+
+	assert.Equal(t, starlark.False, attr(1, "accepted"), "10% position size limit")
+	assert.Equal(t, starlark.String(broker.OrderStatusRejected), attr(1, "status"))
+	assert.Contains(t, attr(1, "reason").String(), "position size")
+
+	assert.Equal(t, starlark.False, attr(2, "accepted"))
+	assert.Contains(t, attr(2, "reason").String(), "invalid side")
+	assert.NotEqual(t, attr(0, "client_order_id"), attr(1, "client_order_id"))
+}
+
+func TestBuiltins_OrderTakesTrailsAndTimeInForce(t *testing.T) {
+	bf := newTestBuiltins(t, &risk.RiskConfig{
+		MaxPositionSize:        10,
+		MaxDailyLoss:           5,
+		MaxWeeklyLoss:          10,
+		MaxConcurrentPositions: 5,
+	})
+	code := `
+results = []
+
+def on_bar(symbol, bar):
+    results.append(order(symbol, "sell", "trailing", 1, trail_percent=1.5, time_in_force="gtc"))
+    results.append(order(symbol, "sell", "trailing", 1))
+    results.append(order(symbol, "sell", "trailing", 1, trail_amount=1, trail_percent=1))
+    results.append(order(symbol, "buy", "limit", 1, price=bar.close, time_in_force="ioc"))
+`
+	instance := newTestInstance(t, code, bf.Globals(), DefaultQuota)
+	bar := Bar{Timestamp: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC), Close: 100}
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", bar))
+
+	results := instance.globals["results"].(*starlark.List)
+	require.Equal(t, 4, results.Len())
+	attr := func(i int, name string) starlark.Value {
+		value, err := results.Index(i).(starlark.HasAttrs).Attr(name)
+		require.NoError(t, err)
+		return value
+	}
+
+	assert.Equal(t, starlark.True, attr(0, "accepted"))
+	orders, err := adapterOf(bf).GetOrders(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, broker.TimeInForceGTC, orders[0].TimeInForce)
+	assert.Equal(t, 1.5, *orders[0].TrailPercent)
+
+	assert.Contains(t, attr(1, "reason").String(), "trail amount or a trail percent")
+	assert.Contains(t, attr(2, "reason").String(), "trail amount or a trail percent")
+	assert.Contains(t, attr(3, "reason").String(), "invalid time in force")
+}
+
+func TestBuiltins_ClientOrderIDIsDeterministic(t *testing.T) {
+	bar := Bar{Timestamp: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC), Close: 100}
+	ids := func() []string {
+		thread := &starlark.Thread{Name: "s1"}
+		scriptContextOf(thread).push("AAPL", bar)
+		return []string{clientOrderID(thread, "AAPL"), clientOrderID(thread, "AAPL")}
+	}
+
+	first, replay := ids(), ids()
+	assert.Equal(t, first, replay)
+	assert.NotEqual(t, first[0], first[1])
+}
+
+func TestBuiltins_PriceAndPositionReadLiveState(t *testing.T) {
+	bf := newTestBuiltins(t, &risk.RiskConfig{})
+
+	_, ok := bf.GetPrice("AAPL")
+	assert.False(t, ok)
+	adapterOf(bf).PublishMarketData(broker.MarketData{Symbol: "AAPL", Price: 187.5, Timestamp: time.Now()})
+	price, ok := bf.GetPrice("AAPL")
+	require.True(t, ok)
+	assert.Equal(t, 187.5, price)
+
+	bf.riskManager.UpdatePosition("AAPL", 20, 187.5, "LONG")
+	bf.riskManager.UpdatePosition("TSLA", 5, 250, "SHORT")
+	assert.Equal(t, 20.0, bf.GetPosition("AAPL"))
+	assert.Equal(t, -5.0, bf.GetPosition("TSLA"))
+	assert.Equal(t, 0.0, bf.GetPosition("MSFT"))
+}
+
+func TestBuiltins_PriceFallsBackToLatestBar(t *testing.T) {
+	bf := newTestBuiltins(t, &risk.RiskConfig{})
+	code := `
+prices = []
+
+def on_bar(symbol, bar):
+    prices.append((price(symbol), price("MSFT")))
+`
+	instance := newTestInstance(t, code, bf.Globals(), DefaultQuota)
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 101}))
+	adapterOf(bf).PublishMarketData(broker.MarketData{Symbol: "AAPL", Price: 101.5, Timestamp: time.Now()})
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 102}))
+
+	prices := instance.globals["prices"].(*starlark.List)
+	assert.Equal(t, starlark.Tuple{starlark.Float(101), starlark.None}, prices.Index(0))
+	assert.Equal(t, starlark.Tuple{starlark.Float(101.5), starlark.None}, prices.Index(1))
+}
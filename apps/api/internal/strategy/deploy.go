@@ -0,0 +1,425 @@
+package strategy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+)
+
+// Errors returned by Deployer
+var (
+	ErrVersionNotFound   = errors.New("strategy version not found")
+	ErrNotDeployed       = errors.New("strategy is not deployed")
+	ErrInvalidDeployment = errors.New("invalid deployment")
+)
+
+// Deployment is the version, parameters and symbols a strategy is deployed
+// with, and whether it runs per symbol or as a portfolio
+type Deployment struct {
+	VersionID   string                 `json:"version_id"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Symbols     []string               `json:"symbols"`
+	Timeframe   string                 `json:"timeframe"`
+	Mode        string                 `json:"mode"`
+	MissingBars MissingBarPolicy       `json:"missing_bars"`
+}
+
+// ExecutionInfo is the status of a strategy on one symbol. Running executions
+// report their live status, others the status last persisted.
+type ExecutionInfo struct {
+	Symbol    string          `json:"symbol"`
+	VersionID string          `json:"version_id"`
+	Status    ExecutionStatus `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	Backtrace string          `json:"backtrace,omitempty"`
+	QuotaHits int64           `json:"quota_hits"`
+	StartedAt *time.Time      `json:"started_at"`
+	StoppedAt *time.Time      `json:"stopped_at,omitempty"`
+}
+
+// Deployer deploys stored strategy versions to the engine and starts and
+// stops their executions. Deployments and the status of executions are kept
+// in MySQL, so Restore resumes the running executions after a restart.
+type Deployer struct {
+	engine      *StrategyEngine
+	strategies  *database.StrategyRepository
+	deployments *database.StrategyDeploymentRepository
+}
+
+// NewDeployer creates a deployer and makes the engine persist the status of
+// its executions in deployments
+func NewDeployer(engine *StrategyEngine, strategies *database.StrategyRepository, deployments *database.StrategyDeploymentRepository) *Deployer {
+	engine.SetExecutionStore(deployments)
+	return &Deployer{engine: engine, strategies: strategies, deployments: deployments}
+}
+
+// Deploy loads a version of a strategy into the engine. Running executions
+// are upgraded to it and executions of symbols no longer deployed stopped.
+func (d *Deployer) Deploy(ctx context.Context, strategyID string, deployment *Deployment) (*Strategy, error) {
+	if len(deployment.Symbols) == 0 {
+		return nil, fmt.Errorf("%w: at least one symbol is required", ErrInvalidDeployment)
+	}
+	if _, err := ParseTimeframe(deployment.Timeframe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeployment, err)
+	}
+	if err := validateMode(&Strategy{Mode: deployment.Mode, Symbols: deployment.Symbols, MissingBars: deployment.MissingBars}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeployment, err)
+	}
+
+	strategy, err := d.strategyOf(ctx, strategyID, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	running := d.engine.GetStrategyExecutions(strategyID)
+	if len(running) > 0 {
+		if current := d.engine.loadedStrategy(strategyID); current != nil {
+			if err := upgradeConflict(current, strategy); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidDeployment, err)
+			}
+		}
+		err = d.engine.UpgradeStrategy(ctx, strategy, 0)
+	} else {
+		err = d.engine.LoadStrategy(strategy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.engine.saveDeployment(ctx, strategy); err != nil {
+		return nil, err
+	}
+	d.engine.publishStrategyLog(ctx, map[string]interface{}{
+		"type":        "strategy_deployed",
+		"strategy_id": strategyID,
+		"version_id":  strategy.VersionID,
+		"timestamp":   time.Now().Unix(),
+	})
+
+	deployed := make(map[string]bool, len(strategy.Symbols))
+	for _, symbol := range strategy.ExecutionSymbols() {
+		deployed[symbol] = true
+	}
+	for _, execution := range running {
+		if !deployed[execution.Symbol] {
+			if err := d.engine.StopStrategy(strategyID, execution.Symbol); err != nil {
+				log.Printf("Failed to stop strategy %s on %s: %v", strategyID, execution.Symbol, err)
+			}
+		}
+	}
+	return strategy, nil
+}
+
+// Start starts executions of a deployed strategy on the given symbols, or on
+// every deployed symbol. Symbols already running are left alone. Portfolio
+// strategies always start on all of their symbols.
+func (d *Deployer) Start(ctx context.Context, strategyID string, symbols []string) error {
+	strategy := d.engine.loadedStrategy(strategyID)
+	if strategy == nil {
+		var err error
+		if strategy, err = d.loadDeployment(ctx, strategyID); err != nil {
+			return err
+		}
+	}
+	if strategy.IsPortfolio() && len(symbols) > 0 {
+		return fmt.Errorf("%w: portfolio strategies start on all of their symbols", ErrInvalidDeployment)
+	}
+
+	deployed := make(map[string]bool, len(strategy.Symbols))
+	for _, symbol := range strategy.ExecutionSymbols() {
+		deployed[symbol] = true
+	}
+	if len(symbols) == 0 {
+		symbols = strategy.ExecutionSymbols()
+	}
+	for _, symbol := range symbols {
+		if !deployed[symbol] {
+			return fmt.Errorf("%w: %s is not a deployed symbol", ErrInvalidDeployment, symbol)
+		}
+	}
+
+	for _, symbol := range symbols {
+		if status, err := d.engine.GetStrategyStatus(strategyID, symbol); err == nil && status.Status == ExecutionStatusRunning {
+			continue
+		}
+		// Executions outlive the request that started them
+		if err := d.engine.StartStrategy(context.Background(), strategyID, symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops the executions of a strategy on the given symbols, or on every
+// symbol. Symbols that are not running are left alone.
+func (d *Deployer) Stop(ctx context.Context, strategyID string, symbols []string) error {
+	if len(symbols) == 0 {
+		for _, execution := range d.engine.GetStrategyExecutions(strategyID) {
+			symbols = append(symbols, execution.Symbol)
+		}
+	}
+	for _, symbol := range symbols {
+		if _, err := d.engine.GetStrategyStatus(strategyID, symbol); err != nil {
+			continue
+		}
+		if err := d.engine.StopStrategy(strategyID, symbol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Executions returns the status of a strategy on every symbol it ran on
+func (d *Deployer) Executions(ctx context.Context, strategyID string) ([]*ExecutionInfo, error) {
+	records, err := d.deployments.ListExecutionsByStrategyID(ctx, strategyID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []*ExecutionInfo{}
+	index := make(map[string]*ExecutionInfo)
+	for _, record := range records {
+		info := &ExecutionInfo{
+			Symbol:    record.Symbol,
+			VersionID: record.VersionID,
+			Status:    ExecutionStatus(record.Status),
+			StartedAt: record.StartedAt,
+			StoppedAt: record.StoppedAt,
+		}
+		if record.Error != nil {
+			info.Error = *record.Error
+		}
+		// Executions persisted as running that the engine does not run were
+		// interrupted, e.g. by a restart that could not restore them
+		if info.Status == ExecutionStatusRunning {
+			info.Status = ExecutionStatusStopped
+		}
+		infos = append(infos, info)
+		index[record.Symbol] = info
+	}
+
+	for _, execution := range d.engine.GetStrategyExecutions(strategyID) {
+		info, exists := index[execution.Symbol]
+		if !exists {
+			info = &ExecutionInfo{Symbol: execution.Symbol}
+			infos = append(infos, info)
+		}
+		startedAt := execution.StartedAt
+		info.VersionID = execution.VersionID
+		info.Status = execution.Status
+		info.Error = execution.Error
+		info.Backtrace = execution.Backtrace
+		info.QuotaHits = execution.QuotaHits
+		info.StartedAt = &startedAt
+		info.StoppedAt = nil
+	}
+	return infos, nil
+}
+
+// Restore restarts the executions that were running when the process stopped
+func (d *Deployer) Restore(ctx context.Context) error {
+	records, err := d.deployments.ListExecutionsByStatus(ctx, string(ExecutionStatusRunning))
+	if err != nil {
+		return fmt.Errorf("failed to list running executions: %w", err)
+	}
+
+	for _, record := range records {
+		if d.engine.loadedStrategy(record.StrategyID) == nil {
+			if _, err := d.loadDeployment(ctx, record.StrategyID); err != nil {
+				log.Printf("Failed to restore strategy %s: %v", record.StrategyID, err)
+				continue
+			}
+		}
+		if err := d.engine.StartStrategy(context.Background(), record.StrategyID, record.Symbol); err != nil {
+			log.Printf("Failed to restore strategy %s on %s: %v", record.StrategyID, record.Symbol, err)
+			continue
+		}
+		log.Printf("Restored strategy %s on %s", record.StrategyID, record.Symbol)
+	}
+	return nil
+}
+
+// loadDeployment loads the persisted deployment of a strategy into the engine
+func (d *Deployer) loadDeployment(ctx context.Context, strategyID string) (*Strategy, error) {
+	record, err := d.deployments.GetDeployment(ctx, strategyID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotDeployed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deployment := &Deployment{
+		VersionID:   record.VersionID,
+		Timeframe:   record.Timeframe,
+		Mode:        record.Mode,
+		MissingBars: MissingBarPolicy(record.MissingBars),
+	}
+	if err := json.Unmarshal(record.Parameters, &deployment.Parameters); err != nil {
+		return nil, fmt.Errorf("invalid parameters of deployment %s: %w", strategyID, err)
+	}
+	if err := json.Unmarshal(record.Symbols, &deployment.Symbols); err != nil {
+		return nil, fmt.Errorf("invalid symbols of deployment %s: %w", strategyID, err)
+	}
+
+	strategy, err := d.strategyOf(ctx, strategyID, deployment)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.engine.LoadStrategy(strategy); err != nil {
+		return nil, err
+	}
+	return strategy, nil
+}
+
+// strategyOf builds the engine strategy of a deployment. Parameters are
+// validated against the version's definitions and converted to their types;
+// those the deployment does not set take the version's defaults.
+func (d *Deployer) strategyOf(ctx context.Context, strategyID string, deployment *Deployment) (*Strategy, error) {
+	pkg, err := d.strategies.GetPackageByID(ctx, strategyID)
+	if err == sql.ErrNoRows {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	version, err := d.strategies.GetVersionByID(ctx, deployment.VersionID)
+	if err == sql.ErrNoRows || (err == nil && version.PackageID != strategyID) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	params, err := d.strategies.GetParamsByVersionID(ctx, version.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := CoerceParams(params, deployment.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDeployment, err)
+	}
+	missingBars, err := ParseMissingBarPolicy(string(deployment.MissingBars))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDeployment, err)
+	}
+	return &Strategy{
+		ID:          strategyID,
+		Name:        pkg.Name,
+		VersionID:   version.ID,
+		Code:        version.Code,
+		Parameters:  values,
+		Symbols:     deployment.Symbols,
+		Timeframe:   deployment.Timeframe,
+		Mode:        modeName(deployment.Mode),
+		MissingBars: missingBars,
+		IsActive:    true,
+		CreatedAt:   version.CreatedAt,
+		UpdatedAt:   version.UpdatedAt,
+	}, nil
+}
+
+// loadedStrategy returns the strategy loaded under an ID, if any
+func (se *StrategyEngine) loadedStrategy(strategyID string) *Strategy {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.strategies[strategyID]
+}
+
+// saveDeployment persists the version, parameters and symbols of a strategy
+func (se *StrategyEngine) saveDeployment(ctx context.Context, strategy *Strategy) error {
+	se.mu.RLock()
+	store := se.executionStore
+	se.mu.RUnlock()
+	if store == nil {
+		return nil
+	}
+
+	parameters, err := json.Marshal(strategy.Parameters)
+	if err != nil {
+		return err
+	}
+	symbols, err := json.Marshal(strategy.Symbols)
+	if err != nil {
+		return err
+	}
+	timeframe := strategy.Timeframe
+	if timeframe == "" {
+		timeframe = DefaultTimeframe
+	}
+	missingBars, err := ParseMissingBarPolicy(string(strategy.MissingBars))
+	if err != nil {
+		return err
+	}
+	err = store.SaveDeployment(ctx, &database.StrategyDeployment{
+		StrategyID:  strategy.ID,
+		VersionID:   strategy.VersionID,
+		Parameters:  parameters,
+		Symbols:     symbols,
+		Timeframe:   timeframe,
+		Mode:        modeName(strategy.Mode),
+		MissingBars: string(missingBars),
+	})
+	if err != nil {
+		log.Printf("Failed to save deployment of strategy %s: %v", strategy.ID, err)
+	}
+	return err
+}
+
+// recordTransition persists the status of an execution and publishes it on
+// the strategy logs stream
+func (se *StrategyEngine) recordTransition(execution *StrategyExecution) {
+	se.mu.RLock()
+	store := se.executionStore
+	se.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if store != nil {
+		startedAt := execution.StartedAt
+		record := &database.StrategyExecution{
+			StrategyID: execution.StrategyID,
+			Symbol:     execution.Symbol,
+			VersionID:  execution.VersionID,
+			Status:     string(execution.Status),
+			StartedAt:  &startedAt,
+		}
+		if execution.Error != "" {
+			record.Error = &execution.Error
+		}
+		if execution.Status != ExecutionStatusRunning {
+			stoppedAt := time.Now()
+			record.StoppedAt = &stoppedAt
+		}
+		if err := store.SaveExecution(ctx, record); err != nil {
+			log.Printf("Failed to save execution of strategy %s on %s: %v", execution.StrategyID, execution.Symbol, err)
+		}
+	}
+
+	se.publishStrategyLog(ctx, map[string]interface{}{
+		"type":        "strategy_execution",
+		"strategy_id": execution.StrategyID,
+		"symbol":      execution.Symbol,
+		"version_id":  execution.VersionID,
+		"status":      string(execution.Status),
+		"error":       execution.Error,
+		"timestamp":   time.Now().Unix(),
+	})
+}
+
+// publishStrategyLog publishes a lifecycle event on the strategy logs stream
+func (se *StrategyEngine) publishStrategyLog(ctx context.Context, event map[string]interface{}) {
+	if se.streamManager == nil {
+		return
+	}
+	if err := se.streamManager.PublishStrategyLog(ctx, event); err != nil {
+		log.Printf("Failed to publish strategy event: %v", err)
+	}
+}
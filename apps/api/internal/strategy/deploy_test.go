@@ -0,0 +1,107 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/config"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	deployTestPackageColumns    = []string{"id", "name", "description", "author", "is_public", "created_at", "updated_at"}
+	deployTestVersionColumns    = []string{"id", "package_id", "version", "code", "description", "is_active", "created_at", "updated_at"}
+	deployTestParamColumns      = []string{"id", "version_id", "param_name", "param_type", "default_value", "min_value", "max_value", "step", "enum_values", "description", "is_required", "created_at", "updated_at"}
+	deployTestDeploymentColumns = []string{"id", "strategy_id", "version_id", "parameters", "symbols", "timeframe", "mode", "missing_bars", "created_at", "updated_at"}
+	deployTestExecutionColumns  = []string{"id", "strategy_id", "symbol", "version_id", "status", "error", "started_at", "stopped_at", "created_at", "updated_at"}
+)
+
+func newTestDeployer(t *testing.T) (*Deployer, *StrategyEngine, *testutil.SQLMock) {
+	t.Helper()
+	adapter := broker.NewMoomooAdapter(&config.MoomooConfig{})
+	require.NoError(t, adapter.Connect(context.Background()))
+	engine := NewStrategyEngine(adapter, nil, nil)
+	db, mock := testutil.NewSQLMock(t)
+	deployer := NewDeployer(engine, database.NewStrategyRepository(db), database.NewStrategyDeploymentRepository(db))
+	return deployer, engine, mock
+}
+
+// expectDeployedVersion expects the lookups building the strategy of version v1 of s1
+func expectDeployedVersion(mock *testutil.SQLMock, now time.Time) {
+	mock.ExpectQuery(`FROM strategy_packages WHERE id = \?`).WithArgs("s1").
+		WillReturnRows(deployTestPackageColumns, []interface{}{"s1", "Counter", nil, "alice", false, now, now})
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).WithArgs("v1").
+		WillReturnRows(deployTestVersionColumns, []interface{}{"v1", "s1", "1.0.0", countingStrategyCode, nil, true, now, now})
+	mock.ExpectQuery(`FROM strategy_params WHERE version_id = \?`).WithArgs("v1").
+		WillReturnRows(deployTestParamColumns, []interface{}{"p1", "v1", "size", "float", "10", nil, nil, nil, nil, nil, false, now, now})
+}
+
+func TestDeployer_DeployStartAndStopPersistExecutions(t *testing.T) {
+	deployer, engine, mock := newTestDeployer(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	expectDeployedVersion(mock, now)
+	mock.ExpectExec(`INSERT INTO strategy_deployments`).
+		WithArgs(testutil.AnyArg, "s1", "v1", []byte(`{"size":5}`), []byte(`["AAPL"]`), "1m", "symbol", "omit", testutil.AnyArg, testutil.AnyArg)
+	deployed, err := deployer.Deploy(ctx, "s1", &Deployment{VersionID: "v1", Parameters: map[string]interface{}{"size": 5}, Symbols: []string{"AAPL"}, Timeframe: "1m"})
+	require.NoError(t, err)
+	assert.Equal(t, "v1", deployed.VersionID)
+
+	mock.ExpectExec(`INSERT INTO strategy_executions`).
+		WithArgs(testutil.AnyArg, "s1", "AAPL", "v1", "RUNNING", nil, testutil.AnyArg, nil, testutil.AnyArg, testutil.AnyArg)
+	require.NoError(t, deployer.Start(ctx, "s1", nil))
+	assert.ErrorIs(t, deployer.Start(ctx, "s1", []string{"MSFT"}), ErrInvalidDeployment)
+
+	mock.ExpectQuery(`FROM strategy_executions WHERE strategy_id = \?`).WithArgs("s1").
+		WillReturnRows(deployTestExecutionColumns, []interface{}{"e1", "s1", "AAPL", "v1", "RUNNING", nil, now, nil, now, now})
+	executions, err := deployer.Executions(ctx, "s1")
+	require.NoError(t, err)
+	require.Len(t, executions, 1)
+	assert.Equal(t, ExecutionStatusRunning, executions[0].Status)
+
+	mock.ExpectExec(`INSERT INTO strategy_executions`).
+		WithArgs(testutil.AnyArg, "s1", "AAPL", "v1", "STOPPED", nil, testutil.AnyArg, testutil.AnyArg, testutil.AnyArg, testutil.AnyArg)
+	require.NoError(t, deployer.Stop(ctx, "s1", nil))
+	assert.Empty(t, engine.GetStrategyExecutions("s1"))
+}
+
+func TestDeployer_RestoreStartsPersistedRunningExecutions(t *testing.T) {
+	deployer, engine, mock := newTestDeployer(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM strategy_executions WHERE status = \?`).WithArgs("RUNNING").
+		WillReturnRows(deployTestExecutionColumns, []interface{}{"e1", "s1", "AAPL", "v1", "RUNNING", nil, now, nil, now, now})
+	mock.ExpectQuery(`FROM strategy_deployments WHERE strategy_id = \?`).WithArgs("s1").
+		WillReturnRows(deployTestDeploymentColumns, []interface{}{"d1", "s1", "v1", []byte(`{"size":5}`), []byte(`["AAPL"]`), "1m", "symbol", "omit", now, now})
+	expectDeployedVersion(mock, now)
+	mock.ExpectExec(`INSERT INTO strategy_executions`).
+		WithArgs(testutil.AnyArg, "s1", "AAPL", "v1", "RUNNING", nil, testutil.AnyArg, nil, testutil.AnyArg, testutil.AnyArg)
+
+	require.NoError(t, deployer.Restore(context.Background()))
+	defer engine.StopStrategy("s1", "AAPL")
+
+	status, err := engine.GetStrategyStatus("s1", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, ExecutionStatusRunning, status.Status)
+	assert.Equal(t, 5.0, engine.loadedStrategy("s1").Parameters["size"])
+}
+
+func TestDeployer_DeployRejectsVersionOfAnotherStrategy(t *testing.T) {
+	deployer, _, mock := newTestDeployer(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_packages WHERE id = \?`).WithArgs("s2").
+		WillReturnRows(deployTestPackageColumns, []interface{}{"s2", "Other", nil, "alice", false, now, now})
+	mock.ExpectQuery(`FROM strategy_versions WHERE id = \?`).WithArgs("v1").
+		WillReturnRows(deployTestVersionColumns, []interface{}{"v1", "s1", "1.0.0", countingStrategyCode, nil, true, now, now})
+
+	_, err := deployer.Deploy(context.Background(), "s2", &Deployment{VersionID: "v1", Symbols: []string{"AAPL"}, Timeframe: "1m"})
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+	_, err = deployer.Deploy(context.Background(), "s2", &Deployment{VersionID: "v1", Timeframe: "1m"})
+	assert.ErrorIs(t, err, ErrInvalidDeployment)
+}
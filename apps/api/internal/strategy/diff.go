@@ -0,0 +1,252 @@
+package strategy
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moomoo-trading/api/internal/database"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// Parameter changes between versions
+const (
+	ParamAdded   = "added"
+	ParamRemoved = "removed"
+	ParamChanged = "changed"
+)
+
+// ParamChange is a parameter definition added, removed or changed between two versions
+type ParamChange struct {
+	Name   string                  `json:"name"`
+	Change string                  `json:"change"`
+	From   *database.StrategyParam `json:"from,omitempty"`
+	To     *database.StrategyParam `json:"to,omitempty"`
+}
+
+// VersionDiff is the difference between two versions of a strategy
+type VersionDiff struct {
+	FromVersionID string        `json:"from_version_id"`
+	ToVersionID   string        `json:"to_version_id"`
+	Code          string        `json:"code"`
+	Params        string        `json:"params"`
+	ParamChanges  []ParamChange `json:"param_changes"`
+}
+
+// DiffVersions compares the code and parameter definitions of two versions.
+// Code and parameters are given as unified diffs, parameters also as a list
+// of changes.
+func DiffVersions(from, to *database.StrategyVersion, fromParams, toParams []*database.StrategyParam) *VersionDiff {
+	fromName := "version " + from.Version
+	toName := "version " + to.Version
+	return &VersionDiff{
+		FromVersionID: from.ID,
+		ToVersionID:   to.ID,
+		Code:          UnifiedDiff(fromName, toName, from.Code, to.Code),
+		Params:        UnifiedDiff(fromName+" params", toName+" params", paramsText(fromParams), paramsText(toParams)),
+		ParamChanges:  DiffParams(fromParams, toParams),
+	}
+}
+
+// DiffParams lists the parameter definitions added, removed or changed from
+// one version to another, ordered by name
+func DiffParams(from, to []*database.StrategyParam) []ParamChange {
+	before := make(map[string]*database.StrategyParam, len(from))
+	for _, param := range from {
+		before[param.ParamName] = param
+	}
+	after := make(map[string]*database.StrategyParam, len(to))
+	for _, param := range to {
+		after[param.ParamName] = param
+	}
+
+	changes := []ParamChange{}
+	for _, name := range sortedParamNames(from, to) {
+		was, is := before[name], after[name]
+		switch {
+		case was == nil:
+			changes = append(changes, ParamChange{Name: name, Change: ParamAdded, To: is})
+		case is == nil:
+			changes = append(changes, ParamChange{Name: name, Change: ParamRemoved, From: was})
+		case paramLine(was) != paramLine(is):
+			changes = append(changes, ParamChange{Name: name, Change: ParamChanged, From: was, To: is})
+		}
+	}
+	return changes
+}
+
+// sortedParamNames returns the names of the parameters of both lists, sorted
+func sortedParamNames(from, to []*database.StrategyParam) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, params := range [][]*database.StrategyParam{from, to} {
+		for _, param := range params {
+			if !seen[param.ParamName] {
+				seen[param.ParamName] = true
+				names = append(names, param.ParamName)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paramsText renders parameter definitions one per line, ordered by name
+func paramsText(params []*database.StrategyParam) string {
+	byName := make(map[string]*database.StrategyParam, len(params))
+	for _, param := range params {
+		byName[param.ParamName] = param
+	}
+
+	var text strings.Builder
+	for _, name := range sortedParamNames(params, nil) {
+		text.WriteString(paramLine(byName[name]))
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+// paramLine renders a parameter definition on one line
+func paramLine(param *database.StrategyParam) string {
+	line := param.ParamName + ": " + param.ParamType
+	if param.DefaultValue != nil {
+		line += " default=" + strconv.Quote(*param.DefaultValue)
+	}
+	for _, bound := range []struct {
+		name  string
+		value *float64
+	}{{"min", param.MinValue}, {"max", param.MaxValue}, {"step", param.Step}} {
+		if bound.value != nil {
+			line += fmt.Sprintf(" %s=%g", bound.name, *bound.value)
+		}
+	}
+	if len(param.EnumValues) > 0 {
+		line += " enum=" + string(param.EnumValues)
+	}
+	if param.IsRequired {
+		line += " required"
+	}
+	if param.Description != nil {
+		line += " description=" + strconv.Quote(*param.Description)
+	}
+	return line
+}
+
+// diffOp is a line kept (' '), deleted ('-') or inserted ('+'), with the
+// indices in the old and new text it applies at
+type diffOp struct {
+	kind   byte
+	line   string
+	aIndex int
+	bIndex int
+}
+
+// UnifiedDiff returns the unified diff of two texts, or an empty string if
+// they have the same lines
+func UnifiedDiff(fromName, toName, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var changed []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(changed); {
+		lo := max(changed[i]-diffContext, 0)
+		j := i
+		for j+1 < len(changed) && changed[j+1]-changed[j] <= 2*diffContext {
+			j++
+		}
+		hi := min(changed[j]+diffContext+1, len(ops))
+		writeHunk(&out, ops[lo:hi])
+		i = j + 1
+	}
+	return out.String()
+}
+
+// writeHunk writes the header and lines of a hunk
+func writeHunk(out *bytes.Buffer, ops []diffOp) {
+	var aCount, bCount int
+	for _, op := range ops {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(ops[0].aIndex, aCount), hunkRange(ops[0].bIndex, bCount))
+	for _, op := range ops {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		out.WriteByte('\n')
+	}
+}
+
+// hunkRange formats the 1-based start and length of a hunk's side; an empty
+// side starts at the line before it
+func hunkRange(index, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", index)
+	case 1:
+		return strconv.Itoa(index + 1)
+	}
+	return fmt.Sprintf("%d,%d", index+1, count)
+}
+
+// splitLines splits text into lines, ignoring a final newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes the edit script between two lists of lines from their
+// longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
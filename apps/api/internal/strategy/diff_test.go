@@ -0,0 +1,64 @@
+package strategy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	to := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+
+	assert.Equal(t, `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,3 +9,4 @@
+ i
+ j
+ k
++l
+`, UnifiedDiff("old", "new", from, to))
+}
+
+func TestUnifiedDiff_MergesCloseChangesAndHandlesEmptySides(t *testing.T) {
+	assert.Equal(t, "--- old\n+++ new\n@@ -1,3 +1,3 @@\n-x\n+y\n a\n-b\n+c\n", UnifiedDiff("old", "new", "x\na\nb\n", "y\na\nc\n"))
+	assert.Equal(t, "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n", UnifiedDiff("old", "new", "", "a\nb"))
+	assert.Empty(t, UnifiedDiff("old", "new", "a\n", "a"))
+}
+
+func TestDiffParams(t *testing.T) {
+	from := []*database.StrategyParam{
+		{ParamName: "period", ParamType: ParamTypeInt, DefaultValue: stringPtr("14")},
+		{ParamName: "size", ParamType: ParamTypeInt, DefaultValue: stringPtr("1")},
+	}
+	to := []*database.StrategyParam{
+		{ParamName: "period", ParamType: ParamTypeInt, DefaultValue: stringPtr("20"), MinValue: floatPtr(2)},
+		{ParamName: "side", ParamType: ParamTypeEnum, EnumValues: json.RawMessage(`["long","short"]`)},
+	}
+
+	changes := DiffParams(from, to)
+	require.Len(t, changes, 3)
+	assert.Equal(t, []string{"period", "side", "size"}, []string{changes[0].Name, changes[1].Name, changes[2].Name})
+	assert.Equal(t, []string{ParamChanged, ParamAdded, ParamRemoved}, []string{changes[0].Change, changes[1].Change, changes[2].Change})
+
+	diff := DiffVersions(&database.StrategyVersion{ID: "v1", Version: "1.0.0", Code: "pass\n"}, &database.StrategyVersion{ID: "v2", Version: "1.1.0", Code: "pass\n"}, from, to)
+	assert.Empty(t, diff.Code)
+	assert.Equal(t, `--- version 1.0.0 params
++++ version 1.1.0 params
+@@ -1,2 +1,2 @@
+-period: int default="14"
+-size: int default="1"
++period: int default="20" min=2
++side: enum enum=["long","short"]
+`, diff.Params)
+}
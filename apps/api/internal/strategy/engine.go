@@ -2,22 +2,31 @@ package strategy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/database"
 	"github.com/moomoo-trading/api/internal/redis"
+	"github.com/moomoo-trading/api/internal/risk"
+	"go.starlark.net/starlark"
 )
 
 // Strategy represents a trading strategy
 type Strategy struct {
 	ID          string                 `json:"id"`
 	Name        string                 `json:"name"`
+	VersionID   string                 `json:"version_id,omitempty"`
 	Code        string                 `json:"code"`
 	Parameters  map[string]interface{} `json:"parameters"`
 	Symbols     []string               `json:"symbols"`
+	Timeframe   string                 `json:"timeframe,omitempty"`
+	Mode        string                 `json:"mode,omitempty"`
+	MissingBars MissingBarPolicy       `json:"missing_bars,omitempty"`
 	IsActive    bool                   `json:"is_active"`
 	CreatedAt   time.Time              `json:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at"`
@@ -26,9 +35,18 @@ type Strategy struct {
 // StrategyEngine represents the strategy execution engine
 type StrategyEngine struct {
 	broker       *broker.MoomooAdapter
+	riskManager  *risk.RiskManager
 	streamManager *redis.StreamManager
+	builtins     *BuiltinFunctions
+	quota        Quota
+	historyDepth int
+	stateStore   *StateStore
+	auditLogs    *database.AuditLogRepository
+	executionStore *database.StrategyDeploymentRepository
 	strategies   map[string]*Strategy
+	programs     map[string]*Program
 	executions   map[string]*StrategyExecution
+	quotaHits    map[string]int64
 	mu           sync.RWMutex
 }
 
@@ -38,8 +56,17 @@ type StrategyExecution struct {
 	Symbol     string
 	Context    context.Context
 	Cancel     context.CancelFunc
+	VersionID  string
 	Status     ExecutionStatus
 	StartedAt  time.Time
+	HistoryDepth int
+	Error      string
+	Backtrace  string
+	QuotaHits  int64
+	violations int
+	program    *Program      // code the execution currently runs
+	pending    *strategySwap // code to switch to at the next bar
+	mu         sync.Mutex
 }
 
 // ExecutionStatus represents the status of a strategy execution
@@ -52,42 +79,130 @@ const (
 )
 
 // NewStrategyEngine creates a new strategy engine
-func NewStrategyEngine(broker *broker.MoomooAdapter, streamManager *redis.StreamManager) *StrategyEngine {
+func NewStrategyEngine(broker *broker.MoomooAdapter, riskManager *risk.RiskManager, streamManager *redis.StreamManager) *StrategyEngine {
 	return &StrategyEngine{
 		broker:        broker,
+		riskManager:   riskManager,
 		streamManager: streamManager,
+		builtins:      newBuiltinFunctions(broker, riskManager, streamManager),
+		quota:         DefaultQuota,
+		historyDepth:  DefaultHistoryDepth,
 		strategies:    make(map[string]*Strategy),
+		programs:      make(map[string]*Program),
 		executions:    make(map[string]*StrategyExecution),
+		quotaHits:     make(map[string]int64),
 	}
 }
 
+// SetQuota sets the sandbox quota applied to executions started afterwards
+func (se *StrategyEngine) SetQuota(quota Quota) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.quota = quota
+}
+
+// SetHistoryDepth sets how many bars of each series executions started afterwards keep
+func (se *StrategyEngine) SetHistoryDepth(depth int) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.historyDepth = depth
+}
+
+// SetStateStore sets the store persisting script state across restarts. Without
+// one, state lives only as long as the execution.
+func (se *StrategyEngine) SetStateStore(store *StateStore) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.stateStore = store
+}
+
+// SetAuditLog sets the repository recording upgrades and rollbacks. Without
+// one, they are only logged.
+func (se *StrategyEngine) SetAuditLog(repo *database.AuditLogRepository) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.auditLogs = repo
+}
+
+// SetExecutionStore sets the repository the status of executions is written
+// to on every transition. Without one, transitions are only published.
+func (se *StrategyEngine) SetExecutionStore(repo *database.StrategyDeploymentRepository) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.executionStore = repo
+}
+
+// GetQuotaHits returns the number of quota violations recorded for a strategy
+func (se *StrategyEngine) GetQuotaHits(strategyID string) int64 {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.quotaHits[strategyID]
+}
+
 // LoadStrategy loads a strategy into the engine
 func (se *StrategyEngine) LoadStrategy(strategy *Strategy) error {
 	se.mu.Lock()
 	defer se.mu.Unlock()
 
-	// TODO: Validate strategy code
-	// TODO: Compile Starlark code
+	if _, err := ParseTimeframe(strategy.Timeframe); err != nil {
+		return fmt.Errorf("failed to load strategy %s: %w", strategy.ID, err)
+	}
+	if err := validateMode(strategy); err != nil {
+		return fmt.Errorf("failed to load strategy %s: %w", strategy.ID, err)
+	}
+
+	program, err := se.compile(strategy)
+	if err != nil {
+		return err
+	}
 
 	se.strategies[strategy.ID] = strategy
+	se.programs[strategy.ID] = program
 	log.Printf("Loaded strategy: %s", strategy.Name)
 
 	return nil
 }
 
-// StartStrategy starts executing a strategy
+// compile compiles the code of a strategy against the engine's builtins and
+// the strategy's parameters, requiring the bar callback of its mode
+func (se *StrategyEngine) compile(strategy *Strategy) (*Program, error) {
+	predeclared := append(globalNames(se.builtins.Globals()), paramNames(strategy.Parameters)...)
+	program, err := Compile(strategy.ID+".star", strategy.Code, predeclared)
+	if err == nil {
+		err = program.requireCallback(strategy.Mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile strategy %s: %w", strategy.ID, err)
+	}
+	return program, nil
+}
+
+// StartStrategy starts executing a strategy. An execution that stopped with
+// an error is replaced. Portfolio strategies run a single execution on all of
+// their symbols, started with PortfolioSymbol.
 func (se *StrategyEngine) StartStrategy(ctx context.Context, strategyID, symbol string) error {
 	se.mu.Lock()
-	defer se.mu.Unlock()
 
 	strategy, exists := se.strategies[strategyID]
 	if !exists {
+		se.mu.Unlock()
 		return fmt.Errorf("strategy not found: %s", strategyID)
 	}
+	if strategy.IsPortfolio() != (symbol == PortfolioSymbol) {
+		se.mu.Unlock()
+		if strategy.IsPortfolio() {
+			return fmt.Errorf("portfolio strategy %s runs on all of its symbols: start it with %q", strategyID, PortfolioSymbol)
+		}
+		return fmt.Errorf("strategy %s is not a portfolio strategy: start it on a symbol", strategyID)
+	}
 
 	executionKey := fmt.Sprintf("%s_%s", strategyID, symbol)
-	if _, exists := se.executions[executionKey]; exists {
-		return fmt.Errorf("strategy already running: %s", executionKey)
+	if previous, exists := se.executions[executionKey]; exists {
+		if previous.snapshot().Status == ExecutionStatusRunning {
+			se.mu.Unlock()
+			return fmt.Errorf("strategy already running: %s", executionKey)
+		}
+		previous.Cancel()
 	}
 
 	// Create execution context
@@ -96,41 +211,50 @@ func (se *StrategyEngine) StartStrategy(ctx context.Context, strategyID, symbol
 	execution := &StrategyExecution{
 		StrategyID: strategyID,
 		Symbol:     symbol,
+		VersionID:  strategy.VersionID,
 		Context:    execCtx,
 		Cancel:     cancel,
 		Status:     ExecutionStatusRunning,
 		StartedAt:  time.Now(),
+		HistoryDepth: se.historyDepth,
+		program:    se.programs[strategyID],
 	}
 
 	se.executions[executionKey] = execution
 
 	// Start strategy execution in goroutine
-	go se.runStrategy(execution, strategy)
+	go se.runStrategy(execution, strategy, se.programs[strategyID], se.quota, se.stateStore)
+	se.mu.Unlock()
 
 	log.Printf("Started strategy execution: %s", executionKey)
+	se.recordTransition(execution.snapshot())
 	return nil
 }
 
 // StopStrategy stops executing a strategy
 func (se *StrategyEngine) StopStrategy(strategyID, symbol string) error {
 	se.mu.Lock()
-	defer se.mu.Unlock()
 
 	executionKey := fmt.Sprintf("%s_%s", strategyID, symbol)
 	execution, exists := se.executions[executionKey]
 	if !exists {
+		se.mu.Unlock()
 		return fmt.Errorf("strategy execution not found: %s", executionKey)
 	}
 
 	execution.Cancel()
+	execution.mu.Lock()
 	execution.Status = ExecutionStatusStopped
+	execution.mu.Unlock()
 	delete(se.executions, executionKey)
+	se.mu.Unlock()
 
 	log.Printf("Stopped strategy execution: %s", executionKey)
+	se.recordTransition(execution.snapshot())
 	return nil
 }
 
-// GetStrategyStatus returns the status of a strategy execution
+// GetStrategyStatus returns a snapshot of the status of a strategy execution
 func (se *StrategyEngine) GetStrategyStatus(strategyID, symbol string) (*StrategyExecution, error) {
 	se.mu.RLock()
 	defer se.mu.RUnlock()
@@ -141,70 +265,555 @@ func (se *StrategyEngine) GetStrategyStatus(strategyID, symbol string) (*Strateg
 		return nil, fmt.Errorf("strategy execution not found: %s", executionKey)
 	}
 
-	return execution, nil
+	return execution.snapshot(), nil
+}
+
+// GetStrategyExecutions returns snapshots of the executions of a strategy ordered by symbol
+func (se *StrategyEngine) GetStrategyExecutions(strategyID string) []*StrategyExecution {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+
+	var executions []*StrategyExecution
+	for _, execution := range se.executions {
+		if execution.StrategyID == strategyID {
+			executions = append(executions, execution.snapshot())
+		}
+	}
+	sort.Slice(executions, func(i, j int) bool { return executions[i].Symbol < executions[j].Symbol })
+	return executions
 }
 
 // runStrategy runs a strategy execution
-func (se *StrategyEngine) runStrategy(execution *StrategyExecution, strategy *Strategy) {
+func (se *StrategyEngine) runStrategy(execution *StrategyExecution, strategy *Strategy, program *Program, quota Quota, store *StateStore) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Strategy execution panic: %v", r)
-			execution.Status = ExecutionStatusError
+			execution.fail(fmt.Errorf("panic: %v", r))
+		}
+		if status := execution.snapshot(); status.Status == ExecutionStatusError {
+			se.recordTransition(status)
 		}
 	}()
 
-	// TODO: Implement actual Starlark execution
-	// This is a placeholder for the actual strategy execution logic
-
 	log.Printf("Running strategy: %s for symbol: %s", strategy.Name, execution.Symbol)
 
+	// Load persisted state; without a store it lasts as long as the execution
+	state := newScriptState(execution.StrategyID, execution.Symbol, DefaultStateLimit)
+	var snapshots <-chan time.Time
+	if store != nil {
+		var err error
+		state, err = store.attach(execution.Context, execution.StrategyID, execution.Symbol)
+		if err != nil {
+			log.Printf("Failed to load state of strategy %s: %v", strategy.ID, err)
+			execution.fail(err)
+			return
+		}
+		defer se.detachState(store, state)
+
+		ticker := time.NewTicker(stateSnapshotInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+
+	script := newScriptContext(execution.HistoryDepth)
+	script.state = state
+	instance, err := se.newInstance(execution, strategy, program, quota, script)
+	if err != nil {
+		if isQuotaError(err) {
+			se.recordQuotaHit(execution, err, quota)
+		}
+		log.Printf("Failed to initialize strategy %s: %v", strategy.ID, err)
+		execution.fail(err)
+		return
+	}
+
 	// Subscribe to market data
-	dataChan, err := se.broker.SubscribeMarketData(execution.Context, execution.Symbol)
+	symbols := []string{execution.Symbol}
+	if strategy.IsPortfolio() {
+		symbols = strategy.Symbols
+	}
+	dataChan, unsubscribe, err := se.subscribeMarketData(execution.Context, symbols)
 	if err != nil {
 		log.Printf("Failed to subscribe to market data: %v", err)
-		execution.Status = ExecutionStatusError
+		execution.fail(err)
+		return
+	}
+	defer unsubscribe()
+
+	// Subscribe to order updates for fill and reject callbacks
+	orderUpdates, err := se.broker.SubscribeOrderUpdates(execution.Context)
+	if err != nil {
+		log.Printf("Failed to subscribe to order updates: %v", err)
+		execution.fail(err)
+		return
+	}
+	defer se.broker.UnsubscribeOrderUpdates(orderUpdates)
+
+	timers := time.NewTicker(timerResolution)
+	defer timers.Stop()
+
+	// Upgrades switch code at bar boundaries. An upgraded instance is on trial
+	// until it has run the upgrade's number of callbacks without an error.
+	var trial *strategyUpgrade
+	swap := func() error {
+		if swap := execution.takeSwap(); swap != nil {
+			next, err := se.swapInstance(execution, instance, swap, quota)
+			if err != nil {
+				return err
+			}
+			if next != instance {
+				instance, trial = next, swap.upgrade
+			}
+		}
+		return nil
+	}
+
+	// Ticks are aggregated into bars of the strategy's timeframe, as in
+	// backtests. Portfolio strategies get the bars of all symbols aligned.
+	addTick, closeBars, err := barHandlers(strategy, func(bar Bar) error {
+		if err := swap(); err != nil {
+			return err
+		}
+		return instance.OnBar(execution.Context, execution.Symbol, bar)
+	}, func(group AlignedBars) error {
+		if err := swap(); err != nil {
+			return err
+		}
+		return instance.OnBars(execution.Context, group)
+	})
+	if err != nil {
+		execution.fail(err)
 		return
 	}
 
-	// Process market data
+	// Multiplex market data, order updates and timers into script callbacks
 	for {
+		var err error
 		select {
+		case <-snapshots:
+			if err := store.snapshot(execution.Context, state); err != nil {
+				log.Printf("Strategy %s on %s: %v", execution.StrategyID, execution.Symbol, err)
+			}
+			continue
 		case <-execution.Context.Done():
 			log.Printf("Strategy execution cancelled: %s", execution.StrategyID)
 			return
-		case data := <-dataChan:
-			// TODO: Execute Starlark code with market data
-			log.Printf("Received market data for %s: $%.2f", data.Symbol, data.Price)
+		case data, ok := <-dataChan:
+			if !ok {
+				return
+			}
+			err = addTick(data)
+		case update, ok := <-orderUpdates:
+			if !ok {
+				return
+			}
+			if instance.OwnsOrder(update.Order.ClientOrderID) {
+				err = instance.OnOrderUpdate(execution.Context, update)
+			}
+		case now := <-timers.C:
+			err = closeBars(now.Add(-barCloseGrace))
+			if err == nil {
+				err = instance.OnTimers(execution.Context, now)
+			}
+		}
+		if trial != nil && err != nil && execution.Context.Err() == nil {
+			// The rollback reaches this execution as a pending swap to the old code
+			se.rollbackUpgrade(trial, err)
+			if swap := execution.takeSwap(); swap != nil {
+				instance, err = se.swapInstance(execution, instance, swap, quota)
+			}
+			trial = nil
+		} else if trial != nil && instance.callbacks >= trial.trialCallbacks {
+			trial = nil
+		}
+		if se.handleCallbackError(execution, quota, err) {
+			return
+		}
+		if store != nil {
+			if err := store.flush(execution.Context, state); err != nil {
+				log.Printf("Strategy %s on %s: %v", execution.StrategyID, execution.Symbol, err)
+			}
+		}
+	}
+}
+
+// barHandlers returns the functions feeding a tick to a strategy and closing
+// the bars whose period ended by a time without a tick of the next one
+// arriving. Completed bars go to onBar, or aligned to onBars in portfolio mode.
+func barHandlers(strategy *Strategy, onBar func(Bar) error, onBars func(AlignedBars) error) (func(broker.MarketData) error, func(time.Time) error, error) {
+	if strategy.IsPortfolio() {
+		feed, err := NewPortfolioFeed(strategy.Timeframe, strategy.Symbols, strategy.MissingBars)
+		if err != nil {
+			return nil, nil, err
+		}
+		deliver := func(groups []AlignedBars) error {
+			for _, group := range groups {
+				if err := onBars(group); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		addTick := func(data broker.MarketData) error { return deliver(feed.AddTick(data)) }
+		closeBars := func(now time.Time) error { return deliver(feed.Flush(now)) }
+		return addTick, closeBars, nil
+	}
+
+	feed, err := NewBarFeed(strategy.Timeframe)
+	if err != nil {
+		return nil, nil, err
+	}
+	addTick := func(data broker.MarketData) error {
+		if bar, completed := feed.AddTick(data); completed {
+			return onBar(bar)
 		}
+		return nil
 	}
+	closeBars := func(now time.Time) error {
+		for _, bar := range feed.Flush(now) {
+			if err := onBar(bar); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return addTick, closeBars, nil
+}
+
+// subscribeMarketData subscribes to the market data of symbols, merging the
+// ticks of several symbols into one channel. The returned function
+// unsubscribes and closes the channel.
+func (se *StrategyEngine) subscribeMarketData(ctx context.Context, symbols []string) (<-chan broker.MarketData, func(), error) {
+	channels := make([]<-chan broker.MarketData, 0, len(symbols))
+	unsubscribe := func() {
+		for i, ch := range channels {
+			se.broker.UnsubscribeMarketData(context.Background(), symbols[i], ch)
+		}
+	}
+	for _, symbol := range symbols {
+		ch, err := se.broker.SubscribeMarketData(ctx, symbol)
+		if err != nil {
+			unsubscribe()
+			return nil, nil, err
+		}
+		channels = append(channels, ch)
+	}
+	if len(channels) == 1 {
+		return channels[0], unsubscribe, nil
+	}
+
+	// Forwarders stop on stop rather than the context, since an execution
+	// that fails returns without its context being cancelled
+	merged := make(chan broker.MarketData, 100)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(ch <-chan broker.MarketData) {
+			defer wg.Done()
+			for data := range ch {
+				select {
+				case merged <- data:
+				case <-stop:
+					return
+				}
+			}
+		}(ch)
+	}
+	return merged, func() {
+		close(stop)
+		unsubscribe()
+		wg.Wait()
+		close(merged)
+	}, nil
+}
+
+// detachState persists the final state of an execution once it stops
+func (se *StrategyEngine) detachState(store *StateStore, state *scriptState) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := store.detach(ctx, state); err != nil {
+		log.Printf("Strategy %s on %s: %v", state.strategyID, state.symbol, err)
+	}
+}
+
+// handleCallbackError records the outcome of a script callback and reports
+// whether the execution has to stop
+func (se *StrategyEngine) handleCallbackError(execution *StrategyExecution, quota Quota, err error) bool {
+	switch {
+	case err == nil:
+		execution.resetViolations()
+		return false
+	case execution.Context.Err() != nil:
+		return true
+	case isQuotaError(err):
+		return se.recordQuotaHit(execution, err, quota)
+	default:
+		log.Printf("Strategy %s failed on %s: %v", execution.StrategyID, execution.Symbol, err)
+		execution.fail(err)
+		return true
+	}
+}
+
+// newInstance initializes a script instance for an execution on the given script context
+func (se *StrategyEngine) newInstance(execution *StrategyExecution, strategy *Strategy, program *Program, quota Quota, script *scriptContext) (*Instance, error) {
+	params, err := paramGlobals(strategy.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	predeclared := se.builtins.Globals()
+	for name, value := range params {
+		predeclared[name] = value
+	}
+
+	thread := &starlark.Thread{
+		Name: fmt.Sprintf("%s_%s", execution.StrategyID, execution.Symbol),
+		Print: func(_ *starlark.Thread, msg string) {
+			se.builtins.Log(msg)
+		},
+	}
+	thread.SetLocal(threadLocalExecution, execution)
+	setScriptContext(thread, script)
+
+	return program.NewInstance(execution.Context, thread, predeclared, quota)
+}
+
+// recordQuotaHit counts an aborted callback against the strategy and stops the
+// execution once it reaches the consecutive violation limit. It reports
+// whether the execution was stopped.
+func (se *StrategyEngine) recordQuotaHit(execution *StrategyExecution, err error, quota Quota) bool {
+	totalQuotaHits.Add(1)
+
+	se.mu.Lock()
+	se.quotaHits[execution.StrategyID]++
+	se.mu.Unlock()
+
+	execution.mu.Lock()
+	execution.QuotaHits++
+	execution.violations++
+	violations := execution.violations
+	execution.mu.Unlock()
+
+	log.Printf("Strategy %s on %s aborted callback: %v (%d consecutive)",
+		execution.StrategyID, execution.Symbol, err, violations)
+
+	if quota.MaxViolations > 0 && violations >= quota.MaxViolations {
+		execution.fail(fmt.Errorf("stopped after %d consecutive quota violations: %w", violations, err))
+		execution.Cancel()
+		return true
+	}
+	return false
+}
+
+// snapshot copies the exported fields of the execution under its lock, so
+// callers can read them while the execution keeps running
+func (execution *StrategyExecution) snapshot() *StrategyExecution {
+	execution.mu.Lock()
+	defer execution.mu.Unlock()
+
+	return &StrategyExecution{
+		StrategyID:   execution.StrategyID,
+		Symbol:       execution.Symbol,
+		VersionID:    execution.VersionID,
+		Context:      execution.Context,
+		Cancel:       execution.Cancel,
+		Status:       execution.Status,
+		StartedAt:    execution.StartedAt,
+		HistoryDepth: execution.HistoryDepth,
+		Error:        execution.Error,
+		Backtrace:    execution.Backtrace,
+		QuotaHits:    execution.QuotaHits,
+	}
+}
+
+// resetViolations clears the consecutive quota violation counter after a clean callback
+func (execution *StrategyExecution) resetViolations() {
+	execution.mu.Lock()
+	defer execution.mu.Unlock()
+	execution.violations = 0
+}
+
+// fail marks the execution as errored, keeping the Starlark stack trace when available
+func (execution *StrategyExecution) fail(err error) {
+	execution.mu.Lock()
+	defer execution.mu.Unlock()
+
+	execution.Status = ExecutionStatusError
+	execution.Error = err.Error()
+
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		execution.Backtrace = runtimeErr.Backtrace
+	}
+}
+
+// OrderRouter is the part of a broker that script builtins trade through.
+// Live executions use the Moomoo adapter, the test harness a simulator.
+type OrderRouter interface {
+	PlaceOrder(ctx context.Context, order *broker.Order) error
+	GetAccountInfo(ctx context.Context) (map[string]interface{}, error)
+	GetLastTick(symbol string) (broker.MarketData, bool)
+}
+
+// PositionSource reports the signed quantity held in a symbol
+type PositionSource interface {
+	NetPosition(symbol string) float64
+}
+
+// builtinObserver is notified of the side effects of builtins, e.g. by the test harness
+type builtinObserver interface {
+	observeOrder(order *broker.Order, result *OrderResult)
+	observeLog(message string)
 }
 
 // Built-in functions for Starlark scripts
 type BuiltinFunctions struct {
-	broker       *broker.MoomooAdapter
+	broker       OrderRouter
+	riskManager  *risk.RiskManager
+	positions    PositionSource // overrides the risk manager's position book when set
 	streamManager *redis.StreamManager
+	observer     builtinObserver
+}
+
+// newBuiltinFunctions creates the builtins of a live engine; a nil adapter
+// leaves order() unconfigured
+func newBuiltinFunctions(adapter *broker.MoomooAdapter, riskManager *risk.RiskManager, streamManager *redis.StreamManager) *BuiltinFunctions {
+	bf := &BuiltinFunctions{riskManager: riskManager, streamManager: streamManager}
+	if adapter != nil {
+		bf.broker = adapter
+	}
+	return bf
+}
+
+// OrderResult reports the outcome of an order placed by a script
+type OrderResult struct {
+	Accepted      bool               `json:"accepted"`
+	OrderID       string             `json:"order_id,omitempty"`
+	ClientOrderID string             `json:"client_order_id"`
+	Status        broker.OrderStatus `json:"status"`
+	Reason        string             `json:"reason,omitempty"`
+}
+
+// Order checks an order against the risk manager and submits it to the broker.
+// Rejections are reported in the result so a script can react to them.
+func (bf *BuiltinFunctions) Order(ctx context.Context, order *broker.Order) *OrderResult {
+	result := &OrderResult{ClientOrderID: order.ClientOrderID, Status: broker.OrderStatusRejected}
+	reject := func(err error) *OrderResult {
+		result.Reason = err.Error()
+		log.Printf("Order rejected: %s %s %s %.2f (%s): %v", order.Symbol, order.Side, order.Type, order.Quantity, order.ClientOrderID, err)
+		return result
+	}
+
+	if err := validateOrder(order); err != nil {
+		return reject(err)
+	}
+	if bf.broker == nil {
+		return reject(errors.New("broker not configured"))
+	}
+	if bf.riskManager != nil {
+		balance, err := bf.accountBalance(ctx)
+		if err != nil {
+			return reject(err)
+		}
+		if err := bf.riskManager.CheckOrderRisk(ctx, order, balance); err != nil {
+			return reject(err)
+		}
+	}
+	if err := bf.broker.PlaceOrder(ctx, order); err != nil {
+		return reject(err)
+	}
+
+	log.Printf("Order: %s %s %s %.2f (%s)", order.Symbol, order.Side, order.Type, order.Quantity, order.ClientOrderID)
+	result.Accepted = true
+	result.OrderID = order.ID
+	result.Status = order.Status
+	return result
 }
 
-// Order places an order
-func (bf *BuiltinFunctions) Order(symbol string, side broker.OrderSide, orderType broker.OrderType, quantity float64, price *float64) error {
-	// TODO: Implement order placement
-	log.Printf("Order: %s %s %s %.2f", symbol, side, orderType, quantity)
+// accountBalance reads the account balance used for risk checks
+func (bf *BuiltinFunctions) accountBalance(ctx context.Context) (float64, error) {
+	info, err := bf.broker.GetAccountInfo(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get account info: %w", err)
+	}
+	balance, ok := info["balance"].(float64)
+	if !ok || balance <= 0 {
+		return 0, errors.New("account balance unavailable")
+	}
+	return balance, nil
+}
+
+// validateOrder checks that an order is complete before it reaches risk and the broker
+func validateOrder(order *broker.Order) error {
+	if order.Symbol == "" {
+		return errors.New("symbol is required")
+	}
+	if order.Side != broker.OrderSideBuy && order.Side != broker.OrderSideSell {
+		return fmt.Errorf("invalid side %q", order.Side)
+	}
+	if order.Quantity <= 0 {
+		return fmt.Errorf("quantity must be positive, got %g", order.Quantity)
+	}
+	switch order.Type {
+	case broker.OrderTypeMarket:
+	case broker.OrderTypeTrailing:
+		if (order.TrailAmount == nil) == (order.TrailPercent == nil) {
+			return errors.New("trailing order requires either a trail amount or a trail percent")
+		}
+		if order.TrailAmount != nil && *order.TrailAmount <= 0 || order.TrailPercent != nil && *order.TrailPercent <= 0 {
+			return errors.New("trail must be positive")
+		}
+	case broker.OrderTypeLimit:
+		if order.Price == nil {
+			return errors.New("limit order requires a price")
+		}
+	case broker.OrderTypeStop:
+		if order.StopPrice == nil {
+			return errors.New("stop order requires a stop price")
+		}
+	case broker.OrderTypeStopLimit:
+		if order.Price == nil || order.StopPrice == nil {
+			return errors.New("stop-limit order requires a price and a stop price")
+		}
+	default:
+		return fmt.Errorf("invalid order type %q", order.Type)
+	}
+	switch order.TimeInForce {
+	case "", broker.TimeInForceDay, broker.TimeInForceGTC:
+	default:
+		return fmt.Errorf("invalid time in force %q", order.TimeInForce)
+	}
 	return nil
 }
 
 // Log logs a message
 func (bf *BuiltinFunctions) Log(message string) {
+	if bf.observer != nil {
+		bf.observer.observeLog(message)
+	}
 	log.Printf("Strategy Log: %s", message)
 }
 
-// GetPrice gets the current price for a symbol
-func (bf *BuiltinFunctions) GetPrice(symbol string) (float64, error) {
-	// TODO: Implement price retrieval
-	return 100.0, nil
+// GetPrice returns the price of the latest tick received for a symbol
+func (bf *BuiltinFunctions) GetPrice(symbol string) (float64, bool) {
+	if bf.broker == nil {
+		return 0, false
+	}
+	tick, ok := bf.broker.GetLastTick(symbol)
+	if !ok {
+		return 0, false
+	}
+	return tick.Price, true
 }
 
-// GetPosition gets the current position for a symbol
-func (bf *BuiltinFunctions) GetPosition(symbol string) (float64, error) {
-	// TODO: Implement position retrieval
-	return 0.0, nil
-}
\ No newline at end of file
+// GetPosition returns the signed quantity held in a symbol according to the live position book
+func (bf *BuiltinFunctions) GetPosition(symbol string) float64 {
+	if bf.positions != nil {
+		return bf.positions.NetPosition(symbol)
+	}
+	if bf.riskManager == nil {
+		return 0
+	}
+	return bf.riskManager.NetPosition(symbol)
+}
@@ -0,0 +1,234 @@
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	_ "time/tzdata" // schedule() needs the market time zone on images without zoneinfo
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Optional script callbacks
+const (
+	OnOrderFillCallback   = "on_order_fill"
+	OnOrderRejectCallback = "on_order_reject"
+	OnTimerCallback       = "on_timer"
+)
+
+// timerResolution is how often live executions check for due timers
+const timerResolution = time.Second
+
+// marketLocation is the time zone schedule() times are interpreted in
+var marketLocation = loadLocation("America/New_York")
+
+func loadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// TradingDay returns midnight of the market day t falls on, in the market
+// time zone
+func TradingDay(t time.Time) time.Time {
+	local := t.In(marketLocation)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, marketLocation)
+}
+
+// isTradingDay reports whether t falls on a weekday in the market time zone
+func isTradingDay(t time.Time) bool {
+	switch t.In(marketLocation).Weekday() {
+	case time.Saturday, time.Sunday:
+		return false
+	}
+	return true
+}
+
+// scriptTimer is a callback registered with schedule() or every()
+type scriptTimer struct {
+	id       int
+	spec     string
+	fn       starlark.Callable // nil dispatches to on_timer
+	interval time.Duration     // every(): time between runs
+	hour     int               // schedule(): wall-clock time of the daily run
+	minute   int
+	next     time.Time
+	removed  bool
+}
+
+// advance moves the timer to its first run strictly after t
+func (timer *scriptTimer) advance(t time.Time) {
+	if timer.interval > 0 {
+		if timer.next.IsZero() {
+			timer.next = t.Add(timer.interval)
+			return
+		}
+		for !timer.next.After(t) {
+			timer.next = timer.next.Add(timer.interval)
+		}
+		return
+	}
+
+	local := t.In(marketLocation)
+	next := time.Date(local.Year(), local.Month(), local.Day(), timer.hour, timer.minute, 0, 0, marketLocation)
+	for !next.After(t) || !isTradingDay(next) {
+		next = next.AddDate(0, 0, 1)
+	}
+	timer.next = next
+}
+
+// dueTimer is a timer run together with the time it was scheduled for
+type dueTimer struct {
+	timer     *scriptTimer
+	scheduled time.Time
+}
+
+// addTimer registers a timer whose first run follows the script clock
+func (sc *scriptContext) addTimer(timer *scriptTimer) int {
+	sc.nextTimerID++
+	timer.id = sc.nextTimerID
+	timer.advance(sc.clock())
+	sc.timers = append(sc.timers, timer)
+	return timer.id
+}
+
+// cancelTimer removes a timer and reports whether it existed
+func (sc *scriptContext) cancelTimer(id int) bool {
+	for i, timer := range sc.timers {
+		if timer.id == id {
+			timer.removed = true
+			sc.timers = append(sc.timers[:i], sc.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// dueTimers returns the timers due at now in firing order and schedules
+// their next run. A timer that missed several runs fires once.
+func (sc *scriptContext) dueTimers(now time.Time) []dueTimer {
+	var due []dueTimer
+	for _, timer := range sc.timers {
+		if !timer.next.After(now) {
+			due = append(due, dueTimer{timer: timer, scheduled: timer.next})
+			timer.advance(now)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].scheduled.Before(due[j].scheduled)
+	})
+	return due
+}
+
+// starlarkSchedule implements schedule("HH:MM", fn=None), running fn (or
+// on_timer) every trading day at the given market time. It returns the timer ID.
+func starlarkSchedule(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		at       string
+		callback starlark.Callable
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "at", &at, "fn?", &callback); err != nil {
+		return nil, err
+	}
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid time %q, want HH:MM", fn.Name(), at)
+	}
+	id := scriptContextOf(thread).addTimer(&scriptTimer{
+		spec:   at,
+		fn:     callback,
+		hour:   clock.Hour(),
+		minute: clock.Minute(),
+	})
+	return starlark.MakeInt(id), nil
+}
+
+// starlarkEvery implements every("5m", fn=None), running fn (or on_timer)
+// at a fixed interval. It returns the timer ID.
+func starlarkEvery(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		interval string
+		callback starlark.Callable
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "interval", &interval, "fn?", &callback); err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d < timerResolution {
+		return nil, fmt.Errorf("%s: invalid interval %q, want a duration of at least %s", fn.Name(), interval, timerResolution)
+	}
+	id := scriptContextOf(thread).addTimer(&scriptTimer{spec: interval, fn: callback, interval: d})
+	return starlark.MakeInt(id), nil
+}
+
+// starlarkCancelTimer implements cancel_timer(id)
+func starlarkCancelTimer(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var id int
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &id); err != nil {
+		return nil, err
+	}
+	return starlark.Bool(scriptContextOf(thread).cancelTimer(id)), nil
+}
+
+// eventGlobals returns the builtins used to register event callbacks
+func eventGlobals() starlark.StringDict {
+	return starlark.StringDict{
+		"schedule":     starlark.NewBuiltin("schedule", starlarkSchedule),
+		"every":        starlark.NewBuiltin("every", starlarkEvery),
+		"cancel_timer": starlark.NewBuiltin("cancel_timer", starlarkCancelTimer),
+	}
+}
+
+// newOrderFillEvent converts a broker fill into the event passed to on_order_fill
+func newOrderFillEvent(update broker.OrderUpdate) starlark.Value {
+	order := update.Order
+	avgFillPrice := 0.0
+	if order.AvgFillPrice != nil {
+		avgFillPrice = *order.AvgFillPrice
+	}
+	return starlarkstruct.FromStringDict(starlark.String("order_fill"), starlark.StringDict{
+		"order_id":           starlark.String(order.ID),
+		"client_order_id":    starlark.String(order.ClientOrderID),
+		"symbol":             starlark.String(order.Symbol),
+		"side":               starlark.String(order.Side),
+		"order_type":         starlark.String(order.Type),
+		"quantity":           starlark.Float(update.Fill.Quantity),
+		"price":              starlark.Float(update.Fill.Price),
+		"commission":         starlark.Float(update.Fill.Commission),
+		"filled_quantity":    starlark.Float(order.FilledQuantity),
+		"remaining_quantity": starlark.Float(order.Quantity - order.FilledQuantity),
+		"avg_fill_price":     starlark.Float(avgFillPrice),
+		"status":             starlark.String(order.Status),
+		"timestamp":          starlark.MakeInt64(update.Timestamp.Unix()),
+	})
+}
+
+// newOrderRejectEvent converts a broker reject into the event passed to on_order_reject
+func newOrderRejectEvent(update broker.OrderUpdate) starlark.Value {
+	order := update.Order
+	return starlarkstruct.FromStringDict(starlark.String("order_reject"), starlark.StringDict{
+		"order_id":        starlark.String(order.ID),
+		"client_order_id": starlark.String(order.ClientOrderID),
+		"symbol":          starlark.String(order.Symbol),
+		"side":            starlark.String(order.Side),
+		"order_type":      starlark.String(order.Type),
+		"quantity":        starlark.Float(order.Quantity),
+		"status":          starlark.String(order.Status),
+		"reason":          starlark.String(update.Reason),
+		"timestamp":       starlark.MakeInt64(update.Timestamp.Unix()),
+	})
+}
+
+// newTimerEvent builds the event passed to a timer callback
+func newTimerEvent(due dueTimer, now time.Time) starlark.Value {
+	return starlarkstruct.FromStringDict(starlark.String("timer"), starlark.StringDict{
+		"id":        starlark.MakeInt(due.timer.id),
+		"spec":      starlark.String(due.timer.spec),
+		"scheduled": starlark.MakeInt64(due.scheduled.Unix()),
+		"timestamp": starlark.MakeInt64(now.Unix()),
+	})
+}
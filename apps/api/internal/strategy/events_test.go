@@ -0,0 +1,114 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/risk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func TestEvents_TimersFireOnSchedule(t *testing.T) {
+	code := `
+fired = []
+
+def flatten(event):
+    fired.append("flatten " + event.spec)
+
+def on_timer(event):
+    fired.append("tick " + event.spec)
+
+schedule("15:55", flatten)
+every("5m")
+cancel_timer(every("1m"))
+
+def on_bar(symbol, bar):
+    pass
+`
+	start := time.Date(2024, 3, 4, 15, 50, 0, 0, marketLocation)
+	program, err := Compile("test.star", code, globalNames(eventGlobals()))
+	require.NoError(t, err)
+	thread := &starlark.Thread{Name: "test"}
+	script := newScriptContext(DefaultHistoryDepth)
+	script.clock = func() time.Time { return start }
+	setScriptContext(thread, script)
+	instance, err := program.NewInstance(context.Background(), thread, eventGlobals(), DefaultQuota)
+	require.NoError(t, err)
+
+	require.NoError(t, instance.OnTimers(context.Background(), start.Add(4*time.Minute)))
+	// Both timers are due at 15:55 and fire in registration order
+	require.NoError(t, instance.OnTimers(context.Background(), start.Add(5*time.Minute)))
+	require.NoError(t, instance.OnTimers(context.Background(), start.Add(10*time.Minute)))
+	// Missed interval runs fire once; the schedule repeats the next day
+	require.NoError(t, instance.OnTimers(context.Background(), start.Add(24*time.Hour)))
+	require.NoError(t, instance.OnTimers(context.Background(), start.Add(24*time.Hour+5*time.Minute)))
+
+	assert.Equal(t,
+		`["flatten 15:55", "tick 5m", "tick 5m", "tick 5m", "flatten 15:55", "tick 5m"]`,
+		instance.globals["fired"].String())
+}
+
+func TestScriptTimer_ScheduleSkipsWeekends(t *testing.T) {
+	timer := &scriptTimer{hour: 15, minute: 55}
+
+	timer.advance(time.Date(2024, 3, 8, 16, 0, 0, 0, marketLocation))
+	assert.Equal(t, time.Date(2024, 3, 11, 15, 55, 0, 0, marketLocation), timer.next, "Friday after the run moves to Monday")
+
+	timer.advance(time.Date(2024, 3, 9, 10, 0, 0, 0, marketLocation))
+	assert.Equal(t, time.Date(2024, 3, 11, 15, 55, 0, 0, marketLocation), timer.next, "registered on a Saturday")
+
+	timer.advance(time.Date(2024, 3, 8, 9, 0, 0, 0, marketLocation))
+	assert.Equal(t, time.Date(2024, 3, 8, 15, 55, 0, 0, marketLocation), timer.next)
+}
+
+func TestEvents_OrderFillAndRejectCallbacks(t *testing.T) {
+	bf := newTestBuiltins(t, &risk.RiskConfig{
+		MaxPositionSize:        50,
+		MaxDailyLoss:           5,
+		MaxWeeklyLoss:          10,
+		MaxConcurrentPositions: 5,
+	})
+	code := `
+ids = []
+events = []
+
+def on_bar(symbol, bar):
+    ids.append(order(symbol, "BUY", "MARKET", 10).order_id)
+    ids.append(order(symbol, "BUY", "MARKET", 5).order_id)
+
+def on_order_fill(event):
+    events.append((event.status, event.quantity, event.price, position(event.symbol)))
+
+def on_order_reject(event):
+    events.append((event.status, event.reason))
+`
+	instance := newTestInstance(t, code, bf.Globals(), DefaultQuota)
+	updates, err := adapterOf(bf).SubscribeOrderUpdates(context.Background())
+	require.NoError(t, err)
+	defer adapterOf(bf).UnsubscribeOrderUpdates(updates)
+
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Timestamp: time.Now(), Close: 100}))
+	ids := instance.globals["ids"].(*starlark.List)
+	first := string(ids.Index(0).(starlark.String))
+	second := string(ids.Index(1).(starlark.String))
+
+	// Fills are booked when they happen, before any subscriber reads them
+	_, err = adapterOf(bf).FillOrder(context.Background(), first, 4, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, bf.riskManager.NetPosition("AAPL"))
+	require.NoError(t, instance.OnOrderUpdate(context.Background(), <-updates))
+
+	_, err = adapterOf(bf).FillOrder(context.Background(), first, 6, 101)
+	require.NoError(t, err)
+	require.NoError(t, adapterOf(bf).RejectOrder(context.Background(), second, "insufficient buying power"))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, instance.OnOrderUpdate(context.Background(), <-updates))
+	}
+
+	assert.Equal(t,
+		`[("PARTIAL", 4.0, 100.0, 4.0), ("FILLED", 6.0, 101.0, 10.0), ("REJECTED", "insufficient buying power")]`,
+		instance.globals["events"].String())
+}
@@ -0,0 +1,442 @@
+package strategy
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"go.starlark.net/starlark"
+)
+
+// harnessThreadName names the thread scripts run on in the harness. It seeds
+// client order IDs, so a harness run places the same IDs every time.
+const harnessThreadName = "harness"
+
+// DefaultHarnessBalance is the simulated account balance of a harness run
+const DefaultHarnessBalance = 100000
+
+// HarnessBar is one bar of a scripted sequence. Bars without a symbol belong
+// to the run's default symbol.
+type HarnessBar struct {
+	Symbol string `json:"symbol,omitempty"`
+	Bar
+}
+
+// HarnessConfig describes a harness run. In portfolio mode the bars sharing a
+// timestamp are handed to on_bars together, as one period.
+type HarnessConfig struct {
+	Code    string                 `json:"code"`
+	Params  map[string]interface{} `json:"params"`
+	Mode    string                 `json:"mode"` // symbol (default) or portfolio
+	Symbol  string                 `json:"symbol"`
+	Bars    []HarnessBar           `json:"bars"`
+	Balance float64                `json:"balance"`
+	Quota   Quota                  `json:"-"`
+	// FillMarketOrders fills accepted market orders at the close of the bar
+	// that placed them, so position() and on_order_fill see them
+	FillMarketOrders bool `json:"fill_market_orders"`
+}
+
+// HarnessOrder is an order() call made by the script
+type HarnessOrder struct {
+	ClientOrderID string             `json:"client_order_id"`
+	Symbol        string             `json:"symbol"`
+	Side          broker.OrderSide   `json:"side"`
+	Type          broker.OrderType   `json:"type"`
+	Quantity      float64            `json:"quantity"`
+	Price         *float64           `json:"price,omitempty"`
+	StopPrice     *float64           `json:"stop_price,omitempty"`
+	Accepted      bool               `json:"accepted"`
+	Status        broker.OrderStatus `json:"status"`
+	Reason        string             `json:"reason,omitempty"`
+}
+
+// StateChange is a key of the persistent state written or deleted during a
+// bar. Value is nil for deleted keys.
+type StateChange struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// HarnessStep records everything the script did while processing one bar,
+// including timers due at the bar and fill callbacks of its orders. In
+// portfolio mode a step is a period: its symbol is PortfolioSymbol, Bar only
+// has the period's timestamp and Bars holds the bars of the period.
+type HarnessStep struct {
+	Index     int            `json:"index"`
+	Symbol    string         `json:"symbol"`
+	Bar       Bar            `json:"bar"`
+	Bars      map[string]Bar `json:"bars,omitempty"`
+	Orders    []HarnessOrder `json:"orders"`
+	Logs      []string       `json:"logs"`
+	State     []StateChange  `json:"state"`
+	Error     string         `json:"error,omitempty"`
+	Backtrace string         `json:"backtrace,omitempty"`
+}
+
+// HarnessResult is the outcome of a harness run. Steps ends at the bar whose
+// callback failed, if any.
+type HarnessResult struct {
+	Steps []HarnessStep `json:"steps"`
+	Error string        `json:"error,omitempty"`
+}
+
+// RunHarness runs strategy code over a scripted bar sequence with the runtime
+// StrategyEngine uses and records the orders, logs and state changes of every
+// bar, or of every period in portfolio mode. Orders go to an in-memory
+// simulator, so runs are deterministic. Compile errors, including a script
+// without the bar callback of the mode, are returned as *CompileError; errors
+// raised by the script end the run and are reported in the result.
+func RunHarness(ctx context.Context, config *HarnessConfig) (*HarnessResult, error) {
+	portfolio := config.Mode == ModePortfolio
+	if config.Mode != "" && config.Mode != ModeSymbol && !portfolio {
+		return nil, fmt.Errorf("invalid mode %q: must be symbol or portfolio", config.Mode)
+	}
+	bars, err := harnessBars(config)
+	if err != nil {
+		return nil, err
+	}
+	balance := config.Balance
+	if balance <= 0 {
+		balance = DefaultHarnessBalance
+	}
+	quota := config.Quota
+	if quota == (Quota{}) {
+		quota = DefaultQuota
+	}
+
+	sim := newHarnessBroker(balance, config.FillMarketOrders)
+	recorder := &harnessRecorder{}
+	bf := &BuiltinFunctions{broker: sim, positions: sim, observer: recorder}
+	predeclared := bf.Globals()
+	params, err := paramGlobals(config.Params)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range params {
+		predeclared[name] = value
+	}
+
+	program, err := Compile(harnessThreadName+".star", config.Code, globalNames(predeclared))
+	if err != nil {
+		return nil, err
+	}
+	if err := program.requireCallback(config.Mode); err != nil {
+		return nil, err
+	}
+
+	thread := &starlark.Thread{
+		Name:  harnessThreadName,
+		Print: func(_ *starlark.Thread, msg string) { bf.Log(msg) },
+	}
+	script := newScriptContext(DefaultHistoryDepth)
+	symbol := config.Symbol
+	if portfolio {
+		symbol = PortfolioSymbol
+	}
+	script.state = newScriptState(harnessThreadName, symbol, DefaultStateLimit)
+	if len(bars) > 0 {
+		start := bars[0].Timestamp
+		script.clock = func() time.Time { return start }
+	}
+	setScriptContext(thread, script)
+
+	instance, err := program.NewInstance(ctx, thread, predeclared, quota)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &HarnessResult{Steps: make([]HarnessStep, 0, len(bars))}
+	for i := 0; i < len(bars); {
+		now := bars[i].Timestamp
+		end := i + 1
+		for portfolio && end < len(bars) && bars[end].Timestamp.Equal(now) {
+			end++
+		}
+		script.clock = func() time.Time { return now }
+		before := script.state.snapshotValues()
+		*recorder = harnessRecorder{}
+
+		step := HarnessStep{Index: len(result.Steps), Symbol: bars[i].Symbol, Bar: bars[i].Bar}
+		group := AlignedBars{Timestamp: now, Bars: make(map[string]Bar, end-i)}
+		for _, bar := range bars[i:end] {
+			group.Bars[bar.Symbol] = bar.Bar
+		}
+		if portfolio {
+			step.Symbol, step.Bar, step.Bars = PortfolioSymbol, Bar{Timestamp: now}, group.Bars
+		}
+
+		err := instance.OnTimers(ctx, now)
+		if err == nil {
+			for symbol, bar := range group.Bars {
+				sim.setBar(symbol, bar)
+			}
+			if portfolio {
+				err = instance.OnBars(ctx, group)
+			} else {
+				err = instance.OnBar(ctx, bars[i].Symbol, bars[i].Bar)
+			}
+		}
+		for err == nil {
+			update, ok := sim.nextUpdate()
+			if !ok {
+				break
+			}
+			err = instance.OnOrderUpdate(ctx, update)
+		}
+
+		step.Orders = recorder.orders
+		step.Logs = recorder.logs
+		step.State = diffState(before, script.state.snapshotValues())
+		if step.Orders == nil {
+			step.Orders = []HarnessOrder{}
+		}
+		if step.Logs == nil {
+			step.Logs = []string{}
+		}
+		if err != nil {
+			step.Error = err.Error()
+			var runtimeErr *RuntimeError
+			if errors.As(err, &runtimeErr) {
+				step.Backtrace = runtimeErr.Backtrace
+			}
+			result.Error = fmt.Sprintf("bar %d: %v", step.Index, err)
+		}
+		result.Steps = append(result.Steps, step)
+		if err != nil {
+			break
+		}
+		i = end
+	}
+	return result, nil
+}
+
+// harnessBars fills in default symbols and checks the bars are in time order
+func harnessBars(config *HarnessConfig) ([]HarnessBar, error) {
+	bars := make([]HarnessBar, len(config.Bars))
+	for i, bar := range config.Bars {
+		if bar.Symbol == "" {
+			bar.Symbol = config.Symbol
+		}
+		if bar.Symbol == "" {
+			return nil, fmt.Errorf("bar %d: symbol is required", i)
+		}
+		if bar.Timestamp.IsZero() {
+			return nil, fmt.Errorf("bar %d: timestamp is required", i)
+		}
+		if i > 0 && bar.Timestamp.Before(bars[i-1].Timestamp) {
+			return nil, fmt.Errorf("bar %d: timestamp %s is before the previous bar", i, bar.Timestamp.Format(time.RFC3339))
+		}
+		if config.Mode == ModePortfolio {
+			for j := i - 1; j >= 0 && bars[j].Timestamp.Equal(bar.Timestamp); j-- {
+				if bars[j].Symbol == bar.Symbol {
+					return nil, fmt.Errorf("bar %d: %s already has a bar at %s", i, bar.Symbol, bar.Timestamp.Format(time.RFC3339))
+				}
+			}
+		}
+		bars[i] = bar
+	}
+	return bars, nil
+}
+
+// diffState returns the keys whose values differ between two state snapshots
+func diffState(before, after map[string]json.RawMessage) []StateChange {
+	changes := []StateChange{}
+	for key, value := range after {
+		if old, ok := before[key]; !ok || !bytes.Equal(old, value) {
+			changes = append(changes, StateChange{Key: key, Value: value})
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, StateChange{Key: key})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// ParseHarnessCSV parses bars from CSV with a header row naming the columns
+// timestamp, open, high, low, close and optionally volume and symbol.
+// Timestamps are RFC 3339, "2006-01-02 15:04:05" or "2006-01-02" in UTC.
+func ParseHarnessCSV(data string) ([]HarnessBar, error) {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV has no header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"timestamp", "open", "high", "low", "close"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV is missing the %s column", name)
+		}
+	}
+
+	bars := make([]HarnessBar, 0, len(records)-1)
+	for line, record := range records[1:] {
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		number := func(name string) (float64, error) {
+			value := field(name)
+			if value == "" && name == "volume" {
+				return 0, nil
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: invalid %s %q", line+2, name, value)
+			}
+			return f, nil
+		}
+
+		timestamp, err := parseHarnessTime(field("timestamp"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+2, err)
+		}
+		bar := HarnessBar{Symbol: field("symbol"), Bar: Bar{Timestamp: timestamp}}
+		for name, dst := range map[string]*float64{"open": &bar.Open, "high": &bar.High, "low": &bar.Low, "close": &bar.Close, "volume": &bar.Volume} {
+			if *dst, err = number(name); err != nil {
+				return nil, err
+			}
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+func parseHarnessTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// harnessRecorder collects the orders and logs of the bar being processed
+type harnessRecorder struct {
+	orders []HarnessOrder
+	logs   []string
+}
+
+func (r *harnessRecorder) observeOrder(order *broker.Order, result *OrderResult) {
+	r.orders = append(r.orders, HarnessOrder{
+		ClientOrderID: result.ClientOrderID,
+		Symbol:        order.Symbol,
+		Side:          order.Side,
+		Type:          order.Type,
+		Quantity:      order.Quantity,
+		Price:         order.Price,
+		StopPrice:     order.StopPrice,
+		Accepted:      result.Accepted,
+		Status:        result.Status,
+		Reason:        result.Reason,
+	})
+}
+
+func (r *harnessRecorder) observeLog(message string) {
+	r.logs = append(r.logs, message)
+}
+
+// harnessBroker accepts every valid order and optionally fills market orders
+// at the close of the current bar of their symbol
+type harnessBroker struct {
+	balance   float64
+	fill      bool
+	bars      map[string]Bar
+	positions map[string]float64
+	updates   []broker.OrderUpdate
+	nextID    int
+}
+
+func newHarnessBroker(balance float64, fill bool) *harnessBroker {
+	return &harnessBroker{
+		balance:   balance,
+		fill:      fill,
+		bars:      make(map[string]Bar),
+		positions: make(map[string]float64),
+	}
+}
+
+func (b *harnessBroker) setBar(symbol string, bar Bar) {
+	b.bars[symbol] = bar
+}
+
+func (b *harnessBroker) PlaceOrder(_ context.Context, order *broker.Order) error {
+	b.nextID++
+	order.ID = fmt.Sprintf("harness-%d", b.nextID)
+	order.Status = broker.OrderStatusSubmitted
+	order.CreatedAt = b.bars[order.Symbol].Timestamp
+	order.UpdatedAt = order.CreatedAt
+
+	bar, ok := b.bars[order.Symbol]
+	if !b.fill || !ok || order.Type != broker.OrderTypeMarket {
+		return nil
+	}
+
+	fill := *order
+	price := bar.Close
+	fill.FilledQuantity = order.Quantity
+	fill.AvgFillPrice = &price
+	fill.Status = broker.OrderStatusFilled
+	quantity := order.Quantity
+	if order.Side == broker.OrderSideSell {
+		quantity = -quantity
+	}
+	b.positions[order.Symbol] += quantity
+	b.updates = append(b.updates, broker.OrderUpdate{
+		Order: fill,
+		Fill: &broker.Trade{
+			ID:        order.ID + "-fill",
+			OrderID:   order.ID,
+			Symbol:    order.Symbol,
+			Side:      order.Side,
+			Quantity:  order.Quantity,
+			Price:     price,
+			TradeTime: bar.Timestamp,
+		},
+		Timestamp: bar.Timestamp,
+	})
+	return nil
+}
+
+func (b *harnessBroker) GetAccountInfo(context.Context) (map[string]interface{}, error) {
+	return map[string]interface{}{"balance": b.balance}, nil
+}
+
+// GetLastTick reports no ticks, so price() reads the script's latest bar
+func (b *harnessBroker) GetLastTick(string) (broker.MarketData, bool) {
+	return broker.MarketData{}, false
+}
+
+func (b *harnessBroker) NetPosition(symbol string) float64 {
+	return b.positions[symbol]
+}
+
+// nextUpdate pops the oldest order update not yet delivered to the script
+func (b *harnessBroker) nextUpdate() (broker.OrderUpdate, bool) {
+	if len(b.updates) == 0 {
+		return broker.OrderUpdate{}, false
+	}
+	update := b.updates[0]
+	b.updates = b.updates[1:]
+	return update, true
+}
@@ -0,0 +1,139 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const harnessTestCode = `
+def on_bar(symbol, bar):
+    log("close %s" % bar.close)
+    if bar.close > threshold and position(symbol) == 0:
+        order(symbol, "buy", "market", 10)
+    state.set("last", bar.close[0])
+
+def on_order_fill(event):
+    state.set("filled", event.quantity)
+    state.delete("last")
+`
+
+func harnessTestBars(closes ...float64) []HarnessBar {
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	bars := make([]HarnessBar, len(closes))
+	for i, close := range closes {
+		bars[i] = HarnessBar{Bar: Bar{Timestamp: start.Add(time.Duration(i) * time.Minute), Open: close, High: close, Low: close, Close: close, Volume: 100}}
+	}
+	return bars
+}
+
+func TestRunHarness_RecordsOrdersLogsAndStatePerBar(t *testing.T) {
+	config := &HarnessConfig{
+		Code:             harnessTestCode,
+		Params:           map[string]interface{}{"threshold": 100},
+		Symbol:           "AAPL",
+		Bars:             harnessTestBars(99, 101, 102),
+		FillMarketOrders: true,
+	}
+
+	result, err := RunHarness(context.Background(), config)
+	require.NoError(t, err)
+	require.Empty(t, result.Error)
+	require.Len(t, result.Steps, 3)
+
+	first := result.Steps[0]
+	assert.Empty(t, first.Orders)
+	assert.Equal(t, []string{"close 99.0"}, first.Logs)
+	assert.Equal(t, []StateChange{{Key: "last", Value: json.RawMessage(`99.0`)}}, first.State)
+
+	second := result.Steps[1]
+	require.Len(t, second.Orders, 1)
+	assert.True(t, second.Orders[0].Accepted)
+	assert.Equal(t, "AAPL", second.Orders[0].Symbol)
+	assert.Equal(t, 10.0, second.Orders[0].Quantity)
+	assert.Equal(t, []StateChange{{Key: "filled", Value: json.RawMessage(`10.0`)}, {Key: "last"}}, second.State)
+
+	// The fill is reflected in position(), so no further order is placed
+	assert.Empty(t, result.Steps[2].Orders)
+
+	// Runs are deterministic
+	again, err := RunHarness(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, result, again)
+}
+
+func TestRunHarness_StopsAtFirstRuntimeError(t *testing.T) {
+	code := "def on_bar(symbol, bar):\n    if bar.close > 100:\n        fail(\"too high\")\n"
+
+	result, err := RunHarness(context.Background(), &HarnessConfig{Code: code, Symbol: "AAPL", Bars: harnessTestBars(99, 101, 102)})
+	require.NoError(t, err)
+	require.Len(t, result.Steps, 2)
+	assert.Contains(t, result.Error, "bar 1")
+	assert.Contains(t, result.Steps[1].Error, "too high")
+	assert.Contains(t, result.Steps[1].Backtrace, "on_bar")
+
+	_, err = RunHarness(context.Background(), &HarnessConfig{Code: "def on_bar(symbol, bar):\n    undefined_call()\n", Symbol: "AAPL"})
+	var compileErr *CompileError
+	assert.ErrorAs(t, err, &compileErr)
+
+	bars := harnessTestBars(1, 2)
+	bars[0], bars[1] = bars[1], bars[0]
+	_, err = RunHarness(context.Background(), &HarnessConfig{Code: code, Symbol: "AAPL", Bars: bars})
+	assert.ErrorContains(t, err, "before the previous bar")
+}
+
+func TestRunHarness_PortfolioModeHandsOutPeriods(t *testing.T) {
+	code := `
+def on_bars(bars_by_symbol):
+    spread = bars_by_symbol["AAPL"].close - bars_by_symbol["MSFT"].close
+    log("spread %s" % spread)
+    if spread > 5:
+        order("AAPL", "sell", "market", 1)
+`
+	aapl, msft := harnessTestBars(100, 110), harnessTestBars(98, 102)
+	bars := []HarnessBar{aapl[0], msft[0], aapl[1], msft[1]}
+	bars[0].Symbol, bars[1].Symbol, bars[2].Symbol, bars[3].Symbol = "AAPL", "MSFT", "AAPL", "MSFT"
+
+	result, err := RunHarness(context.Background(), &HarnessConfig{Code: code, Mode: ModePortfolio, Bars: bars})
+	require.NoError(t, err)
+	require.Empty(t, result.Error)
+	require.Len(t, result.Steps, 2)
+	assert.Equal(t, PortfolioSymbol, result.Steps[0].Symbol)
+	assert.Equal(t, aapl[0].Timestamp, result.Steps[0].Bar.Timestamp)
+	assert.Len(t, result.Steps[0].Bars, 2)
+	assert.Equal(t, []string{"spread 2.0"}, result.Steps[0].Logs)
+	assert.Empty(t, result.Steps[0].Orders)
+	require.Len(t, result.Steps[1].Orders, 1)
+	assert.Equal(t, "AAPL", result.Steps[1].Orders[0].Symbol)
+
+	// Each mode needs its own bar callback
+	var compileErr *CompileError
+	_, err = RunHarness(context.Background(), &HarnessConfig{Code: code, Symbol: "AAPL", Bars: aapl})
+	require.ErrorAs(t, err, &compileErr)
+	assert.Contains(t, compileErr.Message, "on_bar(symbol, bar)")
+	_, err = RunHarness(context.Background(), &HarnessConfig{Code: harnessTestCode, Mode: ModePortfolio, Params: map[string]interface{}{"threshold": 1}, Bars: bars})
+	require.ErrorAs(t, err, &compileErr)
+	assert.Contains(t, compileErr.Message, "on_bars(bars_by_symbol)")
+}
+
+func TestParseHarnessCSV(t *testing.T) {
+	data := "Timestamp,Symbol,Open,High,Low,Close,Volume\n" +
+		"2024-01-02T15:00:00Z,AAPL,1,2,0.5,1.5,10\n" +
+		"2024-01-02 15:01:00,MSFT,2,3,1.5,2.5,\n"
+
+	bars, err := ParseHarnessCSV(data)
+	require.NoError(t, err)
+	require.Len(t, bars, 2)
+	assert.Equal(t, HarnessBar{Symbol: "AAPL", Bar: Bar{Timestamp: time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC), Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 10}}, bars[0])
+	assert.Equal(t, "MSFT", bars[1].Symbol)
+	assert.Zero(t, bars[1].Volume)
+
+	_, err = ParseHarnessCSV("timestamp,open,high,low\n")
+	assert.ErrorContains(t, err, "close column")
+	_, err = ParseHarnessCSV("timestamp,open,high,low,close\nyesterday,1,1,1,1\n")
+	assert.ErrorContains(t, err, "line 2")
+}
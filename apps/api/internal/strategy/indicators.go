@@ -0,0 +1,428 @@
+package strategy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/moomoo-trading/api/internal/indicator"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// indModule is the name of the module of batch indicator functions
+const indModule = "ind"
+
+// Default Ichimoku periods used by the ichimoku_* builtins
+const (
+	ichimokuTenkan  = 9
+	ichimokuKijun   = 26
+	ichimokuSenkouB = 52
+)
+
+// indicatorInput is the first argument of an indicator builtin: either the
+// current value of a series, which is streamed through a cached indicator,
+// or a list of values, oldest first, which is evaluated in one pass
+type indicatorInput struct {
+	series *Series
+	value  float64
+	values []float64
+}
+
+func (in *indicatorInput) Unpack(v starlark.Value) error {
+	if s, ok := v.(*Series); ok {
+		in.series = s
+		if s.detached() {
+			in.values = s.history()
+		} else if s.Len() > 0 {
+			in.value = float64(s.current())
+		}
+		return nil
+	}
+	if f, ok := starlark.AsFloat(v); ok {
+		in.value = f
+		return nil
+	}
+	values, err := floatList(v)
+	if err != nil {
+		return err
+	}
+	in.values = values
+	return nil
+}
+
+// batch reports whether the input is a list of values
+func (in *indicatorInput) batch() bool {
+	return in.values != nil
+}
+
+// batchOnly converts a live series to its retained history for the ind
+// module, whose functions always evaluate whole lists
+func (in *indicatorInput) batchOnly(fn *starlark.Builtin) error {
+	if in.batch() || !strings.HasPrefix(fn.Name(), indModule+".") {
+		return nil
+	}
+	if in.series == nil {
+		return fmt.Errorf("%s: got number, want list or series", fn.Name())
+	}
+	in.values = in.series.history()
+	return nil
+}
+
+// floatList converts a Starlark list or tuple of numbers to a slice
+func floatList(v starlark.Value) ([]float64, error) {
+	iterable, ok := v.(starlark.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("got %s, want number or list of numbers", v.Type())
+	}
+	values := make([]float64, iterable.Len())
+	for i := range values {
+		f, ok := asFloat(iterable.Index(i))
+		if !ok {
+			return nil, fmt.Errorf("element %d: got %s, want number", i, iterable.Index(i).Type())
+		}
+		values[i] = f
+	}
+	return values, nil
+}
+
+// indicatorSymbol returns the symbol an indicator is cached under: that of
+// its live input series, since a portfolio script feeds series of several
+// symbols, or else the symbol of the current bar
+func indicatorSymbol(script *scriptContext, series *Series) string {
+	if series != nil && series.symbol != "" {
+		return series.symbol
+	}
+	return script.symbol
+}
+
+// seriesKey identifies the series a streaming indicator is fed from. Bar
+// series are keyed by name; plain numbers carry no name, so the position of
+// the calling expression is used and each call site owns its own indicator.
+func seriesKey(thread *starlark.Thread, input indicatorInput) string {
+	if input.series != nil {
+		return input.series.name
+	}
+	if thread.CallStackDepth() < 2 {
+		return ""
+	}
+	return thread.CallFrame(1).Pos.String()
+}
+
+// evaluate runs an indicator over a batch input, or feeds the current value to
+// the indicator cached for this call site and returns its memoized result.
+// A live series is tracked by its bar number, and bars on which the call was
+// skipped, for example inside an if, are replayed from the series history so
+// the result does not depend on how often the script evaluates it.
+func evaluate(thread *starlark.Thread, name, params string, input indicatorInput, create func() interface{}, update func(ind interface{}, v float64) starlark.Value) starlark.Value {
+	if input.batch() {
+		ind := create()
+		var result starlark.Value = starlark.None
+		for _, v := range input.values {
+			result = update(ind, v)
+		}
+		return result
+	}
+
+	script := scriptContextOf(thread)
+	key := indicator.Key{Symbol: indicatorSymbol(script, input.series), Series: seriesKey(thread, input), Name: name, Params: params}
+	series := input.series
+	if series == nil {
+		// Plain numbers carry no history, so the indicator only sees the
+		// bars on which it is evaluated
+		result := script.indicators.Update(key, script.seq, create, func(ind interface{}, _ int64) interface{} {
+			return update(ind, input.value)
+		})
+		return result.(starlark.Value)
+	}
+
+	result := script.indicators.Update(key, series.end, create, func(ind interface{}, last int64) interface{} {
+		for i := series.missed(last); i > 0; i-- {
+			update(ind, series.lookback(i))
+		}
+		return update(ind, input.value)
+	})
+	return result.(starlark.Value)
+}
+
+// optionalFloat returns value, or None while an indicator is warming up
+func optionalFloat(value float64, ok bool) starlark.Value {
+	if !ok {
+		return starlark.None
+	}
+	return starlark.Float(value)
+}
+
+// scalarIndicator is a single-input indicator producing one value per update
+type scalarIndicator interface {
+	Update(v float64) (float64, bool)
+}
+
+// scalarBuiltin implements name(x, period) for a single-input indicator
+func scalarBuiltin(name string, create func(period int) scalarIndicator) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var (
+			input  indicatorInput
+			period int
+		)
+		if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "x", &input, "period", &period); err != nil {
+			return nil, err
+		}
+		if err := input.batchOnly(fn); err != nil {
+			return nil, err
+		}
+		return evaluate(thread, name, strconv.Itoa(period), input,
+			func() interface{} { return create(period) },
+			func(ind interface{}, v float64) starlark.Value {
+				return optionalFloat(ind.(scalarIndicator).Update(v))
+			}), nil
+	})
+}
+
+// starlarkMACD implements macd(x, fast=12, slow=26, signal=9), returning
+// (macd, signal, histogram) or None while warming up
+func starlarkMACD(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		input              indicatorInput
+		fast, slow, signal = 12, 26, 9
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "x", &input, "fast?", &fast, "slow?", &slow, "signal?", &signal); err != nil {
+		return nil, err
+	}
+	if err := input.batchOnly(fn); err != nil {
+		return nil, err
+	}
+	params := fmt.Sprintf("%d,%d,%d", fast, slow, signal)
+	return evaluate(thread, "macd", params, input,
+		func() interface{} { return indicator.NewMACD(fast, slow, signal) },
+		func(ind interface{}, v float64) starlark.Value {
+			value, ok := ind.(*indicator.MACD).Update(v)
+			if !ok {
+				return starlark.None
+			}
+			return starlark.Tuple{starlark.Float(value.MACD), starlark.Float(value.Signal), starlark.Float(value.Histogram)}
+		}), nil
+}
+
+// starlarkBollinger implements bollinger(x, period=20, k=2.0), returning
+// (upper, middle, lower) or None while warming up
+func starlarkBollinger(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		input  indicatorInput
+		period = 20
+		k      = number(2)
+	)
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &input, &period, &k); err != nil {
+		return nil, err
+	}
+	if err := input.batchOnly(fn); err != nil {
+		return nil, err
+	}
+	params := fmt.Sprintf("%d,%g", period, float64(k))
+	return evaluate(thread, "bollinger", params, input,
+		func() interface{} { return indicator.NewBollinger(period, float64(k)) },
+		func(ind interface{}, v float64) starlark.Value {
+			value, ok := ind.(*indicator.Bollinger).Update(v)
+			if !ok {
+				return starlark.None
+			}
+			return starlark.Tuple{starlark.Float(value.Upper), starlark.Float(value.Middle), starlark.Float(value.Lower)}
+		}), nil
+}
+
+// barField reads a numeric field such as high or low from a bar value
+func barField(bar starlark.Value, name string) (float64, error) {
+	attrs, ok := bar.(starlark.HasAttrs)
+	if !ok {
+		return 0, fmt.Errorf("got %s, want bar", bar.Type())
+	}
+	attr, err := attrs.Attr(name)
+	if err != nil || attr == nil {
+		return 0, fmt.Errorf("bar has no field %s", name)
+	}
+	f, ok := asFloat(attr)
+	if !ok {
+		return 0, fmt.Errorf("bar.%s: got %s, want number", name, attr.Type())
+	}
+	return f, nil
+}
+
+// barFields reads several numeric fields from a bar value
+func barFields(bar starlark.Value, names ...string) ([]float64, error) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		f, err := barField(bar, name)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = f
+	}
+	return values, nil
+}
+
+// cachedBarIndicator feeds the named fields of the current bar to an
+// indicator shared by every call site for the symbol and returns its memoized
+// result. Like evaluate, it replays bars the indicator did not see when the
+// fields are live series.
+func cachedBarIndicator(thread *starlark.Thread, fn *starlark.Builtin, name, params string, bar starlark.Value, fields []string, create func() interface{}, update func(ind interface{}, values []float64) interface{}) (interface{}, error) {
+	values, err := barFields(bar, fields...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+	}
+
+	script := scriptContextOf(thread)
+	series := liveBarSeries(bar, fields)
+	var first *Series
+	if series != nil {
+		first = series[0]
+	}
+	key := indicator.Key{Symbol: indicatorSymbol(script, first), Series: "bar", Name: name, Params: params}
+	if series == nil {
+		return script.indicators.Update(key, script.seq, create, func(ind interface{}, _ int64) interface{} {
+			return update(ind, values)
+		}), nil
+	}
+
+	return script.indicators.Update(key, series[0].end, create, func(ind interface{}, last int64) interface{} {
+		past := make([]float64, len(series))
+		for i := series[0].missed(last); i > 0; i-- {
+			for j, s := range series {
+				past[j] = s.lookback(i)
+			}
+			update(ind, past)
+		}
+		return update(ind, values)
+	}), nil
+}
+
+// liveBarSeries returns the live series behind the named fields of a bar, or
+// nil when any of them is a plain number or a snapshot
+func liveBarSeries(bar starlark.Value, fields []string) []*Series {
+	attrs, ok := bar.(starlark.HasAttrs)
+	if !ok {
+		return nil
+	}
+	series := make([]*Series, len(fields))
+	for i, name := range fields {
+		attr, err := attrs.Attr(name)
+		s, isSeries := attr.(*Series)
+		if err != nil || !isSeries || s.detached() || s.Len() == 0 {
+			return nil
+		}
+		series[i] = s
+	}
+	return series
+}
+
+// starlarkATR implements atr(bar, period)
+func starlarkATR(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		bar    starlark.Value
+		period int
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "bar", &bar, "period", &period); err != nil {
+		return nil, err
+	}
+	result, err := cachedBarIndicator(thread, fn, "atr", strconv.Itoa(period), bar, []string{"high", "low", "close"},
+		func() interface{} { return indicator.NewATR(period) },
+		func(ind interface{}, hlc []float64) interface{} {
+			return optionalFloat(ind.(*indicator.ATR).Update(hlc[0], hlc[1], hlc[2]))
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result.(starlark.Value), nil
+}
+
+// ichimokuResult is the memoized output of the shared Ichimoku indicator
+type ichimokuResult struct {
+	value indicator.IchimokuValue
+	ok    bool
+}
+
+// ichimokuBuiltin implements one of the ichimoku_*(bar) builtins. All four
+// share a single indicator per symbol so each bar is only counted once.
+func ichimokuBuiltin(name string, line func(indicator.IchimokuValue) float64) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var bar starlark.Value
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &bar); err != nil {
+			return nil, err
+		}
+		params := fmt.Sprintf("%d,%d,%d", ichimokuTenkan, ichimokuKijun, ichimokuSenkouB)
+		result, err := cachedBarIndicator(thread, fn, "ichimoku", params, bar, []string{"high", "low"},
+			func() interface{} { return indicator.NewIchimoku(ichimokuTenkan, ichimokuKijun, ichimokuSenkouB) },
+			func(ind interface{}, hl []float64) interface{} {
+				value, ok := ind.(*indicator.Ichimoku).Update(hl[0], hl[1])
+				return ichimokuResult{value: value, ok: ok}
+			})
+		if err != nil {
+			return nil, err
+		}
+		ichimoku := result.(ichimokuResult)
+		return optionalFloat(line(ichimoku.value), ichimoku.ok), nil
+	})
+}
+
+// starlarkBatchATR implements ind.atr(highs, lows, closes, period)
+func starlarkBatchATR(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		highs, lows, closes starlark.Value
+		period              int
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "highs", &highs, "lows", &lows, "closes", &closes, "period", &period); err != nil {
+		return nil, err
+	}
+	series := make([][]float64, 3)
+	for i, v := range []starlark.Value{highs, lows, closes} {
+		values, err := floatList(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		series[i] = values
+	}
+	if len(series[0]) != len(series[1]) || len(series[0]) != len(series[2]) {
+		return nil, fmt.Errorf("%s: highs, lows and closes must have the same length", fn.Name())
+	}
+
+	atr := indicator.NewATR(period)
+	var result starlark.Value = starlark.None
+	for i := range series[0] {
+		result = optionalFloat(atr.Update(series[0][i], series[1][i], series[2][i]))
+	}
+	return result, nil
+}
+
+// indicatorGlobals returns the indicator builtins. The top-level functions
+// stream the current bar's value through a cached indicator; the ind module
+// evaluates lists of values, oldest first, or a series' retained history.
+func indicatorGlobals() starlark.StringDict {
+	scalar := map[string]func(period int) scalarIndicator{
+		"sma":     func(period int) scalarIndicator { return indicator.NewSMA(period) },
+		"ema":     func(period int) scalarIndicator { return indicator.NewEMA(period) },
+		"rsi":     func(period int) scalarIndicator { return indicator.NewRSI(period) },
+		"stddev":  func(period int) scalarIndicator { return indicator.NewStdDev(period) },
+		"highest": func(period int) scalarIndicator { return indicator.NewHighest(period) },
+		"lowest":  func(period int) scalarIndicator { return indicator.NewLowest(period) },
+	}
+
+	globals := starlark.StringDict{
+		"atr":               starlark.NewBuiltin("atr", starlarkATR),
+		"macd":              starlark.NewBuiltin("macd", starlarkMACD),
+		"bollinger":         starlark.NewBuiltin("bollinger", starlarkBollinger),
+		"ichimoku_tenkan":   ichimokuBuiltin("ichimoku_tenkan", func(v indicator.IchimokuValue) float64 { return v.Tenkan }),
+		"ichimoku_kijun":    ichimokuBuiltin("ichimoku_kijun", func(v indicator.IchimokuValue) float64 { return v.Kijun }),
+		"ichimoku_senkou_a": ichimokuBuiltin("ichimoku_senkou_a", func(v indicator.IchimokuValue) float64 { return v.SenkouA }),
+		"ichimoku_senkou_b": ichimokuBuiltin("ichimoku_senkou_b", func(v indicator.IchimokuValue) float64 { return v.SenkouB }),
+	}
+	members := starlark.StringDict{
+		"atr":             starlark.NewBuiltin(indModule+".atr", starlarkBatchATR),
+		"macd":            starlark.NewBuiltin(indModule+".macd", starlarkMACD),
+		"bollinger_bands": starlark.NewBuiltin(indModule+".bollinger_bands", starlarkBollinger),
+	}
+	for name, create := range scalar {
+		globals[name] = scalarBuiltin(name, create)
+		members[name] = scalarBuiltin(indModule+"."+name, create)
+	}
+	globals[indModule] = &starlarkstruct.Module{Name: indModule, Members: members}
+	return globals
+}
@@ -0,0 +1,115 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/risk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func TestIndicators_StreamPerCallSiteAndSymbol(t *testing.T) {
+	code := `
+seen = {}
+
+def on_bar(symbol, bar):
+    fast = sma(bar.close, 2)
+    slow = sma(bar.close, 3)
+    again = sma(bar.close, 2)
+    seen[symbol] = (fast, slow, again)
+`
+	instance := newTestInstance(t, code, indicatorGlobals(), DefaultQuota)
+	seen := func(symbol string) starlark.Value {
+		value, _, err := instance.globals["seen"].(*starlark.Dict).Get(starlark.String(symbol))
+		require.NoError(t, err)
+		return value
+	}
+
+	for _, close := range []float64{1, 2, 3} {
+		require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: close}))
+	}
+	require.NoError(t, instance.OnBar(context.Background(), "MSFT", Bar{Close: 10}))
+
+	assert.Equal(t, starlark.Tuple{starlark.Float(2.5), starlark.Float(2), starlark.Float(2.5)}, seen("AAPL"))
+	assert.Equal(t, starlark.Tuple{starlark.None, starlark.None, starlark.None}, seen("MSFT"))
+}
+
+func TestIndicators_CatchUpOnSkippedBars(t *testing.T) {
+	code := `
+every = []
+skipped = []
+
+def on_bar(symbol, bar):
+    every.append(ema(bar.close, 3))
+    if bar.close > 3:
+        skipped.append(ema(bar.close, 3))
+`
+	instance := newTestInstance(t, code, indicatorGlobals(), DefaultQuota)
+	for _, close := range []float64{1, 2, 3, 4, 5, 2, 6} {
+		require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: close}))
+	}
+
+	every := instance.globals["every"].(*starlark.List)
+	skipped := instance.globals["skipped"].(*starlark.List)
+	require.Equal(t, 3, skipped.Len())
+	for i, bar := range []int{3, 4, 6} {
+		assert.Equal(t, every.Index(bar), skipped.Index(i), "bar %d", bar)
+	}
+}
+
+func TestIndicators_BatchModule(t *testing.T) {
+	code := `
+def on_bar(symbol, bar):
+    pass
+
+closes = [1, 2, 3, 4, 5]
+mean = ind.sma(closes, 3)
+short = ind.ema(closes[:2], 3)
+bands = ind.bollinger_bands([2, 4, 4, 4, 5, 5, 7, 9], 8, 2)
+range_ = ind.atr([10, 11, 12], [8, 9, 10], [9, 10.5, 11], 3)
+`
+	instance := newTestInstance(t, code, indicatorGlobals(), DefaultQuota)
+
+	assert.Equal(t, starlark.Float(4), instance.globals["mean"])
+	assert.Equal(t, starlark.None, instance.globals["short"])
+	assert.Equal(t, starlark.Tuple{starlark.Float(9), starlark.Float(5), starlark.Float(1)}, instance.globals["bands"])
+	assert.Equal(t, starlark.Float(2), instance.globals["range_"])
+}
+
+func TestTemplates_CompileAgainstBuiltins(t *testing.T) {
+	bf := &BuiltinFunctions{}
+	for name, code := range StrategyTemplates {
+		params := map[string]interface{}{
+			"fast_period": 2, "slow_period": 3, "rsi_period": 2, "oversold": 30, "overbought": 70,
+			"lookback_period": 2, "std_dev": 2, "threshold": 1, "quantity": 1,
+		}
+		_, err := Compile(name+".star", code, append(globalNames(bf.Globals()), paramNames(params)...))
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestTemplates_BreakoutPlacesOrders(t *testing.T) {
+	bf := newTestBuiltins(t, &risk.RiskConfig{MaxPositionSize: 10, MaxConcurrentPositions: 5})
+	params := map[string]interface{}{"lookback_period": 2, "quantity": 1}
+	predeclared, err := paramGlobals(params)
+	require.NoError(t, err)
+	for name, value := range bf.Globals() {
+		predeclared[name] = value
+	}
+	instance := newTestInstance(t, StrategyTemplates["breakout"], predeclared, DefaultQuota)
+
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	for i, close := range []float64{10, 11, 10.5, 12} {
+		bar := Bar{Timestamp: start.Add(time.Duration(i) * time.Minute), High: close, Low: close - 1, Close: close}
+		require.NoError(t, instance.OnBar(context.Background(), "AAPL", bar))
+	}
+
+	orders, err := adapterOf(bf).GetOrders(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, "AAPL", orders[0].Symbol)
+	assert.Equal(t, "BUY", string(orders[0].Side))
+}
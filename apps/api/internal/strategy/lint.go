@@ -0,0 +1,227 @@
+package strategy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Diagnostic severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic codes
+const (
+	DiagSyntax             = "syntax"
+	DiagResolve            = "resolve"
+	DiagMissingCallback    = "missing-callback"
+	DiagCallbackArity      = "callback-arity"
+	DiagUnknownBuiltin     = "unknown-builtin"
+	DiagUndeclaredParam    = "undeclared-parameter"
+	DiagForbiddenConstruct = "forbidden-construct"
+)
+
+// Diagnostic is a problem found in a strategy script by Validate
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Line     int32  `json:"line"`
+	Column   int32  `json:"column"`
+	Message  string `json:"message"`
+}
+
+// callbackArity is the number of positional arguments each script callback receives
+var callbackArity = map[string]int{
+	OnBarCallback:         2,
+	OnBarsCallback:        1,
+	OnOrderFillCallback:   1,
+	OnOrderRejectCallback: 1,
+	OnTimerCallback:       1,
+}
+
+// HasErrors reports whether any of the diagnostics is an error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// WarnUndeclaredParams downgrades undeclared-parameter errors to warnings,
+// for code checked before any of its parameters are known
+func WarnUndeclaredParams(diagnostics []Diagnostic) []Diagnostic {
+	for i := range diagnostics {
+		if diagnostics[i].Code == DiagUndeclaredParam {
+			diagnostics[i].Severity = SeverityWarning
+		}
+	}
+	return diagnostics
+}
+
+// Validate checks strategy code without running it against the engine's
+// builtins and the declared parameter names. Diagnostics are ordered by
+// position; code that Validate accepts without errors also compiles.
+func Validate(filename, code string, params []string) []Diagnostic {
+	file, err := syntax.LegacyFileOptions().Parse(filename, code, 0)
+	if err != nil {
+		var syntaxErr syntax.Error
+		if errors.As(err, &syntaxErr) {
+			return []Diagnostic{{Severity: SeverityError, Code: DiagSyntax, Line: syntaxErr.Pos.Line, Column: syntaxErr.Pos.Col, Message: syntaxErr.Msg}}
+		}
+		return []Diagnostic{{Severity: SeverityError, Code: DiagSyntax, Line: 1, Column: 1, Message: err.Error()}}
+	}
+
+	// Internal builtins resolve so that their use is reported as forbidden only
+	builtins := map[string]bool{seriesValueBuiltin: true, allocCheckBuiltin: true}
+	for _, name := range globalNames((&BuiltinFunctions{}).Globals()) {
+		builtins[name] = true
+	}
+	declared := map[string]bool{ParamsGlobal: true}
+	for _, name := range params {
+		declared[name] = true
+	}
+
+	var diagnostics []Diagnostic
+	report := func(severity, code string, pos syntax.Position, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Severity: severity, Code: code, Line: pos.Line, Column: pos.Col, Message: fmt.Sprintf(format, args...)})
+	}
+
+	diagnostics = append(diagnostics, checkCallbacks(file)...)
+	calls := forbiddenConstructs(file, declared, report)
+
+	err = resolve.File(file, func(name string) bool {
+		return builtins[name] || declared[name]
+	}, starlark.Universe.Has)
+	var resolveErrs resolve.ErrorList
+	if errors.As(err, &resolveErrs) {
+		for _, e := range resolveErrs {
+			name, undefined := strings.CutPrefix(e.Msg, "undefined: ")
+			switch {
+			case undefined && calls[name]:
+				report(SeverityError, DiagUnknownBuiltin, e.Pos, "unknown builtin %s", name)
+			case undefined:
+				report(SeverityError, DiagUndeclaredParam, e.Pos, "%s is not a declared parameter or a global defined by the script", name)
+			case strings.Contains(e.Msg, "not allowed"):
+				report(SeverityError, DiagForbiddenConstruct, e.Pos, "%s", e.Msg)
+			default:
+				report(SeverityError, DiagResolve, e.Pos, "%s", e.Msg)
+			}
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return diagnostics
+}
+
+// checkCallbacks requires on_bar or on_bars and checks that every callback the script
+// defines accepts the arguments the engine passes
+func checkCallbacks(file *syntax.File) []Diagnostic {
+	var diagnostics []Diagnostic
+	if findDef(file, OnBarCallback) == nil && findDef(file, OnBarsCallback) == nil {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Code:     DiagMissingCallback,
+			Line:     1,
+			Column:   1,
+			Message:  fmt.Sprintf("strategy must define %s(symbol, bar) or %s(bars_by_symbol)", OnBarCallback, OnBarsCallback),
+		})
+	}
+
+	for name, arity := range callbackArity {
+		def := findDef(file, name)
+		if def == nil || acceptsPositional(def, arity) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Code:     DiagCallbackArity,
+			Line:     def.Def.Line,
+			Column:   def.Def.Col,
+			Message:  fmt.Sprintf("%s must accept %d positional arguments", name, arity),
+		})
+	}
+	return diagnostics
+}
+
+// acceptsPositional reports whether a function can be called with n positional arguments
+func acceptsPositional(def *syntax.DefStmt, n int) bool {
+	required, optional, variadic := 0, 0, false
+	for _, param := range def.Params {
+		switch param := param.(type) {
+		case *syntax.Ident:
+			required++
+		case *syntax.BinaryExpr:
+			optional++
+		case *syntax.UnaryExpr:
+			if param.Op == syntax.STAR && param.X != nil {
+				variadic = true
+			}
+			if param.Op == syntax.STAR && param.X == nil {
+				// Parameters after a bare * are keyword-only
+				return required <= n && (n <= required+optional)
+			}
+		}
+	}
+	return required <= n && (variadic || n <= required+optional)
+}
+
+// forbiddenConstructs reports load statements, recursion, references to the
+// engine's internal builtins and params["name"] lookups of undeclared
+// parameters. It returns the names used as call targets.
+func forbiddenConstructs(file *syntax.File, declared map[string]bool, report func(severity, code string, pos syntax.Position, format string, args ...interface{})) map[string]bool {
+	calls := make(map[string]bool)
+	var enclosing []string
+	var walk func(n syntax.Node) bool
+	walk = func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.LoadStmt:
+			report(SeverityError, DiagForbiddenConstruct, n.Load, "load statements are not supported")
+		case *syntax.DefStmt:
+			enclosing = append(enclosing, n.Name.Name)
+			for _, stmt := range n.Body {
+				syntax.Walk(stmt, walk)
+			}
+			enclosing = enclosing[:len(enclosing)-1]
+			return false
+		case *syntax.CallExpr:
+			if fn, ok := n.Fn.(*syntax.Ident); ok {
+				calls[fn.Name] = true
+				for _, name := range enclosing {
+					if fn.Name == name {
+						report(SeverityError, DiagForbiddenConstruct, fn.NamePos, "recursive call to %s is not allowed", name)
+					}
+				}
+			}
+		case *syntax.Ident:
+			if n.Name == seriesValueBuiltin || n.Name == allocCheckBuiltin {
+				report(SeverityError, DiagForbiddenConstruct, n.NamePos, "%s is reserved for the engine", n.Name)
+			}
+		case *syntax.IndexExpr:
+			x, isIdent := n.X.(*syntax.Ident)
+			key, isLiteral := n.Y.(*syntax.Literal)
+			if isIdent && isLiteral && x.Name == ParamsGlobal && key.Token == syntax.STRING {
+				if name, _ := key.Value.(string); !declared[name] {
+					report(SeverityError, DiagUndeclaredParam, key.TokenPos, "%s is not a declared parameter", name)
+				}
+			}
+		}
+		return true
+	}
+	syntax.Walk(file, walk)
+	return calls
+}
@@ -0,0 +1,77 @@
+package strategy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate_AcceptsTemplates(t *testing.T) {
+	for _, template := range Templates() {
+		var params []string
+		for _, param := range template.Params {
+			params = append(params, param.Name)
+			assert.NoError(t, ValidateParamDefinition(param.Definition()), template.Name+"."+param.Name)
+		}
+		assert.NotEmpty(t, template.Description, template.Name)
+		assert.Empty(t, Validate(template.Name+".star", template.Code, params), template.Name)
+	}
+}
+
+func TestValidate_ReportsDiagnosticsWithPositions(t *testing.T) {
+	code := `load("x.star", "y")
+
+def on_bar(symbol):
+    fast = sma(bar.close, fast_period)
+    if cross(fast, 1):
+        order(symbol, "BUY", "MARKET", params["size"])
+    on_bar(symbol)
+
+def on_order_fill():
+    _series_value(1)
+`
+	diagnostics := Validate("test.star", code, []string{"fast_period"})
+
+	type found struct {
+		Code string
+		Line int32
+	}
+	var got []found
+	for _, d := range diagnostics {
+		assert.Equal(t, SeverityError, d.Severity, d.Message)
+		got = append(got, found{d.Code, d.Line})
+	}
+	assert.Equal(t, []found{
+		{DiagForbiddenConstruct, 1},
+		{DiagCallbackArity, 3},
+		{DiagUndeclaredParam, 4},
+		{DiagUnknownBuiltin, 5},
+		{DiagUndeclaredParam, 6},
+		{DiagForbiddenConstruct, 7},
+		{DiagCallbackArity, 9},
+		{DiagForbiddenConstruct, 10},
+	}, got)
+	assert.True(t, HasErrors(diagnostics))
+}
+
+func TestValidate_SyntaxAndMissingOnBar(t *testing.T) {
+	diagnostics := Validate("test.star", "def on_bar(symbol, bar)\n    pass\n", nil)
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, DiagSyntax, diagnostics[0].Code)
+	assert.Equal(t, int32(2), diagnostics[0].Line)
+
+	diagnostics = Validate("test.star", "def on_tick(symbol, bar):\n    pass\n", nil)
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, DiagMissingCallback, diagnostics[0].Code)
+
+	assert.Empty(t, Validate("test.star", "def on_bar(symbol, bar, extra=None):\n    pass\n", nil))
+}
+
+func TestValidate_AcceptsPortfolioCallback(t *testing.T) {
+	assert.Empty(t, Validate("test.star", "def on_bars(bars_by_symbol):\n    pass\n", nil))
+
+	diagnostics := Validate("test.star", "def on_bars(symbol, bars):\n    pass\n", nil)
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, DiagCallbackArity, diagnostics[0].Code)
+}
@@ -0,0 +1,407 @@
+package strategy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moomoo-trading/api/internal/database"
+)
+
+// Parameter types
+const (
+	ParamTypeInt    = "int"
+	ParamTypeFloat  = "float"
+	ParamTypeBool   = "bool"
+	ParamTypeString = "string"
+	ParamTypeEnum   = "enum"   // a string out of the parameter's enum values
+	ParamTypeSymbol = "symbol" // a ticker symbol, upper-cased
+	ParamTypeArray  = "array"  // a JSON array, for parameters defined before typed parameters
+	ParamTypeObject = "object" // a JSON object, for parameters defined before typed parameters
+)
+
+// legacyParamTypes maps the types of untyped parameters to their typed equivalent
+var legacyParamTypes = map[string]string{
+	"number":  ParamTypeFloat,
+	"boolean": ParamTypeBool,
+}
+
+// stepTolerance is how far a value may be off a multiple of its step, in steps
+const stepTolerance = 1e-9
+
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-]{0,19}$`)
+
+// ParamError lists the parameters that failed validation with the reason for each
+type ParamError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *ParamError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reasons := make([]string, len(names))
+	for i, name := range names {
+		reasons[i] = fmt.Sprintf("%s: %s", name, e.Fields[name])
+	}
+	return "invalid parameters: " + strings.Join(reasons, "; ")
+}
+
+// paramType returns the type of a parameter, mapping legacy types
+func paramType(param *database.StrategyParam) string {
+	if typed, ok := legacyParamTypes[param.ParamType]; ok {
+		return typed
+	}
+	return param.ParamType
+}
+
+// enumValues returns the allowed values of an enum parameter
+func enumValues(param *database.StrategyParam) ([]string, error) {
+	if len(param.EnumValues) == 0 {
+		return nil, nil
+	}
+	var values []string
+	if err := json.Unmarshal(param.EnumValues, &values); err != nil {
+		return nil, fmt.Errorf("enum values must be a list of strings")
+	}
+	return values, nil
+}
+
+// ValidateParamDefinition checks a parameter definition: its name and type,
+// that its constraints fit the type and that its default satisfies them
+func ValidateParamDefinition(param *database.StrategyParam) error {
+	if !isIdentifier(param.ParamName) || param.ParamName == ParamsGlobal {
+		return fmt.Errorf("invalid parameter name %q", param.ParamName)
+	}
+
+	numeric := false
+	switch param.ParamType {
+	case ParamTypeInt, ParamTypeFloat:
+		numeric = true
+	case ParamTypeBool, ParamTypeString, ParamTypeEnum, ParamTypeSymbol, ParamTypeArray, ParamTypeObject:
+	default:
+		return fmt.Errorf("invalid parameter type %q: must be int, float, bool, string, enum or symbol", param.ParamType)
+	}
+
+	if !numeric && (param.MinValue != nil || param.MaxValue != nil || param.Step != nil) {
+		return fmt.Errorf("min, max and step apply to int and float parameters only")
+	}
+	if param.MinValue != nil && param.MaxValue != nil && *param.MinValue > *param.MaxValue {
+		return fmt.Errorf("min %g is greater than max %g", *param.MinValue, *param.MaxValue)
+	}
+	if param.Step != nil && *param.Step <= 0 {
+		return fmt.Errorf("step must be positive, got %g", *param.Step)
+	}
+
+	values, err := enumValues(param)
+	if err != nil {
+		return err
+	}
+	if param.ParamType == ParamTypeEnum && len(values) == 0 {
+		return fmt.Errorf("enum parameters require enum values")
+	}
+	if param.ParamType != ParamTypeEnum && len(values) > 0 {
+		return fmt.Errorf("enum values apply to enum parameters only")
+	}
+
+	if param.DefaultValue != nil {
+		if _, err := coerceParam(param, parseDefault(param)); err != nil {
+			return fmt.Errorf("invalid default value: %w", err)
+		}
+	}
+	return nil
+}
+
+// isIdentifier reports whether name can be used as a Starlark global
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		letter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDefault returns the value of a parameter's default. Defaults are stored
+// as JSON; values that do not parse, and defaults of string-like parameters,
+// are used as strings.
+func parseDefault(param *database.StrategyParam) interface{} {
+	raw := *param.DefaultValue
+	switch paramType(param) {
+	case ParamTypeString, ParamTypeEnum, ParamTypeSymbol:
+		return raw
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+	return value
+}
+
+// FormatParamDefault returns how a default given as JSON is stored: as the
+// plain string for string-like parameters, as JSON otherwise. It is the
+// inverse of parseDefault.
+func FormatParamDefault(paramType string, value json.RawMessage) *string {
+	if len(value) == 0 || string(value) == "null" {
+		return nil
+	}
+	raw := string(value)
+	switch paramType {
+	case ParamTypeString, ParamTypeEnum, ParamTypeSymbol:
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			raw = s
+		}
+	}
+	return &raw
+}
+
+// CoerceParams validates parameter values against their definitions and
+// returns them converted to the declared types, with defaults for the
+// parameters not given. Required parameters without a value, unknown names
+// and values violating a constraint are reported together in a *ParamError.
+// Values of versions without parameter definitions are passed through.
+func CoerceParams(params []*database.StrategyParam, values map[string]interface{}) (map[string]interface{}, error) {
+	coerced := make(map[string]interface{}, len(params))
+	if len(params) == 0 {
+		for name, value := range values {
+			coerced[name] = value
+		}
+		return coerced, nil
+	}
+
+	fields := make(map[string]string)
+	defined := make(map[string]bool, len(params))
+	for _, param := range params {
+		defined[param.ParamName] = true
+
+		value, given := values[param.ParamName]
+		if !given || value == nil {
+			if param.DefaultValue == nil {
+				if param.IsRequired {
+					fields[param.ParamName] = "is required"
+				}
+				continue
+			}
+			value = parseDefault(param)
+		}
+
+		converted, err := coerceParam(param, value)
+		if err != nil {
+			fields[param.ParamName] = err.Error()
+			continue
+		}
+		coerced[param.ParamName] = converted
+	}
+	for name := range values {
+		if !defined[name] {
+			fields[name] = "is not a parameter of the version"
+		}
+	}
+
+	if len(fields) > 0 {
+		return nil, &ParamError{Fields: fields}
+	}
+	return coerced, nil
+}
+
+// coerceParam converts a value to the type of a parameter and checks it
+// against the parameter's constraints
+func coerceParam(param *database.StrategyParam, value interface{}) (interface{}, error) {
+	switch paramType(param) {
+	case ParamTypeInt:
+		f, err := toNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) || math.Abs(f) >= 1<<53 {
+			return nil, fmt.Errorf("must be an integer, got %v", value)
+		}
+		if err := checkRange(param, f); err != nil {
+			return nil, err
+		}
+		return int64(f), nil
+	case ParamTypeFloat:
+		f, err := toNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("must be a finite number")
+		}
+		if err := checkRange(param, f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	case ParamTypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("must be a boolean, got %q", v)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("must be a boolean, got %v", value)
+	case ParamTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string, got %v", value)
+		}
+		return s, nil
+	case ParamTypeEnum:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string, got %v", value)
+		}
+		values, err := enumValues(param)
+		if err != nil {
+			return nil, err
+		}
+		for _, allowed := range values {
+			if s == allowed {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s, got %q", strings.Join(values, ", "), s)
+	case ParamTypeSymbol:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a symbol, got %v", value)
+		}
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if !symbolPattern.MatchString(s) {
+			return nil, fmt.Errorf("must be a symbol, got %q", s)
+		}
+		return s, nil
+	case ParamTypeArray:
+		if _, ok := value.([]interface{}); !ok {
+			return nil, fmt.Errorf("must be an array, got %v", value)
+		}
+		return value, nil
+	case ParamTypeObject:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("must be an object, got %v", value)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("has unknown type %q", param.ParamType)
+}
+
+// toNumber converts a JSON number, a Go number or a numeric string to a float
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("must be a number, got %q", v)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("must be a number, got %v", value)
+}
+
+// checkRange checks a number against the min, max and step of a parameter.
+// Steps count from min, or from zero without one.
+func checkRange(param *database.StrategyParam, f float64) error {
+	if param.MinValue != nil && f < *param.MinValue {
+		return fmt.Errorf("must be at least %g, got %g", *param.MinValue, f)
+	}
+	if param.MaxValue != nil && f > *param.MaxValue {
+		return fmt.Errorf("must be at most %g, got %g", *param.MaxValue, f)
+	}
+	if param.Step != nil {
+		base := 0.0
+		if param.MinValue != nil {
+			base = *param.MinValue
+		}
+		steps := (f - base) / *param.Step
+		if math.Abs(steps-math.Round(steps)) > stepTolerance*math.Max(1, math.Abs(steps)) {
+			return fmt.Errorf("must be a multiple of %g from %g, got %g", *param.Step, base, f)
+		}
+	}
+	return nil
+}
+
+// ParamsJSONSchema describes the parameters of a version as a JSON Schema
+// object, so forms can be generated from it. Enum and symbol parameters carry
+// their type in x-param-type.
+func ParamsJSONSchema(params []*database.StrategyParam) map[string]interface{} {
+	properties := make(map[string]interface{}, len(params))
+	required := []string{}
+	for _, param := range params {
+		property := map[string]interface{}{"x-param-type": paramType(param)}
+		switch paramType(param) {
+		case ParamTypeInt:
+			property["type"] = "integer"
+		case ParamTypeFloat:
+			property["type"] = "number"
+		case ParamTypeBool:
+			property["type"] = "boolean"
+		case ParamTypeString:
+			property["type"] = "string"
+		case ParamTypeEnum:
+			values, _ := enumValues(param)
+			property["type"] = "string"
+			property["enum"] = values
+		case ParamTypeSymbol:
+			property["type"] = "string"
+			property["pattern"] = symbolPattern.String()
+		case ParamTypeArray, ParamTypeObject:
+			property["type"] = paramType(param)
+		}
+		if param.MinValue != nil {
+			property["minimum"] = *param.MinValue
+		}
+		if param.MaxValue != nil {
+			property["maximum"] = *param.MaxValue
+		}
+		if param.Step != nil && param.MinValue == nil {
+			property["multipleOf"] = *param.Step
+		}
+		if param.Step != nil {
+			property["x-step"] = *param.Step
+		}
+		if param.DefaultValue != nil {
+			if value, err := coerceParam(param, parseDefault(param)); err == nil {
+				property["default"] = value
+			}
+		}
+		if param.Description != nil {
+			property["description"] = *param.Description
+		}
+		properties[param.ParamName] = property
+		if param.IsRequired && param.DefaultValue == nil {
+			required = append(required, param.ParamName)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": len(params) == 0,
+	}
+}
@@ -0,0 +1,119 @@
+package strategy
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func stringPtr(s string) *string { return &s }
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCoerceParams_ConvertsToDeclaredTypes(t *testing.T) {
+	params := []*database.StrategyParam{
+		{ParamName: "period", ParamType: ParamTypeInt, DefaultValue: stringPtr("14"), MinValue: floatPtr(2), MaxValue: floatPtr(200)},
+		{ParamName: "threshold", ParamType: "number", DefaultValue: stringPtr(`"1.5"`)},
+		{ParamName: "enabled", ParamType: ParamTypeBool, DefaultValue: stringPtr("true")},
+		{ParamName: "side", ParamType: ParamTypeEnum, DefaultValue: stringPtr("long"), EnumValues: json.RawMessage(`["long","short"]`)},
+		{ParamName: "hedge", ParamType: ParamTypeSymbol, IsRequired: true},
+		{ParamName: "note", ParamType: ParamTypeString},
+	}
+
+	values, err := CoerceParams(params, map[string]interface{}{"period": 20.0, "enabled": "false", "hedge": " spy "})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"period":    int64(20),
+		"threshold": 1.5,
+		"enabled":   false,
+		"side":      "long",
+		"hedge":     "SPY",
+	}, values)
+}
+
+func TestCoerceParams_ReportsEveryInvalidField(t *testing.T) {
+	params := []*database.StrategyParam{
+		{ParamName: "period", ParamType: ParamTypeInt, MinValue: floatPtr(2)},
+		{ParamName: "size", ParamType: ParamTypeFloat, MinValue: floatPtr(0.5), Step: floatPtr(0.25)},
+		{ParamName: "side", ParamType: ParamTypeEnum, EnumValues: json.RawMessage(`["long","short"]`)},
+		{ParamName: "hedge", ParamType: ParamTypeSymbol, IsRequired: true},
+	}
+
+	_, err := CoerceParams(params, map[string]interface{}{"period": 2.5, "size": 0.6, "side": "flat", "extra": 1})
+	var paramErr *ParamError
+	require.ErrorAs(t, err, &paramErr)
+	assert.Equal(t, []string{"extra", "hedge", "period", "side", "size"}, sortedKeys(paramErr.Fields))
+	assert.Contains(t, paramErr.Fields["period"], "integer")
+	assert.Contains(t, paramErr.Fields["size"], "multiple of 0.25 from 0.5")
+	assert.Contains(t, paramErr.Fields["side"], "one of long, short")
+}
+
+func TestCoerceParams_StepsCountFromMin(t *testing.T) {
+	param := &database.StrategyParam{ParamName: "size", ParamType: ParamTypeFloat, MinValue: floatPtr(0.1), Step: floatPtr(0.1)}
+	for _, value := range []float64{0.1, 0.3, 1.7} {
+		_, err := coerceParam(param, value)
+		assert.NoError(t, err, value)
+	}
+	_, err := coerceParam(param, 0.35)
+	assert.Error(t, err)
+}
+
+func TestCoerceParams_PassesThroughUndefinedVersions(t *testing.T) {
+	values, err := CoerceParams(nil, map[string]interface{}{"size": 5.0})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"size": 5.0}, values)
+}
+
+func TestValidateParamDefinition(t *testing.T) {
+	valid := &database.StrategyParam{ParamName: "fast_period", ParamType: ParamTypeInt, DefaultValue: stringPtr("10"), MinValue: floatPtr(1), Step: floatPtr(1)}
+	assert.NoError(t, ValidateParamDefinition(valid))
+
+	for name, param := range map[string]*database.StrategyParam{
+		"name":         {ParamName: "1st", ParamType: ParamTypeInt},
+		"type":         {ParamName: "x", ParamType: "decimal"},
+		"legacy type":  {ParamName: "x", ParamType: "number"},
+		"range":        {ParamName: "x", ParamType: ParamTypeFloat, MinValue: floatPtr(2), MaxValue: floatPtr(1)},
+		"step":         {ParamName: "x", ParamType: ParamTypeFloat, Step: floatPtr(0)},
+		"bool range":   {ParamName: "x", ParamType: ParamTypeBool, MinValue: floatPtr(0)},
+		"enum values":  {ParamName: "x", ParamType: ParamTypeEnum},
+		"default type": {ParamName: "x", ParamType: ParamTypeInt, DefaultValue: stringPtr("1.5")},
+		"default enum": {ParamName: "x", ParamType: ParamTypeEnum, DefaultValue: stringPtr("up"), EnumValues: json.RawMessage(`["long"]`)},
+	} {
+		assert.Error(t, ValidateParamDefinition(param), name)
+	}
+}
+
+func TestFormatParamDefault(t *testing.T) {
+	assert.Equal(t, "long", *FormatParamDefault(ParamTypeEnum, json.RawMessage(`"long"`)))
+	assert.Equal(t, "14", *FormatParamDefault(ParamTypeInt, json.RawMessage(`14`)))
+	assert.Nil(t, FormatParamDefault(ParamTypeInt, json.RawMessage(`null`)))
+}
+
+func TestParamsJSONSchema(t *testing.T) {
+	schema := ParamsJSONSchema([]*database.StrategyParam{
+		{ParamName: "size", ParamType: ParamTypeFloat, DefaultValue: stringPtr("1"), MinValue: floatPtr(0.5), Step: floatPtr(0.5), Description: stringPtr("Order size")},
+		{ParamName: "side", ParamType: ParamTypeEnum, EnumValues: json.RawMessage(`["long","short"]`), IsRequired: true},
+	})
+
+	assert.Equal(t, []string{"side"}, schema["required"])
+	assert.Equal(t, false, schema["additionalProperties"])
+	properties := schema["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"type": "number", "x-param-type": ParamTypeFloat, "default": 1.0,
+		"minimum": 0.5, "x-step": 0.5, "description": "Order size",
+	}, properties["size"])
+	assert.Equal(t, []string{"long", "short"}, properties["side"].(map[string]interface{})["enum"])
+}
@@ -0,0 +1,229 @@
+package strategy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+)
+
+// Strategy modes
+const (
+	// ModeSymbol runs one instance of the script per symbol, calling on_bar
+	ModeSymbol = "symbol"
+
+	// ModePortfolio runs one instance of the script for all of the strategy's
+	// symbols, calling on_bars with their bars aligned by timestamp
+	ModePortfolio = "portfolio"
+)
+
+// PortfolioSymbol is the symbol the single execution of a portfolio strategy
+// is started, recorded and reported under
+const PortfolioSymbol = "*"
+
+// MissingBarPolicy decides what a portfolio strategy sees for a timestamp at
+// which some of its symbols have no bar
+type MissingBarPolicy string
+
+const (
+	// MissingBarsOmit leaves symbols without a bar out of bars_by_symbol
+	MissingBarsOmit MissingBarPolicy = "omit"
+
+	// MissingBarsForwardFill repeats the last close of symbols without a bar
+	// as a flat bar with no volume. Symbols that never had a bar are left out.
+	MissingBarsForwardFill MissingBarPolicy = "forward_fill"
+
+	// MissingBarsSkip drops timestamps at which any symbol has no bar
+	MissingBarsSkip MissingBarPolicy = "skip"
+)
+
+// ParseMissingBarPolicy parses a missing bar policy, defaulting to MissingBarsOmit
+func ParseMissingBarPolicy(policy string) (MissingBarPolicy, error) {
+	switch MissingBarPolicy(policy) {
+	case "":
+		return MissingBarsOmit, nil
+	case MissingBarsOmit, MissingBarsForwardFill, MissingBarsSkip:
+		return MissingBarPolicy(policy), nil
+	}
+	return "", fmt.Errorf("invalid missing bar policy %q: must be omit, forward_fill or skip", policy)
+}
+
+// IsPortfolio reports whether the strategy runs in portfolio mode
+func (s *Strategy) IsPortfolio() bool {
+	return s.Mode == ModePortfolio
+}
+
+// ExecutionSymbols returns the symbols the strategy's executions are started
+// under: each of its symbols, or PortfolioSymbol in portfolio mode
+func (s *Strategy) ExecutionSymbols() []string {
+	if s.IsPortfolio() {
+		return []string{PortfolioSymbol}
+	}
+	return s.Symbols
+}
+
+// validateMode checks the mode, symbols and missing bar policy of a strategy
+func validateMode(strategy *Strategy) error {
+	switch strategy.Mode {
+	case "", ModeSymbol:
+	case ModePortfolio:
+		if len(strategy.Symbols) == 0 {
+			return fmt.Errorf("portfolio strategies require at least one symbol")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q: must be symbol or portfolio", strategy.Mode)
+	}
+	_, err := ParseMissingBarPolicy(string(strategy.MissingBars))
+	return err
+}
+
+// AlignedBars are the bars of a portfolio's symbols for one period
+type AlignedBars struct {
+	Timestamp time.Time
+	Bars      map[string]Bar
+}
+
+// PortfolioFeed aggregates the ticks or finer bars of several symbols into
+// bars of a timeframe and aligns them by timestamp. A period is handed out as
+// soon as every symbol has a bar for it, or once it has ended and is flushed,
+// in which case the missing bar policy applies. Periods are handed out in
+// order, so a complete period waits for earlier ones.
+type PortfolioFeed struct {
+	feed    *BarFeed
+	symbols []string
+	member  map[string]bool
+	policy  MissingBarPolicy
+	pending map[int64]map[string]Bar // bars of periods not handed out yet by start
+	emitted time.Time                // start of the last period handed out
+	last    map[string]Bar           // last bar handed out per symbol
+}
+
+// NewPortfolioFeed creates a feed aligning bars of the given timeframe for symbols
+func NewPortfolioFeed(timeframe string, symbols []string, policy MissingBarPolicy) (*PortfolioFeed, error) {
+	feed, err := NewBarFeed(timeframe)
+	if err != nil {
+		return nil, err
+	}
+	if policy, err = ParseMissingBarPolicy(string(policy)); err != nil {
+		return nil, err
+	}
+
+	pf := &PortfolioFeed{
+		feed:    feed,
+		member:  make(map[string]bool, len(symbols)),
+		policy:  policy,
+		pending: make(map[int64]map[string]Bar),
+		last:    make(map[string]Bar),
+	}
+	for _, symbol := range symbols {
+		if !pf.member[symbol] {
+			pf.member[symbol] = true
+			pf.symbols = append(pf.symbols, symbol)
+		}
+	}
+	sort.Strings(pf.symbols)
+	return pf, nil
+}
+
+// AddTick merges a market data update into the pending bar of its symbol and
+// returns the periods it completes. Ticks of other symbols are ignored.
+func (pf *PortfolioFeed) AddTick(data broker.MarketData) []AlignedBars {
+	if !pf.member[data.Symbol] {
+		return nil
+	}
+	if bar, completed := pf.feed.AddTick(data); completed {
+		pf.add(data.Symbol, bar)
+	}
+	return pf.ready(time.Time{}, false)
+}
+
+// AddBar merges a bar no longer than the feed's timeframe into the pending bar
+// of its symbol and returns the periods it completes
+func (pf *PortfolioFeed) AddBar(symbol string, bar Bar) []AlignedBars {
+	if !pf.member[symbol] {
+		return nil
+	}
+	if completed, ok := pf.feed.AddBar(symbol, bar); ok {
+		pf.add(symbol, completed)
+	}
+	return pf.ready(time.Time{}, false)
+}
+
+// Flush closes the bars whose period has ended by now and returns every period
+// ended by now, applying the missing bar policy. Pass the zero time to flush
+// every pending period, e.g. at the end of a backtest.
+func (pf *PortfolioFeed) Flush(now time.Time) []AlignedBars {
+	symbols, bars := pf.feed.flush(now)
+	for i, bar := range bars {
+		pf.add(symbols[i], bar)
+	}
+	return pf.ready(now, true)
+}
+
+// add records a completed bar; bars of periods already handed out are dropped
+func (pf *PortfolioFeed) add(symbol string, bar Bar) {
+	if !pf.emitted.IsZero() && !bar.Timestamp.After(pf.emitted) {
+		return
+	}
+	key := bar.Timestamp.UnixNano()
+	if pf.pending[key] == nil {
+		pf.pending[key] = make(map[string]Bar, len(pf.symbols))
+	}
+	pf.pending[key][symbol] = bar
+}
+
+// ready hands out the pending periods, oldest first, that are complete or,
+// when flushing, have ended by now
+func (pf *PortfolioFeed) ready(now time.Time, flushing bool) []AlignedBars {
+	starts := make([]int64, 0, len(pf.pending))
+	for start := range pf.pending {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var groups []AlignedBars
+	for _, start := range starts {
+		bars := pf.pending[start]
+		timestamp := time.Unix(0, start)
+		for _, bar := range bars {
+			timestamp = bar.Timestamp
+			break
+		}
+		complete := len(bars) == len(pf.symbols)
+		ended := flushing && (now.IsZero() || !now.Before(pf.feed.periodEnd(timestamp)))
+		if !complete && !ended {
+			break
+		}
+
+		delete(pf.pending, start)
+		pf.emitted = timestamp
+		if group, ok := pf.align(timestamp, bars); ok {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// align applies the missing bar policy to the bars of a period
+func (pf *PortfolioFeed) align(timestamp time.Time, bars map[string]Bar) (AlignedBars, bool) {
+	if len(bars) < len(pf.symbols) {
+		switch pf.policy {
+		case MissingBarsSkip:
+			return AlignedBars{}, false
+		case MissingBarsForwardFill:
+			for _, symbol := range pf.symbols {
+				last, seen := pf.last[symbol]
+				if _, present := bars[symbol]; present || !seen {
+					continue
+				}
+				bars[symbol] = Bar{Timestamp: timestamp, Open: last.Close, High: last.Close, Low: last.Close, Close: last.Close}
+			}
+		}
+	}
+
+	for symbol, bar := range bars {
+		pf.last[symbol] = bar
+	}
+	return AlignedBars{Timestamp: timestamp, Bars: bars}, len(bars) > 0
+}
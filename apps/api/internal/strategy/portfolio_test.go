@@ -0,0 +1,129 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var portfolioTestStart = time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+
+func portfolioTestBar(minute int, close float64) Bar {
+	return Bar{Timestamp: portfolioTestStart.Add(time.Duration(minute) * time.Minute), Open: close, High: close, Low: close, Close: close, Volume: 100}
+}
+
+func TestPortfolioFeed_AlignsBarsByTimestamp(t *testing.T) {
+	feed, err := NewPortfolioFeed("1m", []string{"MSFT", "AAPL"}, "")
+	require.NoError(t, err)
+
+	assert.Empty(t, feed.AddBar("AAPL", portfolioTestBar(0, 185)))
+	assert.Empty(t, feed.AddBar("MSFT", portfolioTestBar(0, 370)))
+	assert.Empty(t, feed.AddBar("TSLA", portfolioTestBar(1, 240)))
+	// AAPL's first bar is complete, but the period waits for MSFT
+	assert.Empty(t, feed.AddBar("AAPL", portfolioTestBar(1, 186)))
+
+	groups := feed.AddBar("MSFT", portfolioTestBar(1, 371))
+	require.Len(t, groups, 1)
+	assert.Equal(t, portfolioTestStart, groups[0].Timestamp)
+	assert.Equal(t, map[string]Bar{"AAPL": portfolioTestBar(0, 185), "MSFT": portfolioTestBar(0, 370)}, groups[0].Bars)
+
+	groups = feed.Flush(portfolioTestStart.Add(2 * time.Minute))
+	require.Len(t, groups, 1)
+	assert.Len(t, groups[0].Bars, 2)
+	assert.Empty(t, feed.AddBar("AAPL", portfolioTestBar(1, 190)), "late bars of a period handed out are dropped")
+}
+
+func TestPortfolioFeed_MissingBarPolicies(t *testing.T) {
+	second := portfolioTestStart.Add(time.Minute)
+	tests := []struct {
+		policy MissingBarPolicy
+		want   map[string]Bar // bars of the second period; nil when it is skipped
+	}{
+		{MissingBarsOmit, map[string]Bar{"AAPL": portfolioTestBar(1, 186)}},
+		{MissingBarsForwardFill, map[string]Bar{"AAPL": portfolioTestBar(1, 186), "MSFT": {Timestamp: second, Open: 370, High: 370, Low: 370, Close: 370}}},
+		{MissingBarsSkip, nil},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			feed, err := NewPortfolioFeed("1m", []string{"AAPL", "MSFT"}, tt.policy)
+			require.NoError(t, err)
+
+			var groups []AlignedBars
+			groups = append(groups, feed.AddBar("AAPL", portfolioTestBar(0, 185))...)
+			groups = append(groups, feed.AddBar("MSFT", portfolioTestBar(0, 370))...)
+			groups = append(groups, feed.AddBar("AAPL", portfolioTestBar(1, 186))...)
+			groups = append(groups, feed.AddBar("AAPL", portfolioTestBar(2, 187))...)
+			groups = append(groups, feed.AddBar("MSFT", portfolioTestBar(2, 372))...)
+			groups = append(groups, feed.Flush(time.Time{})...)
+
+			var timestamps []time.Time
+			for _, group := range groups {
+				timestamps = append(timestamps, group.Timestamp)
+				if group.Timestamp.Equal(second) {
+					assert.Equal(t, tt.want, group.Bars)
+				}
+			}
+			want := []time.Time{portfolioTestStart, second, portfolioTestStart.Add(2 * time.Minute)}
+			if tt.want == nil {
+				want = []time.Time{portfolioTestStart, portfolioTestStart.Add(2 * time.Minute)}
+			}
+			assert.Equal(t, want, timestamps)
+		})
+	}
+
+	_, err := ParseMissingBarPolicy("interpolate")
+	assert.Error(t, err)
+}
+
+func TestInstance_OnBarsKeepsIndicatorsPerSymbol(t *testing.T) {
+	se := NewStrategyEngine(nil, nil, nil)
+	code := "def on_bars(bars):\n    for symbol, bar in bars.items():\n        state.set(symbol, sma(bar.close, 2))\n"
+	strategy := &Strategy{ID: "pairs", Code: code, Mode: ModePortfolio, Symbols: []string{"AAPL", "MSFT"}}
+	require.NoError(t, se.LoadStrategy(strategy))
+
+	execution := &StrategyExecution{StrategyID: "pairs", Symbol: PortfolioSymbol, Context: context.Background()}
+	instance, err := se.newInstance(execution, strategy, se.programs["pairs"], DefaultQuota, newScriptContext(DefaultHistoryDepth))
+	require.NoError(t, err)
+
+	for i, closes := range [][2]float64{{10, 100}, {12, 102}} {
+		group := AlignedBars{Timestamp: portfolioTestBar(i, 0).Timestamp, Bars: map[string]Bar{"AAPL": portfolioTestBar(i, closes[0]), "MSFT": portfolioTestBar(i, closes[1])}}
+		require.NoError(t, instance.OnBars(context.Background(), group))
+	}
+
+	aapl, _ := instance.script.state.get("AAPL")
+	msft, _ := instance.script.state.get("MSFT")
+	assert.JSONEq(t, `11.0`, string(aapl))
+	assert.JSONEq(t, `101.0`, string(msft))
+	assert.Error(t, instance.OnBar(context.Background(), "AAPL", portfolioTestBar(2, 13)))
+}
+
+func TestStrategyEngine_PortfolioOrdersOnAlignedBars(t *testing.T) {
+	adapter := broker.NewMoomooAdapter(&config.MoomooConfig{})
+	require.NoError(t, adapter.Connect(context.Background()))
+	se := NewStrategyEngine(adapter, nil, nil)
+
+	code := "def on_bars(bars):\n    if len(bars) == 2 and bars[\"AAPL\"].close > bars[\"MSFT\"].close:\n        order(\"MSFT\", \"buy\", \"market\", 1)\n"
+	require.NoError(t, se.LoadStrategy(&Strategy{ID: "pairs", Code: code, Mode: ModePortfolio, Symbols: []string{"AAPL", "MSFT"}}))
+	assert.ErrorContains(t, se.StartStrategy(context.Background(), "pairs", "AAPL"), "portfolio")
+	require.NoError(t, se.StartStrategy(context.Background(), "pairs", PortfolioSymbol))
+	defer se.StopStrategy("pairs", PortfolioSymbol)
+
+	ticks := 0
+	require.Eventually(t, func() bool {
+		timestamp := portfolioTestStart.Add(time.Duration(ticks) * time.Minute)
+		adapter.PublishMarketData(broker.MarketData{Symbol: "AAPL", Price: 101, Volume: 1, Timestamp: timestamp})
+		adapter.PublishMarketData(broker.MarketData{Symbol: "MSFT", Price: 100, Volume: 1, Timestamp: timestamp})
+		ticks++
+		orders, err := adapter.GetOrders(context.Background())
+		return err == nil && len(orders) > 0 && orders[0].Symbol == "MSFT"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status, err := se.GetStrategyStatus("pairs", PortfolioSymbol)
+	require.NoError(t, err)
+	assert.Equal(t, ExecutionStatusRunning, status.Status)
+}
@@ -0,0 +1,442 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/indicator"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// OnBarCallback is the script function invoked for every bar
+	OnBarCallback = "on_bar"
+
+	// OnBarsCallback is the script function invoked with the aligned bars of
+	// every period in portfolio mode
+	OnBarsCallback = "on_bars"
+
+	// threadLocalExecution is the thread-local key holding the running *StrategyExecution
+	threadLocalExecution = "execution"
+
+	// threadLocalScript is the thread-local key holding the instance's *scriptContext
+	threadLocalScript = "script"
+)
+
+// Bar represents an OHLCV price bar delivered to a strategy
+type Bar struct {
+	Timestamp time.Time `json:"timestamp"`
+	Open      float64   `json:"open"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Close     float64   `json:"close"`
+	Volume    float64   `json:"volume"`
+}
+
+// CompileError describes a strategy script that failed to parse or resolve
+type CompileError struct {
+	Filename string `json:"filename"`
+	Line     int32  `json:"line"`
+	Column   int32  `json:"column"`
+	Message  string `json:"message"`
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
+}
+
+// RuntimeError wraps a Starlark evaluation error together with its stack trace
+type RuntimeError struct {
+	Err       error
+	Backtrace string
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Program represents a compiled strategy script
+type Program struct {
+	filename string
+	program  *starlark.Program
+	defs     map[string]bool // top-level functions the script defines
+}
+
+// Compile parses and resolves strategy code. Every name in predeclared is
+// treated as a global provided by the engine (builtins and parameters).
+func Compile(filename, code string, predeclared []string) (*Program, error) {
+	names := map[string]bool{seriesValueBuiltin: true, allocCheckBuiltin: true}
+	for _, name := range predeclared {
+		names[name] = true
+	}
+
+	file, err := syntax.LegacyFileOptions().Parse(filename, code, 0)
+	if err != nil {
+		return nil, newCompileError(filename, err)
+	}
+	rewriteComparisons(file)
+	rewriteAllocations(file)
+	program, err := starlark.FileProgram(file, func(name string) bool {
+		return names[name]
+	})
+	if err != nil {
+		return nil, newCompileError(filename, err)
+	}
+
+	if findDef(file, OnBarCallback) == nil && findDef(file, OnBarsCallback) == nil {
+		return nil, &CompileError{
+			Filename: filename,
+			Line:     1,
+			Column:   1,
+			Message:  fmt.Sprintf("strategy must define %s(symbol, bar) or %s(bars_by_symbol)", OnBarCallback, OnBarsCallback),
+		}
+	}
+
+	defs := make(map[string]bool)
+	for _, stmt := range file.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok {
+			defs[def.Name.Name] = true
+		}
+	}
+	return &Program{filename: filename, program: program, defs: defs}, nil
+}
+
+// requireCallback checks that the script defines the bar callback of a mode
+func (p *Program) requireCallback(mode string) error {
+	name, signature := OnBarCallback, "(symbol, bar)"
+	if mode == ModePortfolio {
+		name, signature = OnBarsCallback, "(bars_by_symbol)"
+	}
+	if p.defs[name] {
+		return nil
+	}
+	return &CompileError{
+		Filename: p.filename,
+		Line:     1,
+		Column:   1,
+		Message:  fmt.Sprintf("%s strategy must define %s%s", modeName(mode), name, signature),
+	}
+}
+
+// modeName returns the mode a strategy runs in for messages
+func modeName(mode string) string {
+	if mode == "" {
+		return ModeSymbol
+	}
+	return mode
+}
+
+// newCompileError converts a parser or resolver error into a CompileError
+func newCompileError(filename string, err error) *CompileError {
+	var syntaxErr syntax.Error
+	if errors.As(err, &syntaxErr) {
+		return &CompileError{
+			Filename: filename,
+			Line:     syntaxErr.Pos.Line,
+			Column:   syntaxErr.Pos.Col,
+			Message:  syntaxErr.Msg,
+		}
+	}
+
+	var resolveErrs resolve.ErrorList
+	if errors.As(err, &resolveErrs) && len(resolveErrs) > 0 {
+		return &CompileError{
+			Filename: filename,
+			Line:     resolveErrs[0].Pos.Line,
+			Column:   resolveErrs[0].Pos.Col,
+			Message:  resolveErrs[0].Msg,
+		}
+	}
+
+	return &CompileError{Filename: filename, Message: err.Error()}
+}
+
+// findDef returns the top-level function definition with the given name
+func findDef(file *syntax.File, name string) *syntax.DefStmt {
+	for _, stmt := range file.Stmts {
+		if def, ok := stmt.(*syntax.DefStmt); ok && def.Name.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// scriptContext is the per-instance state shared with builtins through a thread local
+type scriptContext struct {
+	symbol      string
+	bar         Bar
+	seq         int64 // incremented for every bar delivered to the instance
+	orders      int   // orders placed while processing the current bar
+	depth       int   // ring-buffer depth of each bar series
+	bars        map[string]*barSeries
+	indicators  *indicator.Cache
+	orderIDs    map[string]bool // client order IDs of orders accepted for this instance
+	timers      []*scriptTimer
+	nextTimerID int
+	clock       func() time.Time // current time as seen by the script
+	state       *scriptState
+}
+
+// newScriptContext creates a script context keeping depth bars of history per symbol
+func newScriptContext(depth int) *scriptContext {
+	if depth <= 0 {
+		depth = DefaultHistoryDepth
+	}
+	return &scriptContext{
+		depth:      depth,
+		bars:       make(map[string]*barSeries),
+		indicators: indicator.NewCache(),
+		orderIDs:   make(map[string]bool),
+		clock:      time.Now,
+		state:      newScriptState("", "", DefaultStateLimit),
+	}
+}
+
+// adopt takes over the bar history, order tracking and state of the context of
+// a replaced instance. Indicators and timers belong to the old code and start
+// afresh.
+func (sc *scriptContext) adopt(prev *scriptContext) {
+	sc.symbol, sc.bar, sc.seq, sc.orders = prev.symbol, prev.bar, prev.seq, prev.orders
+	sc.bars = prev.bars
+	sc.orderIDs = prev.orderIDs
+	sc.state = prev.state
+}
+
+// scriptContextOf returns the script context of a thread, creating a default
+// one for threads that were not set up with setScriptContext
+func scriptContextOf(thread *starlark.Thread) *scriptContext {
+	if sc, ok := thread.Local(threadLocalScript).(*scriptContext); ok {
+		return sc
+	}
+	sc := newScriptContext(DefaultHistoryDepth)
+	thread.SetLocal(threadLocalScript, sc)
+	return sc
+}
+
+// setScriptContext attaches a script context to a thread before it runs
+func setScriptContext(thread *starlark.Thread, sc *scriptContext) {
+	thread.SetLocal(threadLocalScript, sc)
+}
+
+// push records a bar for symbol and returns the bar value passed to the script
+func (sc *scriptContext) push(symbol string, bar Bar) starlark.Value {
+	series, ok := sc.bars[symbol]
+	if !ok {
+		series = newBarSeries(symbol, sc.depth)
+		sc.bars[symbol] = series
+	}
+	sc.symbol = symbol
+	sc.bar = bar
+	sc.seq++
+	sc.orders = 0
+	return series.push(bar)
+}
+
+// pushAll records the bars of a portfolio period and returns the
+// bars_by_symbol dict passed to the script. Builtins see the period rather
+// than any one symbol as the current bar.
+func (sc *scriptContext) pushAll(group AlignedBars) *starlark.Dict {
+	symbols := make([]string, 0, len(group.Bars))
+	for symbol := range group.Bars {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	bars := starlark.NewDict(len(symbols))
+	for _, symbol := range symbols {
+		bars.SetKey(starlark.String(symbol), sc.push(symbol, group.Bars[symbol]))
+	}
+	sc.symbol = ""
+	sc.bar = Bar{Timestamp: group.Timestamp}
+	sc.orders = 0
+	return bars
+}
+
+// lastClose returns the close of the latest bar recorded for symbol
+func (sc *scriptContext) lastClose(symbol string) (float64, bool) {
+	series, ok := sc.bars[symbol]
+	if !ok {
+		return 0, false
+	}
+	return series.lastClose()
+}
+
+// Instance is a program initialized for a single strategy execution
+type Instance struct {
+	thread        *starlark.Thread
+	script        *scriptContext
+	quota         Quota
+	globals       starlark.StringDict
+	onBar         starlark.Callable
+	onBars        starlark.Callable
+	onOrderFill   starlark.Callable
+	onOrderReject starlark.Callable
+	onTimer       starlark.Callable
+	callbacks     int64 // callbacks run since the instance was created
+	retained      int64 // size of the globals at the last scan
+}
+
+// NewInstance executes the program's top-level statements with the given
+// predeclared globals and resolves its callbacks. Initialization and every
+// later callback run under quota.
+func (p *Program) NewInstance(ctx context.Context, thread *starlark.Thread, predeclared starlark.StringDict, quota Quota) (*Instance, error) {
+	script := scriptContextOf(thread)
+
+	thread.SetLocal(threadLocalQuota, quota)
+
+	env := starlark.StringDict{
+		seriesValueBuiltin: starlark.NewBuiltin(seriesValueBuiltin, starlarkSeriesValue),
+		allocCheckBuiltin:  starlark.NewBuiltin(allocCheckBuiltin, starlarkAllocCheck),
+	}
+	for name, value := range predeclared {
+		env[name] = value
+	}
+
+	var globals starlark.StringDict
+	err := runWithQuota(ctx, thread, quota, func() error {
+		var err error
+		globals, err = p.program.Init(thread, env)
+		return err
+	})
+	if err != nil {
+		return nil, wrapEvalError(err)
+	}
+	retained, err := retainedSize(globals, quota)
+	if err != nil {
+		return nil, err
+	}
+
+	optional := make(map[string]starlark.Callable)
+	for _, name := range []string{OnBarCallback, OnBarsCallback, OnOrderFillCallback, OnOrderRejectCallback, OnTimerCallback} {
+		value, defined := globals[name]
+		if !defined {
+			continue
+		}
+		callback, ok := value.(starlark.Callable)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not callable", p.filename, name)
+		}
+		optional[name] = callback
+	}
+
+	return &Instance{
+		thread:        thread,
+		script:        script,
+		quota:         quota,
+		globals:       globals,
+		onBar:         optional[OnBarCallback],
+		onBars:        optional[OnBarsCallback],
+		onOrderFill:   optional[OnOrderFillCallback],
+		onOrderReject: optional[OnOrderRejectCallback],
+		onTimer:       optional[OnTimerCallback],
+		retained:      retained,
+	}, nil
+}
+
+// OnBar invokes the script's on_bar callback
+func (inst *Instance) OnBar(ctx context.Context, symbol string, bar Bar) error {
+	if inst.onBar == nil {
+		return fmt.Errorf("strategy does not define %s(symbol, bar)", OnBarCallback)
+	}
+	value := inst.script.push(symbol, bar)
+	return inst.call(ctx, inst.onBar, starlark.Tuple{starlark.String(symbol), value})
+}
+
+// OnBars invokes the script's on_bars callback with the bars of a portfolio period
+func (inst *Instance) OnBars(ctx context.Context, group AlignedBars) error {
+	if inst.onBars == nil {
+		return fmt.Errorf("strategy does not define %s(bars_by_symbol)", OnBarsCallback)
+	}
+	bars := inst.script.pushAll(group)
+	return inst.call(ctx, inst.onBars, starlark.Tuple{bars})
+}
+
+// OwnsOrder reports whether an order was placed by this instance
+func (inst *Instance) OwnsOrder(clientOrderID string) bool {
+	return inst.script.orderIDs[clientOrderID]
+}
+
+// OnOrderUpdate invokes on_order_fill or on_order_reject for an update to one
+// of the instance's own orders. Other updates are ignored.
+func (inst *Instance) OnOrderUpdate(ctx context.Context, update broker.OrderUpdate) error {
+	if !inst.OwnsOrder(update.Order.ClientOrderID) {
+		return nil
+	}
+	switch {
+	case update.Fill != nil && inst.onOrderFill != nil:
+		return inst.call(ctx, inst.onOrderFill, starlark.Tuple{newOrderFillEvent(update)})
+	case update.Order.Status == broker.OrderStatusRejected && inst.onOrderReject != nil:
+		return inst.call(ctx, inst.onOrderReject, starlark.Tuple{newOrderRejectEvent(update)})
+	}
+	return nil
+}
+
+// OnTimers runs the timers due at now. Timers registered without a function
+// call on_timer, and are skipped when the script does not define it.
+func (inst *Instance) OnTimers(ctx context.Context, now time.Time) error {
+	for _, due := range inst.script.dueTimers(now) {
+		if due.timer.removed {
+			continue
+		}
+		fn := due.timer.fn
+		if fn == nil {
+			fn = inst.onTimer
+		}
+		if fn == nil {
+			continue
+		}
+		if err := inst.call(ctx, fn, starlark.Tuple{newTimerEvent(due, now)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// call invokes a script callback under the instance quota
+func (inst *Instance) call(ctx context.Context, fn starlark.Callable, args starlark.Tuple) error {
+	err := runWithQuota(ctx, inst.thread, inst.quota, func() error {
+		_, err := starlark.Call(inst.thread, fn, args, nil)
+		return err
+	})
+	if err != nil {
+		return wrapEvalError(err)
+	}
+	return inst.checkMemoryQuota()
+}
+
+// checkMemoryQuota scans the retained globals every memoryScanInterval
+// callbacks, and after every callback once they exceed half of the quota
+func (inst *Instance) checkMemoryQuota() error {
+	inst.callbacks++
+	if inst.quota.MaxAllocBytes <= 0 {
+		return nil
+	}
+	if inst.callbacks%memoryScanInterval != 0 && inst.retained < inst.quota.MaxAllocBytes/2 {
+		return nil
+	}
+	retained, err := retainedSize(inst.globals, inst.quota)
+	inst.retained = retained
+	return err
+}
+
+// wrapEvalError keeps the Starlark stack trace of an evaluation error
+func wrapEvalError(err error) error {
+	if isQuotaError(err) {
+		return err
+	}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return &RuntimeError{Err: err, Backtrace: evalErr.Backtrace()}
+	}
+	return &RuntimeError{Err: err}
+}
@@ -0,0 +1,68 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func TestCompile_ReportsLineAndColumn(t *testing.T) {
+	code := "def on_bar(symbol, bar):\n    x = )\n"
+
+	_, err := Compile("test.star", code, nil)
+	require.Error(t, err)
+
+	var compileErr *CompileError
+	require.True(t, errors.As(err, &compileErr))
+	assert.Equal(t, int32(2), compileErr.Line)
+	assert.Greater(t, compileErr.Column, int32(0))
+}
+
+func TestCompile_RejectsUndefinedNamesAndMissingOnBar(t *testing.T) {
+	_, err := Compile("test.star", "def on_bar(symbol, bar):\n    undefined_fn()\n", nil)
+	var compileErr *CompileError
+	require.True(t, errors.As(err, &compileErr))
+	assert.Equal(t, int32(2), compileErr.Line)
+	assert.Contains(t, compileErr.Message, "undefined_fn")
+
+	_, err = Compile("test.star", "x = 1\n", nil)
+	require.True(t, errors.As(err, &compileErr))
+	assert.Contains(t, compileErr.Message, OnBarCallback)
+}
+
+func TestInstance_OnBarUsesParametersAndKeepsBacktrace(t *testing.T) {
+	code := `
+calls = []
+
+def check(bar):
+    if bar.close > limit:
+        fail("close above limit")
+
+def on_bar(symbol, bar):
+    calls.append(symbol)
+    check(bar)
+`
+	params := map[string]interface{}{"limit": 100.0}
+	program, err := Compile("test.star", code, paramNames(params))
+	require.NoError(t, err)
+
+	predeclared, err := paramGlobals(params)
+	require.NoError(t, err)
+	assert.Equal(t, starlark.MakeInt(100), predeclared["limit"])
+
+	instance, err := program.NewInstance(context.Background(), &starlark.Thread{Name: "test"}, predeclared, DefaultQuota)
+	require.NoError(t, err)
+
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 99}))
+	assert.Equal(t, 1, instance.globals["calls"].(*starlark.List).Len())
+
+	err = instance.OnBar(context.Background(), "AAPL", Bar{Close: 101})
+	var runtimeErr *RuntimeError
+	require.True(t, errors.As(err, &runtimeErr))
+	assert.Contains(t, runtimeErr.Backtrace, "in check")
+	assert.Contains(t, runtimeErr.Backtrace, "close above limit")
+}
@@ -0,0 +1,416 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// allocCheckBuiltin performs + and * after checking the size of the value
+// they build, see rewriteAllocations
+const allocCheckBuiltin = "_alloc_check"
+
+// threadLocalQuota is the thread-local key holding the instance's Quota
+const threadLocalQuota = "quota"
+
+// memoryScanInterval is how many callbacks may run between two scans of the
+// retained globals while they are below half of MaxAllocBytes
+const memoryScanInterval = 8
+
+// Quota limits the resources a single script callback may consume.
+// A zero field disables the corresponding limit.
+type Quota struct {
+	MaxSteps      uint64        `json:"max_steps"`       // Starlark execution steps per callback
+	MaxAllocBytes int64         `json:"max_alloc_bytes"` // Estimated size of the retained globals and of any value built by + or *
+	Timeout       time.Duration `json:"timeout"`         // Wall-clock limit per callback
+	MaxViolations int           `json:"max_violations"`  // Consecutive violations before the execution is stopped
+}
+
+// DefaultQuota is applied to executions unless the engine is configured otherwise
+var DefaultQuota = Quota{
+	MaxSteps:      1_000_000,
+	MaxAllocBytes: 10 << 20,
+	Timeout:       100 * time.Millisecond,
+	MaxViolations: 3,
+}
+
+// QuotaKind identifies which quota a callback exceeded
+type QuotaKind string
+
+const (
+	QuotaKindSteps   QuotaKind = "STEPS"
+	QuotaKindMemory  QuotaKind = "MEMORY"
+	QuotaKindTimeout QuotaKind = "TIMEOUT"
+)
+
+// QuotaError is returned when a callback is aborted for exceeding a quota
+type QuotaError struct {
+	Kind  QuotaKind
+	Limit string
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("strategy exceeded %s quota (%s)", e.Kind, e.Limit)
+}
+
+// totalQuotaHits counts quota violations across every execution in the process
+var totalQuotaHits atomic.Int64
+
+// TotalQuotaHits returns the number of quota violations since the process started
+func TotalQuotaHits() int64 {
+	return totalQuotaHits.Load()
+}
+
+// runWithQuota runs fn on thread under the step budget and timeout of quota.
+// The thread is cancelled when ctx is done or the timeout elapses.
+func runWithQuota(ctx context.Context, thread *starlark.Thread, quota Quota, fn func() error) error {
+	thread.Uncancel()
+	startSteps := thread.ExecutionSteps()
+	if quota.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(startSteps + quota.MaxSteps)
+	} else {
+		thread.SetMaxExecutionSteps(math.MaxUint64)
+	}
+
+	callCtx := ctx
+	if quota.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, quota.Timeout)
+		defer cancel()
+	}
+
+	var timedOut atomic.Bool
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-callCtx.Done():
+			if ctx.Err() == nil {
+				timedOut.Store(true)
+			}
+			thread.Cancel(callCtx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	close(done)
+	<-exited
+
+	if err == nil {
+		return nil
+	}
+	if timedOut.Load() {
+		return &QuotaError{Kind: QuotaKindTimeout, Limit: quota.Timeout.String()}
+	}
+	if quota.MaxSteps > 0 && ctx.Err() == nil && thread.ExecutionSteps()-startSteps >= quota.MaxSteps {
+		return &QuotaError{Kind: QuotaKindSteps, Limit: fmt.Sprintf("%d steps", quota.MaxSteps)}
+	}
+	return err
+}
+
+// retainedSize estimates the size of the script's globals. Starlark has no
+// allocation hook, so the retained heap is measured between callbacks. The
+// walk stops as soon as the limit is exceeded, even inside a list or dict,
+// and every element counts at least 8 bytes, so a scan visits at most
+// MaxAllocBytes/8 values.
+func retainedSize(globals starlark.StringDict, quota Quota) (int64, error) {
+	if quota.MaxAllocBytes <= 0 {
+		return 0, nil
+	}
+
+	seen := make(map[starlark.Value]bool)
+	var size int64
+	for _, value := range globals {
+		size += estimateSize(value, seen, quota.MaxAllocBytes-size)
+		if size > quota.MaxAllocBytes {
+			return size, &QuotaError{Kind: QuotaKindMemory, Limit: fmt.Sprintf("%d bytes", quota.MaxAllocBytes)}
+		}
+	}
+	return size, nil
+}
+
+// quotaOf returns the quota of the instance running on a thread
+func quotaOf(thread *starlark.Thread) Quota {
+	if quota, ok := thread.Local(threadLocalQuota).(Quota); ok {
+		return quota
+	}
+	return DefaultQuota
+}
+
+// sequenceSize returns the length of a string, bytes, list or tuple together
+// with the estimated bytes per element
+func sequenceSize(v starlark.Value) (length, unit int64, ok bool) {
+	switch v := v.(type) {
+	case starlark.String:
+		return int64(len(v)), 1, true
+	case starlark.Bytes:
+		return int64(len(v)), 1, true
+	case *starlark.List:
+		return int64(v.Len()), 8, true
+	case starlark.Tuple:
+		return int64(len(v)), 8, true
+	}
+	return 0, 0, false
+}
+
+// allocationSize estimates the bytes allocated by x op y when it builds a
+// string, bytes, list or tuple, and returns 0 for any other operation
+func allocationSize(op string, x, y starlark.Value) int64 {
+	switch op {
+	case "*", "*=":
+		seq, count := x, y
+		if _, _, ok := sequenceSize(seq); !ok {
+			seq, count = y, x
+		}
+		length, unit, ok := sequenceSize(seq)
+		n, isInt := count.(starlark.Int)
+		if !ok || !isInt {
+			return 0
+		}
+		times, exact := n.Int64()
+		if !exact || times <= 0 {
+			return 0
+		}
+		if length > 0 && times > math.MaxInt64/(length*unit) {
+			return math.MaxInt64
+		}
+		return length * unit * times
+	case "+", "+=":
+		xLen, unit, ok := sequenceSize(x)
+		yLen, _, yOK := sequenceSize(y)
+		if !ok || !yOK {
+			return 0
+		}
+		return (xLen + yLen) * unit
+	}
+	return 0
+}
+
+// starlarkAllocCheck implements the builtin inserted by rewriteAllocations.
+// For "+" and "*" it returns x op y; for "+=" and "*=" it only checks the
+// size of the augmented assignment and returns y for the assignment to apply.
+func starlarkAllocCheck(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		op   string
+		x, y starlark.Value
+	)
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 3, &op, &x, &y); err != nil {
+		return nil, err
+	}
+
+	quota := quotaOf(thread)
+	if quota.MaxAllocBytes > 0 && allocationSize(op, x, y) > quota.MaxAllocBytes {
+		return nil, &QuotaError{Kind: QuotaKindMemory, Limit: fmt.Sprintf("%d bytes", quota.MaxAllocBytes)}
+	}
+
+	switch op {
+	case "+":
+		return starlark.Binary(syntax.PLUS, x, y)
+	case "*":
+		return starlark.Binary(syntax.STAR, x, y)
+	default:
+		return y, nil
+	}
+}
+
+// rewriteAllocations routes every + and * that may build a sequence, and
+// every += and *= on a plain name, through allocCheckBuiltin so that a
+// single expression such as [0] * 100000000 cannot allocate past the memory
+// quota in one step. Values built by other builtins, such as
+// list(range(n)) or str.join, are only caught by the scan of the globals.
+func rewriteAllocations(file *syntax.File) {
+	opName := map[syntax.Token]string{
+		syntax.PLUS:    "+",
+		syntax.STAR:    "*",
+		syntax.PLUS_EQ: "+=",
+		syntax.STAR_EQ: "*=",
+	}
+	call := func(pos syntax.Position, op string, x, y syntax.Expr) syntax.Expr {
+		_, end := y.Span()
+		return &syntax.CallExpr{
+			Fn:     &syntax.Ident{NamePos: pos, Name: allocCheckBuiltin},
+			Lparen: pos,
+			Args:   []syntax.Expr{&syntax.Literal{Token: syntax.STRING, TokenPos: pos, Raw: fmt.Sprintf("%q", op), Value: op}, x, y},
+			Rparen: end,
+		}
+	}
+	rewrite := func(e *syntax.Expr) {
+		bin, ok := (*e).(*syntax.BinaryExpr)
+		if !ok || !mayAllocate(bin) {
+			return
+		}
+		start, _ := bin.Span()
+		*e = call(start, opName[bin.Op], bin.X, bin.Y)
+	}
+	rewriteAll := func(exprs []syntax.Expr) {
+		for i := range exprs {
+			rewrite(&exprs[i])
+		}
+	}
+
+	syntax.Walk(file, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.AssignStmt:
+			if ident, ok := n.LHS.(*syntax.Ident); ok && (n.Op == syntax.PLUS_EQ || n.Op == syntax.STAR_EQ) {
+				n.RHS = call(n.OpPos, opName[n.Op], &syntax.Ident{NamePos: ident.NamePos, Name: ident.Name}, n.RHS)
+				return true
+			}
+			rewrite(&n.RHS)
+		case *syntax.BinaryExpr:
+			rewrite(&n.X)
+			rewrite(&n.Y)
+		case *syntax.CallExpr:
+			rewriteAll(n.Args)
+		case *syntax.CondExpr:
+			rewrite(&n.Cond)
+			rewrite(&n.True)
+			rewrite(&n.False)
+		case *syntax.Comprehension:
+			rewrite(&n.Body)
+		case *syntax.DictEntry:
+			rewrite(&n.Key)
+			rewrite(&n.Value)
+		case *syntax.DotExpr:
+			rewrite(&n.X)
+		case *syntax.ExprStmt:
+			rewrite(&n.X)
+		case *syntax.ForClause:
+			rewrite(&n.X)
+		case *syntax.ForStmt:
+			rewrite(&n.X)
+		case *syntax.IfClause:
+			rewrite(&n.Cond)
+		case *syntax.IfStmt:
+			rewrite(&n.Cond)
+		case *syntax.IndexExpr:
+			rewrite(&n.X)
+			rewrite(&n.Y)
+		case *syntax.LambdaExpr:
+			rewrite(&n.Body)
+		case *syntax.ListExpr:
+			rewriteAll(n.List)
+		case *syntax.ParenExpr:
+			rewrite(&n.X)
+		case *syntax.ReturnStmt:
+			if n.Result != nil {
+				rewrite(&n.Result)
+			}
+		case *syntax.SliceExpr:
+			for _, e := range []*syntax.Expr{&n.X, &n.Lo, &n.Hi, &n.Step} {
+				if *e != nil {
+					rewrite(e)
+				}
+			}
+		case *syntax.TupleExpr:
+			rewriteAll(n.List)
+		case *syntax.UnaryExpr:
+			if n.X != nil {
+				rewrite(&n.X)
+			}
+		case *syntax.WhileStmt:
+			rewrite(&n.Cond)
+		}
+		return true
+	})
+}
+
+// mayAllocate reports whether a binary expression is a + or * that could
+// build a sequence. Arithmetic on numeric literals is left alone.
+func mayAllocate(bin *syntax.BinaryExpr) bool {
+	numeric := func(e syntax.Expr) bool {
+		lit, ok := e.(*syntax.Literal)
+		return ok && (lit.Token == syntax.INT || lit.Token == syntax.FLOAT)
+	}
+	switch bin.Op {
+	case syntax.PLUS:
+		return !numeric(bin.X) && !numeric(bin.Y)
+	case syntax.STAR:
+		return !(numeric(bin.X) && numeric(bin.Y))
+	}
+	return false
+}
+
+// estimateSize approximates the memory retained by a Starlark value. It
+// returns as soon as the size exceeds budget, so the size of a value larger
+// than its budget is only known to be over it.
+func estimateSize(value starlark.Value, seen map[starlark.Value]bool, budget int64) int64 {
+	switch v := value.(type) {
+	case starlark.String:
+		return int64(len(v)) + 16
+	case starlark.Bytes:
+		return int64(len(v)) + 16
+	case *starlark.List:
+		if seen[v] {
+			return 0
+		}
+		seen[v] = true
+		size := int64(24)
+		for i := 0; i < v.Len() && size <= budget; i++ {
+			size += 8 + estimateSize(v.Index(i), seen, budget-size-8)
+		}
+		return size
+	case starlark.Tuple:
+		size := int64(24)
+		for i := 0; i < len(v) && size <= budget; i++ {
+			size += 8 + estimateSize(v[i], seen, budget-size-8)
+		}
+		return size
+	case *starlark.Dict:
+		if seen[v] {
+			return 0
+		}
+		seen[v] = true
+		size := int64(48)
+		iter := v.Iterate()
+		defer iter.Done()
+		var key starlark.Value
+		for size <= budget && iter.Next(&key) {
+			size += 16 + estimateSize(key, seen, budget-size-16)
+			if size <= budget {
+				item, _, _ := v.Get(key)
+				size += estimateSize(item, seen, budget-size)
+			}
+		}
+		return size
+	case *starlark.Set:
+		if seen[v] {
+			return 0
+		}
+		seen[v] = true
+		size := int64(48)
+		iter := v.Iterate()
+		defer iter.Done()
+		var elem starlark.Value
+		for size <= budget && iter.Next(&elem) {
+			size += 16 + estimateSize(elem, seen, budget-size-16)
+		}
+		return size
+	case *starlarkstruct.Struct:
+		size := int64(24)
+		for _, name := range v.AttrNames() {
+			if size > budget {
+				break
+			}
+			attr, _ := v.Attr(name)
+			size += 16 + estimateSize(attr, seen, budget-size-16)
+		}
+		return size
+	default:
+		return 16
+	}
+}
+
+// isQuotaError reports whether err was caused by exceeding a quota
+func isQuotaError(err error) bool {
+	var quotaErr *QuotaError
+	return errors.As(err, &quotaErr)
+}
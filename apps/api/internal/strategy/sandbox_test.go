@@ -0,0 +1,162 @@
+package strategy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func newTestInstance(t *testing.T, code string, predeclared starlark.StringDict, quota Quota) *Instance {
+	t.Helper()
+	program, err := Compile("test.star", code, globalNames(predeclared))
+	require.NoError(t, err)
+	instance, err := program.NewInstance(context.Background(), &starlark.Thread{Name: "test"}, predeclared, quota)
+	require.NoError(t, err)
+	return instance
+}
+
+func requireQuotaKind(t *testing.T, err error, kind QuotaKind) {
+	t.Helper()
+	var quotaErr *QuotaError
+	require.True(t, errors.As(err, &quotaErr), "expected quota error, got %v", err)
+	assert.Equal(t, kind, quotaErr.Kind)
+}
+
+func TestSandbox_StepQuotaAbortsRunawayLoop(t *testing.T) {
+	code := `
+def on_bar(symbol, bar):
+    if bar.close > 0:
+        for i in range(100000000):
+            pass
+`
+	instance := newTestInstance(t, code, nil, Quota{MaxSteps: 10000})
+
+	requireQuotaKind(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 1}), QuotaKindSteps)
+
+	// The budget is per callback, so the next cheap callback succeeds
+	assert.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 0}))
+}
+
+func TestSandbox_MemoryQuotaChecksGlobals(t *testing.T) {
+	code := `
+history = []
+
+def on_bar(symbol, bar):
+    history.append("x" * 1024)
+`
+	instance := newTestInstance(t, code, nil, Quota{MaxAllocBytes: 4096})
+
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{}))
+	// The globals are scanned every memoryScanInterval callbacks until they
+	// reach half of the quota, then after every callback
+	var err error
+	for i := 0; i < memoryScanInterval+4 && err == nil; i++ {
+		err = instance.OnBar(context.Background(), "AAPL", Bar{})
+	}
+	requireQuotaKind(t, err, QuotaKindMemory)
+}
+
+func TestRetainedSize_StopsInsideALargeValueAtTheLimit(t *testing.T) {
+	elems := make([]starlark.Value, 1000000)
+	for i := range elems {
+		elems[i] = starlark.MakeInt(i)
+	}
+	nested := starlark.NewDict(1)
+	require.NoError(t, nested.SetKey(starlark.String("history"), starlark.NewList(elems)))
+
+	size, err := retainedSize(starlark.StringDict{"state": nested}, Quota{MaxAllocBytes: 1024})
+
+	requireQuotaKind(t, err, QuotaKindMemory)
+	assert.Less(t, size, int64(1100), "the walk stops at the first element over the limit")
+}
+
+func TestSandbox_MemoryQuotaChecksSingleAllocations(t *testing.T) {
+	for _, expr := range []string{
+		`x = [0] * 100000000`,
+		`x = "a" * (1 << 29)`,
+		`x = 1000 * ("ab",)`,
+		`x = "a" * 3000 + "b" * 3000`,
+		`x = [1]
+    for i in range(30):
+        x += x`,
+		`x = "ab"
+    for i in range(30):
+        x *= 2`,
+	} {
+		instance := newTestInstance(t, "def on_bar(symbol, bar):\n    "+expr+"\n", nil, Quota{MaxAllocBytes: 4096})
+		requireQuotaKind(t, instance.OnBar(context.Background(), "AAPL", Bar{}), QuotaKindMemory)
+	}
+
+	// Arithmetic and small sequences are unaffected
+	instance := newTestInstance(t, `
+out = []
+
+def on_bar(symbol, bar):
+    n = bar.close * 2 + 1
+    s = "a" * 10 + "b"
+    l = [n] * 3 + [s]
+    l += [1]
+    out.append((n, len(s), len(l)))
+`, nil, Quota{MaxAllocBytes: 4096})
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 2}))
+	assert.Equal(t, `[(5.0, 11, 5)]`, instance.globals["out"].String())
+}
+
+func TestSandbox_TimeoutCancelsCallback(t *testing.T) {
+	sleep := starlark.NewBuiltin("sleep", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		time.Sleep(5 * time.Millisecond)
+		return starlark.None, nil
+	})
+	code := `
+def on_bar(symbol, bar):
+    for i in range(1000):
+        sleep()
+`
+	instance := newTestInstance(t, code, starlark.StringDict{"sleep": sleep}, Quota{Timeout: 20 * time.Millisecond})
+
+	requireQuotaKind(t, instance.OnBar(context.Background(), "AAPL", Bar{}), QuotaKindTimeout)
+}
+
+func TestStrategyEngine_RecordQuotaHitStopsAfterConsecutiveViolations(t *testing.T) {
+	se := NewStrategyEngine(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	execution := &StrategyExecution{StrategyID: "s1", Symbol: "AAPL", Context: ctx, Cancel: cancel, Status: ExecutionStatusRunning}
+	quota := Quota{MaxViolations: 2}
+	quotaErr := &QuotaError{Kind: QuotaKindSteps}
+
+	assert.False(t, se.recordQuotaHit(execution, quotaErr, quota))
+	execution.resetViolations()
+	assert.False(t, se.recordQuotaHit(execution, quotaErr, quota))
+	assert.True(t, se.recordQuotaHit(execution, quotaErr, quota))
+
+	assert.Equal(t, ExecutionStatusError, execution.Status)
+	assert.Equal(t, int64(3), execution.QuotaHits)
+	assert.Equal(t, int64(3), se.GetQuotaHits("s1"))
+	assert.Error(t, ctx.Err())
+}
+
+func TestStrategyEngine_StopStrategyDoesNotRaceWithFailingExecution(t *testing.T) {
+	se := NewStrategyEngine(nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	execution := &StrategyExecution{StrategyID: "s1", Symbol: "AAPL", Context: ctx, Cancel: cancel, Status: ExecutionStatusRunning}
+	se.executions["s1_AAPL"] = execution
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		execution.fail(errors.New("boom"))
+	}()
+	status, err := se.GetStrategyStatus("s1", "AAPL")
+	require.NoError(t, err)
+	assert.Equal(t, "AAPL", status.Symbol)
+	require.NoError(t, se.StopStrategy("s1", "AAPL"))
+	<-done
+
+	_, err = se.GetStrategyStatus("s1", "AAPL")
+	assert.Error(t, err)
+}
@@ -0,0 +1,288 @@
+package strategy
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// DefaultHistoryDepth is the number of bars of each series kept per symbol
+// unless an execution is configured otherwise
+const DefaultHistoryDepth = 500
+
+// seriesValueBuiltin unwraps series operands of comparisons, see rewriteComparisons
+const seriesValueBuiltin = "_series_value"
+
+// ring is a fixed-depth buffer addressed by absolute bar number
+type ring struct {
+	values []float64
+	count  int64 // number of values ever pushed
+}
+
+func newRing(depth int) *ring {
+	if depth < 1 {
+		depth = 1
+	}
+	return &ring{values: make([]float64, depth)}
+}
+
+func (r *ring) push(v float64) {
+	r.values[r.count%int64(len(r.values))] = v
+	r.count++
+}
+
+// oldest returns the absolute number of the oldest value still retained
+func (r *ring) oldest() int64 {
+	if r.count <= int64(len(r.values)) {
+		return 0
+	}
+	return r.count - int64(len(r.values))
+}
+
+func (r *ring) at(abs int64) float64 {
+	return r.values[abs%int64(len(r.values))]
+}
+
+// Series is the Starlark value for one OHLCV field of a symbol. It behaves
+// as its current value in arithmetic, comparisons and str(), and as a
+// history indexed by lookback: s[0] is the current bar, s[1] the previous
+// one and s[-1] the oldest retained. Slicing returns a detached snapshot.
+type Series struct {
+	name   string
+	symbol string // symbol of a ring-backed series
+	ring   *ring
+	end    int64     // absolute number after the series' current value; ring-backed series only
+	snap   []float64 // newest first; snapshots only
+}
+
+var (
+	_ starlark.Sliceable = (*Series)(nil)
+	_ starlark.HasBinary = (*Series)(nil)
+	_ starlark.HasUnary  = (*Series)(nil)
+	_ starlark.Iterable  = (*Series)(nil)
+)
+
+func (s *Series) String() string {
+	if s.Len() == 0 {
+		return "None"
+	}
+	return s.current().String()
+}
+
+func (s *Series) Type() string { return "series" }
+
+func (s *Series) Freeze() {}
+
+func (s *Series) Truth() starlark.Bool {
+	return s.Len() > 0 && s.current() != 0
+}
+
+func (s *Series) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: series")
+}
+
+// Len returns the number of bars available for lookback
+func (s *Series) Len() int {
+	if s.ring == nil {
+		return len(s.snap)
+	}
+	n := s.end - s.ring.oldest()
+	if n < 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// Index returns the value i bars back from the series' current bar
+func (s *Series) Index(i int) starlark.Value {
+	return starlark.Float(s.lookback(i))
+}
+
+func (s *Series) lookback(i int) float64 {
+	if s.ring == nil {
+		return s.snap[i]
+	}
+	return s.ring.at(s.end - 1 - int64(i))
+}
+
+func (s *Series) Slice(start, end, step int) starlark.Value {
+	var values []float64
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		values = append(values, s.lookback(i))
+	}
+	return &Series{name: s.name, snap: values}
+}
+
+func (s *Series) Iterate() starlark.Iterator {
+	return &seriesIterator{series: s}
+}
+
+// current returns the value at the series' current bar
+func (s *Series) current() starlark.Float {
+	return starlark.Float(s.lookback(0))
+}
+
+// missed returns how many bars before the current one were not seen by an
+// indicator last fed at bar number last (-1 if never), limited to the
+// retained history
+func (s *Series) missed(last int64) int {
+	if last < 0 {
+		last = 0
+	}
+	n := int(s.end - 1 - last)
+	if n > s.Len()-1 {
+		n = s.Len() - 1
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// history returns the retained values oldest first
+func (s *Series) history() []float64 {
+	n := s.Len()
+	values := make([]float64, n)
+	for i := 0; i < n; i++ {
+		values[n-1-i] = s.lookback(i)
+	}
+	return values
+}
+
+// detached reports whether the series is a snapshot rather than a live view of a symbol's bars
+func (s *Series) detached() bool {
+	return s.ring == nil
+}
+
+func (s *Series) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	if s.Len() == 0 {
+		return nil, fmt.Errorf("series %s is empty", s.name)
+	}
+	y = seriesValue(y)
+	if side == starlark.Left {
+		return starlark.Binary(op, s.current(), y)
+	}
+	return starlark.Binary(op, y, s.current())
+}
+
+func (s *Series) Unary(op syntax.Token) (starlark.Value, error) {
+	if s.Len() == 0 {
+		return nil, fmt.Errorf("series %s is empty", s.name)
+	}
+	return starlark.Unary(op, s.current())
+}
+
+type seriesIterator struct {
+	series *Series
+	i      int
+}
+
+func (it *seriesIterator) Next(p *starlark.Value) bool {
+	if it.i >= it.series.Len() {
+		return false
+	}
+	*p = it.series.Index(it.i)
+	it.i++
+	return true
+}
+
+func (it *seriesIterator) Done() {}
+
+// seriesValue returns the current value of a non-empty series and any other value unchanged
+func seriesValue(v starlark.Value) starlark.Value {
+	if s, ok := v.(*Series); ok && s.Len() > 0 {
+		return s.current()
+	}
+	return v
+}
+
+// asFloat is starlark.AsFloat that also accepts a series as its current value
+func asFloat(v starlark.Value) (float64, bool) {
+	return starlark.AsFloat(seriesValue(v))
+}
+
+// starlarkSeriesValue implements the builtin inserted by rewriteComparisons
+func starlarkSeriesValue(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	return seriesValue(v), nil
+}
+
+// rewriteComparisons wraps the operands of every comparison in a call to
+// seriesValueBuiltin. Starlark only compares values of the same type and
+// offers no hook for mixed operands, so this is what lets `bar.close > x`
+// compare the series' current value.
+func rewriteComparisons(file *syntax.File) {
+	wrap := func(x syntax.Expr) syntax.Expr {
+		if _, ok := x.(*syntax.Literal); ok {
+			return x
+		}
+		start, end := x.Span()
+		return &syntax.CallExpr{
+			Fn:     &syntax.Ident{NamePos: start, Name: seriesValueBuiltin},
+			Lparen: start,
+			Args:   []syntax.Expr{x},
+			Rparen: end,
+		}
+	}
+	syntax.Walk(file, func(n syntax.Node) bool {
+		if bin, ok := n.(*syntax.BinaryExpr); ok {
+			switch bin.Op {
+			case syntax.EQL, syntax.NEQ, syntax.LT, syntax.GT, syntax.LE, syntax.GE:
+				bin.X = wrap(bin.X)
+				bin.Y = wrap(bin.Y)
+			}
+		}
+		return true
+	})
+}
+
+// barSeries holds the OHLCV history of one symbol
+type barSeries struct {
+	symbol                         string
+	open, high, low, close, volume *ring
+}
+
+func newBarSeries(symbol string, depth int) *barSeries {
+	return &barSeries{
+		symbol: symbol,
+		open:   newRing(depth),
+		high:   newRing(depth),
+		low:    newRing(depth),
+		close:  newRing(depth),
+		volume: newRing(depth),
+	}
+}
+
+// lastClose returns the close of the latest bar pushed
+func (bs *barSeries) lastClose() (float64, bool) {
+	if bs.close.count == 0 {
+		return 0, false
+	}
+	return bs.close.at(bs.close.count - 1), true
+}
+
+// push appends a bar and returns the value passed to the script for it
+func (bs *barSeries) push(bar Bar) starlark.Value {
+	bs.open.push(bar.Open)
+	bs.high.push(bar.High)
+	bs.low.push(bar.Low)
+	bs.close.push(bar.Close)
+	bs.volume.push(bar.Volume)
+
+	view := func(name string, r *ring) *Series {
+		return &Series{name: name, symbol: bs.symbol, ring: r, end: r.count}
+	}
+	return starlarkstruct.FromStringDict(starlark.String("bar"), starlark.StringDict{
+		"timestamp": starlark.MakeInt64(bar.Timestamp.Unix()),
+		"open":      view("open", bs.open),
+		"high":      view("high", bs.high),
+		"low":       view("low", bs.low),
+		"close":     view("close", bs.close),
+		"volume":    view("volume", bs.volume),
+	})
+}
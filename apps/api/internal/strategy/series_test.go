@@ -0,0 +1,104 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func TestSeries_LookbackLenAndSlicing(t *testing.T) {
+	code := `
+out = {}
+
+def on_bar(symbol, bar):
+    if len(bar.close) < 2:
+        return
+    out["current"] = bar.close + 0
+    out["prev"] = bar.close[1]
+    out["oldest"] = bar.close[-1]
+    out["len"] = len(bar.close)
+    out["recent"] = list(bar.close[:2])
+    out["above"] = bar.close > 3 and 3 < bar.close
+    out["str"] = str(bar.close)
+`
+	predeclared := starlark.StringDict{}
+	program, err := Compile("test.star", code, nil)
+	require.NoError(t, err)
+	thread := &starlark.Thread{Name: "test"}
+	setScriptContext(thread, newScriptContext(3))
+	instance, err := program.NewInstance(context.Background(), thread, predeclared, DefaultQuota)
+	require.NoError(t, err)
+
+	for _, close := range []float64{1, 2, 3, 4, 5} {
+		require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: close}))
+	}
+
+	out := instance.globals["out"].(*starlark.Dict)
+	get := func(key string) starlark.Value {
+		value, _, err := out.Get(starlark.String(key))
+		require.NoError(t, err)
+		return value
+	}
+	assert.Equal(t, starlark.Float(5), get("current"))
+	assert.Equal(t, starlark.Float(4), get("prev"))
+	assert.Equal(t, starlark.Float(3), get("oldest"), "depth 3 keeps bars 3..5")
+	assert.Equal(t, starlark.MakeInt(3), get("len"))
+	assert.Equal(t, "[5.0, 4.0]", get("recent").String())
+	assert.Equal(t, starlark.True, get("above"))
+	assert.Equal(t, starlark.String("5.0"), get("str"))
+}
+
+func TestSeries_LookbackBeyondHistoryFails(t *testing.T) {
+	code := `
+def on_bar(symbol, bar):
+    return bar.close[5]
+`
+	instance := newTestInstance(t, code, nil, DefaultQuota)
+	err := instance.OnBar(context.Background(), "AAPL", Bar{Close: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "out of range")
+}
+
+func TestSeries_MomentumTemplateRuns(t *testing.T) {
+	params := map[string]interface{}{"lookback_period": 2, "threshold": 1.0, "quantity": 1}
+	bf := &BuiltinFunctions{}
+	program, err := Compile("momentum.star", StrategyTemplates["momentum"], append(globalNames(bf.Globals()), paramNames(params)...))
+	require.NoError(t, err)
+
+	predeclared, err := paramGlobals(params)
+	require.NoError(t, err)
+	for name, value := range bf.Globals() {
+		predeclared[name] = value
+	}
+	instance, err := program.NewInstance(context.Background(), &starlark.Thread{Name: "test"}, predeclared, DefaultQuota)
+	require.NoError(t, err)
+
+	for _, close := range []float64{10, 11, 12, 13, 12} {
+		require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: close}))
+	}
+}
+
+func TestSeries_IndicatorsStreamAndBatch(t *testing.T) {
+	code := `
+out = {}
+
+def on_bar(symbol, bar):
+    out["streamed"] = sma(bar.close, 3)
+    out["history"] = ind.sma(bar.close, 3)
+    out["window"] = ind.ema(bar.close[:3], 3)
+`
+	instance := newTestInstance(t, code, indicatorGlobals(), DefaultQuota)
+	for _, close := range []float64{1, 2, 3, 4, 5} {
+		require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: close}))
+	}
+
+	out := instance.globals["out"].(*starlark.Dict)
+	for _, key := range []string{"streamed", "history", "window"} {
+		value, _, err := out.Get(starlark.String(key))
+		require.NoError(t, err)
+		assert.Equal(t, starlark.Float(4), value, key)
+	}
+}
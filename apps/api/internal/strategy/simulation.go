@@ -0,0 +1,139 @@
+package strategy
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"go.starlark.net/starlark"
+)
+
+// simulationThreadName names the thread of simulations of strategies without
+// an ID. Like the strategy ID otherwise, it seeds client order IDs.
+const simulationThreadName = "simulation"
+
+// SimulatedBroker is the broker a simulation trades through. Updates it
+// produces while the script processes a bar are delivered to on_order_fill
+// and on_order_reject before the next bar.
+type SimulatedBroker interface {
+	OrderRouter
+	PositionSource
+
+	// NextUpdate pops the oldest order update not yet delivered to the script
+	NextUpdate() (broker.OrderUpdate, bool)
+}
+
+// Simulation runs a strategy's code on bars fed by the caller rather than by
+// live market data, with orders going to a simulated broker and the script's
+// clock following the bars. Backtests run strategies through it; given the
+// same bars and broker, it places the same orders every time.
+type Simulation struct {
+	strategy *Strategy
+	instance *Instance
+	script   *scriptContext
+	broker   SimulatedBroker
+}
+
+// NewSimulation compiles a strategy against the builtins and its parameters
+// and initializes the script. Callbacks run under quota without its timeout,
+// so a result does not depend on how busy the machine was. Compile errors are
+// returned as *CompileError.
+func NewSimulation(ctx context.Context, strategy *Strategy, sim SimulatedBroker, quota Quota) (*Simulation, error) {
+	if err := validateMode(strategy); err != nil {
+		return nil, err
+	}
+
+	bf := &BuiltinFunctions{broker: sim, positions: sim}
+	predeclared := bf.Globals()
+	params, err := paramGlobals(strategy.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range params {
+		predeclared[name] = value
+	}
+
+	name := strategy.ID
+	if name == "" {
+		name = simulationThreadName
+	}
+	program, err := Compile(name+".star", strategy.Code, globalNames(predeclared))
+	if err == nil {
+		err = program.requireCallback(strategy.Mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thread := &starlark.Thread{
+		Name:  name,
+		Print: func(_ *starlark.Thread, msg string) { bf.Log(msg) },
+	}
+	script := newScriptContext(DefaultHistoryDepth)
+	script.state = newScriptState(name, "", DefaultStateLimit)
+	script.clock = func() time.Time { return time.Time{} }
+	setScriptContext(thread, script)
+
+	quota.Timeout = 0
+	instance, err := program.NewInstance(ctx, thread, predeclared, quota)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulation{strategy: strategy, instance: instance, script: script, broker: sim}, nil
+}
+
+// Step feeds the bars of a period to the script. The callbacks of order
+// updates the broker produced before the period run first, then the timers
+// due at the period, then on_bars with all of the bars in portfolio mode, or
+// on_bar for each symbol in symbol order otherwise, each followed by the
+// callbacks of the updates it caused.
+func (s *Simulation) Step(ctx context.Context, bars AlignedBars) error {
+	now := bars.Timestamp
+	s.script.clock = func() time.Time { return now }
+
+	if err := s.deliverUpdates(ctx); err != nil {
+		return err
+	}
+	if err := s.instance.OnTimers(ctx, now); err != nil {
+		return err
+	}
+	if err := s.deliverUpdates(ctx); err != nil {
+		return err
+	}
+
+	if s.strategy.IsPortfolio() {
+		if err := s.instance.OnBars(ctx, bars); err != nil {
+			return err
+		}
+		return s.deliverUpdates(ctx)
+	}
+
+	symbols := make([]string, 0, len(bars.Bars))
+	for symbol := range bars.Bars {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		if err := s.instance.OnBar(ctx, symbol, bars.Bars[symbol]); err != nil {
+			return err
+		}
+		if err := s.deliverUpdates(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliverUpdates passes the pending order updates of the broker to the script
+func (s *Simulation) deliverUpdates(ctx context.Context) error {
+	for {
+		update, ok := s.broker.NextUpdate()
+		if !ok {
+			return nil
+		}
+		if err := s.instance.OnOrderUpdate(ctx, update); err != nil {
+			return err
+		}
+	}
+}
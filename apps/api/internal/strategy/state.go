@@ -0,0 +1,523 @@
+package strategy
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+	goredis "github.com/redis/go-redis/v9"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// stateModule is the global exposing the persistent state store to scripts
+const stateModule = "state"
+
+// DefaultStateLimit caps the encoded size of the state of one execution
+const DefaultStateLimit = 64 << 10
+
+// maxStateDepth caps the nesting of values stored with state.set
+const maxStateDepth = 32
+
+// stateSnapshotInterval is how often running executions snapshot their state to MySQL
+const stateSnapshotInterval = time.Minute
+
+// stateKeyPrefix prefixes the Redis keys holding the hot copy of each state
+const stateKeyPrefix = "strategy_state"
+
+// scriptState holds the persistent values of one strategy and symbol as
+// encoded JSON, so reads always return a fresh copy
+type scriptState struct {
+	strategyID string
+	symbol     string
+	limit      int
+	mu         sync.Mutex
+	values     map[string]json.RawMessage
+	size       int
+	dirty      bool // changed since the last Redis write
+	unsaved    bool // changed since the last MySQL snapshot
+}
+
+// newScriptState creates an empty state capped at limit bytes
+func newScriptState(strategyID, symbol string, limit int) *scriptState {
+	if limit <= 0 {
+		limit = DefaultStateLimit
+	}
+	return &scriptState{
+		strategyID: strategyID,
+		symbol:     symbol,
+		limit:      limit,
+		values:     make(map[string]json.RawMessage),
+	}
+}
+
+// load replaces the values with a stored JSON object
+func (st *scriptState) load(data []byte) error {
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid state for %s on %s: %w", st.strategyID, st.symbol, err)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.values = values
+	st.size = 0
+	for key, value := range values {
+		st.size += len(key) + len(value)
+	}
+	return nil
+}
+
+// get returns the encoded value stored under key
+func (st *scriptState) get(key string) (json.RawMessage, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	value, ok := st.values[key]
+	return value, ok
+}
+
+// set stores an encoded value, failing if the state would exceed its limit
+func (st *scriptState) set(key string, value json.RawMessage) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	size := st.size + len(key) + len(value)
+	if old, ok := st.values[key]; ok {
+		size -= len(key) + len(old)
+	}
+	if size > st.limit {
+		return fmt.Errorf("state size %d bytes exceeds limit of %d bytes", size, st.limit)
+	}
+	st.values[key] = value
+	st.size = size
+	st.dirty, st.unsaved = true, true
+	return nil
+}
+
+// delete removes key and reports whether it was set
+func (st *scriptState) delete(key string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	old, ok := st.values[key]
+	if ok {
+		delete(st.values, key)
+		st.size -= len(key) + len(old)
+		st.dirty, st.unsaved = true, true
+	}
+	return ok
+}
+
+// keys returns the stored keys in sorted order
+func (st *scriptState) keys() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	keys := make([]string, 0, len(st.values))
+	for key := range st.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// snapshotValues returns a copy of the stored values
+func (st *scriptState) snapshotValues() map[string]json.RawMessage {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	values := make(map[string]json.RawMessage, len(st.values))
+	for key, value := range st.values {
+		values[key] = value
+	}
+	return values
+}
+
+// reset removes every value
+func (st *scriptState) reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.values = make(map[string]json.RawMessage)
+	st.size = 0
+	st.dirty, st.unsaved = true, true
+}
+
+// encode returns the state as a JSON object
+func (st *scriptState) encode() ([]byte, int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	data, err := json.Marshal(st.values)
+	return data, st.size, err
+}
+
+// encodeStateValue writes v as JSON, failing unless it is made only of None,
+// bools, ints, finite floats, strings, lists, tuples and dicts with string
+// keys. Floats keep a decimal point so they decode as floats again.
+func encodeStateValue(buf *bytes.Buffer, v starlark.Value, depth int) error {
+	if depth > maxStateDepth {
+		return fmt.Errorf("value nested deeper than %d levels", maxStateDepth)
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		buf.WriteString("null")
+	case starlark.Bool:
+		buf.WriteString(strconv.FormatBool(bool(v)))
+	case starlark.Int:
+		buf.WriteString(v.String())
+	case starlark.Float:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Errorf("cannot store non-finite float %v", v)
+		}
+		text := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		buf.WriteString(text)
+	case starlark.String:
+		quoted, err := json.Marshal(string(v))
+		if err != nil {
+			return err
+		}
+		buf.Write(quoted)
+	case *starlark.List, starlark.Tuple:
+		buf.WriteByte('[')
+		iter := v.(starlark.Iterable).Iterate()
+		defer iter.Done()
+		var elem starlark.Value
+		for i := 0; iter.Next(&elem); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeStateValue(buf, elem, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case *starlark.Dict:
+		buf.WriteByte('{')
+		for i, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return fmt.Errorf("dict key %s is not a string", item[0].String())
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeStateValue(buf, key, depth+1); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeStateValue(buf, item[1], depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("value of type %s is not JSON-serializable", v.Type())
+	}
+	return nil
+}
+
+// stateOf returns the state of the instance running on a thread
+func stateOf(thread *starlark.Thread) *scriptState {
+	return scriptContextOf(thread).state
+}
+
+// starlarkStateGet implements state.get(key, default=None)
+func starlarkStateGet(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		key          string
+		defaultValue starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "default?", &defaultValue); err != nil {
+		return nil, err
+	}
+	value, ok := stateOf(thread).get(key)
+	if !ok {
+		return defaultValue, nil
+	}
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(value)}, nil)
+}
+
+// starlarkStateSet implements state.set(key, value)
+func starlarkStateSet(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		key   string
+		value starlark.Value
+	)
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeStateValue(&buf, value, 0); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", fn.Name(), key, err)
+	}
+	if err := stateOf(thread).set(key, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", fn.Name(), key, err)
+	}
+	return starlark.None, nil
+}
+
+// starlarkStateDelete implements state.delete(key)
+func starlarkStateDelete(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+		return nil, err
+	}
+	return starlark.Bool(stateOf(thread).delete(key)), nil
+}
+
+// starlarkStateKeys implements state.keys()
+func starlarkStateKeys(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	keys := stateOf(thread).keys()
+	elems := make([]starlark.Value, len(keys))
+	for i, key := range keys {
+		elems[i] = starlark.String(key)
+	}
+	return starlark.NewList(elems), nil
+}
+
+// starlarkStateClear implements state.clear()
+func starlarkStateClear(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	stateOf(thread).reset()
+	return starlark.None, nil
+}
+
+// stateGlobals returns the state module
+func stateGlobals() starlark.StringDict {
+	return starlark.StringDict{
+		stateModule: &starlarkstruct.Module{
+			Name: stateModule,
+			Members: starlark.StringDict{
+				"get":    starlark.NewBuiltin(stateModule+".get", starlarkStateGet),
+				"set":    starlark.NewBuiltin(stateModule+".set", starlarkStateSet),
+				"delete": starlark.NewBuiltin(stateModule+".delete", starlarkStateDelete),
+				"keys":   starlark.NewBuiltin(stateModule+".keys", starlarkStateKeys),
+				"clear":  starlark.NewBuiltin(stateModule+".clear", starlarkStateClear),
+			},
+		},
+	}
+}
+
+// StateStore persists script state per strategy and symbol, keeping the hot
+// copy in Redis and periodic snapshots in MySQL
+type StateStore struct {
+	redis *goredis.Client
+	repo  *database.StrategyStateRepository
+	limit int
+	live  map[string]*scriptState
+	mu    sync.Mutex
+}
+
+// NewStateStore creates a new state store
+func NewStateStore(client *goredis.Client, repo *database.StrategyStateRepository) *StateStore {
+	return &StateStore{
+		redis: client,
+		repo:  repo,
+		limit: DefaultStateLimit,
+		live:  make(map[string]*scriptState),
+	}
+}
+
+// SetLimit sets the size cap applied to states attached afterwards
+func (s *StateStore) SetLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+}
+
+// stateKey returns the Redis key holding the state of a strategy and symbol
+func stateKey(strategyID, symbol string) string {
+	return fmt.Sprintf("%s:%s:%s", stateKeyPrefix, strategyID, symbol)
+}
+
+// attach loads the state of a strategy and symbol, preferring the Redis copy
+// over the last snapshot, and tracks it until detach
+func (s *StateStore) attach(ctx context.Context, strategyID, symbol string) (*scriptState, error) {
+	s.mu.Lock()
+	st := newScriptState(strategyID, symbol, s.limit)
+	s.mu.Unlock()
+
+	key := stateKey(strategyID, symbol)
+	data, err := s.redis.Get(ctx, key).Bytes()
+	switch {
+	case err == nil:
+		if err := st.load(data); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, goredis.Nil):
+		snapshot, err := s.repo.GetState(ctx, strategyID, symbol)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to load state snapshot: %w", err)
+		}
+		if snapshot != nil {
+			if err := st.load(snapshot.State); err != nil {
+				return nil, err
+			}
+			// Warm the Redis copy on the next flush
+			st.dirty = true
+		}
+	default:
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	s.mu.Lock()
+	s.live[key] = st
+	s.mu.Unlock()
+	return st, nil
+}
+
+// detach writes the final state to Redis and MySQL and stops tracking it
+func (s *StateStore) detach(ctx context.Context, st *scriptState) error {
+	s.mu.Lock()
+	key := stateKey(st.strategyID, st.symbol)
+	if s.live[key] == st {
+		delete(s.live, key)
+	}
+	s.mu.Unlock()
+
+	return errors.Join(s.flush(ctx, st), s.snapshot(ctx, st))
+}
+
+// flush writes the state to Redis if it changed since the last flush
+func (s *StateStore) flush(ctx context.Context, st *scriptState) error {
+	st.mu.Lock()
+	dirty := st.dirty
+	st.dirty = false
+	st.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	data, _, err := st.encode()
+	if err == nil {
+		err = s.redis.Set(ctx, stateKey(st.strategyID, st.symbol), data, 0).Err()
+	}
+	if err != nil {
+		st.mu.Lock()
+		st.dirty = true
+		st.mu.Unlock()
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// snapshot writes the state to MySQL if it changed since the last snapshot
+func (s *StateStore) snapshot(ctx context.Context, st *scriptState) error {
+	st.mu.Lock()
+	unsaved := st.unsaved
+	st.unsaved = false
+	st.mu.Unlock()
+	if !unsaved {
+		return nil
+	}
+
+	data, size, err := st.encode()
+	if err == nil {
+		err = s.repo.SaveState(ctx, &database.StrategyState{
+			StrategyID: st.strategyID,
+			Symbol:     st.symbol,
+			State:      data,
+			SizeBytes:  size,
+		})
+	}
+	if err != nil {
+		st.mu.Lock()
+		st.unsaved = true
+		st.mu.Unlock()
+		return fmt.Errorf("failed to snapshot state: %w", err)
+	}
+	return nil
+}
+
+// Inspect returns the state of every symbol of a strategy, or only of symbol
+// when it is not empty. Redis copies take precedence over snapshots.
+func (s *StateStore) Inspect(ctx context.Context, strategyID, symbol string) (map[string]json.RawMessage, error) {
+	states := make(map[string]json.RawMessage)
+
+	snapshots, err := s.repo.ListStatesByStrategyID(ctx, strategyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state snapshots: %w", err)
+	}
+	for _, snapshot := range snapshots {
+		if symbol == "" || snapshot.Symbol == symbol {
+			states[snapshot.Symbol] = snapshot.State
+		}
+	}
+
+	keys, err := s.redisKeys(ctx, strategyID, symbol)
+	if err != nil {
+		return nil, err
+	}
+	prefix := stateKey(strategyID, "")
+	for _, key := range keys {
+		data, err := s.redis.Get(ctx, key).Bytes()
+		if errors.Is(err, goredis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state: %w", err)
+		}
+		states[strings.TrimPrefix(key, prefix)] = data
+	}
+
+	return states, nil
+}
+
+// Clear deletes the state of every symbol of a strategy, or only of symbol
+// when it is not empty, including the state of running executions
+func (s *StateStore) Clear(ctx context.Context, strategyID, symbol string) error {
+	s.mu.Lock()
+	for _, st := range s.live {
+		if st.strategyID == strategyID && (symbol == "" || st.symbol == symbol) {
+			st.reset()
+			st.mu.Lock()
+			st.dirty, st.unsaved = false, false
+			st.mu.Unlock()
+		}
+	}
+	s.mu.Unlock()
+
+	keys, err := s.redisKeys(ctx, strategyID, symbol)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		if err := s.redis.Del(ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("failed to delete state: %w", err)
+		}
+	}
+	if err := s.repo.DeleteState(ctx, strategyID, symbol); err != nil {
+		return fmt.Errorf("failed to delete state snapshots: %w", err)
+	}
+	return nil
+}
+
+// redisKeys returns the Redis keys holding state of a strategy
+func (s *StateStore) redisKeys(ctx context.Context, strategyID, symbol string) ([]string, error) {
+	if symbol != "" {
+		return []string{stateKey(strategyID, symbol)}, nil
+	}
+	var keys []string
+	iter := s.redis.Scan(ctx, 0, stateKey(strategyID, "*"), 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list state keys: %w", err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,213 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+func TestState_RoundTripSurvivesReload(t *testing.T) {
+	code := `
+def on_bar(symbol, bar):
+    count = state.get("count", 0)
+    state.set("count", count + 1)
+    state.set("last", {"price": bar.close + 0, "tags": ["a", ("b", None)], "flag": True})
+`
+	instance := newTestInstance(t, code, stateGlobals(), DefaultQuota)
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 100}))
+	require.NoError(t, instance.OnBar(context.Background(), "AAPL", Bar{Close: 101}))
+
+	data, _, err := instance.script.state.encode()
+	require.NoError(t, err)
+	assert.Equal(t, `{"count":2,"last":{"price":101.0,"tags":["a",["b",null]],"flag":true}}`, string(data))
+
+	// A restarted execution picks up where the previous one stopped
+	restarted := newTestInstance(t, code+`
+def snapshot():
+    return (state.get("count"), state.get("last")["price"], state.keys(), state.get("missing"))
+`, stateGlobals(), DefaultQuota)
+	require.NoError(t, restarted.script.state.load(data))
+	require.NoError(t, restarted.OnBar(context.Background(), "AAPL", Bar{Close: 102}))
+	value, err := starlark.Call(restarted.thread, restarted.globals["snapshot"], nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `(3, 102.0, ["count", "last"], None)`, value.String())
+}
+
+func TestState_RejectsValuesThatAreNotJSON(t *testing.T) {
+	for _, expr := range []string{
+		`on_bar`,
+		`bar`,
+		`{1: "a"}`,
+		`[float("nan")]`,
+		`{"nested": [len]}`,
+	} {
+		instance := newTestInstance(t, `
+def on_bar(symbol, bar):
+    state.set("key", `+expr+`)
+`, stateGlobals(), DefaultQuota)
+		err := instance.OnBar(context.Background(), "AAPL", Bar{Close: 1})
+		require.Error(t, err, expr)
+		assert.Contains(t, err.Error(), "state.set", expr)
+		assert.Empty(t, instance.script.state.keys(), expr)
+	}
+}
+
+func TestState_EnforcesSizeLimit(t *testing.T) {
+	instance := newTestInstance(t, `
+def on_bar(symbol, bar):
+    state.set("small", "x" * 10)
+    state.set("small", "y" * 20)
+    state.set("big", "z" * 100)
+`, stateGlobals(), DefaultQuota)
+	instance.script.state = newScriptState("s1", "AAPL", 64)
+
+	err := instance.OnBar(context.Background(), "AAPL", Bar{Close: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds limit of 64 bytes")
+
+	value, ok := instance.script.state.get("small")
+	require.True(t, ok)
+	assert.Equal(t, `"yyyyyyyyyyyyyyyyyyyy"`, string(value))
+	assert.True(t, instance.script.state.delete("small"))
+	assert.Zero(t, instance.script.state.size)
+}
+
+var errFakeRedis = errors.New("ERR fake failure")
+
+var stateColumns = []string{"id", "strategy_id", "symbol", "state", "size_bytes", "created_at", "updated_at"}
+
+func newTestStateStore(t *testing.T) (*StateStore, *testutil.Redis, *testutil.SQLMock) {
+	t.Helper()
+	client, server := testutil.NewRedis(t)
+	db, mock := testutil.NewSQLMock(t)
+	return NewStateStore(client, database.NewStrategyStateRepository(db)), server, mock
+}
+
+// expectNoSnapshot expects attach to look up a snapshot that does not exist
+func expectNoSnapshot(mock *testutil.SQLMock, strategyID, symbol string) {
+	mock.ExpectQuery(`FROM strategy_state WHERE strategy_id = \? AND symbol = \?`).
+		WithArgs(strategyID, symbol).
+		WillReturnRows(stateColumns)
+}
+
+func TestStateStore_AttachPrefersRedisOverSnapshot(t *testing.T) {
+	store, server, _ := newTestStateStore(t)
+	server.Set(stateKey("s1", "AAPL"), []byte(`{"count":3}`))
+
+	st, err := store.attach(context.Background(), "s1", "AAPL")
+	require.NoError(t, err)
+	value, ok := st.get("count")
+	require.True(t, ok)
+	assert.Equal(t, json.RawMessage(`3`), value)
+
+	// Nothing changed, so nothing is written back
+	require.NoError(t, store.flush(context.Background(), st))
+	require.NoError(t, store.snapshot(context.Background(), st))
+}
+
+func TestStateStore_AttachFallsBackToSnapshotAndWarmsRedis(t *testing.T) {
+	store, server, mock := newTestStateStore(t)
+	now := time.Now()
+	mock.ExpectQuery(`FROM strategy_state WHERE strategy_id = \? AND symbol = \?`).
+		WithArgs("s1", "AAPL").
+		WillReturnRows(stateColumns, []interface{}{"id1", "s1", "AAPL", []byte(`{"count":2}`), 11, now, now})
+	mock.ExpectQuery(`FROM strategy_state WHERE strategy_id = \? AND symbol = \?`).
+		WithArgs("s1", "MSFT").
+		WillReturnRows(stateColumns)
+
+	st, err := store.attach(context.Background(), "s1", "AAPL")
+	require.NoError(t, err)
+	require.NoError(t, store.flush(context.Background(), st))
+	data, ok := server.Get(stateKey("s1", "AAPL"))
+	require.True(t, ok)
+	assert.JSONEq(t, `{"count":2}`, string(data))
+
+	// Without a snapshot the state starts empty
+	empty, err := store.attach(context.Background(), "s1", "MSFT")
+	require.NoError(t, err)
+	assert.Empty(t, empty.keys())
+}
+
+func TestStateStore_FlushRetriesAfterRedisFailure(t *testing.T) {
+	store, server, mock := newTestStateStore(t)
+	expectNoSnapshot(mock, "s1", "AAPL")
+	st, err := store.attach(context.Background(), "s1", "AAPL")
+	require.NoError(t, err)
+	require.NoError(t, st.set("count", json.RawMessage(`1`)))
+
+	server.Fail("SET", errFakeRedis)
+	assert.Error(t, store.flush(context.Background(), st))
+	_, ok := server.Get(stateKey("s1", "AAPL"))
+	assert.False(t, ok)
+
+	server.Fail("SET", nil)
+	require.NoError(t, store.flush(context.Background(), st))
+	data, ok := server.Get(stateKey("s1", "AAPL"))
+	require.True(t, ok)
+	assert.JSONEq(t, `{"count":1}`, string(data))
+}
+
+func TestStateStore_DetachSnapshotsFinalState(t *testing.T) {
+	store, server, mock := newTestStateStore(t)
+	expectNoSnapshot(mock, "s1", "AAPL")
+	st, err := store.attach(context.Background(), "s1", "AAPL")
+	require.NoError(t, err)
+	require.NoError(t, st.set("count", json.RawMessage(`5`)))
+
+	mock.ExpectExec(`INSERT INTO strategy_state`).
+		WithArgs(testutil.AnyArg, "s1", "AAPL", []byte(`{"count":5}`), testutil.AnyArg, testutil.AnyArg, testutil.AnyArg)
+	require.NoError(t, store.detach(context.Background(), st))
+
+	data, ok := server.Get(stateKey("s1", "AAPL"))
+	require.True(t, ok)
+	assert.JSONEq(t, `{"count":5}`, string(data))
+	assert.Empty(t, store.live)
+}
+
+func TestStateStore_InspectPrefersRedisCopies(t *testing.T) {
+	store, server, mock := newTestStateStore(t)
+	now := time.Now()
+	server.Set(stateKey("s1", "AAPL"), []byte(`{"count":9}`))
+	server.Set(stateKey("s2", "AAPL"), []byte(`{"other":true}`))
+	mock.ExpectQuery(`FROM strategy_state WHERE strategy_id = \? ORDER BY symbol`).
+		WithArgs("s1").
+		WillReturnRows(stateColumns,
+			[]interface{}{"id1", "s1", "AAPL", []byte(`{"count":1}`), 11, now, now},
+			[]interface{}{"id2", "s1", "MSFT", []byte(`{"count":4}`), 11, now, now})
+
+	states, err := store.Inspect(context.Background(), "s1", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{
+		"AAPL": json.RawMessage(`{"count":9}`),
+		"MSFT": json.RawMessage(`{"count":4}`),
+	}, states)
+}
+
+func TestStateStore_ClearResetsLiveExecution(t *testing.T) {
+	store, server, mock := newTestStateStore(t)
+	expectNoSnapshot(mock, "s1", "AAPL")
+	st, err := store.attach(context.Background(), "s1", "AAPL")
+	require.NoError(t, err)
+	require.NoError(t, st.set("count", json.RawMessage(`5`)))
+	require.NoError(t, store.flush(context.Background(), st))
+	server.Set(stateKey("s1", "MSFT"), []byte(`{"count":1}`))
+
+	mock.ExpectExec(`DELETE FROM strategy_state WHERE strategy_id = \?$`).WithArgs("s1")
+	require.NoError(t, store.Clear(context.Background(), "s1", ""))
+
+	assert.Empty(t, st.keys())
+	assert.Empty(t, server.Keys())
+
+	// The cleared state is not written back by the running execution
+	require.NoError(t, store.flush(context.Background(), st))
+	require.NoError(t, store.snapshot(context.Background(), st))
+	assert.Empty(t, server.Keys())
+}
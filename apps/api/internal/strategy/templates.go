@@ -1,5 +1,12 @@
 package strategy
 
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/moomoo-trading/api/internal/database"
+)
+
 // StrategyTemplates contains predefined strategy templates
 var StrategyTemplates = map[string]string{
 	"ema_cross": `
@@ -9,6 +16,8 @@ var StrategyTemplates = map[string]string{
 def on_bar(symbol, bar):
     fast_ema = ema(bar.close, fast_period)
     slow_ema = ema(bar.close, slow_period)
+    if fast_ema == None or slow_ema == None:
+        return
     
     if fast_ema > slow_ema and position(symbol) <= 0:
         order(symbol, "BUY", "MARKET", quantity)
@@ -24,6 +33,8 @@ def on_bar(symbol, bar):
 
 def on_bar(symbol, bar):
     rsi_val = rsi(bar.close, rsi_period)
+    if rsi_val == None:
+        return
     
     if rsi_val < oversold and position(symbol) <= 0:
         order(symbol, "BUY", "MARKET", quantity)
@@ -38,8 +49,11 @@ def on_bar(symbol, bar):
 # Parameters: lookback_period, quantity
 
 def on_bar(symbol, bar):
-    high = highest(bar.high, lookback_period)
-    low = lowest(bar.low, lookback_period)
+    # Compare against the previous bars; the current bar's high can never exceed itself
+    high = highest(bar.high[1:lookback_period + 1], lookback_period)
+    low = lowest(bar.low[1:lookback_period + 1], lookback_period)
+    if high == None or low == None:
+        return
     
     if bar.close > high and position(symbol) <= 0:
         order(symbol, "BUY", "MARKET", quantity)
@@ -58,6 +72,8 @@ def on_bar(symbol, bar):
     kijun = ichimoku_kijun(bar)
     senkou_a = ichimoku_senkou_a(bar)
     senkou_b = ichimoku_senkou_b(bar)
+    if senkou_a == None or senkou_b == None:
+        return
     
     # Price above cloud and tenkan above kijun
     if (bar.close > senkou_a and bar.close > senkou_b and 
@@ -78,6 +94,8 @@ def on_bar(symbol, bar):
 def on_bar(symbol, bar):
     mean = sma(bar.close, lookback_period)
     std = stddev(bar.close, lookback_period)
+    if mean == None or std == None:
+        return
     
     upper_band = mean + (std_dev * std)
     lower_band = mean - (std_dev * std)
@@ -95,6 +113,8 @@ def on_bar(symbol, bar):
 # Parameters: lookback_period, threshold, quantity
 
 def on_bar(symbol, bar):
+    if len(bar.close) <= lookback_period:
+        return
     momentum = (bar.close - bar.close[lookback_period]) / bar.close[lookback_period] * 100
     
     if momentum > threshold and position(symbol) <= 0:
@@ -106,17 +126,130 @@ def on_bar(symbol, bar):
 `,
 }
 
+// Template is a strategy template with the parameters its code uses
+type Template struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Code        string          `json:"code"`
+	Params      []TemplateParam `json:"params"`
+}
+
+// TemplateParam is a parameter of a template with its default and constraints
+type TemplateParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default"`
+	MinValue    *float64    `json:"min_value,omitempty"`
+	MaxValue    *float64    `json:"max_value,omitempty"`
+	Step        *float64    `json:"step,omitempty"`
+	Description string      `json:"description"`
+}
+
+// Definition returns the parameter definition of a template parameter
+func (p TemplateParam) Definition() *database.StrategyParam {
+	value, _ := json.Marshal(p.Default)
+	description := p.Description
+	return &database.StrategyParam{
+		ParamName:    p.Name,
+		ParamType:    p.Type,
+		DefaultValue: FormatParamDefault(p.Type, value),
+		MinValue:     p.MinValue,
+		MaxValue:     p.MaxValue,
+		Step:         p.Step,
+		Description:  &description,
+	}
+}
+
+func bound(f float64) *float64 { return &f }
+
+// quantityParam is the order size parameter shared by the templates
+var quantityParam = TemplateParam{Name: "quantity", Type: ParamTypeInt, Default: 100, MinValue: bound(1), Description: "Shares per order"}
+
+// templateCatalog describes the templates in StrategyTemplates
+var templateCatalog = map[string]struct {
+	description string
+	params      []TemplateParam
+}{
+	"ema_cross": {
+		description: "Buys when the fast EMA is above the slow EMA and sells when it is below",
+		params: []TemplateParam{
+			{Name: "fast_period", Type: ParamTypeInt, Default: 12, MinValue: bound(1), Description: "Fast EMA period"},
+			{Name: "slow_period", Type: ParamTypeInt, Default: 26, MinValue: bound(1), Description: "Slow EMA period"},
+			quantityParam,
+		},
+	},
+	"rsi_reversal": {
+		description: "Buys when RSI is oversold and sells when it is overbought",
+		params: []TemplateParam{
+			{Name: "rsi_period", Type: ParamTypeInt, Default: 14, MinValue: bound(2), Description: "RSI period"},
+			{Name: "oversold", Type: ParamTypeFloat, Default: 30, MinValue: bound(0), MaxValue: bound(100), Description: "RSI level below which to buy"},
+			{Name: "overbought", Type: ParamTypeFloat, Default: 70, MinValue: bound(0), MaxValue: bound(100), Description: "RSI level above which to sell"},
+			quantityParam,
+		},
+	},
+	"breakout": {
+		description: "Buys on a close above the highest high of the lookback and sells on a close below the lowest low",
+		params: []TemplateParam{
+			{Name: "lookback_period", Type: ParamTypeInt, Default: 20, MinValue: bound(1), Description: "Bars the high and low are taken over"},
+			quantityParam,
+		},
+	},
+	"ichimoku": {
+		description: "Buys above the Ichimoku cloud with tenkan above kijun and sells below it with tenkan below kijun",
+		params: []TemplateParam{
+			quantityParam,
+		},
+	},
+	"mean_reversion": {
+		description: "Buys below the lower Bollinger band and sells above the upper band",
+		params: []TemplateParam{
+			{Name: "lookback_period", Type: ParamTypeInt, Default: 20, MinValue: bound(2), Description: "Moving average and deviation period"},
+			{Name: "std_dev", Type: ParamTypeFloat, Default: 2, MinValue: bound(0), Description: "Band width in standard deviations"},
+			quantityParam,
+		},
+	},
+	"momentum": {
+		description: "Buys when the rate of change over the lookback exceeds the threshold and sells when it falls below its negative",
+		params: []TemplateParam{
+			{Name: "lookback_period", Type: ParamTypeInt, Default: 10, MinValue: bound(1), Description: "Bars the rate of change is taken over"},
+			{Name: "threshold", Type: ParamTypeFloat, Default: 5, MinValue: bound(0), Description: "Rate of change in percent that triggers an order"},
+			quantityParam,
+		},
+	},
+}
+
+// Templates returns every template, ordered by name
+func Templates() []Template {
+	templates := make([]Template, 0, len(StrategyTemplates))
+	for _, name := range ListTemplates() {
+		template, _ := LookupTemplate(name)
+		templates = append(templates, *template)
+	}
+	return templates
+}
+
+// LookupTemplate returns a template with its description and parameters by name
+func LookupTemplate(name string) (*Template, bool) {
+	code, exists := StrategyTemplates[name]
+	if !exists {
+		return nil, false
+	}
+	info := templateCatalog[name]
+	return &Template{Name: name, Description: info.description, Code: code, Params: info.params}, true
+}
+
 // GetTemplate returns a strategy template by name
 func GetTemplate(name string) (string, bool) {
 	template, exists := StrategyTemplates[name]
 	return template, exists
 }
 
-// ListTemplates returns all available template names
+// ListTemplates returns all available template names, sorted
 func ListTemplates() []string {
 	templates := make([]string, 0, len(StrategyTemplates))
 	for name := range StrategyTemplates {
 		templates = append(templates, name)
 	}
+	sort.Strings(templates)
 	return templates
-}
\ No newline at end of file
+}
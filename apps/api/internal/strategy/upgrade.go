@@ -0,0 +1,247 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/database"
+)
+
+// DefaultUpgradeTrialCallbacks is the number of callbacks an upgraded
+// instance has to run without an error before the upgrade is kept
+const DefaultUpgradeTrialCallbacks = 20
+
+// AuditCategoryStrategy is the audit log category of strategy lifecycle events
+const AuditCategoryStrategy = "strategy"
+
+// strategyUpgrade is a switch of a strategy's executions to new code
+type strategyUpgrade struct {
+	from, to               *Strategy
+	fromProgram, toProgram *Program
+	trialCallbacks         int64
+	rolledBack             bool // guarded by StrategyEngine.mu
+}
+
+// strategySwap is a change of code waiting for an execution's next bar
+type strategySwap struct {
+	strategy *Strategy
+	program  *Program
+	upgrade  *strategyUpgrade // nil when the swap rolls an upgrade back
+}
+
+// UpgradeStrategy switches a loaded strategy to new code, e.g. of another
+// version, without stopping its executions. Each running execution switches
+// at its next completed bar; the new instance takes over the bar history, the
+// tracking of orders placed so far and the persistent state. If the new code
+// fails in one of the first trialCallbacks callbacks of any execution, every
+// execution goes back to the previous code. Upgrades and rollbacks are
+// recorded in the audit log.
+func (se *StrategyEngine) UpgradeStrategy(ctx context.Context, strategy *Strategy, trialCallbacks int) error {
+	if trialCallbacks <= 0 {
+		trialCallbacks = DefaultUpgradeTrialCallbacks
+	}
+
+	se.mu.Lock()
+	current, exists := se.strategies[strategy.ID]
+	if !exists {
+		se.mu.Unlock()
+		return fmt.Errorf("strategy not found: %s", strategy.ID)
+	}
+	if err := upgradeConflict(current, strategy); err != nil {
+		se.mu.Unlock()
+		return fmt.Errorf("failed to upgrade strategy %s: %w", strategy.ID, err)
+	}
+
+	program, err := se.compile(strategy)
+	if err != nil {
+		se.mu.Unlock()
+		return err
+	}
+
+	upgrade := &strategyUpgrade{
+		from:           current,
+		to:             strategy,
+		fromProgram:    se.programs[strategy.ID],
+		toProgram:      program,
+		trialCallbacks: int64(trialCallbacks),
+	}
+	se.strategies[strategy.ID] = strategy
+	se.programs[strategy.ID] = program
+	symbols := []string{}
+	for _, execution := range se.executions {
+		if execution.StrategyID == strategy.ID {
+			execution.setSwap(&strategySwap{strategy: strategy, program: program, upgrade: upgrade})
+			symbols = append(symbols, execution.Symbol)
+		}
+	}
+	se.mu.Unlock()
+	sort.Strings(symbols)
+
+	log.Printf("Upgrading strategy %s from version %s to %s on %v", strategy.ID, current.VersionID, strategy.VersionID, symbols)
+	se.audit(ctx, database.AuditLevelInfo, strategy.ID,
+		fmt.Sprintf("Upgraded strategy %s from version %s to %s", strategy.ID, current.VersionID, strategy.VersionID),
+		map[string]interface{}{
+			"action":          "upgrade",
+			"from_version_id": current.VersionID,
+			"to_version_id":   strategy.VersionID,
+			"symbols":         symbols,
+			"trial_callbacks": trialCallbacks,
+		})
+	return nil
+}
+
+// upgradeConflict reports a change that running executions cannot take over:
+// they aggregate bars of the timeframe they started with, and portfolio
+// executions align the symbols they subscribed to
+func upgradeConflict(current, next *Strategy) error {
+	if next.Timeframe != current.Timeframe {
+		return errors.New("timeframe cannot change while it runs")
+	}
+	if next.IsPortfolio() != current.IsPortfolio() {
+		return errors.New("mode cannot change while it runs")
+	}
+	if !next.IsPortfolio() {
+		return nil
+	}
+	if next.MissingBars != current.MissingBars {
+		return errors.New("missing bar policy cannot change while it runs")
+	}
+	symbols := make(map[string]bool, len(current.Symbols))
+	for _, symbol := range current.Symbols {
+		symbols[symbol] = true
+	}
+	for _, symbol := range next.Symbols {
+		if !symbols[symbol] {
+			return errors.New("symbols of a portfolio cannot change while it runs")
+		}
+		delete(symbols, symbol)
+	}
+	if len(symbols) > 0 {
+		return errors.New("symbols of a portfolio cannot change while it runs")
+	}
+	return nil
+}
+
+// rollbackUpgrade restores the code a failed upgrade replaced. Executions
+// still waiting for the upgrade keep their code; those running it switch back
+// at their next bar.
+func (se *StrategyEngine) rollbackUpgrade(upgrade *strategyUpgrade, cause error) {
+	se.mu.Lock()
+	if upgrade.rolledBack {
+		se.mu.Unlock()
+		return
+	}
+	upgrade.rolledBack = true
+	id := upgrade.to.ID
+	restored := se.programs[id] == upgrade.toProgram
+	if restored {
+		se.strategies[id] = upgrade.from
+		se.programs[id] = upgrade.fromProgram
+	}
+	for _, execution := range se.executions {
+		if execution.StrategyID == id {
+			execution.cancelUpgrade(upgrade)
+		}
+	}
+	se.mu.Unlock()
+
+	log.Printf("Rolling back strategy %s from version %s to %s: %v", id, upgrade.to.VersionID, upgrade.from.VersionID, cause)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if restored {
+		// Executions restored after a restart run the version rolled back to
+		se.saveDeployment(ctx, upgrade.from)
+	}
+	se.audit(ctx, database.AuditLevelError, id,
+		fmt.Sprintf("Rolled back strategy %s from version %s to %s", id, upgrade.to.VersionID, upgrade.from.VersionID),
+		map[string]interface{}{
+			"action":          "rollback",
+			"from_version_id": upgrade.to.VersionID,
+			"to_version_id":   upgrade.from.VersionID,
+			"error":           cause.Error(),
+		})
+}
+
+// swapInstance creates an instance of the swap's code that takes over the bar
+// history, order tracking and state of the current one. If the code of an
+// upgrade fails to initialize, the upgrade is rolled back and the current
+// instance kept.
+func (se *StrategyEngine) swapInstance(execution *StrategyExecution, current *Instance, swap *strategySwap, quota Quota) (*Instance, error) {
+	script := newScriptContext(execution.HistoryDepth)
+	script.adopt(current.script)
+	instance, err := se.newInstance(execution, swap.strategy, swap.program, quota, script)
+	if err != nil && swap.upgrade != nil {
+		se.rollbackUpgrade(swap.upgrade, err)
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+
+	execution.mu.Lock()
+	execution.program = swap.program
+	execution.VersionID = swap.strategy.VersionID
+	execution.mu.Unlock()
+	log.Printf("Strategy %s on %s switched to version %s", execution.StrategyID, execution.Symbol, swap.strategy.VersionID)
+	se.recordTransition(execution.snapshot())
+	return instance, nil
+}
+
+// audit records a strategy lifecycle event in the audit log
+func (se *StrategyEngine) audit(ctx context.Context, level, strategyID, message string, metadata map[string]interface{}) {
+	se.mu.RLock()
+	repo := se.auditLogs
+	se.mu.RUnlock()
+	if repo == nil {
+		return
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		log.Printf("Failed to encode audit log metadata of strategy %s: %v", strategyID, err)
+		return
+	}
+	entry := &database.AuditLog{
+		Level:      level,
+		Category:   AuditCategoryStrategy,
+		Message:    message,
+		StrategyID: &strategyID,
+		Metadata:   data,
+	}
+	if err := repo.CreateAuditLog(ctx, entry); err != nil {
+		log.Printf("Failed to record audit log of strategy %s: %v", strategyID, err)
+	}
+}
+
+// setSwap schedules a change of code for the execution's next bar
+func (execution *StrategyExecution) setSwap(swap *strategySwap) {
+	execution.mu.Lock()
+	defer execution.mu.Unlock()
+	execution.pending = swap
+}
+
+// takeSwap returns and clears the scheduled change of code, if any
+func (execution *StrategyExecution) takeSwap() *strategySwap {
+	execution.mu.Lock()
+	defer execution.mu.Unlock()
+	swap := execution.pending
+	execution.pending = nil
+	return swap
+}
+
+// cancelUpgrade drops a pending upgrade or schedules the switch back from it
+func (execution *StrategyExecution) cancelUpgrade(upgrade *strategyUpgrade) {
+	execution.mu.Lock()
+	defer execution.mu.Unlock()
+	switch {
+	case execution.pending != nil && execution.pending.upgrade == upgrade:
+		execution.pending = nil
+	case execution.pending == nil && execution.program == upgrade.toProgram:
+		execution.pending = &strategySwap{strategy: upgrade.from, program: upgrade.fromProgram}
+	}
+}
@@ -0,0 +1,114 @@
+package strategy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moomoo-trading/api/internal/broker"
+	"github.com/moomoo-trading/api/internal/config"
+	"github.com/moomoo-trading/api/internal/database"
+	"github.com/moomoo-trading/api/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const countingStrategyCode = "def on_bar(symbol, bar):\n    state.set(\"count\", state.get(\"count\", 0) + 1)\n"
+
+// newUpgradeTestExecution loads v1 of a counting strategy and registers an
+// execution for it without starting its run loop
+func newUpgradeTestExecution(t *testing.T, se *StrategyEngine) (*StrategyExecution, *Instance) {
+	t.Helper()
+	require.NoError(t, se.LoadStrategy(&Strategy{ID: "s1", VersionID: "v1", Code: countingStrategyCode}))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	execution := &StrategyExecution{StrategyID: "s1", Symbol: "AAPL", VersionID: "v1", Context: ctx, Cancel: cancel, Status: ExecutionStatusRunning, program: se.programs["s1"]}
+	se.executions["s1_AAPL"] = execution
+
+	script := newScriptContext(DefaultHistoryDepth)
+	script.state = newScriptState("s1", "AAPL", DefaultStateLimit)
+	instance, err := se.newInstance(execution, se.strategies["s1"], se.programs["s1"], DefaultQuota, script)
+	require.NoError(t, err)
+	return execution, instance
+}
+
+func TestStrategyEngine_UpgradeCarriesHistoryOrdersAndState(t *testing.T) {
+	se := NewStrategyEngine(nil, nil, nil)
+	execution, instance := newUpgradeTestExecution(t, se)
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	for i, close := range []float64{100, 101} {
+		require.NoError(t, instance.OnBar(execution.Context, "AAPL", Bar{Timestamp: start.Add(time.Duration(i) * time.Minute), Close: close}))
+	}
+	instance.script.orderIDs["stg-open"] = true
+
+	code := "def on_bar(symbol, bar):\n    state.set(\"count\", state.get(\"count\") + 1)\n    state.set(\"previous\", bar.close[1])\n"
+	require.NoError(t, se.UpgradeStrategy(context.Background(), &Strategy{ID: "s1", VersionID: "v2", Code: code}, 2))
+	assert.Equal(t, "v2", se.strategies["s1"].VersionID)
+
+	swap := execution.takeSwap()
+	require.NotNil(t, swap)
+	upgraded, err := se.swapInstance(execution, instance, swap, DefaultQuota)
+	require.NoError(t, err)
+	require.NotSame(t, instance, upgraded)
+	assert.Equal(t, "v2", execution.snapshot().VersionID)
+	assert.True(t, upgraded.OwnsOrder("stg-open"))
+
+	require.NoError(t, upgraded.OnBar(execution.Context, "AAPL", Bar{Timestamp: start.Add(2 * time.Minute), Close: 102}))
+	count, _ := upgraded.script.state.get("count")
+	previous, _ := upgraded.script.state.get("previous")
+	assert.JSONEq(t, `3`, string(count))
+	assert.JSONEq(t, `101.0`, string(previous))
+
+	assert.ErrorContains(t, se.UpgradeStrategy(context.Background(), &Strategy{ID: "s1", Code: code, Timeframe: "5m"}, 0), "timeframe")
+	assert.ErrorContains(t, se.UpgradeStrategy(context.Background(), &Strategy{ID: "missing", Code: code}, 0), "not found")
+}
+
+func TestStrategyEngine_UpgradeRollsBackWhenInitializationFails(t *testing.T) {
+	se := NewStrategyEngine(nil, nil, nil)
+	db, mock := testutil.NewSQLMock(t)
+	se.SetAuditLog(database.NewAuditLogRepository(db))
+	execution, instance := newUpgradeTestExecution(t, se)
+
+	mock.ExpectExec(`INSERT INTO audit_logs`).WithArgs(testutil.AnyArg, "info", "strategy", "Upgraded strategy s1 from version v1 to v2", "s1", nil, nil, nil,
+		[]byte(`{"action":"upgrade","from_version_id":"v1","symbols":["AAPL"],"to_version_id":"v2","trial_callbacks":20}`), testutil.AnyArg)
+	mock.ExpectExec(`INSERT INTO audit_logs`).WithArgs(testutil.AnyArg, "error", "strategy", "Rolled back strategy s1 from version v2 to v1", "s1", nil, nil, nil, testutil.AnyArg, testutil.AnyArg)
+
+	code := "fail(\"misconfigured\")\n\ndef on_bar(symbol, bar):\n    pass\n"
+	require.NoError(t, se.UpgradeStrategy(context.Background(), &Strategy{ID: "s1", VersionID: "v2", Code: code}, 0))
+
+	current, err := se.swapInstance(execution, instance, execution.takeSwap(), DefaultQuota)
+	require.NoError(t, err)
+	assert.Same(t, instance, current)
+	assert.Equal(t, "v1", se.strategies["s1"].VersionID)
+	assert.Equal(t, "v1", execution.snapshot().VersionID)
+	assert.Nil(t, execution.takeSwap())
+}
+
+func TestStrategyEngine_UpgradeRollsBackAfterErrorInTrial(t *testing.T) {
+	adapter := broker.NewMoomooAdapter(&config.MoomooConfig{})
+	require.NoError(t, adapter.Connect(context.Background()))
+	se := NewStrategyEngine(adapter, nil, nil)
+	db, mock := testutil.NewSQLMock(t)
+	se.SetAuditLog(database.NewAuditLogRepository(db))
+	mock.ExpectExec(`INSERT INTO audit_logs`)
+	mock.ExpectExec(`INSERT INTO audit_logs`).WithArgs(testutil.AnyArg, "error", testutil.AnyArg, testutil.AnyArg, "s1", nil, nil, nil, testutil.AnyArg, testutil.AnyArg)
+
+	require.NoError(t, se.LoadStrategy(&Strategy{ID: "s1", VersionID: "v1", Code: countingStrategyCode}))
+	require.NoError(t, se.StartStrategy(context.Background(), "s1", "AAPL"))
+	defer se.StopStrategy("s1", "AAPL")
+
+	code := "def on_bar(symbol, bar):\n    fail(\"broken\")\n"
+	require.NoError(t, se.UpgradeStrategy(context.Background(), &Strategy{ID: "s1", VersionID: "v2", Code: code}, 5))
+
+	start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	ticks := 0
+	require.Eventually(t, func() bool {
+		adapter.PublishMarketData(broker.MarketData{Symbol: "AAPL", Price: 100, Volume: 1, Timestamp: start.Add(time.Duration(ticks) * time.Minute)})
+		ticks++
+		se.mu.RLock()
+		version := se.strategies["s1"].VersionID
+		se.mu.RUnlock()
+		status, err := se.GetStrategyStatus("s1", "AAPL")
+		return err == nil && version == "v1" && status.VersionID == "v1" && status.Status == ExecutionStatusRunning && mock.ExpectationsWereMet() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,102 @@
+package strategy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// ParamsGlobal is the global dict holding every strategy parameter
+const ParamsGlobal = "params"
+
+// paramGlobals converts strategy parameters into Starlark globals. Each
+// parameter is exposed both as a top-level name and through the params dict.
+func paramGlobals(params map[string]interface{}) (starlark.StringDict, error) {
+	globals := make(starlark.StringDict, len(params)+1)
+	dict := starlark.NewDict(len(params))
+
+	for name, raw := range params {
+		value, err := toStarlarkValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %s: %w", name, err)
+		}
+		globals[name] = value
+		if err := dict.SetKey(starlark.String(name), value); err != nil {
+			return nil, err
+		}
+	}
+
+	globals[ParamsGlobal] = dict
+	return globals, nil
+}
+
+// paramNames returns the names of the globals created by paramGlobals
+func paramNames(params map[string]interface{}) []string {
+	names := make([]string, 0, len(params)+1)
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(names, ParamsGlobal)
+}
+
+// toStarlarkValue converts a JSON-compatible Go value into a Starlark value.
+// Whole floats become ints because JSON does not distinguish the two and
+// scripts use parameters such as periods as integers.
+func toStarlarkValue(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case starlark.Value:
+		return v, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case []interface{}:
+		elems := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			elem, err := toStarlarkValue(item)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			elem, err := toStarlarkValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(key), elem); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
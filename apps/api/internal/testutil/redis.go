@@ -0,0 +1,271 @@
+// Package testutil provides in-process stand-ins for Redis and MySQL so
+// packages can test their storage code without external services.
+package testutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Redis is an in-process Redis server speaking RESP2. It implements the
+// string, keyspace and stream consumer group commands the API uses.
+type Redis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string][]byte
+	streams  map[string]*stream
+	failures map[string]error
+}
+
+// NewRedis starts a server for the duration of the test and returns a client
+// connected to it
+func NewRedis(t testing.TB) (*goredis.Client, *Redis) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start test redis: %v", err)
+	}
+
+	server := &Redis{
+		listener: listener,
+		values:   make(map[string][]byte),
+		streams:  make(map[string]*stream),
+		failures: make(map[string]error),
+	}
+	go server.serve()
+
+	client := goredis.NewClient(&goredis.Options{
+		Addr:            listener.Addr().String(),
+		Protocol:        2,
+		DisableIdentity: true,
+		MaxRetries:      -1,
+	})
+	t.Cleanup(func() {
+		client.Close()
+		listener.Close()
+	})
+	return client, server
+}
+
+// Get returns the value stored at key
+func (r *Redis) Get(key string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value, ok := r.values[key]
+	return value, ok
+}
+
+// Set stores a value at key
+func (r *Redis) Set(key string, value []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[key] = value
+}
+
+// Keys returns the stored keys in order
+func (r *Redis) Keys() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.values))
+	for key := range r.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Fail makes every later call of command return err until it is cleared with
+// a nil error
+func (r *Redis) Fail(command string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		delete(r.failures, strings.ToUpper(command))
+		return
+	}
+	r.failures[strings.ToUpper(command)] = err
+}
+
+func (r *Redis) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *Redis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		r.execute(writer, args)
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as a RESP array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command line %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("unexpected argument line %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (r *Redis) execute(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		writeError(w, errors.New("ERR empty command"))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	command := strings.ToUpper(args[0])
+	if err, ok := r.failures[command]; ok {
+		writeError(w, err)
+		return
+	}
+
+	switch command {
+	case "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case "SELECT":
+		fmt.Fprint(w, "+OK\r\n")
+	case "GET":
+		if len(args) != 2 {
+			writeArity(w, command)
+			return
+		}
+		value, ok := r.values[args[1]]
+		if !ok {
+			fmt.Fprint(w, "$-1\r\n")
+			return
+		}
+		writeBulk(w, string(value))
+	case "SET":
+		if len(args) < 3 {
+			writeArity(w, command)
+			return
+		}
+		r.values[args[1]] = []byte(args[2])
+		fmt.Fprint(w, "+OK\r\n")
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := r.values[key]; ok {
+				delete(r.values, key)
+				deleted++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", deleted)
+	case "SCAN":
+		r.scan(w, args[1:])
+	case "XADD":
+		r.xadd(w, args[1:])
+	case "XGROUP":
+		r.xgroup(w, args[1:])
+	case "XREADGROUP":
+		r.xreadgroup(w, args[1:])
+	case "XACK":
+		r.xack(w, args[1:])
+	case "XCLAIM":
+		r.xclaim(w, args[1:])
+	case "XAUTOCLAIM":
+		r.xautoclaim(w, args[1:])
+	default:
+		writeError(w, fmt.Errorf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+// scan returns every key matching the MATCH pattern in a single batch
+func (r *Redis) scan(w *bufio.Writer, args []string) {
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		if strings.EqualFold(args[i], "MATCH") {
+			pattern = args[i+1]
+		}
+	}
+
+	var keys []string
+	for key := range r.values {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Fprint(w, "*2\r\n")
+	writeBulk(w, "0")
+	fmt.Fprintf(w, "*%d\r\n", len(keys))
+	for _, key := range keys {
+		writeBulk(w, key)
+	}
+}
+
+func writeBulk(w *bufio.Writer, value string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+}
+
+func writeError(w *bufio.Writer, err error) {
+	fmt.Fprintf(w, "-%s\r\n", strings.ReplaceAll(err.Error(), "\r\n", " "))
+}
+
+func writeArity(w *bufio.Writer, command string) {
+	writeError(w, fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(command)))
+}
@@ -0,0 +1,260 @@
+package testutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stream is a stream with its consumer groups. Entry IDs are sequence
+// numbers, so entries are in ID order.
+type stream struct {
+	entries []streamEntry
+	groups  map[string]*streamGroup
+	seq     int
+}
+
+type streamEntry struct {
+	id     string
+	fields []string
+}
+
+// streamGroup is a consumer group: the index of the next entry to deliver
+// and the entries delivered but not yet acknowledged
+type streamGroup struct {
+	next    int
+	pending map[string]*pendingEntry
+}
+
+type pendingEntry struct {
+	consumer    string
+	deliveredAt time.Time
+}
+
+// Pending returns the IDs of the entries of a consumer group delivered but
+// not acknowledged, by the consumer they were delivered to
+func (r *Redis) Pending(key, group string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := make(map[string]string)
+	if s, ok := r.streams[key]; ok && s.groups[group] != nil {
+		for id, entry := range s.groups[group].pending {
+			pending[id] = entry.consumer
+		}
+	}
+	return pending
+}
+
+// xadd appends an entry with a generated ID: XADD key * field value ...
+func (r *Redis) xadd(w *bufio.Writer, args []string) {
+	if len(args) < 4 || len(args)%2 != 0 || args[1] != "*" {
+		writeArity(w, "XADD")
+		return
+	}
+	s := r.streamOf(args[0])
+	s.seq++
+	id := fmt.Sprintf("%d-0", s.seq)
+	s.entries = append(s.entries, streamEntry{id: id, fields: args[2:]})
+	writeBulk(w, id)
+}
+
+// xgroup creates a consumer group: XGROUP CREATE key group id [MKSTREAM]
+func (r *Redis) xgroup(w *bufio.Writer, args []string) {
+	if len(args) < 4 || !strings.EqualFold(args[0], "CREATE") {
+		writeError(w, errors.New("ERR only XGROUP CREATE is supported"))
+		return
+	}
+	s, ok := r.streams[args[1]]
+	if !ok {
+		if len(args) < 5 || !strings.EqualFold(args[4], "MKSTREAM") {
+			writeError(w, errors.New("ERR The XGROUP subcommand requires the key to exist"))
+			return
+		}
+		s = r.streamOf(args[1])
+	}
+	if _, ok := s.groups[args[2]]; ok {
+		writeError(w, errors.New("BUSYGROUP Consumer Group name already exists"))
+		return
+	}
+	group := &streamGroup{pending: make(map[string]*pendingEntry)}
+	if args[3] == "$" {
+		group.next = len(s.entries)
+	}
+	s.groups[args[2]] = group
+	fmt.Fprint(w, "+OK\r\n")
+}
+
+// xreadgroup delivers new entries of one stream to a consumer, waiting up to
+// BLOCK milliseconds for one to arrive:
+// XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] STREAMS key >
+func (r *Redis) xreadgroup(w *bufio.Writer, args []string) {
+	if len(args) < 6 || !strings.EqualFold(args[0], "GROUP") {
+		writeArity(w, "XREADGROUP")
+		return
+	}
+	group, consumer := args[1], args[2]
+	count, block := 0, -1
+	var key string
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			i++
+			count, _ = strconv.Atoi(args[i])
+		case "BLOCK":
+			i++
+			block, _ = strconv.Atoi(args[i])
+		case "STREAMS":
+			if len(args) != i+3 || args[i+2] != ">" {
+				writeError(w, errors.New("ERR only one stream read with > is supported"))
+				return
+			}
+			key, i = args[i+1], len(args)
+		}
+	}
+
+	deadline := time.Now().Add(time.Duration(block) * time.Millisecond)
+	for {
+		s, ok := r.streams[key]
+		if !ok || s.groups[group] == nil {
+			writeError(w, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group))
+			return
+		}
+		g := s.groups[group]
+		if g.next < len(s.entries) {
+			end := len(s.entries)
+			if count > 0 && g.next+count < end {
+				end = g.next + count
+			}
+			delivered := s.entries[g.next:end]
+			g.next = end
+			for _, entry := range delivered {
+				g.pending[entry.id] = &pendingEntry{consumer: consumer, deliveredAt: time.Now()}
+			}
+			fmt.Fprint(w, "*1\r\n*2\r\n")
+			writeBulk(w, key)
+			writeEntries(w, delivered)
+			return
+		}
+		if block < 0 || (block > 0 && !time.Now().Before(deadline)) {
+			fmt.Fprint(w, "*-1\r\n")
+			return
+		}
+
+		// Wait for another connection to add an entry
+		r.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		r.mu.Lock()
+	}
+}
+
+// xack acknowledges entries: XACK key group id ...
+func (r *Redis) xack(w *bufio.Writer, args []string) {
+	if len(args) < 3 {
+		writeArity(w, "XACK")
+		return
+	}
+	acked := 0
+	if s, ok := r.streams[args[0]]; ok && s.groups[args[1]] != nil {
+		for _, id := range args[2:] {
+			if _, ok := s.groups[args[1]].pending[id]; ok {
+				delete(s.groups[args[1]].pending, id)
+				acked++
+			}
+		}
+	}
+	fmt.Fprintf(w, ":%d\r\n", acked)
+}
+
+// xclaim takes over pending entries idle for at least min-idle milliseconds,
+// resetting their idle time: XCLAIM key group consumer min-idle id ... JUSTID
+func (r *Redis) xclaim(w *bufio.Writer, args []string) {
+	if len(args) < 6 || !strings.EqualFold(args[len(args)-1], "JUSTID") {
+		writeError(w, errors.New("ERR only XCLAIM ... JUSTID is supported"))
+		return
+	}
+	g, err := r.groupOf(args[0], args[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	minIdle, _ := strconv.Atoi(args[3])
+	var claimed []string
+	for _, id := range args[4 : len(args)-1] {
+		if entry, ok := g.pending[id]; ok && time.Since(entry.deliveredAt) >= time.Duration(minIdle)*time.Millisecond {
+			entry.consumer, entry.deliveredAt = args[2], time.Now()
+			claimed = append(claimed, id)
+		}
+	}
+	fmt.Fprintf(w, "*%d\r\n", len(claimed))
+	for _, id := range claimed {
+		writeBulk(w, id)
+	}
+}
+
+// xautoclaim takes over pending entries idle for at least min-idle
+// milliseconds, scanning the whole stream:
+// XAUTOCLAIM key group consumer min-idle start [COUNT n]
+func (r *Redis) xautoclaim(w *bufio.Writer, args []string) {
+	if len(args) < 5 {
+		writeArity(w, "XAUTOCLAIM")
+		return
+	}
+	g, err := r.groupOf(args[0], args[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	minIdle, _ := strconv.Atoi(args[3])
+	count := 100
+	if len(args) == 7 && strings.EqualFold(args[5], "COUNT") {
+		count, _ = strconv.Atoi(args[6])
+	}
+
+	var claimed []streamEntry
+	for _, entry := range r.streams[args[0]].entries {
+		pending, ok := g.pending[entry.id]
+		if !ok || time.Since(pending.deliveredAt) < time.Duration(minIdle)*time.Millisecond {
+			continue
+		}
+		if len(claimed) == count {
+			break
+		}
+		pending.consumer, pending.deliveredAt = args[2], time.Now()
+		claimed = append(claimed, entry)
+	}
+	fmt.Fprint(w, "*3\r\n")
+	writeBulk(w, "0-0")
+	writeEntries(w, claimed)
+	fmt.Fprint(w, "*0\r\n")
+}
+
+func (r *Redis) streamOf(key string) *stream {
+	s, ok := r.streams[key]
+	if !ok {
+		s = &stream{groups: make(map[string]*streamGroup)}
+		r.streams[key] = s
+	}
+	return s
+}
+
+func (r *Redis) groupOf(key, group string) (*streamGroup, error) {
+	if s, ok := r.streams[key]; ok && s.groups[group] != nil {
+		return s.groups[group], nil
+	}
+	return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+func writeEntries(w *bufio.Writer, entries []streamEntry) {
+	fmt.Fprintf(w, "*%d\r\n", len(entries))
+	for _, entry := range entries {
+		fmt.Fprint(w, "*2\r\n")
+		writeBulk(w, entry.id)
+		fmt.Fprintf(w, "*%d\r\n", len(entry.fields))
+		for _, field := range entry.fields {
+			writeBulk(w, field)
+		}
+	}
+}
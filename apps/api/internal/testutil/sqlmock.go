@@ -0,0 +1,320 @@
+package testutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// AnyArg matches any argument of an expected statement
+var AnyArg = anyArg{}
+
+type anyArg struct{}
+
+// SQLMock is a database/sql driver that answers statements from a list of
+// expectations, met in order
+type SQLMock struct {
+	t        testing.TB
+	mu       sync.Mutex
+	expected []*Expectation
+}
+
+// Expectation describes a statement the code under test is expected to run
+// and what the driver answers
+type Expectation struct {
+	kind    string // query, exec, begin, commit or rollback
+	pattern *regexp.Regexp
+	args    []interface{}
+	columns []string
+	rows    [][]driver.Value
+	result  driver.Result
+	err     error
+	met     bool
+}
+
+// NewSQLMock returns a database whose statements are checked against the
+// returned mock. The test fails if expectations are left unmet.
+func NewSQLMock(t testing.TB) (*sql.DB, *SQLMock) {
+	t.Helper()
+	mock := &SQLMock{t: t}
+	db := sql.OpenDB(mockConnector{mock: mock})
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db, mock
+}
+
+func (m *SQLMock) expect(kind, pattern string) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &Expectation{kind: kind}
+	if pattern != "" {
+		e.pattern = regexp.MustCompile(pattern)
+	}
+	m.expected = append(m.expected, e)
+	return e
+}
+
+// ExpectQuery expects a query whose whitespace-collapsed text matches pattern
+func (m *SQLMock) ExpectQuery(pattern string) *Expectation {
+	return m.expect("query", pattern)
+}
+
+// ExpectExec expects a statement whose whitespace-collapsed text matches pattern
+func (m *SQLMock) ExpectExec(pattern string) *Expectation {
+	return m.expect("exec", pattern).WillReturnResult(0, 1)
+}
+
+// ExpectBegin expects a transaction to start
+func (m *SQLMock) ExpectBegin() *Expectation {
+	return m.expect("begin", "")
+}
+
+// ExpectCommit expects a transaction to commit
+func (m *SQLMock) ExpectCommit() *Expectation {
+	return m.expect("commit", "")
+}
+
+// ExpectRollback expects a transaction to roll back
+func (m *SQLMock) ExpectRollback() *Expectation {
+	return m.expect("rollback", "")
+}
+
+// ExpectationsWereMet reports the first expectation that was not met
+func (m *SQLMock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expected {
+		if !e.met {
+			return fmt.Errorf("expected %s %v was not run", e.kind, e.pattern)
+		}
+	}
+	return nil
+}
+
+// WithArgs sets the arguments the statement must be run with
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	return e
+}
+
+// WillReturnRows sets the rows a query returns
+func (e *Expectation) WillReturnRows(columns []string, rows ...[]interface{}) *Expectation {
+	e.columns = columns
+	for _, row := range rows {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			value, err := driver.DefaultParameterConverter.ConvertValue(v)
+			if err != nil {
+				panic(err)
+			}
+			values[i] = value
+		}
+		e.rows = append(e.rows, values)
+	}
+	return e
+}
+
+// WillReturnResult sets the result of a statement
+func (e *Expectation) WillReturnResult(lastInsertID, rowsAffected int64) *Expectation {
+	e.result = mockResult{lastInsertID: lastInsertID, rowsAffected: rowsAffected}
+	return e
+}
+
+// WillReturnError makes the statement fail
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// next returns the next unmet expectation after checking it matches
+func (m *SQLMock) next(kind, query string, args []driver.NamedValue) (*Expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	for _, e := range m.expected {
+		if e.met {
+			continue
+		}
+		if e.kind != kind {
+			return nil, fmt.Errorf("unexpected %s %q: next expectation is %s %v", kind, query, e.kind, e.pattern)
+		}
+		if e.pattern != nil && !e.pattern.MatchString(query) {
+			return nil, fmt.Errorf("%s %q does not match %v", kind, query, e.pattern)
+		}
+		if err := e.matchArgs(args); err != nil {
+			return nil, fmt.Errorf("%s %q: %w", kind, query, err)
+		}
+		e.met = true
+		return e, e.err
+	}
+	return nil, fmt.Errorf("unexpected %s %q: all expectations were met", kind, query)
+}
+
+func (e *Expectation) matchArgs(args []driver.NamedValue) error {
+	if e.args == nil {
+		return nil
+	}
+	if len(args) != len(e.args) {
+		return fmt.Errorf("got %d arguments, expected %d", len(args), len(e.args))
+	}
+	for i, want := range e.args {
+		if _, ok := want.(anyArg); ok {
+			continue
+		}
+		value, err := driver.DefaultParameterConverter.ConvertValue(want)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(value, args[i].Value) {
+			return fmt.Errorf("argument %d is %#v, expected %#v", i, args[i].Value, value)
+		}
+	}
+	return nil
+}
+
+type mockConnector struct {
+	mock *SQLMock
+}
+
+func (c mockConnector) Connect(context.Context) (driver.Conn, error) {
+	return &mockConn{mock: c.mock}, nil
+}
+
+func (c mockConnector) Driver() driver.Driver {
+	return mockDriver{}
+}
+
+type mockDriver struct{}
+
+func (mockDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("sqlmock databases are opened with NewSQLMock")
+}
+
+type mockConn struct {
+	mock *SQLMock
+}
+
+var (
+	_ driver.QueryerContext    = (*mockConn)(nil)
+	_ driver.ExecerContext     = (*mockConn)(nil)
+	_ driver.ConnBeginTx       = (*mockConn)(nil)
+	_ driver.NamedValueChecker = (*mockConn)(nil)
+)
+
+func (c *mockConn) Prepare(query string) (driver.Stmt, error) {
+	return &mockStmt{conn: c, query: query}, nil
+}
+
+func (c *mockConn) Close() error { return nil }
+
+func (c *mockConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *mockConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.mock.next("begin", "", nil); err != nil {
+		return nil, err
+	}
+	return &mockTx{mock: c.mock}, nil
+}
+
+func (c *mockConn) CheckNamedValue(value *driver.NamedValue) error {
+	converted, err := driver.DefaultParameterConverter.ConvertValue(value.Value)
+	if err != nil {
+		return err
+	}
+	value.Value = converted
+	return nil
+}
+
+func (c *mockConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	e, err := c.mock.next("query", query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &mockRows{columns: e.columns, rows: e.rows}, nil
+}
+
+func (c *mockConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, err := c.mock.next("exec", query, args)
+	if err != nil {
+		return nil, err
+	}
+	return e.result, nil
+}
+
+type mockStmt struct {
+	conn  *mockConn
+	query string
+}
+
+func (s *mockStmt) Close() error  { return nil }
+func (s *mockStmt) NumInput() int { return -1 }
+
+func (s *mockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *mockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+type mockTx struct {
+	mock *SQLMock
+}
+
+func (tx *mockTx) Commit() error {
+	_, err := tx.mock.next("commit", "", nil)
+	return err
+}
+
+func (tx *mockTx) Rollback() error {
+	_, err := tx.mock.next("rollback", "", nil)
+	return err
+}
+
+type mockRows struct {
+	columns []string
+	rows    [][]driver.Value
+	pos     int
+}
+
+func (r *mockRows) Columns() []string { return r.columns }
+func (r *mockRows) Close() error      { return nil }
+
+func (r *mockRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
+
+type mockResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r mockResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r mockResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
@@ -6,12 +6,17 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/moomoo-trading/api/internal/archive"
 	"github.com/moomoo-trading/api/internal/audit"
+	"github.com/moomoo-trading/api/internal/backtest"
+	"github.com/moomoo-trading/api/internal/broker"
 	"github.com/moomoo-trading/api/internal/config"
 	"github.com/moomoo-trading/api/internal/database"
 	"github.com/moomoo-trading/api/internal/handlers"
 	"github.com/moomoo-trading/api/internal/middleware"
 	"github.com/moomoo-trading/api/internal/redis"
+	"github.com/moomoo-trading/api/internal/risk"
+	"github.com/moomoo-trading/api/internal/strategy"
 )
 
 func main() {
@@ -43,13 +48,8 @@ func main() {
 	orderRepo := database.NewOrderRepository(db)
 	universeRepo := database.NewUniverseRepository(db)
 	backtestRepo := database.NewBacktestRepository(db)
-
-	// Initialize handlers
-	strategyHandler := handlers.NewStrategyHandler(strategyRepo)
-	orderHandler := handlers.NewOrderHandler(orderRepo)
-	universeHandler := handlers.NewUniverseHandler(universeRepo)
-	backtestHandler := handlers.NewBacktestHandler(backtestRepo)
-	auditHandler := handlers.NewAuditHandler(audit.NewTraceManager(gormDB, redisClient))
+	auditLogRepo := database.NewAuditLogRepository(db)
+	stateStore := strategy.NewStateStore(redisClient, database.NewStrategyStateRepository(db))
 
 	// Initialize Redis Streams
 	streamManager := redis.NewStreamManager(redisClient)
@@ -57,6 +57,55 @@ func main() {
 		log.Fatalf("Failed to initialize Redis streams: %v", err)
 	}
 
+	// Initialize the broker and the strategy engine; fills are booked in the
+	// risk manager's positions, which position() and risk checks read
+	adapter := broker.NewMoomooAdapter(&cfg.Moomoo)
+	if err := adapter.Connect(context.Background()); err != nil {
+		log.Fatalf("Failed to connect to Moomoo OpenD: %v", err)
+	}
+	riskManager := risk.NewRiskManager(&risk.RiskConfig{
+		MaxPositionSize:        cfg.Risk.MaxPositionSize,
+		MaxDailyLoss:           cfg.Risk.MaxDailyLoss,
+		MaxWeeklyLoss:          cfg.Risk.MaxWeeklyLoss,
+		MaxDrawdown:            cfg.Risk.MaxDrawdown,
+		MaxConcurrentPositions: cfg.Risk.MaxConcurrentPositions,
+		ATRRiskPerTrade:        cfg.Risk.ATRRiskPerTrade,
+	})
+	adapter.SetPositionBook(riskManager)
+
+	strategyEngine := strategy.NewStrategyEngine(adapter, riskManager, streamManager)
+	strategyEngine.SetStateStore(stateStore)
+	strategyEngine.SetAuditLog(auditLogRepo)
+	deployer := strategy.NewDeployer(strategyEngine, strategyRepo, database.NewStrategyDeploymentRepository(db))
+	if err := deployer.Restore(context.Background()); err != nil {
+		log.Printf("Failed to restore strategy executions: %v", err)
+	}
+
+	// Start the backtest workers; backtests are queued on a Redis stream so
+	// workers of any instance can run them
+	backtestRunner := backtest.NewRunner(
+		backtest.NewBacktestEngine(backtest.NewStoredBars(database.NewBarRepository(db)), nil),
+		redis.NewJobQueue(redisClient, redis.BacktestJobsStream, redis.BacktestWorkersGroup),
+		backtestRepo,
+		strategyRepo,
+		backtest.RunnerConfig{Workers: cfg.Backtest.Workers, ClaimIdle: cfg.Backtest.ClaimIdle},
+	)
+	go func() {
+		if err := backtestRunner.Run(context.Background()); err != nil {
+			log.Printf("Backtest workers stopped: %v", err)
+		}
+	}()
+
+	// Initialize handlers
+	strategyHandler := handlers.NewStrategyHandler(strategyRepo, auditLogRepo)
+	strategyStateHandler := handlers.NewStrategyStateHandler(stateStore)
+	strategyDeploymentHandler := handlers.NewStrategyDeploymentHandler(deployer)
+	strategyArchiveHandler := handlers.NewStrategyArchiveHandler(archive.NewService(strategyRepo, backtestRepo, auditLogRepo, cfg.Archive.SigningKey))
+	orderHandler := handlers.NewOrderHandler(orderRepo)
+	universeHandler := handlers.NewUniverseHandler(universeRepo)
+	backtestHandler := handlers.NewBacktestHandler(backtestRepo, strategyRepo, backtestRunner)
+	auditHandler := handlers.NewAuditHandler(audit.NewTraceManager(gormDB, redisClient))
+
 	// Set Gin mode
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -97,11 +146,36 @@ func main() {
 		{
 			strategies.GET("/", strategyHandler.GetStrategies)
 			strategies.POST("/", strategyHandler.CreateStrategy)
+			strategies.POST("/from-template", strategyHandler.CreateStrategyFromTemplate)
+			strategies.POST("/import", strategyArchiveHandler.ImportStrategy)
 			strategies.GET("/:id", strategyHandler.GetStrategy)
 			strategies.PUT("/:id", strategyHandler.UpdateStrategy)
 			strategies.DELETE("/:id", strategyHandler.DeleteStrategy)
+			strategies.GET("/:id/export", strategyArchiveHandler.ExportStrategy)
 			strategies.GET("/:id/versions", strategyHandler.GetStrategyVersions)
 			strategies.POST("/:id/versions", strategyHandler.CreateStrategyVersion)
+			strategies.POST("/:id/versions/validate", strategyHandler.ValidateStrategyVersion)
+			strategies.POST("/:id/versions/rollback", strategyHandler.RollbackStrategyVersion)
+			strategies.POST("/:id/versions/:vid/activate", strategyHandler.ActivateStrategyVersion)
+			strategies.GET("/:id/versions/:vid/diff/:other", strategyHandler.DiffStrategyVersions)
+			strategies.POST("/:id/versions/:vid/test", strategyHandler.TestStrategyVersion)
+			strategies.GET("/:id/versions/:vid/params", strategyHandler.GetStrategyParams)
+			strategies.POST("/:id/versions/:vid/params", strategyHandler.CreateStrategyParam)
+			strategies.PUT("/:id/versions/:vid/params/:pid", strategyHandler.UpdateStrategyParam)
+			strategies.DELETE("/:id/versions/:vid/params/:pid", strategyHandler.DeleteStrategyParam)
+			strategies.GET("/:id/versions/:vid/schema", strategyHandler.GetStrategyParamsSchema)
+			strategies.GET("/:id/state", strategyStateHandler.GetStrategyState)
+			strategies.DELETE("/:id/state", strategyStateHandler.ClearStrategyState)
+			strategies.POST("/:id/deploy", strategyDeploymentHandler.DeployStrategy)
+			strategies.POST("/:id/start", strategyDeploymentHandler.StartStrategy)
+			strategies.POST("/:id/stop", strategyDeploymentHandler.StopStrategy)
+			strategies.GET("/:id/executions", strategyDeploymentHandler.GetStrategyExecutions)
+		}
+
+		// Strategy templates
+		templates := api.Group("/strategy-templates")
+		{
+			templates.GET("/", strategyHandler.GetStrategyTemplates)
 		}
 
 		// Backtests
@@ -112,6 +186,8 @@ func main() {
 			backtests.GET("/:id", backtestHandler.GetBacktest)
 			backtests.DELETE("/:id", backtestHandler.DeleteBacktest)
 			backtests.POST("/:id/cancel", backtestHandler.CancelBacktest)
+			backtests.POST("/:id/montecarlo", backtestHandler.RunMonteCarlo)
+			backtests.GET("/:id/montecarlo", backtestHandler.GetMonteCarlo)
 		}
 
 		// Universe